@@ -9,12 +9,15 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/rs/zerolog"
+	"github.com/novelist/novelist/pkg/logging"
 )
 
 func main() {
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	// Setup logger
+	logger, err := logging.FromEnv()
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid logging settings, using JSON at info level")
+	}
 
 	apiURL := env("NOVELIST_API_URL", "http://localhost:8080")
 	heartbeatSec := envInt("NOVELIST_AGENT_HEARTBEAT_SEC", 30)
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,19 +16,32 @@ import (
 	"github.com/novelist/novelist/pkg/agents"
 	"github.com/novelist/novelist/pkg/api"
 	"github.com/novelist/novelist/pkg/config"
+	"github.com/novelist/novelist/pkg/logging"
 	"github.com/rs/zerolog"
 )
 
 func main() {
 	// Setup logger
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	logger, err := logging.FromEnv()
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid logging settings, using JSON at info level")
+	}
 
 	// Load config
-	cfg, err := config.Load("")
+	configPath := flag.String("config", "", "config file (yaml, json or toml); defaults to NOVELIST_CONFIG, then config.* in NOVELIST_PROJECT, . or /etc/novelist")
+	flag.Parse()
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to load config")
 	}
+	if cfg.File != "" {
+		logger.Info().Str("file", cfg.File).Msg("Loaded config")
+	} else {
+		logger.Info().Msg("No config file found, using defaults and environment")
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid config")
+	}
 
 	// Setup Gin
 	gin.SetMode(gin.ReleaseMode)
@@ -34,36 +50,114 @@ func main() {
 		logger.Warn().Err(err).Msg("Failed to set trusted proxies")
 	}
 
-	// Setup swarm
-	agentConfigs, err := agents.BuildAgentConfigs(cfg.Provider)
-	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to initialize providers")
-	}
-	swarm := agents.NewSwarm(agentConfigs)
-
 	// Runtime limits
 	maxRequestBytes := int64(envInt("NOVELIST_MAX_REQUEST_BYTES", 64*1024))
 	requestTimeout := time.Duration(envInt("NOVELIST_REQUEST_TIMEOUT_SEC", 90)) * time.Second
 	maxConcurrent := envInt("NOVELIST_MAX_CONCURRENT_REQUESTS", 8)
+	maxQueued := envInt("NOVELIST_MAX_QUEUED_REQUESTS", 16)
+	busyRetryAfter := time.Duration(envInt("NOVELIST_BUSY_RETRY_AFTER_SEC", 2)) * time.Second
 	rateLimitPerMinute := envInt("NOVELIST_RATE_LIMIT_PER_MIN", 30)
+	maxRequiredEventsChars := envInt("NOVELIST_MAX_REQUIRED_EVENTS_CHARS", 2000)
+	defaultSchemaVersion := os.Getenv("NOVELIST_DEFAULT_SCHEMA_VERSION")
+	if defaultSchemaVersion != "" && !api.IsSupportedSchemaVersion(defaultSchemaVersion) {
+		logger.Fatal().
+			Str("version", defaultSchemaVersion).
+			Strs("supported", api.SchemaVersions).
+			Msg("Unsupported NOVELIST_DEFAULT_SCHEMA_VERSION")
+	}
+
+	apiKeys := envAPIKeys("NOVELIST_API_KEYS", cfg.Server.APIKeys)
+	if len(apiKeys) == 0 {
+		logger.Warn().Msg("No API keys configured; the API is open to every client")
+	}
+	for name, priority := range cfg.Server.KeyPriorities {
+		if !agents.IsValidPriority(strings.ToLower(strings.TrimSpace(priority))) {
+			logger.Fatal().
+				Str("key", name).
+				Str("priority", priority).
+				Strs("supported", agents.ScenePriorities).
+				Msg("Unsupported server.key_priorities value")
+		}
+	}
 
 	statsStore := api.NewStatsStore()
+	responseCache := agents.NewResponseCache(cfg.Swarm.ResponseCache)
+	deps := swarmDeps{
+		logger:     &logger,
+		stats:      statsStore,
+		cache:      responseCache,
+		characters: agents.NewCharacterStore(),
+	}
+	if cfg.Project != "" {
+		deps.memory = agents.NewFileMemoryStore(cfg.Project)
+		logger.Info().Str("project", cfg.Project).Msg("Persisting committed scenes")
+		loaded, err := deps.characters.LoadDir(cfg.Project)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to load characters")
+		}
+		logger.Info().Int("characters", loaded).Msg("Loaded characters")
+	}
+	swarm, err := buildSwarm(cfg, deps)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to build the swarm")
+	}
+
+	jobQueue := api.NewJobQueue(
+		envInt("NOVELIST_JOB_WORKERS", 2),
+		envInt("NOVELIST_MAX_QUEUED_JOBS", 32),
+		time.Duration(envInt("NOVELIST_JOB_TTL_SEC", 3600))*time.Second,
+		time.Duration(envInt("NOVELIST_JOB_TIMEOUT_SEC", 300))*time.Second,
+	).WithRetryAfter(busyRetryAfter)
+	statsStore.RegisterGauge("jobs_queued", jobQueue.Queued)
+
+	// Setup handlers
+	handler := api.NewHandler(swarm, &logger, statsStore).
+		WithMaxRequiredEventsChars(maxRequiredEventsChars).
+		WithDefaultSchemaVersion(defaultSchemaVersion).
+		WithAdminToken(os.Getenv("NOVELIST_ADMIN_TOKEN")).
+		WithPresets(cfg.Presets).
+		WithHealthPolicy(cfg.Health).
+		WithJobQueue(jobQueue).
+		WithUniqueSceneNumbers(envBool("NOVELIST_UNIQUE_SCENE_NUMBERS", false))
+	if cfg.Project != "" {
+		handler.WithSceneRepository(api.NewFileSceneRepository(filepath.Join(cfg.Project, "runs", "scenes"), &logger))
+	}
 
-	r.Use(gin.Recovery())
 	r.Use(api.RequestIDMiddleware())
 	r.Use(api.StatsMiddleware(statsStore))
 	r.Use(loggerMiddleware(&logger))
+	r.Use(api.RecoveryMiddleware(&logger, statsStore))
+	r.Use(api.DegradedMiddleware(func() bool { return handler.Swarm().Degraded() }))
+	if corsOrigins := envList("NOVELIST_CORS_ORIGINS"); len(corsOrigins) > 0 {
+		r.Use(api.CORSMiddleware(corsOrigins))
+		logger.Info().Strs("origins", corsOrigins).Msg("CORS enabled")
+	}
+	statsStore.RegisterGauge("scenes_load_shed", func() int { return handler.Swarm().LoadShedCount() })
+	if responseCache != nil {
+		statsStore.RegisterGauge("response_cache_entries", responseCache.Len)
+		statsStore.RegisterGauge("response_cache_hits", responseCache.Hits)
+		statsStore.RegisterGauge("response_cache_misses", responseCache.Misses)
+		statsStore.RegisterRate("response_cache_hit_rate", responseCache.HitRate)
+	}
 
 	rateLimiter := api.NewIPRateLimiter(rateLimitPerMinute, time.Minute)
-	concurrencyLimiter := api.NewConcurrencyLimiter(maxConcurrent)
-
-	// Setup handlers
-	handler := api.NewHandler(swarm, &logger, statsStore)
+	stopJanitor := rateLimiter.StartJanitor(time.Minute)
+	defer stopJanitor()
+	statsStore.RegisterGauge("rate_limit_clients", rateLimiter.Clients)
+	concurrencyLimiter := api.NewConcurrencyLimiter(maxConcurrent, maxQueued).WithRetryAfter(busyRetryAfter)
+	concurrencyLimiter.RegisterQueueGauges(statsStore)
+	jobQueue.WithLimiter(concurrencyLimiter)
 
 	// Routes
 	apiGroup := r.Group("/api/v1")
 	{
-		apiGroup.POST(
+		apiGroup.GET("/health", handler.Health)
+		apiGroup.GET("/ready", handler.Ready)
+		apiGroup.GET("/stats", handler.Stats)
+	}
+	protected := apiGroup.Group("", api.APIKeyAuthMiddleware(apiKeys), api.PriorityMiddleware(cfg.Server.KeyPriorities))
+	{
+		protected.POST(
 			"/scenes",
 			api.BodyLimitMiddleware(maxRequestBytes),
 			rateLimiter.Middleware(),
@@ -71,9 +165,53 @@ func main() {
 			api.TimeoutMiddleware(requestTimeout),
 			handler.GenerateScene,
 		)
-		apiGroup.GET("/health", handler.Health)
-		apiGroup.GET("/ready", handler.Ready)
-		apiGroup.GET("/stats", handler.Stats)
+		protected.POST(
+			"/scenes/stream",
+			api.BodyLimitMiddleware(maxRequestBytes),
+			rateLimiter.Middleware(),
+			concurrencyLimiter.Middleware(),
+			api.TimeoutMiddleware(requestTimeout),
+			handler.GenerateSceneStream,
+		)
+		protected.POST(
+			"/scenes/validate",
+			api.BodyLimitMiddleware(maxRequestBytes),
+			rateLimiter.Middleware(),
+			concurrencyLimiter.Middleware(),
+			api.TimeoutMiddleware(requestTimeout),
+			handler.ValidateScene,
+		)
+		protected.POST(
+			"/jobs",
+			api.BodyLimitMiddleware(maxRequestBytes),
+			rateLimiter.Middleware(),
+			handler.SubmitJob,
+		)
+		protected.GET("/jobs/:id", handler.GetJob)
+		protected.POST(
+			"/scenes/prompts",
+			api.BodyLimitMiddleware(maxRequestBytes),
+			handler.PreviewPrompts,
+		)
+		protected.POST(
+			"/scenes/preview",
+			api.BodyLimitMiddleware(maxRequestBytes),
+			handler.PreviewScene,
+		)
+		protected.GET("/providers", handler.Providers)
+		protected.GET("/scenes/search", handler.SearchScenes)
+		protected.GET("/scenes/:id", handler.GetScene)
+		protected.GET("/chapters/:n/scenes", handler.ListChapterScenes)
+		protected.POST(
+			"/scenes/renumber",
+			api.BodyLimitMiddleware(maxRequestBytes),
+			handler.RenumberScenes,
+		)
+		protected.POST(
+			"/characters/import",
+			api.BodyLimitMiddleware(maxRequestBytes*16),
+			handler.ImportCharacters,
+		)
 	}
 
 	// Create server
@@ -94,14 +232,29 @@ func main() {
 		Str("port", cfg.Server.HTTPPort).
 		Int("rate_limit_per_min", rateLimitPerMinute).
 		Int("max_concurrent_requests", maxConcurrent).
+		Int("max_queued_requests", maxQueued).
 		Int64("max_request_bytes", maxRequestBytes).
+		Int("api_keys", len(apiKeys)).
 		Dur("request_timeout", requestTimeout).
 		Msg("Server started")
 
-	// Wait for interrupt
+	// Reload on SIGHUP until interrupted. Retired swarms may still be
+	// committing in the background; shutdown waits for them too.
+	reloader := newSwarmReloader(*configPath, handler, deps)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+wait:
+	for {
+		select {
+		case <-reload:
+			logger.Info().Msg("Received SIGHUP, reloading config")
+			reloader.trigger()
+		case <-quit:
+			break wait
+		}
+	}
 
 	logger.Info().Msg("Shutting down server...")
 
@@ -111,6 +264,12 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
+	for _, swarm := range reloader.swarms() {
+		if err := swarm.WaitForCommits(ctx); err != nil {
+			logger.Warn().Err(err).Msg("Shutdown timed out before background commits finished")
+			break
+		}
+	}
 
 	logger.Info().Msg("Server exited")
 }
@@ -141,7 +300,15 @@ func loggerMiddleware(logger *zerolog.Logger) gin.HandlerFunc {
 			path = path + "?" + raw
 		}
 
-		logger.Info().
+		// Failed requests stay visible under NOVELIST_LOG_LEVEL=warn/error.
+		event := logger.Info()
+		switch {
+		case statusCode >= http.StatusInternalServerError:
+			event = logger.Error()
+		case statusCode >= http.StatusBadRequest:
+			event = logger.Warn()
+		}
+		event.
 			Str("request_id", requestID).
 			Str("client_ip", clientIP).
 			Str("user_agent", userAgent).
@@ -164,3 +331,47 @@ func envInt(key string, fallback int) int {
 	}
 	return parsed
 }
+
+func envBool(key string, fallback bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+// envList reads a comma-separated list, dropping empty entries.
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// envAPIKeys adds the comma-separated name:key pairs in key to configured.
+// A bare key is named by its position, e.g. "key2".
+func envAPIKeys(key string, configured map[string]string) map[string]string {
+	keys := make(map[string]string, len(configured))
+	for name, apiKey := range configured {
+		keys[name] = apiKey
+	}
+	for i, entry := range strings.Split(os.Getenv(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, apiKey, ok := strings.Cut(entry, ":")
+		if !ok {
+			name, apiKey = "key"+strconv.Itoa(i+1), entry
+		}
+		keys[strings.TrimSpace(name)] = strings.TrimSpace(apiKey)
+	}
+	return keys
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/novelist/novelist/pkg/agents"
+	"github.com/novelist/novelist/pkg/api"
+	"github.com/novelist/novelist/pkg/config"
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog"
+)
+
+// documentEmbeddingTimeout bounds embedding the context documents.
+const documentEmbeddingTimeout = 2 * time.Minute
+
+// swarmDeps are shared by every swarm built from config, so state outlives
+// a reload: the stats, the response cache, the scene memory and the
+// characters, including imported ones.
+type swarmDeps struct {
+	logger     *zerolog.Logger
+	stats      *api.StatsStore
+	cache      *agents.ResponseCache
+	memory     agents.MemoryStore
+	characters *agents.CharacterStore
+}
+
+// buildSwarm builds a swarm from the provider, swarm, prompt, moderation,
+// context and input filter settings of cfg.
+func buildSwarm(cfg *config.Config, deps swarmDeps) (*agents.Swarm, error) {
+	pool := agents.NewProviderPool()
+	agentConfigs, err := agents.BuildAgentConfigsWithPool(cfg.Provider, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize providers: %w", err)
+	}
+	allMock := agents.UnintendedMockFallback(cfg.Provider, agentConfigs)
+	if allMock && (cfg.Provider.Strict || strings.EqualFold(cfg.Provider.OnAllMock, "fail")) {
+		return nil, errors.New("every agent fell back to the mock provider; check provider.default and provider.routing")
+	}
+
+	agents.ApplyPromptOverrides(agentConfigs, cfg.Prompts)
+	agents.WrapResponseCache(agentConfigs, deps.cache)
+	swarm := agents.NewSwarm(agentConfigs, cfg.Swarm)
+	if allMock {
+		deps.logger.Warn().Msg("!!! Every agent fell back to the MOCK provider despite configured routing; serving fake scenes in degraded mode !!!")
+		swarm.SetDegraded(true)
+	}
+
+	router, err := agents.BuildModelRouterWithPool(cfg.Provider, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize routing rules: %w", err)
+	}
+	swarm.SetModelRouter(router)
+	swarm.SetProviderCatalog(agents.NewProviderCatalogWithPool(cfg.Provider, pool))
+	swarm.SetPricing(agents.NewPricingTable(cfg.Provider.Pricing))
+	swarm.SetHealthCacheTTL(time.Duration(cfg.Health.CacheTTLSec) * time.Second)
+
+	moderator, err := agents.NewModerator(cfg.Moderation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize moderation: %w", err)
+	}
+	if moderator != nil {
+		swarm.SetModerator(moderator, cfg.Moderation.BlockCommit)
+	}
+
+	if len(cfg.Context.Documents) > 0 {
+		documents, err := agents.LoadDocuments(cfg.Context.Documents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load context documents: %w", err)
+		}
+		retriever, err := indexDocuments(cfg, documents, pool, deps.logger)
+		if err != nil {
+			return nil, err
+		}
+		swarm.SetRetriever(retriever, cfg.Context)
+	}
+
+	sanitizer, err := agents.NewInputSanitizer(cfg.InputFilter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize input filter: %w", err)
+	}
+	if sanitizer != nil {
+		swarm.SetInputSanitizer(sanitizer)
+	}
+
+	if deps.memory != nil {
+		swarm.SetMemoryStore(deps.memory)
+	}
+	swarm.SetCharacters(deps.characters)
+	swarm.SetPanicHandler(deps.stats.RecordPanic)
+	swarm.SetLoadSignal(deps.stats.InFlight)
+	swarm.SetUsageRecorder(deps.stats.RecordUsage)
+	return swarm, nil
+}
+
+// indexDocuments indexes documents by the embeddings of
+// context.embedding_provider, taken from pool, when set, otherwise by
+// TF-IDF.
+func indexDocuments(cfg *config.Config, documents []models.Document, pool *agents.ProviderPool, logger *zerolog.Logger) (agents.Retriever, error) {
+	name := cfg.Context.EmbeddingProvider
+	if name == "" {
+		retriever := agents.NewTFIDFRetriever()
+		retriever.Add(documents...)
+		logger.Info().Int("documents", retriever.Len()).Msg("Indexed context documents")
+		return retriever, nil
+	}
+
+	provider, err := pool.Get(name, cfg.Provider.Available[name])
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize embedding provider %s: %w", name, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), documentEmbeddingTimeout)
+	defer cancel()
+	retriever := agents.NewEmbeddingRetriever(provider)
+	if err := retriever.Add(ctx, documents...); err != nil {
+		return nil, fmt.Errorf("failed to index context documents with %s: %w", name, err)
+	}
+	logger.Info().Int("documents", retriever.Len()).Str("embedding_provider", name).Msg("Indexed context documents")
+	return retriever, nil
+}
+
+// swarmReloader reloads the config on SIGHUP. Reloads run off the signal
+// loop, one at a time, and it tracks the swarms shutdown has to wait for:
+// the current one and the ones still finishing background commits.
+type swarmReloader struct {
+	configPath string
+	handler    *api.Handler
+	deps       swarmDeps
+
+	running  atomic.Bool
+	mu       sync.Mutex
+	draining map[*agents.Swarm]struct{}
+}
+
+func newSwarmReloader(configPath string, handler *api.Handler, deps swarmDeps) *swarmReloader {
+	return &swarmReloader{
+		configPath: configPath,
+		handler:    handler,
+		deps:       deps,
+		draining:   make(map[*agents.Swarm]struct{}),
+	}
+}
+
+// trigger starts a reload in the background unless one is running.
+func (r *swarmReloader) trigger() {
+	if !r.running.CompareAndSwap(false, true) {
+		r.deps.logger.Warn().Msg("Config reload already in progress, ignoring SIGHUP")
+		return
+	}
+	go func() {
+		defer r.running.Store(false)
+		r.reload()
+	}()
+}
+
+// reload re-reads the config and has handler serve new requests from a
+// swarm built from it. Requests in flight finish on the swarm they started
+// with, which is dropped once its background commits are done. On failure
+// it logs the error and keeps the current swarm. Server settings, the
+// project directory, presets and the response cache settings are not
+// reloaded.
+func (r *swarmReloader) reload() {
+	cfg, err := config.Load(r.configPath)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	var swarm *agents.Swarm
+	if err == nil {
+		swarm, err = buildSwarm(cfg, r.deps)
+	}
+	if err != nil {
+		r.deps.logger.Error().Err(err).Msg("Config reload failed, keeping the current config")
+		return
+	}
+
+	previous := r.handler.SwapSwarm(swarm)
+	if r.deps.cache != nil {
+		r.deps.cache.Purge()
+	}
+	r.deps.logger.Info().Str("file", cfg.File).Msg("Reloaded config")
+
+	r.mu.Lock()
+	r.draining[previous] = struct{}{}
+	r.mu.Unlock()
+	go func() {
+		_ = previous.WaitForCommits(context.Background())
+		r.mu.Lock()
+		delete(r.draining, previous)
+		r.mu.Unlock()
+	}()
+}
+
+// swarms returns the current swarm and those still draining.
+func (r *swarmReloader) swarms() []*agents.Swarm {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	swarms := []*agents.Swarm{r.handler.Swarm()}
+	for swarm := range r.draining {
+		swarms = append(swarms, swarm)
+	}
+	return swarms
+}
@@ -2,13 +2,29 @@ package agents
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/novelist/novelist/pkg/models"
 	"github.com/rs/zerolog/log"
 )
 
+// defaultMinTimeRemaining is the least time left on a deadline for a
+// provider call to be started. Even a short generation rarely returns in
+// under a couple of seconds, so a call started with less would only be cut
+// off.
+const defaultMinTimeRemaining = 2 * time.Second
+
+// ErrInsufficientTimeRemaining is returned instead of starting a provider
+// call that the request deadline would cut short.
+var ErrInsufficientTimeRemaining = errors.New("insufficient time remaining")
+
+// ErrEmbeddingsUnsupported is returned by Embed on providers without an
+// embeddings endpoint.
+var ErrEmbeddingsUnsupported = errors.New("embeddings not supported")
+
 // Agent is the interface for all agents
 type Agent interface {
 	Name() string
@@ -19,6 +35,57 @@ type Agent interface {
 type BaseAgent struct {
 	name     string
 	provider Provider
+
+	// promptTokenWarn logs a warning when a prompt's estimated size exceeds
+	// it. Zero disables the warning.
+	promptTokenWarn int
+
+	// minTimeRemaining is the least time left on the context deadline for
+	// a provider call to be worth starting.
+	minTimeRemaining time.Duration
+
+	// defaultTopP fills in GenerateParams.TopP when a call leaves it zero.
+	// Zero keeps it unset so providers apply their own default.
+	defaultTopP float64
+
+	// defaultSeed fills in GenerateParams.Seed when a call leaves it zero.
+	defaultSeed int64
+
+	// temperature and maxTokens are the configured generation parameters;
+	// nil and zero keep each call's built-in value.
+	temperature *float64
+	maxTokens   int
+
+	// overflow switches the agent off its primary provider after repeated
+	// context overflows. Nil disables it.
+	overflow *overflowAdapter
+
+	// switchover moves the agent to a fallback provider for good once its
+	// primary reports the model unavailable. Nil disables it.
+	switchover *modelSwitchover
+
+	// pricing estimates each generation's cost. Nil reports zero.
+	pricing *PricingTable
+
+	// usage, when set, is told the tokens of each generation.
+	usage UsageRecorder
+
+	// prompts holds the agent's prompt templates by language.
+	prompts PromptTemplates
+
+	// systemPrompt replaces the templates' system prompt when set.
+	systemPrompt string
+
+	// providerChain lists the configured provider names routed to the
+	// agent, primary first.
+	providerChain []string
+}
+
+// PromptPart is a named section of a user prompt, used to attribute prompt
+// size in token diagnostics.
+type PromptPart struct {
+	Name string
+	Text string
 }
 
 // Name returns the agent name
@@ -29,6 +96,12 @@ func (a *BaseAgent) Name() string {
 // Provider interface for LLM providers
 type Provider interface {
 	Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error)
+	// GenerateStream generates like Generate, delivering the text in
+	// chunks as it is produced. The channel is closed after the last chunk.
+	GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error)
+	// Embed returns one embedding vector per text, in order. Providers
+	// without embeddings return ErrEmbeddingsUnsupported.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
 	Capabilities() ProviderCapabilities
 	HealthCheck(ctx context.Context) error
 	Name() string
@@ -44,8 +117,24 @@ type Message struct {
 type GenerateParams struct {
 	Temperature float64 `json:"temperature"`
 	MaxTokens   int     `json:"max_tokens"`
-	TopP        float64 `json:"top_p"`
+	TopP        float64 `json:"top_p"` // zero: unset, the provider's default applies
 	JSONMode    bool    `json:"json_mode"`
+	// Seed asks providers that support it for reproducible sampling. Zero
+	// leaves it unset.
+	Seed int64 `json:"seed,omitempty"`
+	// Tools are functions the model may call instead of answering in
+	// text; ToolChoice names one the model must call. Both are dropped for
+	// providers without tool support.
+	Tools      []ToolDefinition `json:"tools,omitempty"`
+	ToolChoice string           `json:"tool_choice,omitempty"`
+}
+
+// ToolDefinition describes a function a model can call. Parameters is the
+// JSON Schema of its arguments.
+type ToolDefinition struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
 }
 
 // ProviderCapabilities represents provider capabilities
@@ -55,27 +144,79 @@ type ProviderCapabilities struct {
 	SupportsJSONMode  bool `json:"supports_json_mode"`
 	SupportsThinking  bool `json:"supports_thinking_mode"`
 	SupportsStreaming bool `json:"supports_streaming"`
+	// MaxTemperature is the highest temperature the API accepts. Zero
+	// means unknown.
+	MaxTemperature float64 `json:"max_temperature,omitempty"`
 }
 
 // Generate executes generation with the provider
 func (a *BaseAgent) Generate(ctx context.Context, systemPrompt, userPrompt string, params GenerateParams) (*models.GenerationResult, error) {
+	a.logPromptTokens(systemPrompt, []PromptPart{{Name: "user", Text: userPrompt}})
+	return a.generate(ctx, systemPrompt, userPrompt, params)
+}
+
+// GenerateParts executes generation with a user prompt assembled from parts,
+// so token diagnostics can attribute prompt size to each part.
+func (a *BaseAgent) GenerateParts(ctx context.Context, systemPrompt string, parts []PromptPart, params GenerateParams) (*models.GenerationResult, error) {
+	a.logPromptTokens(systemPrompt, parts)
+	return a.generate(ctx, systemPrompt, joinPromptParts(parts), params)
+}
+
+func joinPromptParts(parts []PromptPart) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+func (a *BaseAgent) generate(ctx context.Context, systemPrompt, userPrompt string, params GenerateParams) (*models.GenerationResult, error) {
 	start := time.Now()
 
+	hint, language := agentHintFor(ctx, a.name)
 	messages := []Message{
 		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userPrompt},
+		{Role: "user", Content: appendAgentHint(userPrompt, hint, language)},
+	}
+	if params.TopP == 0 {
+		params.TopP = a.defaultTopP
+	}
+	if params.Seed == 0 {
+		params.Seed = a.defaultSeed
+	}
+	provider := a.providerFor(ctx)
+	result, err := a.generateWithRetry(ctx, provider, messages, a.filterParams(provider.Capabilities(), messages, params))
+	if err != nil && provider == a.provider {
+		// generateWithRetry recorded any model-unavailable error from the
+		// primary; once switched over, retry with the fallback.
+		if fallback, ok := a.switchover.provider(); ok {
+			provider = fallback
+			result, err = a.generateWithRetry(ctx, fallback, messages, a.filterParams(fallback.Capabilities(), messages, params))
+		}
 	}
-
-	result, err := a.provider.Generate(ctx, messages, params)
 	if err != nil {
 		log.Error().
 			Str("agent", a.name).
 			Err(err).
 			Msg("Generation failed")
+		if IsModelUnavailable(err) && !errors.Is(err, ErrModelUnavailable) {
+			return nil, fmt.Errorf("%s generation failed: %w: %w", a.name, ErrModelUnavailable, err)
+		}
 		return nil, fmt.Errorf("%s generation failed: %w", a.name, err)
 	}
 
 	result.DurationMs = time.Since(start).Milliseconds()
+	if result.Cached {
+		recordCacheHit(ctx, a.name)
+	}
+	result.CostUSD = a.pricing.Cost(provider, result)
+	recordCost(ctx, result.CostUSD)
+	if !result.Cached {
+		recordTokens(ctx, result.PromptTokens+result.CompletionTokens)
+		if a.usage != nil {
+			a.usage(servedBy(provider, result), a.name, result.PromptTokens, result.CompletionTokens)
+		}
+	}
 
 	log.Debug().
 		Str("agent", a.name).
@@ -86,6 +227,160 @@ func (a *BaseAgent) Generate(ctx context.Context, systemPrompt, userPrompt strin
 	return result, nil
 }
 
+// generateWithRetry calls the provider, retrying transient failures per
+// the provider's retry config while the request's shared retry budget
+// allows. No call is started once less than minTimeRemaining is left on
+// the context deadline, and a call that already streamed text is not
+// retried. A fallback chain is walked member by member, each retried on
+// its own; a weighted router's picked member is retried on its own.
+// Failures of the agent's primary provider, the first member of its chain,
+// are recorded even when a later member answers.
+func (a *BaseAgent) generateWithRetry(ctx context.Context, provider Provider, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	primary := provider == a.provider
+	switch group := provider.(type) {
+	case *FallbackProvider:
+		return group.try(ctx, func(member Provider) (*models.GenerationResult, bool, error) {
+			result, streamed, err := a.callWithRetry(ctx, member, messages, params)
+			if err != nil && primary && member == group.members[0] {
+				a.recordPrimaryFailure(err)
+			}
+			return result, streamed, err
+		})
+	case *WeightedRouterProvider:
+		result, err := group.route(func(member Provider) (*models.GenerationResult, error) {
+			result, _, err := a.callWithRetry(ctx, member, messages, params)
+			return result, err
+		})
+		if err != nil && primary {
+			a.recordPrimaryFailure(err)
+		}
+		return result, err
+	}
+	result, _, err := a.callWithRetry(ctx, provider, messages, params)
+	if err != nil && primary {
+		a.recordPrimaryFailure(err)
+	}
+	return result, err
+}
+
+// recordPrimaryFailure feeds a failure of the agent's primary provider to
+// the context-overflow adapter and the model switchover.
+func (a *BaseAgent) recordPrimaryFailure(err error) {
+	a.overflow.record(a.name, err)
+	a.switchover.record(a.name, err)
+}
+
+// callWithRetry is generateWithRetry for a single provider; streamed
+// reports whether text already reached the stream sink.
+func (a *BaseAgent) callWithRetry(ctx context.Context, provider Provider, messages []Message, params GenerateParams) (result *models.GenerationResult, streamed bool, err error) {
+	err = retryDo(ctx, a.name, retryPolicyFor(provider), func() (bool, error) {
+		if err := a.checkTimeRemaining(ctx); err != nil {
+			return false, err
+		}
+		var err error
+		result, streamed, err = a.call(ctx, provider, messages, params)
+		return !streamed && IsRetryable(err), err
+	})
+	if err != nil {
+		return nil, streamed, err
+	}
+	return result, streamed, nil
+}
+
+// checkTimeRemaining returns ErrInsufficientTimeRemaining when ctx's
+// deadline is closer than minTimeRemaining.
+func (a *BaseAgent) checkTimeRemaining(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || a.minTimeRemaining <= 0 {
+		return nil
+	}
+	if remaining := time.Until(deadline); remaining < a.minTimeRemaining {
+		log.Warn().
+			Str("agent", a.name).
+			Dur("remaining", remaining).
+			Dur("min_time_remaining", a.minTimeRemaining).
+			Msg("Skipping provider call, deadline too close")
+		return fmt.Errorf("%w: %s left, need %s", ErrInsufficientTimeRemaining, remaining.Round(time.Millisecond), a.minTimeRemaining)
+	}
+	return nil
+}
+
+// filterParams drops or adjusts parameters the provider cannot accept:
+// JSON mode is removed when unsupported and max_tokens is clamped to what
+// fits in the context window after the prompt.
+func (a *BaseAgent) filterParams(caps ProviderCapabilities, messages []Message, params GenerateParams) GenerateParams {
+	if params.JSONMode && !caps.SupportsJSONMode {
+		params.JSONMode = false
+		log.Debug().
+			Str("agent", a.name).
+			Str("param", "json_mode").
+			Msg("Dropped parameter unsupported by provider")
+	}
+	if len(params.Tools) > 0 && !caps.SupportsTools {
+		params.Tools = nil
+		params.ToolChoice = ""
+		log.Debug().
+			Str("agent", a.name).
+			Str("param", "tools").
+			Msg("Dropped parameter unsupported by provider")
+	}
+	if caps.MaxTemperature > 0 && params.Temperature > caps.MaxTemperature {
+		log.Debug().
+			Str("agent", a.name).
+			Str("param", "temperature").
+			Float64("requested", params.Temperature).
+			Float64("clamped", caps.MaxTemperature).
+			Msg("Clamped parameter to provider range")
+		params.Temperature = caps.MaxTemperature
+	}
+
+	if caps.CtxLen > 0 && params.MaxTokens > 0 {
+		limit := caps.CtxLen - estimateTokensFromMessages(messages)
+		if limit <= 0 {
+			limit = caps.CtxLen
+		}
+		if params.MaxTokens > limit {
+			log.Debug().
+				Str("agent", a.name).
+				Str("param", "max_tokens").
+				Int("requested", params.MaxTokens).
+				Int("clamped", limit).
+				Msg("Clamped parameter to provider context length")
+			params.MaxTokens = limit
+		}
+	}
+
+	return params
+}
+
+// logPromptTokens logs the estimated prompt size per part, escalating to a
+// warning with the full breakdown when it exceeds promptTokenWarn.
+func (a *BaseAgent) logPromptTokens(systemPrompt string, parts []PromptPart) {
+	breakdown := map[string]int{"system": estimateTokensFromText(systemPrompt)}
+	total := breakdown["system"]
+	for _, part := range parts {
+		tokens := estimateTokensFromText(part.Text)
+		breakdown[part.Name] += tokens
+		total += tokens
+	}
+
+	if a.promptTokenWarn > 0 && total > a.promptTokenWarn {
+		log.Warn().
+			Str("agent", a.name).
+			Int("prompt_tokens_estimate", total).
+			Int("threshold", a.promptTokenWarn).
+			Interface("breakdown", breakdown).
+			Msg("Prompt exceeds token budget")
+		return
+	}
+
+	log.Debug().
+		Str("agent", a.name).
+		Int("prompt_tokens_estimate", total).
+		Interface("breakdown", breakdown).
+		Msg("Prompt token estimate")
+}
+
 // HealthCheck checks provider reachability.
 func (a *BaseAgent) HealthCheck(ctx context.Context) error {
 	if a.provider == nil {
@@ -104,15 +399,88 @@ func (a *BaseAgent) ProviderName() string {
 
 // AgentConfig represents agent configuration
 type AgentConfig struct {
-	Provider    Provider
-	Temperature float64
+	Provider Provider
+	// Temperature, MaxTokens, TopP and Seed override the agent's built-in
+	// generation parameters. Nil or zero keeps the default.
+	Temperature *float64
 	MaxTokens   int
+	TopP        float64
+	Seed        int64
+
+	// ProviderChain lists the validated provider names routed to the agent,
+	// primary first.
+	ProviderChain []string
+
+	// SystemPrompt replaces the agent's built-in system prompt when set.
+	SystemPrompt string
 }
 
 // NewBaseAgent creates a new base agent
 func NewBaseAgent(name string, provider Provider) *BaseAgent {
 	return &BaseAgent{
-		name:     name,
-		provider: provider,
+		name:             name,
+		provider:         provider,
+		minTimeRemaining: defaultMinTimeRemaining,
+		prompts:          builtinPromptTemplates,
+	}
+}
+
+// newConfiguredAgent creates a base agent with config's provider and
+// generation parameters.
+func newConfiguredAgent(name string, config AgentConfig) *BaseAgent {
+	agent := NewBaseAgent(name, config.Provider)
+	agent.temperature = config.Temperature
+	agent.maxTokens = config.MaxTokens
+	agent.defaultTopP = config.TopP
+	agent.defaultSeed = config.Seed
+	agent.systemPrompt = strings.TrimSpace(config.SystemPrompt)
+	agent.providerChain = config.ProviderChain
+	return agent
+}
+
+// promptsFor returns the agent's prompt template for language, with the
+// configured system prompt if there is one.
+func (a *BaseAgent) promptsFor(language string) *PromptTemplate {
+	t := a.prompts.For(a.name, language)
+	if a.systemPrompt == "" || t == nil {
+		return t
+	}
+	overridden := *t
+	overridden.System = a.systemPrompt
+	return &overridden
+}
+
+// systemPromptOr returns the configured system prompt, else fallback.
+func (a *BaseAgent) systemPromptOr(fallback string) string {
+	if a.systemPrompt != "" {
+		return a.systemPrompt
+	}
+	return fallback
+}
+
+// temperatureOr returns the configured temperature, else fallback.
+func (a *BaseAgent) temperatureOr(fallback float64) float64 {
+	if a.temperature != nil {
+		return *a.temperature
+	}
+	return fallback
+}
+
+// maxTemperature returns the highest temperature the agent's provider
+// accepts, else fallback.
+func (a *BaseAgent) maxTemperature(fallback float64) float64 {
+	if a.provider != nil {
+		if limit := a.provider.Capabilities().MaxTemperature; limit > 0 {
+			return limit
+		}
+	}
+	return fallback
+}
+
+// maxTokensOr returns the configured max tokens, else fallback.
+func (a *BaseAgent) maxTokensOr(fallback int) int {
+	if a.maxTokens > 0 {
+		return a.maxTokens
 	}
+	return fallback
 }
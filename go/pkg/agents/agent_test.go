@@ -0,0 +1,454 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// stubProvider records the last messages and params it received and
+// replies with text ("ok" when empty).
+type stubProvider struct {
+	caps         ProviderCapabilities
+	lastMessages []Message
+	lastParams   GenerateParams
+	finishReason string
+	text         string
+}
+
+func (p *stubProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	p.lastMessages = messages
+	p.lastParams = params
+	text := p.text
+	if text == "" {
+		text = "ok"
+	}
+	return &models.GenerationResult{Text: text, FinishReason: p.finishReason}, nil
+}
+
+func (p *stubProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	return generateAsStream(ctx, p.Generate, messages, params)
+}
+
+func (p *stubProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, ErrEmbeddingsUnsupported
+}
+
+func (p *stubProvider) Capabilities() ProviderCapabilities    { return p.caps }
+func (p *stubProvider) HealthCheck(ctx context.Context) error { return nil }
+func (p *stubProvider) Name() string                          { return "stub" }
+
+func TestGenerateSkipsCallNearDeadline(t *testing.T) {
+	provider := &countingProvider{}
+	agent := NewBaseAgent("test", provider)
+	agent.minTimeRemaining = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := agent.Generate(ctx, "system", "user", GenerateParams{}); !errors.Is(err, ErrInsufficientTimeRemaining) {
+		t.Fatalf("expected ErrInsufficientTimeRemaining, got %v", err)
+	}
+	if provider.calls != 0 {
+		t.Fatalf("expected no provider call, got %d", provider.calls)
+	}
+
+	if _, err := agent.Generate(context.Background(), "system", "user", GenerateParams{}); err != nil {
+		t.Fatalf("expected call without deadline to run, got %v", err)
+	}
+	if provider.calls != 1 {
+		t.Fatalf("expected one provider call, got %d", provider.calls)
+	}
+}
+
+func TestGenerateAppliesDefaultTopP(t *testing.T) {
+	provider := &stubProvider{}
+	agent := NewBaseAgent("test", provider)
+
+	if _, err := agent.Generate(context.Background(), "system", "user", GenerateParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.lastParams.TopP != 0 {
+		t.Fatalf("expected top_p to stay unset, got %v", provider.lastParams.TopP)
+	}
+
+	agent.defaultTopP = 0.95
+	if _, err := agent.Generate(context.Background(), "system", "user", GenerateParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.lastParams.TopP != 0.95 {
+		t.Fatalf("expected default top_p 0.95, got %v", provider.lastParams.TopP)
+	}
+	if _, err := agent.Generate(context.Background(), "system", "user", GenerateParams{TopP: 0.5}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.lastParams.TopP != 0.5 {
+		t.Fatalf("expected explicit top_p kept, got %v", provider.lastParams.TopP)
+	}
+}
+
+func TestAdjustLengthUsesConfiguredTemperature(t *testing.T) {
+	provider := &stubProvider{text: "本文"}
+	writer := NewWriterAgent(AgentConfig{Provider: provider})
+	if _, err := writer.AdjustLength(context.Background(), "短い。", 3, 100, "ja"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.lastParams.Temperature != defaultAdjustTemperature {
+		t.Fatalf("expected the default temperature, got %v", provider.lastParams.Temperature)
+	}
+
+	temperature := 0.3
+	writer = NewWriterAgent(AgentConfig{Provider: provider, Temperature: &temperature})
+	if _, err := writer.AdjustLength(context.Background(), "短い。", 3, 100, "ja"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.lastParams.Temperature != 0.3 {
+		t.Fatalf("expected the configured temperature, got %v", provider.lastParams.Temperature)
+	}
+}
+
+func TestGenerateFiltersUnsupportedParams(t *testing.T) {
+	provider := &stubProvider{caps: ProviderCapabilities{CtxLen: 1000}}
+	agent := NewBaseAgent("test", provider)
+
+	_, err := agent.Generate(context.Background(), "system", "user", GenerateParams{
+		MaxTokens: 5000,
+		JSONMode:  true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if provider.lastParams.JSONMode {
+		t.Fatal("expected json_mode to be dropped for provider without JSON support")
+	}
+	if provider.lastParams.MaxTokens >= 1000 {
+		t.Fatalf("expected max_tokens clamped below ctx_len, got %d", provider.lastParams.MaxTokens)
+	}
+
+	provider.caps = ProviderCapabilities{CtxLen: 100000, SupportsJSONMode: true}
+	if _, err := agent.Generate(context.Background(), "system", "user", GenerateParams{MaxTokens: 5000, JSONMode: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !provider.lastParams.JSONMode || provider.lastParams.MaxTokens != 5000 {
+		t.Fatalf("expected supported params untouched, got %+v", provider.lastParams)
+	}
+}
+
+func TestEditorRejectsRunawayEdits(t *testing.T) {
+	original := strings.Repeat("静かな夜だった。", 10)
+	provider := NewMockProviderWithConfig(MockConfig{
+		Seed:      1,
+		Responses: []string{strings.Repeat("静かな夜だった。", 20), strings.Repeat("静かな夜。", 10)},
+	})
+	editor := NewEditorAgent(AgentConfig{Provider: provider})
+	input := &EditorInput{Text: original, Issues: []models.Issue{{Severity: "warning", Description: "repetition"}}}
+
+	if _, err := editor.Execute(context.Background(), input); !errors.Is(err, ErrEditorExpansion) {
+		t.Fatalf("expected ErrEditorExpansion, got %v", err)
+	}
+	result, err := editor.Execute(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected a shorter edit to pass, got %v", err)
+	}
+	if result.Text != strings.Repeat("静かな夜。", 10) {
+		t.Fatalf("unexpected edit: %q", result.Text)
+	}
+}
+
+func TestEditorMaxTokensFollowsExpansion(t *testing.T) {
+	provider := &stubProvider{}
+	editor := NewEditorAgent(AgentConfig{Provider: provider})
+	text := strings.Repeat("あ", 400) // 480 budgeted tokens, 1200 bytes
+
+	if _, err := editor.Execute(context.Background(), &EditorInput{Text: text}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.lastParams.MaxTokens != 624 {
+		t.Fatalf("expected max_tokens 624, got %d", provider.lastParams.MaxTokens)
+	}
+}
+
+func TestEditorDiscardsTruncatedEdits(t *testing.T) {
+	provider := &stubProvider{text: "静かな夜", finishReason: "length"}
+	editor := NewEditorAgent(AgentConfig{Provider: provider})
+
+	if _, err := editor.Execute(context.Background(), &EditorInput{Text: "静かな夜だった。"}); !errors.Is(err, ErrEditorTruncated) {
+		t.Fatalf("expected ErrEditorTruncated, got %v", err)
+	}
+}
+
+func TestCheckerRestrictsCategories(t *testing.T) {
+	provider := &stubProvider{text: `[{"category":"world","severity":"warning","description":"w"},{"category":"pov","severity":"error","description":"p"}]`}
+	checker := NewCheckerAgent(AgentConfig{Provider: provider})
+	input := &CheckerInput{
+		Text:              "本文",
+		POVCharacter:      "葵",
+		CharactersPresent: []string{"蓮"},
+	}
+
+	result, err := checker.Check(context.Background(), input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	issues := result.Issues
+	if len(issues) != 3 || issues[2].Category != "pov" || issues[2].Severity != "info" {
+		t.Fatalf("expected all issues plus the POV presence issue as info, got %+v", issues)
+	}
+
+	input.Categories = []string{"world"}
+	result, err = checker.Check(context.Background(), input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	issues = result.Issues
+	if len(issues) != 1 || issues[0].Category != "world" {
+		t.Fatalf("expected only the world issue, got %+v", issues)
+	}
+	prompt := provider.lastMessages[1].Content
+	if !strings.Contains(prompt, "設定矛盾") || strings.Contains(prompt, "視点違反") || !strings.Contains(prompt, `"category": "world"`) {
+		t.Fatalf("expected a world-only prompt, got %q", prompt)
+	}
+}
+
+func TestCheckerLintsForbiddenWords(t *testing.T) {
+	provider := &stubProvider{text: `[]`}
+	checker := NewCheckerAgent(AgentConfig{Provider: provider})
+	input := &CheckerInput{
+		Text: "雨が降っていた。俺はそう思いながら、傘を閉じた。",
+		Characters: []models.Character{{
+			ID:       "aoi",
+			Name:     models.CharacterName{Full: "葵"},
+			Language: models.CharacterLanguage{FirstPerson: "私", Tone: "丁寧", ForbiddenWords: []string{"俺", "マジで"}},
+		}},
+	}
+
+	result, err := checker.Check(context.Background(), input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Issues) != 1 || result.Issues[0].Category != "character" || !strings.Contains(result.Issues[0].Location, "俺はそう思い") {
+		t.Fatalf("expected one forbidden word issue, got %+v", result.Issues)
+	}
+	if result.Issues[0].Severity != "info" {
+		t.Fatalf("expected narration by someone else to be info, got %+v", result.Issues[0])
+	}
+	if prompt := provider.lastMessages[1].Content; !strings.Contains(prompt, "一人称「私」") {
+		t.Fatalf("expected the character profile in the prompt, got %q", prompt)
+	}
+
+	input.Categories = []string{"world"}
+	if result, _ := checker.Check(context.Background(), input); len(result.Issues) != 0 {
+		t.Fatalf("expected no lint outside the character category, got %+v", result.Issues)
+	}
+}
+
+func TestLintCharactersAttributesForbiddenWords(t *testing.T) {
+	aoi := models.Character{
+		Name:     models.CharacterName{Full: "葵", Aliases: []string{"あおい"}},
+		Language: models.CharacterLanguage{ForbiddenWords: []string{"マジで"}},
+	}
+	cases := []struct {
+		text, pov, want string
+	}{
+		{"「マジで？」と蓮が笑った。", "", "info"},
+		{"「マジで？」と蓮が笑った。\n「マジで」とあおいが呟いた。", "", "warning"},
+		{"マジで疲れた、と思った。", "葵", "warning"},
+		{"マジで疲れた、と思った。", "蓮", "info"},
+	}
+	for _, tc := range cases {
+		issues := lintCharacters(tc.text, tc.pov, []models.Character{aoi}, builtinPromptTemplates.For("checker", "ja"))
+		if len(issues) != 1 || issues[0].Severity != tc.want {
+			t.Errorf("%q (pov %q): expected one %s issue, got %+v", tc.text, tc.pov, tc.want, issues)
+		}
+	}
+}
+
+func TestStreamSinkScope(t *testing.T) {
+	var tokens []string
+	ctx := WithStreamSink(context.Background(), "writer", func(text string) { tokens = append(tokens, text) })
+
+	// A provider without streaming hands over the whole text at once.
+	if _, err := NewBaseAgent("writer", &stubProvider{text: "一度に届く本文。"}).Generate(ctx, "s", "u", GenerateParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	streaming := &stubProvider{text: "少しずつ届く本文。", caps: ProviderCapabilities{SupportsStreaming: true}}
+	if _, err := NewBaseAgent("checker", streaming).Generate(ctx, "s", "u", GenerateParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0] != "一度に届く本文。" {
+		t.Fatalf("expected only the writer's text, in one piece, got %q", tokens)
+	}
+
+	tokens = nil
+	result, err := NewBaseAgent("writer", streaming).Generate(ctx, "s", "u", GenerateParams{})
+	if err != nil || result.Text != "少しずつ届く本文。" || len(tokens) != 2 {
+		t.Fatalf("expected the text in two chunks, got %q, %v, %v", tokens, result, err)
+	}
+}
+
+func TestCheckerCapsIssues(t *testing.T) {
+	var raw []string
+	for i := 0; i < 300; i++ {
+		severity := "info"
+		switch {
+		case i%100 == 0:
+			severity = "error"
+		case i%10 == 0:
+			severity = "warning"
+		}
+		raw = append(raw, fmt.Sprintf(`{"category":"fact","severity":"%s","description":"d%d"}`, severity, i))
+	}
+	// A repeated issue is dropped before the cap applies.
+	raw = append(raw, `{"category":"fact","severity":"info","description":"d1"}`)
+	provider := &stubProvider{text: "[" + strings.Join(raw, ",") + "]"}
+	checker := NewCheckerAgent(AgentConfig{Provider: provider})
+	checker.maxIssues = 10
+
+	result, err := checker.Check(context.Background(), &CheckerInput{Text: "本文"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Truncated || result.TotalIssues != 300 || len(result.Issues) != 10 {
+		t.Fatalf("expected 10 of 300 issues, got %d of %d (truncated=%v)", len(result.Issues), result.TotalIssues, result.Truncated)
+	}
+	for i, want := range []string{"d0", "d10", "d20", "d30", "d40", "d50", "d60", "d70", "d100", "d200"} {
+		issue := result.Issues[i]
+		if issue.Description != want {
+			t.Fatalf("expected the most severe issues in order, got %+v", result.Issues)
+		}
+	}
+	checker.maxIssues = 0
+	result, err = checker.Check(context.Background(), &CheckerInput{Text: "本文"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Truncated || len(result.Issues) != 300 {
+		t.Fatalf("expected no cap, got %d issues (truncated=%v)", len(result.Issues), result.Truncated)
+	}
+}
+
+func TestCheckerRetriesUnparseableResponses(t *testing.T) {
+	provider := NewMockProviderWithConfig(MockConfig{
+		Seed:      1,
+		Responses: []string{"問題はありません。", `[{"category":"fact","severity":"warning","description":"f"}]`},
+	})
+	checker := NewCheckerAgent(AgentConfig{Provider: provider})
+
+	result, err := checker.Check(context.Background(), &CheckerInput{Text: "本文"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Checked || result.Attempts != 2 || len(result.Issues) != 1 || result.Issues[0].Category != "fact" {
+		t.Fatalf("expected the retry to parse, got %+v", result)
+	}
+
+	provider = NewMockProviderWithConfig(MockConfig{Seed: 1, Responses: []string{"OK", "All good"}})
+	checker = NewCheckerAgent(AgentConfig{Provider: provider})
+	result, err = checker.Check(context.Background(), &CheckerInput{Text: "本文"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Checked || len(result.Issues) != 1 || result.Issues[0].Severity != "info" || result.ParseError == "" {
+		t.Fatalf("expected an inconclusive result with an info issue, got %+v", result)
+	}
+}
+
+// promptRecordingProvider records every user prompt and reports one issue
+// per call, numbered by call.
+type promptRecordingProvider struct {
+	stubProvider
+	prompts []string
+}
+
+func (p *promptRecordingProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	p.prompts = append(p.prompts, messages[len(messages)-1].Content)
+	text := fmt.Sprintf(`[{"category":"fact","severity":"warning","description":"chunk %d"}]`, len(p.prompts))
+	return &models.GenerationResult{Text: text}, nil
+}
+
+func TestCheckerChunksLongText(t *testing.T) {
+	sentence := strings.Repeat("雨", 29) + "。"
+	text := strings.Repeat(sentence, 40)
+	provider := &promptRecordingProvider{stubProvider: stubProvider{caps: ProviderCapabilities{CtxLen: 1000}}}
+	checker := NewCheckerAgent(AgentConfig{Provider: provider})
+
+	result, err := checker.Check(context.Background(), &CheckerInput{Text: text})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(provider.prompts) < 2 {
+		t.Fatalf("expected the text checked in chunks, got %d calls", len(provider.prompts))
+	}
+	if !result.Checked || result.Attempts != len(provider.prompts) || len(result.Issues) != len(provider.prompts) {
+		t.Fatalf("expected one merged issue per chunk, got %+v", result)
+	}
+	for _, prompt := range provider.prompts {
+		if strings.Contains(prompt, text) || !strings.Contains(prompt, sentence) {
+			t.Fatalf("expected each prompt to hold a whole-sentence chunk, got %q", prompt)
+		}
+	}
+
+	// Japanese chunks leave room for the completion at about a token per
+	// character.
+	provider = &promptRecordingProvider{stubProvider: stubProvider{caps: ProviderCapabilities{CtxLen: 3000}}}
+	checker = NewCheckerAgent(AgentConfig{Provider: provider})
+	if _, err := checker.Check(context.Background(), &CheckerInput{Text: strings.Repeat(sentence, 200)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, prompt := range provider.prompts {
+		if tokens := estimateProseTokens(prompt, "ja"); tokens > 2000 {
+			t.Fatalf("expected each prompt within the context less the completion, got %d tokens", tokens)
+		}
+	}
+
+	// Without a known context length the default chunk size applies.
+	provider = &promptRecordingProvider{}
+	checker = NewCheckerAgent(AgentConfig{Provider: provider})
+	if _, err := checker.Check(context.Background(), &CheckerInput{Text: text}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(provider.prompts) != 1 || !strings.Contains(provider.prompts[0], text) {
+		t.Fatalf("expected the whole text in one check, got %d calls", len(provider.prompts))
+	}
+}
+
+func TestSplitTextChunks(t *testing.T) {
+	chunks := splitTextChunks("一文目です。二文目。三文目です", 8)
+	want := []string{"一文目です。", "二文目。三文目で", "す"}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %q, got %q", want, chunks)
+	}
+	if chunks := splitTextChunks("短い", 8); len(chunks) != 1 || chunks[0] != "短い" {
+		t.Fatalf("expected short text in one chunk, got %q", chunks)
+	}
+}
+
+func TestParseIssues(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    int
+		wantErr bool
+	}{
+		{"array", `[{"category":"fact","severity":"warning","description":"a"}]`, 1, false},
+		{"empty array", "[]", 0, false},
+		{"single object", `{"category":"pov","severity":"error","description":"b"}`, 1, false},
+		{"array in prose", "確認しました [注意] 結果:\n```json\n[{\"category\":\"fact\",\"severity\":\"info\",\"description\":\"c\"}]\n```\n以上です [終]", 1, false},
+		{"prose only", "問題はありません。", 0, true},
+		{"null", "null", 0, true},
+		{"empty", "  ", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := parseIssues(tt.text)
+			if (err != nil) != tt.wantErr || len(issues) != tt.want {
+				t.Fatalf("expected %d issues (error %v), got %+v, %v", tt.want, tt.wantErr, issues, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+const (
+	defaultAnthropicBaseURL   = "https://api.anthropic.com"
+	anthropicAPIVersion       = "2023-06-01"
+	defaultAnthropicMaxTokens = 1024
+)
+
+type anthropicProvider struct {
+	model   string
+	apiKey  string
+	apiRoot string
+	client  *http.Client
+	retry   models.RetryConfig
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature,omitempty"`
+	TopP        float64            `json:"top_p,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func init() {
+	RegisterProviderFactory(models.ProviderTypeAnthropic, NewAnthropicProvider)
+}
+
+// NewAnthropicProvider creates a provider backed by the Anthropic Messages
+// API.
+func NewAnthropicProvider(config models.ProviderConfig) (Provider, error) {
+	if strings.TrimSpace(config.Model) == "" {
+		return nil, fmt.Errorf("anthropic provider requires model")
+	}
+
+	baseURL := strings.TrimRight(strings.TrimSpace(config.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultAnthropicBaseURL
+	}
+
+	apiKeyEnv := strings.TrimSpace(config.APIKeyEnv)
+	if apiKeyEnv == "" {
+		apiKeyEnv = models.DefaultAPIKeyEnv(models.ProviderTypeAnthropic)
+	}
+	apiKey := strings.TrimSpace(os.Getenv(apiKeyEnv))
+	if apiKey == "" {
+		return nil, fmt.Errorf("anthropic API key is missing in env %s", apiKeyEnv)
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+
+	return &anthropicProvider{
+		model:   config.Model,
+		apiKey:  apiKey,
+		apiRoot: anthropicAPIRoot(baseURL),
+		client:  newProviderClient(timeout),
+		retry:   config.Retry,
+	}, nil
+}
+
+// anthropicAPIRoot accepts base URLs with or without the /v1 suffix.
+func anthropicAPIRoot(baseURL string) string {
+	if strings.HasSuffix(baseURL, "/v1") {
+		return baseURL
+	}
+	return baseURL + "/v1"
+}
+
+func (p *anthropicProvider) Name() string {
+	return "anthropic"
+}
+
+func (p *anthropicProvider) retryConfig() models.RetryConfig {
+	return p.retry
+}
+
+func (p *anthropicProvider) modelName() string {
+	return p.model
+}
+
+func (p *anthropicProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	payload := anthropicRequest{
+		Model:       p.model,
+		MaxTokens:   params.MaxTokens,
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+	}
+	if payload.MaxTokens <= 0 {
+		payload.MaxTokens = defaultAnthropicMaxTokens
+	}
+	// The system prompt is a top-level field, not a message role.
+	var system []string
+	for _, message := range messages {
+		if message.Role == "system" {
+			system = append(system, message.Content)
+			continue
+		}
+		payload.Messages = append(payload.Messages, anthropicMessage{Role: message.Role, Content: message.Content})
+	}
+	payload.System = strings.Join(system, "\n\n")
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal anthropic request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiRoot+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build anthropic request: %w", err)
+	}
+	p.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading anthropic response: %w", err)
+	}
+
+	var out anthropicResponse
+	decodeErr := json.Unmarshal(raw, &out)
+
+	// Check the status first: gateways answer 5xx with non-JSON pages.
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg := strings.TrimSpace(string(raw))
+		if decodeErr == nil && out.Error != nil && out.Error.Message != "" {
+			msg = out.Error.Type + ": " + out.Error.Message
+		}
+		return nil, &ProviderHTTPError{
+			Provider:   "anthropic",
+			StatusCode: resp.StatusCode,
+			Message:    msg,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode anthropic response: %w", decodeErr)
+	}
+
+	var text strings.Builder
+	for _, block := range out.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	result := &models.GenerationResult{
+		Text:             strings.TrimSpace(text.String()),
+		PromptTokens:     out.Usage.InputTokens,
+		CompletionTokens: out.Usage.OutputTokens,
+		FinishReason:     anthropicFinishReason(out.StopReason),
+	}
+	if result.PromptTokens <= 0 {
+		result.PromptTokens = estimateTokensFromMessages(messages)
+	}
+	if result.CompletionTokens <= 0 {
+		result.CompletionTokens = estimateTokensFromText(result.Text)
+	}
+	return result, nil
+}
+
+// anthropicFinishReason maps stop reasons onto the OpenAI names the
+// pipeline checks, so "length" still means the output hit max_tokens.
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "end_turn", "stop_sequence":
+		return "stop"
+	default:
+		return stopReason
+	}
+}
+
+// GenerateStream delivers a completed generation in chunks; Anthropic
+// streaming is not wired up yet.
+func (p *anthropicProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	return generateAsStream(ctx, p.Generate, messages, params)
+}
+
+// Capabilities reports Claude's context window. JSON mode is off: Claude
+// has no response_format, so JSON is requested in the prompt instead.
+// Tools are off until tool_use blocks are mapped. Temperatures run from 0
+// to 1.
+func (p *anthropicProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		CtxLen:            200000,
+		SupportsTools:     false,
+		SupportsJSONMode:  false,
+		SupportsThinking:  false,
+		SupportsStreaming: false,
+		MaxTemperature:    1,
+	}
+}
+
+// Embed is unsupported: the Messages API has no embeddings endpoint.
+func (p *anthropicProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, ErrEmbeddingsUnsupported
+}
+
+// HealthCheck lists models to confirm the API is reachable and the key is
+// accepted.
+func (p *anthropicProvider) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiRoot+"/models", nil)
+	if err != nil {
+		return err
+	}
+	p.setHeaders(req)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("anthropic health check failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func (p *anthropicProvider) setHeaders(req *http.Request) {
+	req.Header.Set("x-api-key", p.apiKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+}
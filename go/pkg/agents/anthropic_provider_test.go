@@ -0,0 +1,82 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func newTestAnthropicProvider(t *testing.T, baseURL string) Provider {
+	t.Helper()
+	t.Setenv("NOVELIST_TEST_ANTHROPIC_KEY", "test-key")
+	provider, err := CreateProvider(models.ProviderConfig{
+		Type:      "anthropic",
+		Model:     "claude-sonnet",
+		BaseURL:   baseURL,
+		APIKeyEnv: "NOVELIST_TEST_ANTHROPIC_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return provider
+}
+
+func TestAnthropicGenerate(t *testing.T) {
+	var got anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != anthropicAPIVersion {
+			t.Errorf("missing auth or version headers: %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"content":[{"type":"text","text":" 雨が降る。 "}],"stop_reason":"max_tokens","usage":{"input_tokens":21,"output_tokens":7}}`))
+	}))
+	defer server.Close()
+
+	provider := newTestAnthropicProvider(t, server.URL)
+	result, err := provider.Generate(context.Background(), []Message{
+		{Role: "system", Content: "あなたは作家です。"},
+		{Role: "user", Content: "書いてください。"},
+	}, GenerateParams{Temperature: 0.7})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.System != "あなたは作家です。" || len(got.Messages) != 1 || got.Messages[0].Role != "user" {
+		t.Fatalf("expected the system prompt split out of messages, got %+v", got)
+	}
+	if got.MaxTokens != defaultAnthropicMaxTokens {
+		t.Fatalf("expected the default max_tokens, got %d", got.MaxTokens)
+	}
+	if result.Text != "雨が降る。" || result.PromptTokens != 21 || result.CompletionTokens != 7 || result.FinishReason != "length" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if caps := provider.Capabilities(); caps.CtxLen != 200000 || caps.SupportsJSONMode || caps.SupportsTools {
+		t.Fatalf("unexpected capabilities %+v", caps)
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"not_found_error","message":"model: claude-retired"}}`))
+	}))
+	defer server.Close()
+
+	_, err := newTestAnthropicProvider(t, server.URL+"/v1").Generate(context.Background(), []Message{{Role: "user", Content: "hi"}}, GenerateParams{})
+	httpErr, ok := err.(*ProviderHTTPError)
+	if !ok || httpErr.StatusCode != http.StatusNotFound || httpErr.Message != "not_found_error: model: claude-retired" {
+		t.Fatalf("expected a provider HTTP error, got %v", err)
+	}
+	if !IsModelUnavailable(err) {
+		t.Fatal("expected a missing model to be classified as unavailable")
+	}
+}
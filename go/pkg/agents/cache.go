@@ -0,0 +1,258 @@
+package agents
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+const (
+	defaultCacheTTLSec         = 600
+	defaultCacheMaxEntries     = 1000
+	defaultCacheMaxTemperature = 0.5
+)
+
+// ResponseCache stores generation results keyed by prompt, with a TTL and a
+// bounded number of entries. It is safe for concurrent use.
+type ResponseCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]cacheEntry
+	order      []string
+
+	maxTemperature float64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheEntry struct {
+	result    models.GenerationResult
+	expiresAt time.Time
+}
+
+// NewResponseCache builds a cache from config. It returns nil when caching
+// is disabled.
+func NewResponseCache(config models.ResponseCacheConfig) *ResponseCache {
+	if !config.Enabled {
+		return nil
+	}
+	ttl := time.Duration(config.TTLSec) * time.Second
+	if ttl <= 0 {
+		ttl = defaultCacheTTLSec * time.Second
+	}
+	maxEntries := config.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	maxTemperature := config.MaxTemperature
+	if maxTemperature <= 0 {
+		maxTemperature = defaultCacheMaxTemperature
+	}
+	return &ResponseCache{
+		ttl:            ttl,
+		maxEntries:     maxEntries,
+		entries:        make(map[string]cacheEntry),
+		maxTemperature: maxTemperature,
+	}
+}
+
+func (c *ResponseCache) get(key string, now time.Time) (models.GenerationResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		delete(c.entries, key)
+		return models.GenerationResult{}, false
+	}
+	return entry.result, true
+}
+
+func (c *ResponseCache) put(key string, result models.GenerationResult, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = cacheEntry{result: result, expiresAt: now.Add(c.ttl)}
+
+	// Evict oldest insertions; keys already expired or replaced are skipped.
+	for len(c.entries) > c.maxEntries && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.entries, oldest)
+	}
+	if len(c.order) > 2*c.maxEntries {
+		live := c.order[:0]
+		for _, k := range c.order {
+			if _, ok := c.entries[k]; ok {
+				live = append(live, k)
+			}
+		}
+		c.order = live
+	}
+}
+
+// Len returns the number of cached entries, including expired ones not yet
+// evicted.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Purge drops every entry, keeping the hit and miss counts. Entries are
+// keyed by provider name, so a reload that changes what a name points to
+// must purge them.
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.order = nil
+}
+
+// Hits returns the number of cache hits.
+func (c *ResponseCache) Hits() int {
+	return int(c.hits.Load())
+}
+
+// Misses returns the number of cacheable lookups that missed.
+func (c *ResponseCache) Misses() int {
+	return int(c.misses.Load())
+}
+
+// HitRate returns hits / (hits + misses), or 0 before any lookup.
+func (c *ResponseCache) HitRate() float64 {
+	hits, misses := c.hits.Load(), c.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// cachingProvider serves low-temperature generations from a ResponseCache.
+type cachingProvider struct {
+	Provider
+	name  string
+	cache *ResponseCache
+}
+
+// WrapResponseCache wraps each agent's provider with cache. The configured
+// provider name is part of the key, so providers with different models
+// never share entries. A nil cache leaves configs unchanged.
+func WrapResponseCache(configs map[string]AgentConfig, cache *ResponseCache) {
+	if cache == nil {
+		return
+	}
+	for agentName, config := range configs {
+		if config.Provider == nil {
+			continue
+		}
+		wrap := func(name string, member Provider) Provider {
+			return &cachingProvider{Provider: member, name: name, cache: cache}
+		}
+		switch group := config.Provider.(type) {
+		case *FallbackProvider:
+			config.Provider = group.mapMembers(wrap)
+		case *WeightedRouterProvider:
+			config.Provider = group.mapMembers(wrap)
+		default:
+			config.Provider = wrap(primaryProviderName(config), config.Provider)
+		}
+		configs[agentName] = config
+	}
+}
+
+// Unwrap returns the cached provider.
+func (p *cachingProvider) Unwrap() Provider {
+	return p.Provider
+}
+
+// Generate returns a cached result for identical low-temperature requests.
+func (p *cachingProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	if params.Temperature > p.cache.maxTemperature || cacheBypassed(ctx) {
+		return p.Provider.Generate(ctx, messages, params)
+	}
+
+	key, err := cacheKey(p.name, messages, params)
+	if err != nil {
+		return p.Provider.Generate(ctx, messages, params)
+	}
+
+	if cached, ok := p.cache.get(key, time.Now()); ok {
+		p.cache.hits.Add(1)
+		cached.Cached = true
+		return &cached, nil
+	}
+	p.cache.misses.Add(1)
+
+	result, err := p.Provider.Generate(ctx, messages, params)
+	if err != nil {
+		return nil, err
+	}
+	p.cache.put(key, *result, time.Now())
+	return result, nil
+}
+
+func cacheKey(providerName string, messages []Message, params GenerateParams) (string, error) {
+	raw, err := json.Marshal(struct {
+		Provider string         `json:"provider"`
+		Messages []Message      `json:"messages"`
+		Params   GenerateParams `json:"params"`
+	}{providerName, messages, params})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type cacheBypassKey struct{}
+
+// WithCacheBypass makes generations under ctx skip the response cache.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// cacheHitRecorder collects the agents whose generations were served from
+// the cache during one request.
+type cacheHitRecorder struct {
+	mu     sync.Mutex
+	agents []string
+}
+
+type cacheHitRecorderKey struct{}
+
+func withCacheHitRecorder(ctx context.Context) (context.Context, *cacheHitRecorder) {
+	recorder := &cacheHitRecorder{}
+	return context.WithValue(ctx, cacheHitRecorderKey{}, recorder), recorder
+}
+
+func recordCacheHit(ctx context.Context, agent string) {
+	recorder, ok := ctx.Value(cacheHitRecorderKey{}).(*cacheHitRecorder)
+	if !ok {
+		return
+	}
+	recorder.mu.Lock()
+	recorder.agents = append(recorder.agents, agent)
+	recorder.mu.Unlock()
+}
+
+func (r *cacheHitRecorder) hits() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.agents...)
+}
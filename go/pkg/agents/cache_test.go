@@ -0,0 +1,74 @@
+package agents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// countingProvider counts calls and returns a fixed text.
+type countingProvider struct {
+	stubProvider
+	calls int
+}
+
+func (p *countingProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	p.calls++
+	return &models.GenerationResult{Text: "ok", PromptTokens: 10}, nil
+}
+
+func TestResponseCacheServesLowTemperatureRepeats(t *testing.T) {
+	cache := NewResponseCache(models.ResponseCacheConfig{Enabled: true, MaxTemperature: 0.5})
+	inner := &countingProvider{}
+	configs := map[string]AgentConfig{"checker": {Provider: inner, ProviderChain: []string{"local"}}}
+	WrapResponseCache(configs, cache)
+	provider := configs["checker"].Provider
+
+	messages := []Message{{Role: "user", Content: "check this"}}
+	low := GenerateParams{Temperature: 0.2}
+
+	first, _ := provider.Generate(context.Background(), messages, low)
+	second, _ := provider.Generate(context.Background(), messages, low)
+	if inner.calls != 1 || first.Cached || !second.Cached {
+		t.Fatalf("expected second call served from cache, calls=%d", inner.calls)
+	}
+
+	_, _ = provider.Generate(context.Background(), messages, GenerateParams{Temperature: 0.8})
+	_, _ = provider.Generate(WithCacheBypass(context.Background()), messages, low)
+	if inner.calls != 3 {
+		t.Fatalf("expected creative and bypassed calls to reach the provider, calls=%d", inner.calls)
+	}
+
+	if cache.Hits() != 1 || cache.Misses() != 1 || cache.HitRate() != 0.5 {
+		t.Fatalf("unexpected cache counters: hits=%d misses=%d", cache.Hits(), cache.Misses())
+	}
+}
+
+func TestResponseCacheExpiresAndEvicts(t *testing.T) {
+	cache := NewResponseCache(models.ResponseCacheConfig{Enabled: true, TTLSec: 60, MaxEntries: 2})
+	now := time.Now()
+
+	cache.put("a", models.GenerationResult{Text: "a"}, now)
+	cache.put("b", models.GenerationResult{Text: "b"}, now)
+	cache.put("c", models.GenerationResult{Text: "c"}, now)
+	if _, ok := cache.get("a", now); ok {
+		t.Fatal("expected oldest entry evicted")
+	}
+	if _, ok := cache.get("c", now); !ok {
+		t.Fatal("expected newest entry cached")
+	}
+	if _, ok := cache.get("c", now.Add(2*time.Minute)); ok {
+		t.Fatal("expected entry to expire after TTL")
+	}
+
+	cache.Purge()
+	if _, ok := cache.get("b", now); ok || cache.Len() != 0 {
+		t.Fatal("expected purge to drop every entry")
+	}
+
+	if NewResponseCache(models.ResponseCacheConfig{}) != nil {
+		t.Fatal("expected nil cache when disabled")
+	}
+}
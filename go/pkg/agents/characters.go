@@ -0,0 +1,173 @@
+package agents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+const maxCharacterIDLength = 64
+
+// ErrCharacterExists is returned when saving over an existing character
+// without overwrite.
+var ErrCharacterExists = errors.New("character already exists")
+
+// CharacterStore holds the story's characters by ID.
+type CharacterStore struct {
+	mu         sync.RWMutex
+	characters map[string]models.Character
+}
+
+// NewCharacterStore creates an empty character store.
+func NewCharacterStore() *CharacterStore {
+	return &CharacterStore{
+		characters: make(map[string]models.Character),
+	}
+}
+
+// Put validates and stores a character. It reports whether an existing
+// character was replaced, and fails with ErrCharacterExists unless overwrite
+// is set.
+func (s *CharacterStore) Put(character models.Character, overwrite bool) (replaced bool, err error) {
+	character.ID = strings.TrimSpace(character.ID)
+	if err := ValidateCharacter(character); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, replaced = s.characters[character.ID]
+	if replaced && !overwrite {
+		return false, fmt.Errorf("%w: %s", ErrCharacterExists, character.ID)
+	}
+	s.characters[character.ID] = character
+	return replaced, nil
+}
+
+// Get returns a character by ID.
+func (s *CharacterStore) Get(id string) (models.Character, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	character, ok := s.characters[id]
+	return character, ok
+}
+
+// List returns all characters ordered by ID.
+func (s *CharacterStore) List() []models.Character {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	characters := make([]models.Character, 0, len(s.characters))
+	for _, character := range s.characters {
+		characters = append(characters, character)
+	}
+	sort.Slice(characters, func(i, j int) bool {
+		return characters[i].ID < characters[j].ID
+	})
+	return characters
+}
+
+// LoadDir loads characters/*.json from a project directory, replacing
+// stored characters with the same ID. A character without an ID takes the
+// file name's stem. Files that fail to parse or validate are logged and
+// skipped; a missing characters directory loads nothing.
+func (s *CharacterStore) LoadDir(root string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "characters", "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(paths)
+
+	loaded := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return loaded, fmt.Errorf("read %s: %w", path, err)
+		}
+		var character models.Character
+		if err := json.Unmarshal(data, &character); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Skipping unparseable character file")
+			continue
+		}
+		if strings.TrimSpace(character.ID) == "" {
+			character.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		if _, err := s.Put(character, true); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Skipping invalid character file")
+			continue
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// Find returns the characters matching names by ID, full or short name, or
+// alias, in the order of names and without repeats.
+func (s *CharacterStore) Find(names ...string) []models.Character {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.characters))
+	for id := range s.characters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var found []models.Character
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		for _, id := range ids {
+			if character := s.characters[id]; !seen[id] && characterMatches(character, name) {
+				seen[id] = true
+				found = append(found, character)
+			}
+		}
+	}
+	return found
+}
+
+func characterMatches(character models.Character, name string) bool {
+	if character.ID == name || character.Name.Full == name || character.Name.Short == name {
+		return true
+	}
+	for _, alias := range character.Name.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCharacter checks the fields a character needs to be usable.
+func ValidateCharacter(character models.Character) error {
+	id := strings.TrimSpace(character.ID)
+	if id == "" {
+		return errors.New("id is required")
+	}
+	if utf8.RuneCountInString(id) > maxCharacterIDLength {
+		return fmt.Errorf("id must be %d characters or less", maxCharacterIDLength)
+	}
+	if strings.TrimSpace(character.Name.Full) == "" {
+		return errors.New("name.full is required")
+	}
+	for _, word := range character.Language.ForbiddenWords {
+		if strings.TrimSpace(word) == "" {
+			return errors.New("language.forbidden_words must not contain empty entries")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package agents
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestCharacterStorePut(t *testing.T) {
+	store := NewCharacterStore()
+	hero := models.Character{ID: "hero", Name: models.CharacterName{Full: "Aoi Tachibana"}}
+
+	replaced, err := store.Put(hero, false)
+	if err != nil || replaced {
+		t.Fatalf("first put: replaced=%v err=%v", replaced, err)
+	}
+
+	_, err = store.Put(hero, false)
+	if !errors.Is(err, ErrCharacterExists) {
+		t.Fatalf("expected ErrCharacterExists, got %v", err)
+	}
+
+	hero.Name.Short = "Aoi"
+	replaced, err = store.Put(hero, true)
+	if err != nil || !replaced {
+		t.Fatalf("overwrite put: replaced=%v err=%v", replaced, err)
+	}
+	if got, _ := store.Get("hero"); got.Name.Short != "Aoi" {
+		t.Fatalf("expected overwritten character, got %+v", got)
+	}
+}
+
+func TestValidateCharacter(t *testing.T) {
+	cases := []struct {
+		name      string
+		character models.Character
+		wantErr   bool
+	}{
+		{"valid", models.Character{ID: "a", Name: models.CharacterName{Full: "A"}}, false},
+		{"missing id", models.Character{Name: models.CharacterName{Full: "A"}}, true},
+		{"missing name", models.Character{ID: "a"}, true},
+		{
+			"empty forbidden word",
+			models.Character{
+				ID:       "a",
+				Name:     models.CharacterName{Full: "A"},
+				Language: models.CharacterLanguage{ForbiddenWords: []string{" "}},
+			},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		err := ValidateCharacter(tc.character)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: err=%v, wantErr=%v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCharacterStoreLoadDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "characters")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"aoi.json":    `{"_meta": {"version": 1}, "name": {"full": "立花葵", "short": "葵", "aliases": ["タチバナ"]}}`,
+		"ren.json":    `{"id": "ren", "name": {"full": "蓮"}}`,
+		"broken.json": `{"id": `,
+		"noname.json": `{"id": "noname"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewCharacterStore()
+	loaded, err := store.LoadDir(root)
+	if err != nil || loaded != 2 {
+		t.Fatalf("expected 2 characters loaded, got %d (err=%v)", loaded, err)
+	}
+	if _, ok := store.Get("aoi"); !ok {
+		t.Fatal("expected the file stem as the ID of a character without one")
+	}
+
+	found := store.Find("タチバナ", "蓮", "葵", "unknown")
+	if len(found) != 2 || found[0].ID != "aoi" || found[1].ID != "ren" {
+		t.Fatalf("expected aoi then ren, got %+v", found)
+	}
+
+	if loaded, err := NewCharacterStore().LoadDir(t.TempDir()); err != nil || loaded != 0 {
+		t.Fatalf("expected a project without characters to load nothing, got %d (err=%v)", loaded, err)
+	}
+}
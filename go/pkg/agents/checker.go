@@ -3,81 +3,592 @@ package agents
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
 )
 
+// CheckCategories lists the checker's issue categories, in prompt order.
+var CheckCategories = []string{"world", "character", "pov", "fact"}
+
+// IsValidCheckCategory reports whether c is one of CheckCategories.
+func IsValidCheckCategory(c string) bool {
+	return containsString(CheckCategories, c)
+}
+
 // CheckerInput represents input for checker
 type CheckerInput struct {
-	Text         string
-	Chapter      int
-	Scene        int
-	POVCharacter string
+	Text              string
+	Chapter           int
+	Scene             int
+	POVCharacter      string
+	CharactersPresent []string
+	// Characters are the profiles of the POV and present characters. Their
+	// forbidden words are linted before the LLM check, and their first
+	// person and tone are given to it.
+	Characters []models.Character
+	// Categories restricts the checks to these categories. Empty means all.
+	Categories []string
+	// Language selects the prompt templates; empty means
+	// DefaultPromptLanguage.
+	Language string
+}
+
+// CheckerResult is the outcome of a check.
+type CheckerResult struct {
+	Issues []models.Issue
+	// Checked is false when no checker response could be parsed, so the
+	// absence of issues means nothing. ParseError then says why the last
+	// response was rejected.
+	Checked    bool
+	ParseError string
+	// Attempts counts the checker generations made.
+	Attempts int
+	// Truncated is set when Issues was capped at maxIssues; TotalIssues is
+	// the count before capping.
+	Truncated   bool
+	TotalIssues int
+}
+
+const (
+	defaultCheckerParseRetries = 1
+	defaultCheckerMaxIssues    = 50
+)
+
+// CheckerMaxTextChars is the checker's chunk size, in runes, when the
+// provider's context length is unknown.
+const CheckerMaxTextChars = 2000
+
+// minCheckerChunkChars is the smallest chunk the checker splits text
+// into, however little room the context window leaves.
+const minCheckerChunkChars = 200
+
+// reportIssuesToolName is the tool checkers with tool support report
+// issues through.
+const reportIssuesToolName = "report_issues"
+
+// reportIssuesTool defines the report_issues tool, whose arguments are
+// {"issues": [...]} with each issue shaped like models.Issue.
+func reportIssuesTool(categories []string, description string) ToolDefinition {
+	return ToolDefinition{
+		Name:        reportIssuesToolName,
+		Description: description,
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"issues": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"category":    map[string]any{"type": "string", "enum": categories},
+							"severity":    map[string]any{"type": "string", "enum": []string{"error", "warning", "info"}},
+							"description": map[string]any{"type": "string"},
+						},
+						"required": []string{"category", "severity", "description"},
+					},
+				},
+			},
+			"required": []string{"issues"},
+		},
+	}
 }
 
 // CheckerAgent checks for issues
 type CheckerAgent struct {
 	*BaseAgent
+
+	// parseRetries is how many times an unparseable response is retried.
+	parseRetries int
+	// maxIssues caps the issues returned after deduplication. Zero
+	// disables the cap.
+	maxIssues int
 }
 
 // NewCheckerAgent creates a new checker agent
 func NewCheckerAgent(config AgentConfig) *CheckerAgent {
 	return &CheckerAgent{
-		BaseAgent: NewBaseAgent("checker", config.Provider),
+		BaseAgent:    newConfiguredAgent("checker", config),
+		parseRetries: defaultCheckerParseRetries,
+		maxIssues:    defaultCheckerMaxIssues,
 	}
 }
 
-// Check checks text for issues
-func (a *CheckerAgent) Check(ctx context.Context, input *CheckerInput) ([]models.Issue, error) {
-	systemPrompt := `あなたは小説の設定・矛盾チェッカーです。
-文章を客観的に分析し、問題点をJSON形式で出力してください。`
+// Check checks text for issues. Providers with tool support report them
+// through the report_issues tool; otherwise, or when the model answers in
+// text anyway, the text is parsed as a JSON array. A response that parses
+// neither way is retried up to parseRetries times; if none parse, the
+// result is returned with Checked false and an info issue instead of
+// passing the scene.
+func (a *CheckerAgent) Check(ctx context.Context, input *CheckerInput) (*CheckerResult, error) {
+	selected := selectCheckCategories(input.Categories)
+	params := GenerateParams{
+		Temperature: a.temperatureOr(0.2),
+		MaxTokens:   a.maxTokensOr(1000),
+		Tools:       []ToolDefinition{reportIssuesTool(selectedCategories(selected), a.promptsFor(input.Language).render("tool_description", nil))},
+		ToolChoice:  reportIssuesToolName,
+	}
+
+	prompts := a.promptsFor(input.Language)
+	result := &CheckerResult{Checked: true}
+	var issues []models.Issue
+	if selected["character"] {
+		issues = lintCharacters(input.Text, input.POVCharacter, input.Characters, prompts)
+	}
 
-	userPrompt := fmt.Sprintf(`チェック対象の文章:
-%s
+	chunks := splitTextChunks(input.Text, a.chunkChars(ctx, input, selected, params.MaxTokens))
+	if len(chunks) > 1 {
+		log.Info().Int("chunks", len(chunks)).Msg("Checking a long text in chunks")
+	}
+	for _, chunk := range chunks {
+		parsed, err := a.checkChunk(ctx, input, chunk, selected, params, result)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, parsed...)
+	}
 
-以下の点をチェックし、問題があればJSON配列で出力：
-1. 設定矛盾（世界観、技術水準）
-2. キャラクター逸脱（口調、価値観）
-3. 視点違反
-4. 事実矛盾
+	if len(input.Categories) > 0 {
+		// Drop anything outside the requested categories.
+		filtered := issues[:0]
+		for _, issue := range issues {
+			if selected[issue.Category] {
+				filtered = append(filtered, issue)
+			}
+		}
+		issues = filtered
+	}
 
-問題がなければ空配列 [] を返してください。
+	if !result.Checked {
+		log.Warn().
+			Int("attempts", result.Attempts).
+			Str("parse_error", result.ParseError).
+			Msg("Checker output unparseable, the scene was not verified")
+		issues = append(issues, models.Issue{
+			Category:    "checker",
+			Severity:    "info",
+			Description: prompts.render("unchecked_issue", result.Attempts),
+		})
+	}
 
-出力形式:
-[
-  {
-    "category": "fact|character|world|pov",
-    "severity": "error|warning|info",
-    "description": "問題の説明"
-  }
-]`,
-		input.Text[:min(len(input.Text), 2000)],
-	)
+	if selected["pov"] {
+		if issue := povPresenceIssue(input.POVCharacter, input.CharactersPresent, prompts); issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
 
-	params := GenerateParams{
-		Temperature: 0.2,
-		MaxTokens:   1000,
+	issues = dedupIssues(issues)
+	result.TotalIssues = len(issues)
+	if a.maxIssues > 0 && len(issues) > a.maxIssues {
+		log.Warn().
+			Int("issues", len(issues)).
+			Int("max_issues", a.maxIssues).
+			Msg("Checker returned too many issues, keeping the most severe")
+		issues = capIssues(issues, a.maxIssues)
+		result.Truncated = true
+	}
+	result.Issues = issues
+	return result, nil
+}
+
+// checkChunk checks one chunk of input's text, retrying unparseable
+// responses, and records its attempts on result. A chunk whose responses
+// never parse leaves result unchecked and contributes no issues.
+func (a *CheckerAgent) checkChunk(ctx context.Context, input *CheckerInput, chunk string, selected map[string]bool, params GenerateParams, result *CheckerResult) ([]models.Issue, error) {
+	prompts := a.promptsFor(input.Language)
+	userPrompt := a.checkPrompt(input, chunk, selected)
+	for attempt := 0; attempt <= a.parseRetries; attempt++ {
+		if attempt > 0 {
+			log.Warn().Int("attempt", attempt+1).Msg("Checker response was not JSON, retrying with a stricter instruction")
+			userPrompt += "\n\n" + prompts.render("strict_json", nil)
+			params.Temperature = 0
+		}
+		generation, err := a.Generate(ctx, prompts.System, userPrompt, params)
+		if err != nil {
+			return nil, err
+		}
+		result.Attempts++
+		parsed, err := parseCheckerResponse(generation)
+		if err == nil {
+			return parsed, nil
+		}
+		result.ParseError = err.Error()
+	}
+	result.Checked = false
+	return nil, nil
+}
+
+// chunkChars returns how many characters of text fit in one check: the
+// provider's context length less the prompt around the text and the
+// completion budget, at the language's tokens per character, or
+// CheckerMaxTextChars when the length is unknown.
+func (a *CheckerAgent) chunkChars(ctx context.Context, input *CheckerInput, selected map[string]bool, maxTokens int) int {
+	provider := a.providerFor(ctx)
+	if provider == nil {
+		return CheckerMaxTextChars
+	}
+	ctxLen := provider.Capabilities().CtxLen
+	if ctxLen <= 0 {
+		return CheckerMaxTextChars
+	}
+	prompts := a.promptsFor(input.Language)
+	overhead := estimateProseTokens(prompts.System, input.Language) +
+		estimateProseTokens(a.checkPrompt(input, "", selected), input.Language) +
+		estimateProseTokens(prompts.render("strict_json", nil), input.Language) +
+		maxTokens
+	return max(minCheckerChunkChars, charsForTokens(ctxLen-overhead, input.Language))
+}
+
+// splitTextChunks splits text into chunks of at most size runes, ending
+// each at the last line break or sentence end in its second half when
+// there is one.
+func splitTextChunks(text string, size int) []string {
+	runes := []rune(text)
+	if len(runes) <= size {
+		return []string{text}
+	}
+	var chunks []string
+	for len(runes) > size {
+		cut := size
+		for i := size - 1; i >= size/2; i-- {
+			if strings.ContainsRune("\n。！？!?", runes[i]) {
+				cut = i + 1
+				break
+			}
+		}
+		chunks = append(chunks, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
+
+// selectCheckCategories returns the valid requested categories as a set,
+// or all of CheckCategories when none are requested.
+func selectCheckCategories(requested []string) map[string]bool {
+	if len(requested) == 0 {
+		requested = CheckCategories
+	}
+	selected := make(map[string]bool, len(requested))
+	for _, category := range requested {
+		if IsValidCheckCategory(category) {
+			selected[category] = true
+		}
+	}
+	return selected
+}
+
+// checkPrompt builds the user prompt checking text, all or part of
+// input's, for the selected categories.
+func (a *CheckerAgent) checkPrompt(input *CheckerInput, text string, selected map[string]bool) string {
+	check := struct {
+		Text       string
+		Categories []string
+		Characters []models.Character
+	}{
+		Text:       text,
+		Categories: selectedCategories(selected),
+	}
+	if selected["character"] {
+		check.Characters = input.Characters
+	}
+	return a.promptsFor(input.Language).render("check", check)
+}
+
+// dedupIssues drops repeats of an earlier issue with the same category,
+// severity and description.
+func dedupIssues(issues []models.Issue) []models.Issue {
+	type issueKey struct{ category, severity, description string }
+	seen := make(map[issueKey]bool, len(issues))
+	deduped := issues[:0]
+	for _, issue := range issues {
+		key := issueKey{issue.Category, issue.Severity, strings.TrimSpace(issue.Description)}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, issue)
+	}
+	return deduped
+}
+
+var severityRank = map[string]int{"error": 0, "warning": 1, "info": 2}
+
+// capIssues keeps the max most severe issues, in their original order.
+// Unknown severities rank below info.
+func capIssues(issues []models.Issue, max int) []models.Issue {
+	rank := func(issue models.Issue) int {
+		if r, ok := severityRank[issue.Severity]; ok {
+			return r
+		}
+		return len(severityRank)
 	}
+	order := make([]int, len(issues))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return rank(issues[order[i]]) < rank(issues[order[j]])
+	})
+	order = order[:max]
+	sort.Ints(order)
+
+	capped := make([]models.Issue, 0, max)
+	for _, i := range order {
+		capped = append(capped, issues[i])
+	}
+	return capped
+}
+
+// parseCheckerResponse decodes the issues of a checker generation: the
+// arguments of its report_issues call when it made one, else its text.
+func parseCheckerResponse(generation *models.GenerationResult) ([]models.Issue, error) {
+	for _, call := range generation.ToolCalls {
+		if call.Name != reportIssuesToolName {
+			continue
+		}
+		var args struct {
+			Issues *[]models.Issue `json:"issues"`
+		}
+		if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+			return nil, fmt.Errorf("invalid report_issues arguments: %w", err)
+		}
+		if args.Issues == nil {
+			return nil, errors.New("report_issues arguments have no issues")
+		}
+		return *args.Issues, nil
+	}
+	return parseIssues(generation.Text)
+}
+
+// parseIssues decodes a checker response into issues. It accepts, in
+// order: the whole response as a JSON array, the whole response as a single
+// issue object, and the first JSON array of issues embedded in surrounding
+// text, such as prose or a code block.
+func parseIssues(text string) ([]models.Issue, error) {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return nil, errors.New("empty response")
+	}
+
+	var issues []models.Issue
+	if err := json.Unmarshal([]byte(trimmed), &issues); err == nil && issues != nil {
+		return issues, nil
+	}
+
+	var issue models.Issue
+	if err := json.Unmarshal([]byte(trimmed), &issue); err == nil && issue.Description != "" {
+		return []models.Issue{issue}, nil
+	}
+
+	if issues, ok := firstJSONArray(trimmed); ok {
+		return issues, nil
+	}
+	return nil, errors.New("no JSON array of issues in response")
+}
+
+// firstJSONArray decodes the first '['-delimited JSON value in text that
+// is an array of issues, ignoring anything before or after it.
+func firstJSONArray(text string) ([]models.Issue, bool) {
+	for offset := 0; offset < len(text); {
+		start := strings.IndexByte(text[offset:], '[')
+		if start == -1 {
+			return nil, false
+		}
+		start += offset
+		var issues []models.Issue
+		if err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&issues); err == nil && issues != nil {
+			return issues, true
+		}
+		offset = start + 1
+	}
+	return nil, false
+}
+
+// selectedCategories returns the selected categories in CheckCategories
+// order.
+func selectedCategories(selected map[string]bool) []string {
+	categories := make([]string, 0, len(selected))
+	for _, category := range CheckCategories {
+		if selected[category] {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
 
-	result, err := a.Generate(ctx, systemPrompt, userPrompt, params)
-	if err != nil {
-		return nil, err
+// povPresenceIssue returns a pov issue, described from the checker's
+// prompts, when the POV character is missing from a non-empty list of
+// characters present. The list comes from the director's spec, not the
+// request, so it is only info: it must not send the text to the editor.
+func povPresenceIssue(pov string, present []string, prompts *PromptTemplate) *models.Issue {
+	pov = strings.TrimSpace(pov)
+	if pov == "" || len(present) == 0 {
+		return nil
 	}
+	for _, name := range present {
+		if strings.TrimSpace(name) == pov {
+			return nil
+		}
+	}
+	return &models.Issue{
+		Category:    "pov",
+		Severity:    "info",
+		Description: prompts.render("pov_issue", pov),
+	}
+}
 
-	// Parse issues
+// lintCharacters reports each character's forbidden words. A word in
+// quoted dialogue on a line naming the character, or in narration when the
+// character is the POV character, is a warning; elsewhere anyone may have
+// used it, so it is only info and does not send the text to the editor.
+// The surrounding text is the location; descriptions come from the
+// checker's prompts.
+func lintCharacters(text, pov string, characters []models.Character, prompts *PromptTemplate) []models.Issue {
 	var issues []models.Issue
-	jsonStr := extractJSON(result.Text)
-	if jsonStr == "" {
-		jsonStr = result.Text
+	for _, character := range characters {
+		isPOV := pov != "" && characterNamed(character, strings.TrimSpace(pov))
+		for _, word := range character.Language.ForbiddenWords {
+			word = strings.TrimSpace(word)
+			if word == "" {
+				continue
+			}
+			at, attributed := findForbiddenWord(text, word, character, isPOV)
+			if at == -1 {
+				continue
+			}
+			severity := "info"
+			if attributed {
+				severity = "warning"
+			}
+			issues = append(issues, models.Issue{
+				Category:    "character",
+				Severity:    severity,
+				Description: prompts.render("forbidden_word_issue", struct{ Name, Word string }{character.Name.Full, word}),
+				Location:    snippetAround(text, at, len(word), 15),
+			})
+		}
 	}
+	return issues
+}
 
-	if err := json.Unmarshal([]byte(jsonStr), &issues); err != nil {
-		// No issues found or parse error
-		return []models.Issue{}, nil
+// findForbiddenWord returns the byte offset of the first use of word
+// attributed to character, and true, or else of its first use anywhere,
+// and false. It returns -1 when word is not used.
+func findForbiddenWord(text, word string, character models.Character, isPOV bool) (int, bool) {
+	first := -1
+	lineStart := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for offset := 0; ; {
+			i := strings.Index(line[offset:], word)
+			if i == -1 {
+				break
+			}
+			i += offset
+			if first == -1 {
+				first = lineStart + i
+			}
+			quoted := quoteDepthAt(line, i) > 0
+			if (quoted && namesCharacter(unquotedText(line), character)) || (!quoted && isPOV) {
+				return lineStart + i, true
+			}
+			offset = i + len(word)
+		}
+		lineStart += len(line)
 	}
+	return first, false
+}
 
-	return issues, nil
+// quoteDepthAt returns how many quotes are open at byte offset at of line.
+func quoteDepthAt(line string, at int) int {
+	depth := 0
+	for _, r := range line[:at] {
+		depth = nextQuoteDepth(depth, r)
+	}
+	return depth
+}
+
+// unquotedText returns line without its quoted spans, leaving the
+// narration around dialogue, such as who said it.
+func unquotedText(line string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range line {
+		next := nextQuoteDepth(depth, r)
+		if depth == 0 && next == 0 {
+			b.WriteRune(r)
+		}
+		depth = next
+	}
+	return b.String()
+}
+
+// nextQuoteDepth returns the quote depth after r, given depth before it.
+func nextQuoteDepth(depth int, r rune) int {
+	switch r {
+	case '「', '『', '“':
+		return depth + 1
+	case '」', '』', '”':
+		return max(0, depth-1)
+	case '"':
+		if depth > 0 {
+			return depth - 1
+		}
+		return depth + 1
+	}
+	return depth
+}
+
+// namesCharacter reports whether text mentions any of character's names.
+func namesCharacter(text string, character models.Character) bool {
+	for _, name := range characterNames(character) {
+		if strings.Contains(text, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// characterNamed reports whether name is one of character's names.
+func characterNamed(character models.Character, name string) bool {
+	for _, candidate := range characterNames(character) {
+		if candidate == name {
+			return true
+		}
+	}
+	return false
+}
+
+func characterNames(character models.Character) []string {
+	var names []string
+	for _, name := range append([]string{character.Name.Full, character.Name.Short}, character.Name.Aliases...) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// snippetAround returns text[at:at+n] with up to radius runes either side.
+func snippetAround(text string, at, n, radius int) string {
+	start := at
+	for i := 0; i < radius && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	end := at + n
+	for i := 0; i < radius && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	return strings.TrimSpace(text[start:end])
 }
 
 func min(a, b int) int {
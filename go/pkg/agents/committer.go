@@ -2,27 +2,44 @@ package agents
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
+	"github.com/novelist/novelist/pkg/models"
 	"github.com/rs/zerolog/log"
 )
 
+const summarySourceMaxRunes = 3000
+
+// Committer modes. Lite mode persists prose and spec only and skips the
+// committer's LLM calls.
+const (
+	CommitModeFull = "full"
+	CommitModeLite = "lite"
+)
+
 // CommitterInput represents input for committer
 type CommitterInput struct {
 	Text      string
 	Chapter   int
 	Scene     int
 	SceneSpec interface{}
+	Summary   string
+	Metadata  map[string]string
+	Mode      string
 }
 
 // CommitterAgent updates memory
 type CommitterAgent struct {
 	*BaseAgent
+	// store persists committed scenes; nil only logs them.
+	store MemoryStore
 }
 
 // NewCommitterAgent creates a new committer agent
 func NewCommitterAgent(config AgentConfig) *CommitterAgent {
 	return &CommitterAgent{
-		BaseAgent: NewBaseAgent("committer", config.Provider),
+		BaseAgent: newConfiguredAgent("committer", config),
 	}
 }
 
@@ -31,13 +48,94 @@ func (a *CommitterAgent) Commit(ctx context.Context, input *CommitterInput) erro
 	log.Info().
 		Int("chapter", input.Chapter).
 		Int("scene", input.Scene).
+		Str("summary", input.Summary).
+		Str("mode", input.Mode).
+		Interface("metadata", input.Metadata).
 		Msg("Committing scene to memory")
 
-	// In real implementation, this would:
-	// 1. Update episodic memory
-	// 2. Extract and add facts
-	// 3. Update foreshadowing status
-	// 4. Save to chapter file
+	if a.store == nil {
+		return nil
+	}
 
+	record := &SceneRecord{
+		Chapter:  input.Chapter,
+		Scene:    input.Scene,
+		Text:     input.Text,
+		Summary:  input.Summary,
+		Metadata: input.Metadata,
+	}
+	if spec, ok := input.SceneSpec.(*models.SceneSpec); ok && spec != nil {
+		record.Spec = spec
+		record.Title = spec.Scene.Title
+		resolved, planted, err := a.store.UpdateForeshadowing(ctx, input.Chapter,
+			spec.Continuity.ForeshadowingToResolve, spec.Continuity.ForeshadowingToPlant)
+		if err != nil {
+			return fmt.Errorf("failed to update foreshadowing: %w", err)
+		}
+		record.ForeshadowingResolved = resolved
+		record.ForeshadowingPlanted = planted
+	}
+	if err := a.store.SaveScene(ctx, record); err != nil {
+		return fmt.Errorf("failed to save scene: %w", err)
+	}
+
+	log.Info().
+		Int("chapter", input.Chapter).
+		Int("scene", input.Scene).
+		Int("foreshadowing_resolved", len(record.ForeshadowingResolved)).
+		Int("foreshadowing_planted", len(record.ForeshadowingPlanted)).
+		Msg("Scene saved to memory")
 	return nil
 }
+
+// Summarize asks the provider for a short summary of the committed scene,
+// prompting in language.
+func (a *CommitterAgent) Summarize(ctx context.Context, text string, maxSentences int, language string) (string, *models.GenerationResult, error) {
+	prompts := a.promptsFor(language)
+
+	runes := []rune(text)
+	if len(runes) > summarySourceMaxRunes {
+		runes = runes[:summarySourceMaxRunes]
+	}
+	userPrompt := prompts.render("summary", struct {
+		Text         string
+		MaxSentences int
+	}{string(runes), maxSentences})
+
+	params := GenerateParams{
+		Temperature: a.temperatureOr(0.3),
+		MaxTokens:   a.maxTokensOr(80 * maxSentences),
+	}
+
+	result, err := a.Generate(ctx, prompts.System, userPrompt, params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	summary := limitSentences(strings.TrimSpace(result.Text), maxSentences)
+	if summary == "" {
+		return "", result, fmt.Errorf("committer returned an empty summary")
+	}
+	return summary, result, nil
+}
+
+// limitSentences keeps at most n sentences of text, splitting on Japanese
+// and Latin sentence terminators.
+func limitSentences(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if n <= 0 || text == "" {
+		return text
+	}
+
+	count := 0
+	for i, r := range text {
+		switch r {
+		case '。', '！', '？', '.', '!', '?':
+			count++
+			if count == n {
+				return strings.TrimSpace(text[:i+len(string(r))])
+			}
+		}
+	}
+	return text
+}
@@ -1,9 +1,15 @@
 package agents
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
 )
 
 var defaultAgentList = []string{
@@ -14,35 +20,292 @@ var defaultAgentList = []string{
 	"committer",
 }
 
+// optionalAgentList holds agents that only get their own provider when
+// routed explicitly; otherwise the swarm reuses another agent's provider.
+var optionalAgentList = []string{
+	"titler",
+}
+
+// ParseProviderChain parses a comma-separated routing value such as
+// "ollama, openai, mock" into an ordered provider chain. A blank value yields
+// an empty chain; empty or duplicate entries are rejected.
+func ParseProviderChain(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	entries := strings.Split(raw, ",")
+	chain := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSpace(entry)
+		if name == "" {
+			return nil, fmt.Errorf("provider chain %q has an empty entry", raw)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("provider chain %q lists %s more than once", raw, name)
+		}
+		seen[name] = true
+		chain = append(chain, name)
+	}
+	return chain, nil
+}
+
+// ParseProviderWeights parses a weighted routing value such as
+// "ollama=1, openai=3" into provider names and their weights. Weights must
+// be positive numbers; empty or duplicate entries are rejected.
+func ParseProviderWeights(raw string) ([]string, []float64, error) {
+	chain, err := ParseProviderChain(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, len(chain))
+	weights := make([]float64, len(chain))
+	seen := make(map[string]bool, len(chain))
+	for i, entry := range chain {
+		name, rawWeight, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("weighted routing %q: entry %q is not name=weight", raw, entry)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(rawWeight), 64)
+		if err != nil || !(weight > 0) || math.IsInf(weight, 1) {
+			return nil, nil, fmt.Errorf("weighted routing %q: %s needs a positive weight", raw, name)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("weighted routing %q lists %s more than once", raw, name)
+		}
+		seen[name] = true
+		names[i], weights[i] = name, weight
+	}
+	return names, weights, nil
+}
+
+// isWeightedRouting reports whether a routing value weighs providers
+// rather than chaining them.
+func isWeightedRouting(raw string) bool {
+	return strings.Contains(raw, "=")
+}
+
+// resolveProviderChain validates a routing value against the available
+// providers, returning its provider names and, for weighted routing, their
+// weights. Unknown names are an error in strict mode and are dropped with
+// a warning otherwise.
+func resolveProviderChain(provider models.ProviderSection, agentName, raw string) ([]string, []float64, error) {
+	var chain []string
+	var weights []float64
+	var err error
+	if isWeightedRouting(raw) {
+		chain, weights, err = ParseProviderWeights(raw)
+	} else {
+		chain, err = ParseProviderChain(raw)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("routing for %s: %w", agentName, err)
+	}
+
+	resolved := make([]string, 0, len(chain))
+	var resolvedWeights []float64
+	for i, name := range chain {
+		if _, ok := provider.Available[name]; ok {
+			resolved = append(resolved, name)
+			if weights != nil {
+				resolvedWeights = append(resolvedWeights, weights[i])
+			}
+			continue
+		}
+		if provider.Strict {
+			return nil, nil, fmt.Errorf("routing for %s references unknown provider %s", agentName, name)
+		}
+		log.Warn().
+			Str("agent", agentName).
+			Str("provider", name).
+			Msg("Routing references unknown provider, ignoring")
+	}
+	return resolved, resolvedWeights, nil
+}
+
 // BuildAgentConfigs builds agent configs from provider configuration.
+// Agents resolving to the same provider config share one provider instance;
+// providers are safe for concurrent use.
 func BuildAgentConfigs(provider models.ProviderSection) (map[string]AgentConfig, error) {
+	return BuildAgentConfigsWithPool(provider, NewProviderPool())
+}
+
+// BuildAgentConfigsWithPool is BuildAgentConfigs taking its provider
+// instances from pool, so other users of pool share them.
+func BuildAgentConfigsWithPool(provider models.ProviderSection, pool *ProviderPool) (map[string]AgentConfig, error) {
 	configs := make(map[string]AgentConfig)
-	defaultProvider := provider.Default
 
-	for _, agentName := range defaultAgentList {
-		providerName := provider.Routing[agentName]
-		if providerName == "" {
-			providerName = defaultProvider
+	agentNames := append([]string{}, defaultAgentList...)
+	for _, agentName := range optionalAgentList {
+		if provider.Routing[agentName] != "" {
+			agentNames = append(agentNames, agentName)
 		}
+	}
 
-		providerConfig, ok := provider.Available[providerName]
-		if !ok || providerName == "" {
-			providerConfig = models.ProviderConfig{Type: "mock"}
+	for _, agentName := range agentNames {
+		routing := provider.Routing[agentName]
+		if strings.TrimSpace(routing) == "" {
+			routing = provider.Default
 		}
 
-		if providerConfig.Type == "" {
-			return nil, fmt.Errorf("provider type missing for %s", providerName)
+		chain, weights, err := resolveProviderChain(provider, agentName, routing)
+		if err != nil {
+			return nil, err
 		}
 
-		providerInstance, err := CreateProvider(providerConfig)
+		var providerInstance Provider
+		if len(chain) == 0 {
+			providerInstance, err = pool.Get("", models.ProviderConfig{Type: "mock"})
+		} else {
+			members := make([]Provider, len(chain))
+			for i, providerName := range chain {
+				if members[i], err = pool.Get(providerName, provider.Available[providerName]); err != nil {
+					return nil, err
+				}
+			}
+			providerInstance = members[0]
+			switch {
+			case len(members) > 1 && weights != nil:
+				providerInstance, err = NewWeightedRouterProvider(chain, members, weights)
+			case len(members) > 1:
+				providerInstance, err = NewFallbackProvider(chain, members)
+			}
+		}
 		if err != nil {
 			return nil, err
 		}
 
 		configs[agentName] = AgentConfig{
-			Provider: providerInstance,
+			Provider:      providerInstance,
+			ProviderChain: chain,
 		}
 	}
 
 	return configs, nil
 }
+
+// ApplyPromptOverrides sets the system prompt of each agent in overrides
+// that has one. Overrides for unknown agents are logged and ignored; a
+// titler override applies whether or not the titler is routed.
+func ApplyPromptOverrides(configs map[string]AgentConfig, overrides map[string]models.PromptOverride) {
+	for agent, override := range overrides {
+		if !isKnownAgent(agent) {
+			log.Warn().Str("agent", agent).Msg("Ignoring prompt override for unknown agent")
+			continue
+		}
+		if strings.TrimSpace(override.System) == "" {
+			continue
+		}
+		config := configs[agent]
+		config.SystemPrompt = override.System
+		configs[agent] = config
+	}
+}
+
+// withAgentParams returns config with the generation parameters configured
+// for agent. Out-of-range values are logged and ignored.
+func withAgentParams(agent string, config AgentConfig, params map[string]models.AgentParams) AgentConfig {
+	p, ok := params[agent]
+	if !ok {
+		return config
+	}
+	if t := p.Temperature; t != nil {
+		if *t < 0 || *t > 2 {
+			log.Warn().Str("agent", agent).Float64("temperature", *t).Msg("Ignoring agent temperature outside [0, 2]")
+		} else {
+			value := *t
+			config.Temperature = &value
+		}
+	}
+	if p.MaxTokens < 0 {
+		log.Warn().Str("agent", agent).Int("max_tokens", p.MaxTokens).Msg("Ignoring negative agent max_tokens")
+	} else if p.MaxTokens > 0 {
+		config.MaxTokens = p.MaxTokens
+	}
+	if p.TopP < 0 || p.TopP > 1 {
+		log.Warn().Str("agent", agent).Float64("top_p", p.TopP).Msg("Ignoring agent top_p outside (0, 1]")
+	} else if p.TopP > 0 {
+		config.TopP = p.TopP
+	}
+	if p.Seed != 0 {
+		config.Seed = p.Seed
+	}
+	return config
+}
+
+// ProviderPool holds one provider instance per distinct provider config,
+// so the agents, routing rules, the provider catalog and the embedding
+// index share connections, breakers and rate limits.
+type ProviderPool struct {
+	mu        sync.Mutex
+	instances map[string]Provider
+}
+
+// NewProviderPool returns an empty pool.
+func NewProviderPool() *ProviderPool {
+	return &ProviderPool{instances: make(map[string]Provider)}
+}
+
+// Get returns the instance for providerConfig, creating it on first use.
+// providerName is only used in errors.
+func (p *ProviderPool) Get(providerName string, providerConfig models.ProviderConfig) (Provider, error) {
+	if providerConfig.Type == "" {
+		return nil, fmt.Errorf("provider type missing for %s", providerName)
+	}
+	key := providerConfigKey(providerConfig)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if instance, ok := p.instances[key]; ok {
+		return instance, nil
+	}
+	instance, err := CreateProvider(providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	p.instances[key] = instance
+	return instance, nil
+}
+
+// providerConfigKey identifies a provider config for instance sharing.
+func providerConfigKey(config models.ProviderConfig) string {
+	key, _ := json.Marshal(config)
+	return string(key)
+}
+
+// UnintendedMockFallback reports whether every agent ended up on the mock
+// provider even though real providers were configured, which usually means
+// the routing names are wrong. Explicitly configured mock providers do not
+// count.
+func UnintendedMockFallback(provider models.ProviderSection, configs map[string]AgentConfig) bool {
+	configured := []string{provider.Default}
+	for _, routing := range provider.Routing {
+		configured = append(configured, strings.Split(routing, ",")...)
+	}
+
+	intended := false
+	for _, name := range configured {
+		// Weighted routing entries are name=weight.
+		name, _, _ = strings.Cut(name, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		intended = true
+		if providerConfig, ok := provider.Available[name]; ok && strings.EqualFold(providerConfig.Type, "mock") {
+			return false
+		}
+	}
+	if !intended {
+		return false
+	}
+
+	for _, config := range configs {
+		if config.Provider != nil && config.Provider.Name() != "mock" {
+			return false
+		}
+	}
+	return true
+}
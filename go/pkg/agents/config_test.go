@@ -41,3 +41,161 @@ func TestBuildAgentConfigsErrorsOnMissingType(t *testing.T) {
 		t.Fatal("expected error for missing provider type")
 	}
 }
+
+func TestBuildAgentConfigsSharesProviderInstances(t *testing.T) {
+	local := models.ProviderConfig{Type: "ollama", Model: "qwen3:1.7b", BaseURL: "http://localhost:11434"}
+	section := models.ProviderSection{
+		Default: "local",
+		Available: map[string]models.ProviderConfig{
+			"local":  local,
+			"other":  {Type: "ollama", Model: "llama3", BaseURL: "http://localhost:11434"},
+			"shadow": local,
+		},
+		Routing: map[string]string{
+			"checker": "other",
+			"editor":  "shadow",
+		},
+	}
+
+	pool := NewProviderPool()
+	configs, err := BuildAgentConfigsWithPool(section, pool)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if shared, err := pool.Get("shadow", local); err != nil || shared != configs["director"].Provider {
+		t.Fatalf("expected the pool to hand out the agents' instance, got %v (err=%v)", shared, err)
+	}
+	if catalogued, err := NewProviderCatalogWithPool(section, pool).Get("local"); err != nil || catalogued != configs["director"].Provider {
+		t.Fatalf("expected the provider catalog to reuse the agents' instance, got %v (err=%v)", catalogued, err)
+	}
+	if configs["director"].Provider != configs["writer"].Provider {
+		t.Fatal("expected agents on the same provider to share one instance")
+	}
+	if configs["editor"].Provider != configs["director"].Provider {
+		t.Fatal("expected identical provider configs to share one instance")
+	}
+	if configs["checker"].Provider == configs["director"].Provider {
+		t.Fatal("expected a distinct instance for a different provider config")
+	}
+}
+
+func TestParseProviderChain(t *testing.T) {
+	valid := map[string][]string{
+		"":                        nil,
+		"ollama":                  {"ollama"},
+		" ollama , openai ,mock ": {"ollama", "openai", "mock"},
+	}
+	for raw, expected := range valid {
+		chain, err := ParseProviderChain(raw)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", raw, err)
+		}
+		if len(chain) != len(expected) {
+			t.Fatalf("%q: expected %v, got %v", raw, expected, chain)
+		}
+		for i := range chain {
+			if chain[i] != expected[i] {
+				t.Fatalf("%q: expected %v, got %v", raw, expected, chain)
+			}
+		}
+	}
+
+	for _, raw := range []string{"ollama,,openai", "ollama,", ",ollama", "ollama, ollama"} {
+		if _, err := ParseProviderChain(raw); err == nil {
+			t.Fatalf("%q: expected error for malformed chain", raw)
+		}
+	}
+}
+
+func TestBuildAgentConfigsValidatesChains(t *testing.T) {
+	section := models.ProviderSection{
+		Available: map[string]models.ProviderConfig{
+			"local": {Type: "mock"},
+		},
+		Routing: map[string]string{
+			"writer": "local, typo",
+		},
+	}
+
+	configs, err := BuildAgentConfigs(section)
+	if err != nil {
+		t.Fatalf("lax mode: unexpected error: %v", err)
+	}
+	if chain := configs["writer"].ProviderChain; len(chain) != 1 || chain[0] != "local" {
+		t.Fatalf("lax mode: expected unknown entry dropped, got %v", chain)
+	}
+
+	section.Strict = true
+	if _, err := BuildAgentConfigs(section); err == nil {
+		t.Fatal("strict mode: expected error for unknown provider")
+	}
+
+	section.Routing["writer"] = "local,,local"
+	section.Strict = false
+	if _, err := BuildAgentConfigs(section); err == nil {
+		t.Fatal("expected error for malformed chain")
+	}
+}
+
+func TestUnintendedMockFallback(t *testing.T) {
+	build := func(section models.ProviderSection) map[string]AgentConfig {
+		t.Helper()
+		configs, err := BuildAgentConfigs(section)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return configs
+	}
+
+	unconfigured := models.ProviderSection{}
+	if UnintendedMockFallback(unconfigured, build(unconfigured)) {
+		t.Fatal("expected no flag when no providers were configured")
+	}
+
+	typo := models.ProviderSection{
+		Default:   "local_olama",
+		Available: map[string]models.ProviderConfig{"local_ollama": {Type: "ollama", Model: "qwen3"}},
+	}
+	if !UnintendedMockFallback(typo, build(typo)) {
+		t.Fatal("expected flag when configured routing resolved to mock everywhere")
+	}
+
+	explicitMock := models.ProviderSection{
+		Default:   "dev",
+		Available: map[string]models.ProviderConfig{"dev": {Type: "mock"}},
+	}
+	if UnintendedMockFallback(explicitMock, build(explicitMock)) {
+		t.Fatal("expected no flag when mock was configured explicitly")
+	}
+}
+
+func TestApplyPromptOverrides(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ApplyPromptOverrides(configs, map[string]models.PromptOverride{
+		"writer": {System: "あなたは児童文学の作家です。"},
+		"titler": {System: "題名だけを答えてください。"},
+		"editor": {},
+		"poet":   {System: "ignored"},
+	})
+	if _, ok := configs["poet"]; ok {
+		t.Fatal("expected the unknown agent to be ignored")
+	}
+
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	preview := swarm.PreviewPrompts(&models.SceneRequest{Intention: "再会", WordCount: 100}, nil)
+	if preview.Writer.System != "あなたは児童文学の作家です。" {
+		t.Fatalf("expected the writer override in the preview, got %q", preview.Writer.System)
+	}
+	if preview.Director.System != builtinPromptTemplates.For("director", "ja").System {
+		t.Fatalf("expected the built-in director prompt, got %q", preview.Director.System)
+	}
+	if swarm.editor.promptsFor("en").System != builtinPromptTemplates.For("editor", "en").System {
+		t.Fatal("expected an empty override to keep the built-in prompt")
+	}
+	if swarm.titler.provider == nil || swarm.titler.systemPromptOr("") != "題名だけを答えてください。" {
+		t.Fatal("expected the unrouted titler to keep the committer's provider and take its override")
+	}
+}
@@ -0,0 +1,160 @@
+package agents
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrModelUnavailable is returned when a provider no longer serves the
+// configured model, typically after a deprecation.
+var ErrModelUnavailable = errors.New("model unavailable")
+
+// modelUnavailableCodes are the error codes providers use for removed or
+// unknown models. They count on any 4xx-class rejection IsModelUnavailable
+// looks at.
+var modelUnavailableCodes = []string{
+	"model_not_found",
+	"model_decommissioned",
+}
+
+// modelUnavailableMarkers are lowercase fragments of the errors providers
+// return for removed, deprecated or unknown models. Being free text, they
+// count only on a 404 or 410.
+var modelUnavailableMarkers = []string{
+	"not_found_error: model:",
+	"has been deprecated",
+	"has been decommissioned",
+	"does not exist",
+	"no longer supported",
+	"is not found for api version",
+}
+
+// IsModelUnavailable reports whether err is a provider rejecting the
+// configured model itself rather than the request: a 404 or 410 naming
+// the model, or a 400 carrying an explicit model error code. A 400 whose
+// message merely mentions something that "does not exist" is a bad
+// request, not a missing model.
+func IsModelUnavailable(err error) bool {
+	if errors.Is(err, ErrModelUnavailable) {
+		return true
+	}
+	var httpErr *ProviderHTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+	message := strings.ToLower(httpErr.Message)
+	for _, code := range modelUnavailableCodes {
+		if strings.Contains(message, code) {
+			return true
+		}
+	}
+	if httpErr.StatusCode == http.StatusBadRequest {
+		return false
+	}
+	for _, marker := range modelUnavailableMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelSwitchoverStatus reports an agent moved off an unavailable model.
+type ModelSwitchoverStatus struct {
+	Fallback string    `json:"fallback"`
+	Since    time.Time `json:"since"`
+	Error    string    `json:"error"`
+}
+
+// modelSwitchover moves an agent to its fallback provider for good once
+// the primary reports its model unavailable. Only a config change and
+// restart moves it back, since the model will not return by itself.
+type modelSwitchover struct {
+	fallback string
+	resolve  func(name string) (Provider, error)
+	now      func() time.Time
+
+	mu     sync.Mutex
+	status *ModelSwitchoverStatus
+}
+
+// newModelSwitchover returns a switchover to fallback, or nil when there
+// is nothing to switch to.
+func newModelSwitchover(fallback string, resolve func(string) (Provider, error)) *modelSwitchover {
+	if fallback == "" {
+		return nil
+	}
+	return &modelSwitchover{fallback: fallback, resolve: resolve, now: time.Now}
+}
+
+// modelFallback returns the provider an agent switches to when its model
+// is unavailable: the configured fallback, else the next provider in its
+// routing chain.
+func modelFallback(config models.ModelUnavailableConfig, agent AgentConfig) string {
+	primary := primaryProviderName(agent)
+	if config.Fallback != "" && config.Fallback != primary {
+		return config.Fallback
+	}
+	if config.Fallback == "" {
+		return nextInChain(agent)
+	}
+	return ""
+}
+
+// record switches to the fallback after a model-unavailable error from the
+// primary provider and returns the fallback to retry with.
+func (s *modelSwitchover) record(agent string, err error) (Provider, bool) {
+	if s == nil || !IsModelUnavailable(err) {
+		return nil, false
+	}
+	s.mu.Lock()
+	if s.status == nil {
+		s.status = &ModelSwitchoverStatus{Fallback: s.fallback, Since: s.now(), Error: err.Error()}
+		log.Error().
+			Str("agent", agent).
+			Str("fallback", s.fallback).
+			Err(err).
+			Msg("MODEL UNAVAILABLE: the configured model was rejected, switching agent to its fallback provider until the config is updated")
+	}
+	s.mu.Unlock()
+	return s.provider()
+}
+
+// provider returns the fallback provider once switched over.
+func (s *modelSwitchover) provider() (Provider, bool) {
+	if s.state() == nil || s.resolve == nil {
+		return nil, false
+	}
+	provider, err := s.resolve(s.fallback)
+	if err != nil || provider == nil {
+		log.Warn().Err(err).Str("fallback", s.fallback).Msg("Model fallback is unavailable")
+		return nil, false
+	}
+	return provider, true
+}
+
+// state returns a copy of the switchover status, or nil while the primary
+// model is in use.
+func (s *modelSwitchover) state() *ModelSwitchoverStatus {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == nil {
+		return nil
+	}
+	status := *s.status
+	return &status
+}
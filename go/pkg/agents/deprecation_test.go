@@ -0,0 +1,134 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// deprecatedModelServer answers every completion like OpenAI does for a
+// model that has been removed.
+func deprecatedModelServer(t *testing.T) (Provider, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"message":"The model ` + "`gpt-old`" + ` does not exist or you do not have access to it.","type":"invalid_request_error","code":"model_not_found"}}`))
+	}))
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "test")
+	provider, err := NewOpenAIProvider(models.ProviderConfig{
+		Type:      "openai",
+		Model:     "gpt-old",
+		BaseURL:   server.URL,
+		APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return provider, server.Close
+}
+
+func TestIsModelUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&ProviderHTTPError{StatusCode: 404, Message: `{"error":{"code":"model_not_found"}}`}, true},
+		{&ProviderHTTPError{StatusCode: 410, Message: "The model gpt-old has been deprecated"}, true},
+		{&ProviderHTTPError{StatusCode: 400, Message: `{"error":{"code":"model_decommissioned"}}`}, true},
+		{&ProviderHTTPError{StatusCode: 400, Message: "The referenced file does not exist"}, false},
+		{&ProviderHTTPError{StatusCode: 400, Message: "The model gpt-old has been deprecated"}, false},
+		{&ProviderHTTPError{StatusCode: 404, Message: "page not found"}, false},
+		{&ProviderHTTPError{StatusCode: 500, Message: "model_not_found"}, false},
+		{errors.New("model_not_found"), false},
+	}
+	for _, tt := range tests {
+		if got := IsModelUnavailable(tt.err); got != tt.want {
+			t.Errorf("IsModelUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestModelUnavailableWithoutFallback(t *testing.T) {
+	provider, stop := deprecatedModelServer(t)
+	defer stop()
+
+	agent := NewBaseAgent("writer", provider)
+	_, err := agent.Generate(context.Background(), "s", "u", GenerateParams{})
+	if !errors.Is(err, ErrModelUnavailable) {
+		t.Fatalf("expected ErrModelUnavailable, got %v", err)
+	}
+	var httpErr *ProviderHTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the provider error to stay wrapped, got %v", err)
+	}
+}
+
+func TestModelUnavailableSwitchesToFallback(t *testing.T) {
+	provider, stop := deprecatedModelServer(t)
+	defer stop()
+	fallback := &stubProvider{text: "from fallback"}
+
+	agent := NewBaseAgent("writer", provider)
+	agent.switchover = newModelSwitchover("current", func(name string) (Provider, error) { return fallback, nil })
+
+	result, err := agent.Generate(context.Background(), "s", "u", GenerateParams{})
+	if err != nil || result.Text != "from fallback" {
+		t.Fatalf("expected the failing call to be served by the fallback, got %v, %v", result, err)
+	}
+	status := agent.switchover.state()
+	if status == nil || status.Fallback != "current" || status.Error == "" {
+		t.Fatalf("expected a recorded switchover, got %+v", status)
+	}
+	if agent.providerFor(context.Background()) != fallback {
+		t.Fatal("expected later calls to go straight to the fallback")
+	}
+
+	health := checkProvider(context.Background(), agent)
+	if health.ModelUnavailable == nil {
+		t.Fatalf("expected /health to report the switchover, got %+v", health)
+	}
+}
+
+func TestModelUnavailableRecordedWhenChainRecovers(t *testing.T) {
+	provider, stop := deprecatedModelServer(t)
+	defer stop()
+	next := &stubProvider{text: "from next"}
+	chain, err := NewFallbackProvider([]string{"gpt_old", "gpt_new"}, []Provider{provider, next})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	agent := NewBaseAgent("writer", chain)
+	agent.switchover = newModelSwitchover("gpt_new", func(name string) (Provider, error) { return next, nil })
+
+	result, err := agent.Generate(context.Background(), "s", "u", GenerateParams{})
+	if err != nil || result.Text != "from next" {
+		t.Fatalf("expected the chain's next member to answer, got %v, %v", result, err)
+	}
+	if status := agent.switchover.state(); status == nil || status.Fallback != "gpt_new" {
+		t.Fatalf("expected the primary's missing model to be recorded, got %+v", status)
+	}
+	if agent.providerFor(context.Background()) != next {
+		t.Fatal("expected later calls to skip the unavailable model")
+	}
+	if health := checkProvider(context.Background(), agent); health.ModelUnavailable == nil {
+		t.Fatalf("expected /health to report the switchover, got %+v", health)
+	}
+}
+
+func TestModelFallbackFromChain(t *testing.T) {
+	agent := AgentConfig{ProviderChain: []string{"gpt_old", "gpt_new"}}
+	if got := modelFallback(models.ModelUnavailableConfig{}, agent); got != "gpt_new" {
+		t.Fatalf("expected the next chain provider, got %q", got)
+	}
+	if got := modelFallback(models.ModelUnavailableConfig{Fallback: "claude"}, agent); got != "claude" {
+		t.Fatalf("expected the configured fallback, got %q", got)
+	}
+	if got := modelFallback(models.ModelUnavailableConfig{}, AgentConfig{ProviderChain: []string{"gpt_old"}}); got != "" {
+		t.Fatalf("expected no fallback, got %q", got)
+	}
+}
@@ -3,123 +3,193 @@ package agents
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
 )
 
 // DirectorAgent creates SceneSpec from user intention
 type DirectorAgent struct {
 	*BaseAgent
+
+	// delimitInput wraps user-supplied text in <user_input> blocks and
+	// tells the model to treat it as data.
+	delimitInput bool
 }
 
 // NewDirectorAgent creates a new director agent
 func NewDirectorAgent(config AgentConfig) *DirectorAgent {
 	return &DirectorAgent{
-		BaseAgent: NewBaseAgent("director", config.Provider),
+		BaseAgent: newConfiguredAgent("director", config),
 	}
 }
 
-// Execute generates SceneSpec
+// DirectorInput is a scene request with the retrieved documents relevant
+// to it.
+type DirectorInput struct {
+	Request *models.SceneRequest
+	// Context is retrieved reference text, already fitted to the budget.
+	Context []string
+}
+
+// Execute generates SceneSpec from a *DirectorInput or a bare
+// *models.SceneRequest.
 func (a *DirectorAgent) Execute(ctx context.Context, input interface{}) (*models.GenerationResult, error) {
-	req, ok := input.(*models.SceneRequest)
-	if !ok {
+	var in *DirectorInput
+	switch v := input.(type) {
+	case *DirectorInput:
+		in = v
+	case *models.SceneRequest:
+		in = &DirectorInput{Request: v}
+	}
+	if in == nil || in.Request == nil {
 		return nil, fmt.Errorf("invalid input type")
 	}
 
-	systemPrompt := a.systemPrompt()
-	userPrompt := a.buildPrompt(req)
+	systemPrompt := a.systemPrompt(in.Request.Language)
+	parts := a.promptParts(in)
 
 	params := GenerateParams{
-		Temperature: 0.5,
-		MaxTokens:   2000,
+		Temperature: a.temperatureOr(0.5),
+		MaxTokens:   a.maxTokensOr(2000),
 		JSONMode:    true,
 	}
 
-	result, err := a.Generate(ctx, systemPrompt, userPrompt, params)
+	result, err := a.GenerateParts(ctx, systemPrompt, parts, params)
 	if err != nil {
 		return nil, err
 	}
+	result.Text = directorJSON(result.Text)
+
+	// Re-prompt once when the output does not fit the SceneSpec schema,
+	// telling the model what was wrong.
+	violations := sceneSpecSchemaViolations(result.Text)
+	if len(violations) == 0 {
+		return result, nil
+	}
+	log.Warn().Strs("violations", violations).Msg("Director output does not match the SceneSpec schema, re-prompting")
+	retryParts := append(parts, PromptPart{
+		Name: "schema_retry",
+		Text: a.promptsFor(in.Request.Language).render("schema_retry", struct{ Violations []string }{violations}),
+	})
+	retry, err := a.GenerateParts(ctx, systemPrompt, retryParts, params)
+	if err != nil {
+		log.Warn().Err(err).Msg("Director re-prompt failed, keeping the first output")
+		result.Reprompts = 1
+		return result, nil
+	}
+	retry.Text = directorJSON(retry.Text)
+	if !betterSceneSpec(retry.Text, result.Text, violations) {
+		retry.Text = result.Text
+	}
+	retry.PromptTokens += result.PromptTokens
+	retry.CompletionTokens += result.CompletionTokens
+	retry.DurationMs += result.DurationMs
+	retry.CostUSD += result.CostUSD
+	retry.Reprompts = 1
+	return retry, nil
+}
+
+// betterSceneSpec reports whether the re-prompted output retry should
+// replace first, which had violations: retry must parse as a spec, and
+// have fewer violations unless first did not parse at all.
+func betterSceneSpec(retry, first string, violations []string) bool {
+	if _, err := parseSceneSpec(retry); err != nil {
+		return false
+	}
+	if _, err := parseSceneSpec(first); err != nil {
+		return true
+	}
+	return len(sceneSpecSchemaViolations(retry)) < len(violations)
+}
 
-	// Validate JSON
-	var spec models.SceneSpec
-	if err := json.Unmarshal([]byte(result.Text), &spec); err != nil {
-		// Try to extract JSON if wrapped in markdown
-		extracted := extractJSON(result.Text)
-		if extracted != "" {
-			result.Text = extracted
+// directorJSON returns text, or the JSON extracted from it when text is
+// wrapped in markdown or prose.
+func directorJSON(text string) string {
+	if !json.Valid([]byte(strings.TrimSpace(text))) {
+		if extracted := extractJSON(text); extracted != "" {
+			return extracted
 		}
 	}
+	return text
+}
 
-	return result, nil
+// sceneSpecSchemaViolations checks director output against the SceneSpec
+// schema and describes each problem: output that is not a spec, fields of
+// the wrong JSON type and the required fields ValidateSceneSpec checks.
+func sceneSpecSchemaViolations(text string) []string {
+	spec, err := parseSceneSpec(text)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return []string{fmt.Sprintf("%s must be a JSON %s, not %s", typeErr.Field, jsonKind(typeErr.Type), typeErr.Value)}
+	case err != nil:
+		return []string{fmt.Sprintf("the output is not a SceneSpec JSON object: %v", err)}
+	}
+	return ValidateSceneSpec(spec)
 }
 
-func (a *DirectorAgent) systemPrompt() string {
-	return `あなたは小説の演出家（Director）です。
-与えられた設定と意図から、次のシーンの詳細設計図（SceneSpec）をJSON形式で作成してください。
-
-重要：
-- 必ず有効なJSONのみを出力してください
-- 世界観・キャラクター設定に矛盾がないようにしてください
-- 伏線の回収や新しい伏線の設置を考慮してください
-
-SceneSpecの構造：
-{
-  "scene": {
-    "id": "シーンID",
-    "chapter": 章番号,
-    "sequence_in_chapter": シーン番号,
-    "title": "シーンタイトル"
-  },
-  "narrative": {
-    "objective": "このシーンの目的",
-    "summary": "概要",
-    "key_events": ["出来事1", "出来事2"],
-    "revelations": ["明かされる情報"],
-    "hooks": ["次へのフック"]
-  },
-  "constraints": {
-    "pov_character": "視点キャラクター",
-    "location": "場所",
-    "mood": "雰囲気"
-  },
-  "continuity": {
-    "facts_to_reinforce": ["強化する事実"],
-    "foreshadowing_to_resolve": ["回収する伏線ID"],
-    "foreshadowing_to_plant": ["新規伏線"]
-  }
-}`
+// jsonKind names the JSON type that decodes into t.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return "number"
+	}
 }
 
-func (a *DirectorAgent) buildPrompt(req *models.SceneRequest) string {
-	return fmt.Sprintf(`## ユーザーの意図
-%s
-
-## シーン要件
-- Chapter: %d
-- Scene: %d
-- POV Character: %s
-- Mood: %s
-- Word Count: %d
-
-## 必須の出来事
-%s
-
-上記の情報に基づいて、SceneSpec JSONを作成してください。`,
-		req.Intention,
-		req.Chapter,
-		req.Scene,
-		req.POVCharacter,
-		req.Mood,
-		req.WordCount,
-		formatStringSlice(req.RequiredEvents),
-	)
+// systemPrompt returns the system prompt in language, with the notice
+// about delimited user input when it is enabled.
+func (a *DirectorAgent) systemPrompt(language string) string {
+	prompts := a.promptsFor(language)
+	if a.delimitInput {
+		return prompts.System + "\n\n" + prompts.render("input_notice", nil)
+	}
+	return prompts.System
 }
 
-func formatStringSlice(slice []string) string {
-	if len(slice) == 0 {
-		return "なし"
+// promptParts puts retrieved context, when any, ahead of the request.
+func (a *DirectorAgent) promptParts(in *DirectorInput) []PromptPart {
+	parts := a.buildPromptParts(in.Request)
+	if len(in.Context) == 0 {
+		return parts
+	}
+	retrieved := a.promptsFor(in.Request.Language).render("context", in.Context)
+	return append([]PromptPart{{Name: "context", Text: retrieved}}, parts...)
+}
+
+func (a *DirectorAgent) buildPromptParts(req *models.SceneRequest) []PromptPart {
+	prompts := a.promptsFor(req.Language)
+	userIntention := req.Intention
+	requiredEvents := formatStringSlice(req.RequiredEvents)
+	if requiredEvents == "" {
+		requiredEvents = prompts.render("none", nil)
+	}
+	if a.delimitInput {
+		userIntention = delimitUserInput(userIntention)
+		requiredEvents = delimitUserInput(requiredEvents)
 	}
+
+	return []PromptPart{
+		{Name: "intention", Text: prompts.render("intention", struct{ Intention string }{userIntention})},
+		{Name: "requirements", Text: prompts.render("requirements", req)},
+		{Name: "required_events", Text: prompts.render("required_events", struct{ Events string }{requiredEvents})},
+	}
+}
+
+// formatStringSlice formats slice as a bulleted list, or "" when empty.
+func formatStringSlice(slice []string) string {
 	result := ""
 	for _, s := range slice {
 		result += "- " + s + "\n"
@@ -127,23 +197,51 @@ func formatStringSlice(slice []string) string {
 	return result
 }
 
+// extractJSON returns the first balanced JSON object or array in text that
+// is valid JSON, skipping prose, code fences and brace pairs that do not
+// parse. Braces inside string literals do not count toward the nesting.
+// It returns "" when text holds no valid JSON value.
 func extractJSON(text string) string {
-	// Try to find JSON block
-	start := -1
-	end := -1
-
-	for i, c := range text {
-		if c == '{' && start == -1 {
-			start = i
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && text[start] != '[' {
+			continue
 		}
-		if c == '}' && start != -1 {
-			end = i + 1
+		if end := matchingBracket(text, start); end != -1 && json.Valid([]byte(text[start:end])) {
+			return text[start:end]
 		}
 	}
+	return ""
+}
 
-	if start != -1 && end != -1 && end > start {
-		return text[start:end]
+// matchingBracket returns the offset just past the bracket closing the
+// one at text[start], or -1 when it is never closed.
+func matchingBracket(text string, start int) int {
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
 	}
-
-	return ""
+	return -1
 }
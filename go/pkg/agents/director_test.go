@@ -0,0 +1,107 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestExtractJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"bare object", `{"a":1}`, `{"a":1}`},
+		{"nested objects", `Here: {"scene":{"id":"s1","meta":{"n":[1,{"x":2}]}}} done`, `{"scene":{"id":"s1","meta":{"n":[1,{"x":2}]}}}`},
+		{"braces in strings", `{"summary":"a } brace and a { brace","quote":"say \"}\""}`, `{"summary":"a } brace and a { brace","quote":"say \"}\""}`},
+		{"code fence", "```json\n{\"a\": {\"b\": 2}}\n```", `{"a": {"b": 2}}`},
+		{"trailing prose with braces", `{"a":1} Note: I used {placeholders} here.`, `{"a":1}`},
+		{"leading prose with braces", `Using {curly} notation, the spec is {"a":1}`, `{"a":1}`},
+		{"first of several blocks", `{"first":true} and then {"second":true}`, `{"first":true}`},
+		{"array", `Issues: [{"category":"pov"}] end`, `[{"category":"pov"}]`},
+		{"unbalanced", `{"a": {"b": 1}`, `{"b": 1}`},
+		{"none", `no json here }{`, ``},
+	}
+	for _, tc := range cases {
+		if got := extractJSON(tc.text); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSceneSpecSchemaViolations(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"not json", `no spec here`, "not a SceneSpec JSON object"},
+		{"wrong type", `{"narrative":{"objective":"obj","key_events":"再会"}}`, "narrative.key_events must be a JSON array, not string"},
+		{"missing narrative", `{"scene":{"title":"A"},"constraints":{"pov_character":"葵"}}`, "narrative.objective is empty"},
+	}
+	for _, tc := range cases {
+		violations := sceneSpecSchemaViolations(tc.text)
+		if len(violations) == 0 || !strings.Contains(violations[0], tc.want) {
+			t.Errorf("%s: expected a violation containing %q, got %v", tc.name, tc.want, violations)
+		}
+	}
+
+	valid := `{"narrative":{"objective":"obj","key_events":["再会"]},"constraints":{"pov_character":"葵"}}`
+	if violations := sceneSpecSchemaViolations(valid); len(violations) != 0 {
+		t.Fatalf("expected a valid spec, got %v", violations)
+	}
+}
+
+func TestDirectorRepromptsWithSchemaViolations(t *testing.T) {
+	var prompts []string
+	replies := []string{
+		`{"narrative":{"objective":"obj","key_events":"再会"},"constraints":{"pov_character":"葵"}}`,
+		"```json\n{\"narrative\":{\"objective\":\"obj\",\"key_events\":[\"再会\"]},\"constraints\":{\"pov_character\":\"葵\"}}\n```",
+	}
+	director := NewDirectorAgent(AgentConfig{Provider: &replyProvider{reply: func(messages []Message, params GenerateParams) string {
+		prompts = append(prompts, messages[len(messages)-1].Content)
+		return replies[len(prompts)-1]
+	}}})
+
+	result, err := director.Execute(context.Background(), &models.SceneRequest{Intention: "再会", Language: "en"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prompts) != 2 || result.Reprompts != 1 {
+		t.Fatalf("expected one re-prompt, got %d calls and %d reprompts", len(prompts), result.Reprompts)
+	}
+	if !strings.Contains(prompts[1], "narrative.key_events must be a JSON array") {
+		t.Fatalf("expected the re-prompt to list the violation, got %q", prompts[1])
+	}
+	if violations := sceneSpecSchemaViolations(result.Text); len(violations) != 0 {
+		t.Fatalf("expected the corrected spec, got %v in %q", violations, result.Text)
+	}
+	if result.CompletionTokens != 20 {
+		t.Fatalf("expected both generations' tokens, got %d", result.CompletionTokens)
+	}
+}
+
+func TestBetterSceneSpec(t *testing.T) {
+	incomplete := `{"scene":{"title":"A"}}`
+	violations := sceneSpecSchemaViolations(incomplete)
+	if len(violations) < 2 {
+		t.Fatalf("expected several violations, got %v", violations)
+	}
+	valid := `{"narrative":{"objective":"obj","key_events":["再会"]},"constraints":{"pov_character":"葵"}}`
+
+	if betterSceneSpec("no spec here", incomplete, violations) {
+		t.Fatal("expected an unparseable retry not to replace a parseable spec")
+	}
+	if betterSceneSpec(incomplete, incomplete, violations) {
+		t.Fatal("expected a retry with as many violations to be dropped")
+	}
+	if !betterSceneSpec(valid, incomplete, violations) {
+		t.Fatal("expected a retry with fewer violations to be kept")
+	}
+	if !betterSceneSpec(incomplete, "no spec here", sceneSpecSchemaViolations("no spec here")) {
+		t.Fatal("expected a parseable retry to replace an unparseable spec")
+	}
+}
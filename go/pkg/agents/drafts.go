@@ -0,0 +1,148 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"sync"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
+	"github.com/rs/zerolog/log"
+)
+
+// MaxDraftCount bounds SceneRequest.DraftCount.
+const MaxDraftCount = 4
+
+// draftTemperatureStep raises each parallel draft's temperature over the
+// previous one's, so the drafts differ.
+const draftTemperatureStep = 0.1
+
+// checkedDraft is a writer draft and, unless it failed, its check.
+type checkedDraft struct {
+	result *models.GenerationResult
+	check  *CheckerResult
+}
+
+// affordableDrafts returns how many of count parallel drafts fit in the
+// request's token budget, at least one. Each draft is expected to cost its
+// prose twice over, once written and once checked.
+func affordableDrafts(ctx context.Context, response *models.SceneResponse, input *WriterInput, count int) int {
+	perDraft := 2 * tokensForWordCount(input.WordCount, input.Language)
+	for i := 1; i < count; i++ {
+		if !withinBudget(ctx, response, "writer", fmt.Sprintf("drafting beyond draft %d", i), (i+1)*perDraft) {
+			return i
+		}
+	}
+	return count
+}
+
+// writeDrafts writes count drafts of input concurrently, the i-th at the
+// writer's temperature plus i steps up to the provider's maximum, checks
+// each as checkerInput would be checked, and returns the cleanest with a
+// record of the choice. Drafts never stream. It fails only when every
+// draft fails, with the first draft's error.
+func (s *Swarm) writeDrafts(ctx, writerCtx context.Context, requestID string, input *WriterInput, checkerInput *CheckerInput, count int) (*checkedDraft, *models.DraftSelection, error) {
+	base := s.writer.temperatureOr(defaultWriterTemperature)
+	limit := s.writer.maxTemperature(2)
+	drafts := make([]checkedDraft, count)
+	summaries := make([]models.DraftSummary, count)
+	errs := make([]error, count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		temperature := math.Min(base+draftTemperatureStep*float64(i), limit)
+		summaries[i].Temperature = temperature
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().
+						Str("request_id", requestID).
+						Int("draft", i).
+						Interface("panic", r).
+						Bytes("stack", debug.Stack()).
+						Msg("Recovered panic in writer draft")
+					if s.onPanic != nil {
+						s.onPanic()
+					}
+					errs[i] = fmt.Errorf("draft %d panicked: %v", i, r)
+					summaries[i].Error = errs[i].Error()
+					drafts[i] = checkedDraft{}
+				}
+			}()
+
+			draftInput := *input
+			draftInput.Temperature = &temperature
+			result, err := s.writer.Execute(withoutStreamSink(writerCtx), &draftInput)
+			if err != nil {
+				errs[i] = err
+				summaries[i].Error = err.Error()
+				return
+			}
+			drafts[i].result = result
+			summaries[i].Chars = textutil.CountChars(result.Text)
+			summaries[i].Tokens = result.PromptTokens + result.CompletionTokens
+
+			draftCheck := *checkerInput
+			draftCheck.Text = result.Text
+			checkerCtx, cancel := s.stageContext(ctx, "checker")
+			defer cancel()
+			check, err := s.checker.Check(checkerCtx, &draftCheck)
+			if err != nil {
+				log.Warn().Err(err).Int("draft", i).Msg("Draft check failed, ranking the draft last")
+				return
+			}
+			drafts[i].check = check
+			summaries[i].Checked = check.Checked
+			summaries[i].Errors, summaries[i].Warnings = countSeverities(check.Issues)
+		}(i)
+	}
+	wg.Wait()
+
+	selected := -1
+	for i := range drafts {
+		if drafts[i].result == nil {
+			continue
+		}
+		if selected < 0 || betterDraft(summaries[i], summaries[selected]) {
+			selected = i
+		}
+	}
+	if selected < 0 {
+		return nil, nil, errs[0]
+	}
+	log.Info().
+		Int("drafts", count).
+		Int("selected", selected).
+		Int("errors", summaries[selected].Errors).
+		Msg("Selected the cleanest writer draft")
+	return &drafts[selected], &models.DraftSelection{Selected: selected, Drafts: summaries}, nil
+}
+
+// betterDraft reports whether a is strictly cleaner than b: checked over
+// unchecked, then fewer errors, then fewer warnings.
+func betterDraft(a, b models.DraftSummary) bool {
+	if a.Checked != b.Checked {
+		return a.Checked
+	}
+	if a.Errors != b.Errors {
+		return a.Errors < b.Errors
+	}
+	return a.Warnings < b.Warnings
+}
+
+// countSeverities counts the error and warning issues.
+func countSeverities(issues []models.Issue) (errors, warnings int) {
+	for _, issue := range issues {
+		switch issue.Severity {
+		case "error":
+			errors++
+		case "warning":
+			warnings++
+		}
+	}
+	return errors, warnings
+}
@@ -2,8 +2,10 @@ package agents
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"math"
+	"unicode/utf8"
 
 	"github.com/novelist/novelist/pkg/models"
 )
@@ -12,17 +14,35 @@ import (
 type EditorInput struct {
 	Text   string
 	Issues []models.Issue
+	// Language selects the prompt templates; empty means
+	// DefaultPromptLanguage.
+	Language string
 }
 
+const defaultEditorMaxExpansion = 1.3
+
+// ErrEditorExpansion is returned when an edit grows the text beyond the
+// editor's maximum expansion factor. The edit is discarded.
+var ErrEditorExpansion = errors.New("edit exceeds the maximum expansion")
+
+// ErrEditorTruncated is returned when an edit stops at the token limit, so
+// the edited text is incomplete. The edit is discarded.
+var ErrEditorTruncated = errors.New("edit was cut off at the token limit")
+
 // EditorAgent fixes issues
 type EditorAgent struct {
 	*BaseAgent
+
+	// maxExpansion caps the edited length at this multiple of the original,
+	// both in max_tokens and by rejecting longer edits.
+	maxExpansion float64
 }
 
 // NewEditorAgent creates a new editor agent
 func NewEditorAgent(config AgentConfig) *EditorAgent {
 	return &EditorAgent{
-		BaseAgent: NewBaseAgent("editor", config.Provider),
+		BaseAgent:    newConfiguredAgent("editor", config),
+		maxExpansion: defaultEditorMaxExpansion,
 	}
 }
 
@@ -33,42 +53,26 @@ func (a *EditorAgent) Execute(ctx context.Context, input interface{}) (*models.G
 		return nil, fmt.Errorf("invalid input type")
 	}
 
-	systemPrompt := `あなたは熟練した小説編集者です。
-与えられた文章の問題を修正し、品質を向上させてください。
-
-改善の指針：
-- 冗長な表現を簡潔に
-- 同じ語句の過度な反復を削除
-- テンポを改善
-- 原作の意味・意図は保持
-- 本文のみ出力（解説不要）`
-
-	userPrompt := a.buildPrompt(in)
+	prompts := a.promptsFor(in.Language)
+	userPrompt := prompts.render("edit", in)
 
 	params := GenerateParams{
-		Temperature: 0.4,
-		MaxTokens:   len(in.Text) + 500,
+		Temperature: a.temperatureOr(0.4),
+		MaxTokens:   a.maxTokensOr(int(math.Ceil(float64(estimateProseTokens(in.Text, in.Language)) * a.maxExpansion))),
 	}
 
-	return a.Generate(ctx, systemPrompt, userPrompt, params)
-}
-
-func (a *EditorAgent) buildPrompt(input *EditorInput) string {
-	var issueList strings.Builder
-	for _, issue := range input.Issues {
-		if issue.Severity == "error" || issue.Severity == "warning" {
-			issueList.WriteString(fmt.Sprintf("- [%s] %s\n", issue.Category, issue.Description))
-		}
+	result, err := a.Generate(ctx, prompts.System, userPrompt, params)
+	if err != nil {
+		return nil, err
 	}
 
-	return fmt.Sprintf(`## 編集対象の文章
-%s
-
-## 修正すべき問題
-%s
-
-上記の問題を修正した文章を出力してください。`,
-		input.Text,
-		issueList.String(),
-	)
+	if result.FinishReason == "length" {
+		return nil, ErrEditorTruncated
+	}
+	original := utf8.RuneCountInString(in.Text)
+	edited := utf8.RuneCountInString(result.Text)
+	if float64(edited) > float64(original)*a.maxExpansion {
+		return nil, fmt.Errorf("%w: %d chars from %d (max %.2fx)", ErrEditorExpansion, edited, original, a.maxExpansion)
+	}
+	return result, nil
 }
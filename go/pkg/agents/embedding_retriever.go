@@ -0,0 +1,157 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// embeddingBatchSize bounds the documents embedded per provider call.
+const embeddingBatchSize = 64
+
+// EmbeddingRetriever is an in-memory Retriever ranking documents by the
+// cosine similarity of their embeddings to the query's. Documents and
+// queries are embedded by the same provider.
+type EmbeddingRetriever struct {
+	provider Provider
+
+	mu      sync.RWMutex
+	docs    []models.Document
+	vectors [][]float32
+}
+
+// NewEmbeddingRetriever creates an empty retriever embedding with provider.
+func NewEmbeddingRetriever(provider Provider) *EmbeddingRetriever {
+	return &EmbeddingRetriever{provider: provider}
+}
+
+// Add embeds and indexes docs. Documents without content are ignored. On
+// error none of docs are indexed.
+func (r *EmbeddingRetriever) Add(ctx context.Context, docs ...models.Document) error {
+	var kept []models.Document
+	var texts []string
+	for _, doc := range docs {
+		if strings.TrimSpace(doc.Content) == "" {
+			continue
+		}
+		kept = append(kept, doc)
+		texts = append(texts, doc.Content)
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		end := min(start+embeddingBatchSize, len(texts))
+		batch, err := r.provider.Embed(ctx, texts[start:end])
+		if err != nil {
+			return fmt.Errorf("failed to embed documents: %w", err)
+		}
+		if len(batch) != end-start {
+			return fmt.Errorf("failed to embed documents: got %d vectors for %d documents", len(batch), end-start)
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, vector := range vectors {
+		if err := r.checkDims(vector); err != nil {
+			return fmt.Errorf("document %s: %w", kept[i].ID, err)
+		}
+	}
+	for i, vector := range vectors {
+		vector = append([]float32(nil), vector...)
+		normalize(vector)
+		r.docs = append(r.docs, kept[i])
+		r.vectors = append(r.vectors, vector)
+	}
+	return nil
+}
+
+// checkDims fails when vector's length differs from the indexed vectors'.
+// The caller holds mu.
+func (r *EmbeddingRetriever) checkDims(vector []float32) error {
+	if len(r.vectors) > 0 && len(vector) != len(r.vectors[0]) {
+		return fmt.Errorf("embedding has %d dimensions, the index %d", len(vector), len(r.vectors[0]))
+	}
+	return nil
+}
+
+// Len returns the number of indexed documents.
+func (r *EmbeddingRetriever) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.docs)
+}
+
+// Search returns up to topK documents most similar to query, best first.
+// Documents with no positive similarity are left out.
+func (r *EmbeddingRetriever) Search(ctx context.Context, query string, topK int) ([]models.SearchResult, error) {
+	if strings.TrimSpace(query) == "" || topK <= 0 || r.Len() == 0 {
+		return nil, nil
+	}
+	embedded, err := r.provider.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	if len(embedded) != 1 {
+		return nil, fmt.Errorf("failed to embed query: got %d vectors", len(embedded))
+	}
+	queryVector := append([]float32(nil), embedded[0]...)
+	normalize(queryVector)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.checkDims(queryVector); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	var results []models.SearchResult
+	for i, vector := range r.vectors {
+		score := dot(queryVector, vector)
+		if score <= 0 {
+			continue
+		}
+		results = append(results, models.SearchResult{Document: r.docs[i], Score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+	return results, nil
+}
+
+// normalize scales vector to unit length in place, so the dot product of
+// two normalized vectors is their cosine similarity. A zero vector is left
+// as is.
+func normalize(vector []float32) {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := math.Sqrt(sum)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,69 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestMockEmbeddingsAreStable(t *testing.T) {
+	texts := []string{"魔導国の魔法", "王都の市場"}
+	first, err := NewMockProviderWithConfig(MockConfig{Seed: 1}).Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := NewMockProviderWithConfig(MockConfig{Seed: 2}).Embed(context.Background(), texts)
+	if len(first) != 2 || len(first[0]) != mockEmbeddingDims || !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected the same vectors whatever the seed, got %v and %v", first, second)
+	}
+}
+
+func TestEmbeddingRetrieverRanksBySimilarity(t *testing.T) {
+	retriever := NewEmbeddingRetriever(NewMockProviderWithConfig(MockConfig{Seed: 1}))
+	err := retriever.Add(context.Background(),
+		models.Document{ID: "magic", Content: "魔導国では魔法の使用に許可証が要る。"},
+		models.Document{ID: "city", Content: "王都の市場は朝から賑わう。"},
+		models.Document{ID: "empty", Content: "  "},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if retriever.Len() != 2 {
+		t.Fatalf("expected 2 documents, got %d", retriever.Len())
+	}
+
+	results, err := retriever.Search(context.Background(), "魔導国の魔法", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].Document.ID != "magic" || results[0].Rank != 1 || results[0].Score <= 0 {
+		t.Fatalf("expected magic first, got %+v", results)
+	}
+}
+
+func TestEmbeddingRetrieverNeedsEmbeddings(t *testing.T) {
+	retriever := NewEmbeddingRetriever(&stubProvider{})
+	err := retriever.Add(context.Background(), models.Document{ID: "a", Content: "本文"})
+	if !errors.Is(err, ErrEmbeddingsUnsupported) || retriever.Len() != 0 {
+		t.Fatalf("expected unsupported embeddings to fail the add, got %v", err)
+	}
+}
+
+func TestFallbackEmbedsWithFirstSupportingMember(t *testing.T) {
+	mock := NewMockProviderWithConfig(MockConfig{Seed: 1})
+	chain, err := NewFallbackProvider([]string{"stub", "mock"}, []Provider{&stubProvider{}, mock})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := chain.Embed(context.Background(), []string{"雨"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, _ := mock.Embed(context.Background(), []string{"雨"})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the mock's vectors, got %v", got)
+	}
+}
@@ -0,0 +1,129 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// endpointCooldown is how long a failed endpoint is tried only after the
+// healthy ones.
+const endpointCooldown = 30 * time.Second
+
+// EndpointStatus is the health of one provider endpoint.
+type EndpointStatus struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EndpointReporter is implemented by providers that spread requests over
+// several endpoints.
+type EndpointReporter interface {
+	// EndpointHealth probes every endpoint.
+	EndpointHealth(ctx context.Context) []EndpointStatus
+}
+
+// endpointHealthOf returns per-endpoint health when provider, or the
+// provider it wraps, spreads requests over endpoints.
+func endpointHealthOf(ctx context.Context, provider Provider) []EndpointStatus {
+	for provider != nil {
+		if reporter, ok := provider.(EndpointReporter); ok {
+			return reporter.EndpointHealth(ctx)
+		}
+		wrapper, ok := provider.(interface{ Unwrap() Provider })
+		if !ok {
+			return nil
+		}
+		provider = wrapper.Unwrap()
+	}
+	return nil
+}
+
+// endpointPool round-robins over endpoints and fails over past ones that
+// recently failed.
+type endpointPool struct {
+	endpoints []*endpointState
+	next      atomic.Uint64
+}
+
+type endpointState struct {
+	url string
+
+	mu        sync.Mutex
+	downUntil time.Time
+	lastError string
+}
+
+func newEndpointPool(urls []string) *endpointPool {
+	pool := &endpointPool{}
+	for _, url := range urls {
+		pool.endpoints = append(pool.endpoints, &endpointState{url: url})
+	}
+	return pool
+}
+
+// order returns the endpoints to try for one request: round-robin from the
+// next index, with endpoints in cooldown moved to the end.
+func (p *endpointPool) order() []int {
+	n := len(p.endpoints)
+	start := int(p.next.Add(1)-1) % n
+	now := time.Now()
+
+	healthy := make([]int, 0, n)
+	var cooling []int
+	for i := 0; i < n; i++ {
+		idx := (start + i) % n
+		if p.endpoints[idx].coolingDown(now) {
+			cooling = append(cooling, idx)
+		} else {
+			healthy = append(healthy, idx)
+		}
+	}
+	return append(healthy, cooling...)
+}
+
+func (p *endpointPool) markFailure(idx int, err error) {
+	e := p.endpoints[idx]
+	e.mu.Lock()
+	e.downUntil = time.Now().Add(endpointCooldown)
+	e.lastError = err.Error()
+	e.mu.Unlock()
+}
+
+func (p *endpointPool) markSuccess(idx int) {
+	e := p.endpoints[idx]
+	e.mu.Lock()
+	e.downUntil = time.Time{}
+	e.lastError = ""
+	e.mu.Unlock()
+}
+
+func (e *endpointState) coolingDown(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return now.Before(e.downUntil)
+}
+
+// endpointError marks a failure to reach an endpoint at all.
+type endpointError struct{ err error }
+
+func (e *endpointError) Error() string { return e.err.Error() }
+func (e *endpointError) Unwrap() error { return e.err }
+
+// isEndpointFailure reports whether another endpoint may succeed where this
+// one failed: the endpoint was unreachable or answered 5xx. Cancelled
+// requests never fail over.
+func isEndpointFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var unreachable *endpointError
+	if errors.As(err, &unreachable) {
+		return true
+	}
+	var httpErr *ProviderHTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode >= 500
+}
@@ -0,0 +1,168 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// FallbackProvider tries an ordered chain of providers, moving to the next
+// when one fails. Agents retry each member per its own retry config before
+// moving on; the result's ServedBy names the member that answered.
+type FallbackProvider struct {
+	names   []string
+	members []Provider
+}
+
+// NewFallbackProvider chains members, named by their configured provider
+// names, primary first.
+func NewFallbackProvider(names []string, members []Provider) (*FallbackProvider, error) {
+	if len(members) == 0 {
+		return nil, errors.New("fallback chain has no providers")
+	}
+	if len(names) != len(members) {
+		return nil, fmt.Errorf("fallback chain has %d names for %d providers", len(names), len(members))
+	}
+	return &FallbackProvider{
+		names:   append([]string(nil), names...),
+		members: append([]Provider(nil), members...),
+	}, nil
+}
+
+// Generate returns the first member's successful result.
+func (p *FallbackProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	return p.try(ctx, func(member Provider) (*models.GenerationResult, bool, error) {
+		result, err := member.Generate(ctx, messages, params)
+		return result, false, err
+	})
+}
+
+// GenerateStream streams from the first member that starts a stream.
+func (p *FallbackProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	var errs []error
+	for i, member := range p.members {
+		chunks, err := member.GenerateStream(ctx, messages, params)
+		if err == nil {
+			return chunks, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.names[i], err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// try calls generate with each member in turn until one succeeds, and
+// records the serving member on the result. It stops early when ctx ends,
+// when too little time is left, or when generate reports the failure as
+// final (e.g. text was already streamed).
+func (p *FallbackProvider) try(ctx context.Context, generate func(member Provider) (*models.GenerationResult, bool, error)) (*models.GenerationResult, error) {
+	var errs []error
+	for i, member := range p.members {
+		result, final, err := generate(member)
+		if err == nil {
+			result.ServedBy = p.names[i]
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.names[i], err))
+		if final || ctx.Err() != nil || errors.Is(err, ErrInsufficientTimeRemaining) || i == len(p.members)-1 {
+			break
+		}
+		log.Warn().
+			Err(err).
+			Str("provider", p.names[i]).
+			Str("next", p.names[i+1]).
+			Msg("Provider failed, falling back")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Capabilities returns what every member supports, so a request fits
+// whichever member serves it.
+func (p *FallbackProvider) Capabilities() ProviderCapabilities {
+	return sharedCapabilities(p.members)
+}
+
+// Embed embeds with the first member that supports embeddings.
+func (p *FallbackProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedWithFirst(ctx, p.names, p.members, texts)
+}
+
+// embedWithFirst embeds texts with the first of members that supports
+// embeddings. Unlike generation it does not move on when that member
+// fails, since another model's vectors cannot be compared with the ones
+// already stored.
+func embedWithFirst(ctx context.Context, names []string, members []Provider, texts []string) ([][]float32, error) {
+	for i, member := range members {
+		vectors, err := member.Embed(ctx, texts)
+		if errors.Is(err, ErrEmbeddingsUnsupported) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", names[i], err)
+		}
+		return vectors, nil
+	}
+	return nil, ErrEmbeddingsUnsupported
+}
+
+// sharedCapabilities returns what every one of members supports: the
+// smallest known context length and the features all of them have.
+func sharedCapabilities(members []Provider) ProviderCapabilities {
+	caps := members[0].Capabilities()
+	for _, member := range members[1:] {
+		other := member.Capabilities()
+		if other.CtxLen > 0 && (caps.CtxLen == 0 || other.CtxLen < caps.CtxLen) {
+			caps.CtxLen = other.CtxLen
+		}
+		caps.SupportsTools = caps.SupportsTools && other.SupportsTools
+		caps.SupportsJSONMode = caps.SupportsJSONMode && other.SupportsJSONMode
+		caps.SupportsThinking = caps.SupportsThinking && other.SupportsThinking
+		caps.SupportsStreaming = caps.SupportsStreaming && other.SupportsStreaming
+		if other.MaxTemperature > 0 && (caps.MaxTemperature == 0 || other.MaxTemperature < caps.MaxTemperature) {
+			caps.MaxTemperature = other.MaxTemperature
+		}
+	}
+	return caps
+}
+
+// HealthCheck succeeds while any member is reachable.
+func (p *FallbackProvider) HealthCheck(ctx context.Context) error {
+	var errs []error
+	for i, member := range p.members {
+		err := member.HealthCheck(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.names[i], err))
+	}
+	return errors.Join(errs...)
+}
+
+// Name returns the provider name.
+func (p *FallbackProvider) Name() string {
+	return "fallback"
+}
+
+// member returns the member configured under name, or nil.
+func (p *FallbackProvider) member(name string) Provider {
+	for i, candidate := range p.names {
+		if candidate == name {
+			return p.members[i]
+		}
+	}
+	return nil
+}
+
+// mapMembers returns a chain with each member replaced by wrap's result.
+func (p *FallbackProvider) mapMembers(wrap func(name string, member Provider) Provider) *FallbackProvider {
+	mapped := &FallbackProvider{names: p.names, members: make([]Provider, len(p.members))}
+	for i, member := range p.members {
+		mapped.members[i] = wrap(p.names[i], member)
+	}
+	return mapped
+}
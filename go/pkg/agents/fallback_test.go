@@ -0,0 +1,77 @@
+package agents
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestFallbackProviderRetriesThenFallsBack(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	primary := &flakyProvider{
+		failures: 5,
+		status:   http.StatusServiceUnavailable,
+		retry:    models.RetryConfig{MaxAttempts: 2},
+	}
+	secondary := &stubProvider{text: "fallback"}
+	chain, err := NewFallbackProvider([]string{"local_ollama", "openai"}, []Provider{primary, secondary})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx := WithRetryBudget(context.Background(), NewRetryBudget(3))
+	result, err := NewBaseAgent("writer", chain).Generate(ctx, "s", "u", GenerateParams{})
+	if err != nil {
+		t.Fatalf("expected the fallback to serve, got %v", err)
+	}
+	if primary.calls != 2 {
+		t.Fatalf("expected the primary to be retried once, got %d calls", primary.calls)
+	}
+	if result.Text != "fallback" || result.ServedBy != "openai" {
+		t.Fatalf("expected openai to serve the request, got %+v", result)
+	}
+
+	secondary.text = ""
+	primary.failures = 0
+	if result, _ := chain.Generate(context.Background(), nil, GenerateParams{}); result.ServedBy != "local_ollama" {
+		t.Fatalf("expected the primary to serve once healthy, got %q", result.ServedBy)
+	}
+}
+
+func TestFallbackProviderCapabilitiesIntersect(t *testing.T) {
+	chain, err := NewFallbackProvider([]string{"a", "b"}, []Provider{
+		&stubProvider{caps: ProviderCapabilities{CtxLen: 32768, SupportsTools: true, SupportsJSONMode: true, SupportsStreaming: true}},
+		&stubProvider{caps: ProviderCapabilities{CtxLen: 8192, SupportsJSONMode: true}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := ProviderCapabilities{CtxLen: 8192, SupportsJSONMode: true}
+	if caps := chain.Capabilities(); caps != want {
+		t.Fatalf("expected %+v, got %+v", want, caps)
+	}
+}
+
+func TestBuildAgentConfigsChainsFallbacks(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{
+		Available: map[string]models.ProviderConfig{
+			"local": {Type: "mock"},
+			"spare": {Type: "mock", Model: "spare"},
+		},
+		Routing: map[string]string{"writer": "local, spare", "director": "local"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := configs["writer"].Provider.(*FallbackProvider); !ok {
+		t.Fatalf("expected a fallback chain for the writer, got %T", configs["writer"].Provider)
+	}
+	if _, ok := configs["director"].Provider.(*FallbackProvider); ok {
+		t.Fatal("expected a single provider for the director")
+	}
+}
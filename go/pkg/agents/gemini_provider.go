@@ -0,0 +1,302 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+const (
+	defaultGeminiBaseURL = "https://generativelanguage.googleapis.com"
+	geminiAPIVersion     = "v1beta"
+	geminiContextLength  = 1048576
+)
+
+type geminiProvider struct {
+	model   string
+	apiKey  string
+	apiRoot string
+	client  *http.Client
+	retry   models.RetryConfig
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      float64 `json:"temperature"`
+	MaxOutputTokens  int     `json:"maxOutputTokens,omitempty"`
+	TopP             float64 `json:"topP,omitempty"`
+	ResponseMimeType string  `json:"responseMimeType,omitempty"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error,omitempty"`
+}
+
+func init() {
+	RegisterProviderFactory(models.ProviderTypeGemini, NewGeminiProvider)
+}
+
+// NewGeminiProvider creates a provider backed by the Gemini
+// generateContent API.
+func NewGeminiProvider(config models.ProviderConfig) (Provider, error) {
+	if strings.TrimSpace(config.Model) == "" {
+		return nil, fmt.Errorf("gemini provider requires model")
+	}
+
+	baseURL := strings.TrimRight(strings.TrimSpace(config.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultGeminiBaseURL
+	}
+
+	apiKeyEnv := strings.TrimSpace(config.APIKeyEnv)
+	if apiKeyEnv == "" {
+		apiKeyEnv = models.DefaultAPIKeyEnv(models.ProviderTypeGemini)
+	}
+	apiKey := strings.TrimSpace(os.Getenv(apiKeyEnv))
+	if apiKey == "" {
+		return nil, fmt.Errorf("gemini API key is missing in env %s", apiKeyEnv)
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+
+	return &geminiProvider{
+		model:   strings.TrimPrefix(config.Model, "models/"),
+		apiKey:  apiKey,
+		apiRoot: geminiAPIRoot(baseURL),
+		client:  newProviderClient(timeout),
+		retry:   config.Retry,
+	}, nil
+}
+
+// geminiAPIRoot accepts base URLs with or without the /v1beta suffix.
+func geminiAPIRoot(baseURL string) string {
+	if strings.HasSuffix(baseURL, "/"+geminiAPIVersion) {
+		return baseURL
+	}
+	return baseURL + "/" + geminiAPIVersion
+}
+
+func (p *geminiProvider) Name() string {
+	return "gemini"
+}
+
+func (p *geminiProvider) retryConfig() models.RetryConfig {
+	return p.retry
+}
+
+func (p *geminiProvider) modelName() string {
+	return p.model
+}
+
+func (p *geminiProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	payload := geminiRequest{
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:     params.Temperature,
+			MaxOutputTokens: params.MaxTokens,
+			TopP:            params.TopP,
+		},
+	}
+	if params.JSONMode {
+		payload.GenerationConfig.ResponseMimeType = "application/json"
+	}
+	// System prompts go in systemInstruction; Gemini calls the assistant
+	// role "model".
+	var system []string
+	for _, message := range messages {
+		switch message.Role {
+		case "system":
+			system = append(system, message.Content)
+			continue
+		case "assistant":
+			message.Role = "model"
+		}
+		payload.Contents = append(payload.Contents, geminiContent{
+			Role:  message.Role,
+			Parts: []geminiPart{{Text: message.Content}},
+		})
+	}
+	if len(system) > 0 {
+		payload.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: strings.Join(system, "\n\n")}}}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal gemini request: %w", err)
+	}
+
+	endpoint := p.apiRoot + "/models/" + url.PathEscape(p.model) + ":generateContent"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build gemini request: %w", err)
+	}
+	req.Header.Set("x-goog-api-key", p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gemini request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading gemini response: %w", err)
+	}
+
+	var out geminiResponse
+	decodeErr := json.Unmarshal(raw, &out)
+
+	// Check the status first: gateways answer 5xx with non-JSON pages.
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg := strings.TrimSpace(string(raw))
+		if decodeErr == nil && out.Error != nil && out.Error.Message != "" {
+			msg = out.Error.Status + ": " + out.Error.Message
+		}
+		return nil, &ProviderHTTPError{
+			Provider:   "gemini",
+			StatusCode: resp.StatusCode,
+			Message:    msg,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode gemini response: %w", decodeErr)
+	}
+	if len(out.Candidates) == 0 {
+		if reason := out.PromptFeedback.BlockReason; reason != "" {
+			return nil, fmt.Errorf("gemini returned no candidates (prompt blocked: %s)", reason)
+		}
+		return nil, fmt.Errorf("gemini returned no candidates")
+	}
+
+	candidate := out.Candidates[0]
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		text.WriteString(part.Text)
+	}
+	// A blocked candidate may carry partial text; it is not a usable reply.
+	if geminiBlockedReasons[candidate.FinishReason] || strings.TrimSpace(text.String()) == "" {
+		return nil, fmt.Errorf("gemini returned no usable text (finish reason %s)", candidate.FinishReason)
+	}
+	result := &models.GenerationResult{
+		Text:             strings.TrimSpace(text.String()),
+		PromptTokens:     out.UsageMetadata.PromptTokenCount,
+		CompletionTokens: out.UsageMetadata.CandidatesTokenCount,
+		FinishReason:     geminiFinishReason(candidate.FinishReason),
+	}
+	if result.PromptTokens <= 0 {
+		result.PromptTokens = estimateTokensFromMessages(messages)
+	}
+	if result.CompletionTokens <= 0 {
+		result.CompletionTokens = estimateTokensFromText(result.Text)
+	}
+	return result, nil
+}
+
+// geminiBlockedReasons are the finish reasons of a candidate withheld by
+// Gemini's safety or content filters.
+var geminiBlockedReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+}
+
+// geminiFinishReason maps finish reasons onto the OpenAI names the
+// pipeline checks, so "length" still means the output hit max_tokens.
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "MAX_TOKENS":
+		return "length"
+	case "STOP":
+		return "stop"
+	default:
+		return strings.ToLower(reason)
+	}
+}
+
+// GenerateStream delivers a completed generation in chunks; Gemini
+// streaming is not wired up yet.
+func (p *geminiProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	return generateAsStream(ctx, p.Generate, messages, params)
+}
+
+// Capabilities reports Gemini 1.5's context window. JSON mode maps to
+// responseMimeType.
+func (p *geminiProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		CtxLen:            geminiContextLength,
+		SupportsTools:     false,
+		SupportsJSONMode:  true,
+		SupportsThinking:  false,
+		SupportsStreaming: false,
+		MaxTemperature:    2,
+	}
+}
+
+// Embed is unsupported.
+func (p *geminiProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, ErrEmbeddingsUnsupported
+}
+
+// HealthCheck lists models to confirm the API is reachable and the key is
+// accepted.
+func (p *geminiProvider) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiRoot+"/models", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-goog-api-key", p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("gemini health check failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func newTestGeminiProvider(t *testing.T, baseURL string) Provider {
+	t.Helper()
+	t.Setenv("NOVELIST_TEST_GOOGLE_KEY", "test-key")
+	provider, err := CreateProvider(models.ProviderConfig{
+		Type:      "gemini",
+		Model:     "gemini-1.5-pro",
+		BaseURL:   baseURL,
+		APIKeyEnv: "NOVELIST_TEST_GOOGLE_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return provider
+}
+
+func TestGeminiGenerate(t *testing.T) {
+	var got geminiRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/gemini-1.5-pro:generateContent" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("x-goog-api-key") != "test-key" {
+			t.Errorf("missing api key header: %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":" {\"a\":"},{"text":"1} "}]},"finishReason":"MAX_TOKENS"}],"usageMetadata":{"promptTokenCount":21,"candidatesTokenCount":7}}`))
+	}))
+	defer server.Close()
+
+	provider := newTestGeminiProvider(t, server.URL)
+	result, err := provider.Generate(context.Background(), []Message{
+		{Role: "system", Content: "あなたは作家です。"},
+		{Role: "user", Content: "書いてください。"},
+		{Role: "assistant", Content: "はい。"},
+		{Role: "user", Content: "続けて。"},
+	}, GenerateParams{Temperature: 0.2, MaxTokens: 100, JSONMode: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.SystemInstruction == nil || got.SystemInstruction.Parts[0].Text != "あなたは作家です。" {
+		t.Fatalf("expected the system prompt as systemInstruction, got %+v", got.SystemInstruction)
+	}
+	if len(got.Contents) != 3 || got.Contents[0].Role != "user" || got.Contents[1].Role != "model" {
+		t.Fatalf("expected user/model contents, got %+v", got.Contents)
+	}
+	if config := got.GenerationConfig; config.ResponseMimeType != "application/json" || config.MaxOutputTokens != 100 || config.Temperature != 0.2 {
+		t.Fatalf("unexpected generation config %+v", config)
+	}
+	if result.Text != `{"a":1}` || result.PromptTokens != 21 || result.CompletionTokens != 7 || result.FinishReason != "length" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestGeminiErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"code":404,"message":"models/gemini-retired is not found for API version v1beta","status":"NOT_FOUND"}}`))
+	}))
+	defer server.Close()
+
+	_, err := newTestGeminiProvider(t, server.URL+"/v1beta").Generate(context.Background(), []Message{{Role: "user", Content: "hi"}}, GenerateParams{})
+	httpErr, ok := err.(*ProviderHTTPError)
+	if !ok || httpErr.StatusCode != http.StatusNotFound || httpErr.Provider != "gemini" {
+		t.Fatalf("expected a provider HTTP error, got %v", err)
+	}
+	if !IsModelUnavailable(err) {
+		t.Fatal("expected a missing model to be classified as unavailable")
+	}
+}
+
+func TestGeminiBlockedResponses(t *testing.T) {
+	replies := map[string]string{
+		"SAFETY":              `{"candidates":[{"content":{"role":"model","parts":[]},"finishReason":"SAFETY"}]}`,
+		"prompt blocked":      `{"candidates":[],"promptFeedback":{"blockReason":"PROHIBITED_CONTENT"}}`,
+		"RECITATION":          `{"candidates":[{"content":{"role":"model","parts":[{"text":"途中まで"}]},"finishReason":"RECITATION"}]}`,
+		"finish reason OTHER": `{"candidates":[{"content":{"role":"model","parts":[{"text":" "}]},"finishReason":"OTHER"}]}`,
+	}
+	for want, reply := range replies {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(reply))
+		}))
+		_, err := newTestGeminiProvider(t, server.URL).Generate(context.Background(), []Message{{Role: "user", Content: "hi"}}, GenerateParams{})
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error mentioning %q, got %v", want, err)
+		}
+		if IsRetryable(err) {
+			t.Errorf("expected a blocked reply not to be retried: %v", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package agents
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCacheTTL is how long provider health results are reused
+// when no TTL is configured.
+const DefaultHealthCacheTTL = 5 * time.Second
+
+// healthProbeTimeout bounds one provider health check. The check runs
+// detached from the caller's context, so a client that gives up early
+// can't leave failures in the cache for every later caller.
+const healthProbeTimeout = 2 * time.Second
+
+// providerFailure is an agent's most recent failed health check.
+type providerFailure struct {
+	err string
+	at  time.Time
+}
+
+// providerHealthCache reuses provider health results for ttl, so frequent
+// /health and /ready probes don't reach the providers every time, and
+// remembers each agent's last failure after it recovers.
+type providerHealthCache struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	checked  time.Time
+	statuses map[string]ProviderHealthStatus
+	failures map[string]providerFailure
+}
+
+func newProviderHealthCache(ttl time.Duration) *providerHealthCache {
+	return &providerHealthCache{ttl: ttl, failures: make(map[string]providerFailure)}
+}
+
+// setTTL changes the TTL and drops the cached statuses, keeping the
+// recorded failures.
+func (c *providerHealthCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.statuses = nil
+}
+
+// get returns the cached statuses while they are fresh, otherwise runs
+// check under its own timeout and caches its statuses. Concurrent callers
+// wait for one check rather than each probing the providers.
+func (c *providerHealthCache) get(ctx context.Context, check func(context.Context) map[string]ProviderHealthStatus) map[string]ProviderHealthStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.statuses != nil && c.ttl > 0 && time.Since(c.checked) < c.ttl {
+		return copyHealthStatuses(c.statuses)
+	}
+	probeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthProbeTimeout)
+	defer cancel()
+	statuses := check(probeCtx)
+	for agent, status := range statuses {
+		if status.Error != "" {
+			c.failures[agent] = providerFailure{err: status.Error, at: status.CheckedAt}
+		}
+		if failure, ok := c.failures[agent]; ok {
+			at := failure.at
+			status.LastError = failure.err
+			status.LastErrorAt = &at
+			statuses[agent] = status
+		}
+	}
+	c.statuses = statuses
+	c.checked = time.Now()
+	return copyHealthStatuses(statuses)
+}
+
+func copyHealthStatuses(statuses map[string]ProviderHealthStatus) map[string]ProviderHealthStatus {
+	copied := make(map[string]ProviderHealthStatus, len(statuses))
+	for agent, status := range statuses {
+		copied[agent] = status
+	}
+	return copied
+}
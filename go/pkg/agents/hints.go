@@ -0,0 +1,61 @@
+package agents
+
+import (
+	"context"
+	"strings"
+)
+
+// HintAgents lists the agents that read a request's agent_hints. Hints
+// keyed by any other name are ignored.
+var HintAgents = []string{"director", "writer", "checker", "editor", "committer"}
+
+// MaxAgentHintLength caps each agent hint, in characters.
+const MaxAgentHintLength = 500
+
+// IsHintAgent reports whether agent is one of HintAgents.
+func IsHintAgent(agent string) bool {
+	for _, name := range HintAgents {
+		if name == agent {
+			return true
+		}
+	}
+	return false
+}
+
+type agentHintsKey struct{}
+
+// agentHints are a request's hints and the language of its prompts.
+type agentHints struct {
+	hints    map[string]string
+	language string
+}
+
+// withAgentHints makes agents generating under ctx append their hint from
+// hints to the user prompt, introduced in language.
+func withAgentHints(ctx context.Context, hints map[string]string, language string) context.Context {
+	if len(hints) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, agentHintsKey{}, agentHints{hints: hints, language: language})
+}
+
+// agentHintFor returns the request's hint for agent, if any, and the
+// request's prompt language.
+func agentHintFor(ctx context.Context, agent string) (hint, language string) {
+	hints, _ := ctx.Value(agentHintsKey{}).(agentHints)
+	return hints.hints[agent], hints.language
+}
+
+// appendAgentHint adds a request hint to the end of a user prompt under
+// language's hint header.
+func appendAgentHint(prompt, hint, language string) string {
+	hint = strings.TrimSpace(hint)
+	if hint == "" {
+		return prompt
+	}
+	header, ok := agentHintHeaders[language]
+	if !ok {
+		header = agentHintHeaders[DefaultPromptLanguage]
+	}
+	return prompt + "\n\n" + header + "\n" + hint
+}
@@ -0,0 +1,50 @@
+package agents
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// newProviderClient returns the HTTP client of a provider whose configured
+// timeout is timeout. Unlike http.Client.Timeout, the timeout only bounds
+// requests whose context has no deadline: the pipeline's own deadlines,
+// such as the writer's scaled timeout, may run longer, and streamed
+// responses are not cut off mid-stream.
+func newProviderClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: &deadlineTransport{base: http.DefaultTransport, timeout: timeout}}
+}
+
+// deadlineTransport gives requests without a context deadline one of
+// timeout, lasting until their response body is closed.
+type deadlineTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); ok || t.timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
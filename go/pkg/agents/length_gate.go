@@ -0,0 +1,153 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
+	"github.com/rs/zerolog/log"
+)
+
+// Length gate actions for writer output over the allowed length.
+const (
+	LengthActionWarn       = "warn"
+	LengthActionTruncate   = "truncate"
+	LengthActionRegenerate = "regenerate"
+)
+
+// IsValidLengthAction reports whether a is a length gate action.
+func IsValidLengthAction(a string) bool {
+	switch a {
+	case LengthActionWarn, LengthActionTruncate, LengthActionRegenerate:
+		return true
+	}
+	return false
+}
+
+// gateLength handles writer output longer than lengthFactor times the
+// target, per lengthAction: it warns, truncates at a sentence boundary, or
+// regenerates once with a strict limit. ctx carries the writer's routing
+// but not its timeout. Time and tokens spent regenerating are added to
+// stage.
+func (s *Swarm) gateLength(ctx context.Context, response *models.SceneResponse, stage *models.StageInfo, input *WriterInput, text string) string {
+	if s.lengthFactor <= 0 || input.WordCount <= 0 {
+		return text
+	}
+	limit := int(float64(input.WordCount) * s.lengthFactor)
+	length := textutil.CountChars(text)
+	if length <= limit {
+		return text
+	}
+	log.Warn().
+		Int("length", length).
+		Int("word_count", input.WordCount).
+		Int("limit", limit).
+		Str("action", s.lengthAction).
+		Msg("Writer output exceeds the length gate")
+
+	switch s.lengthAction {
+	case LengthActionTruncate:
+		truncated, _ := textutil.TruncateAtSentence(text, limit)
+		addWarning(response, models.WarningLengthTruncated, "writer",
+			fmt.Sprintf("output was %d characters for a target of %d; truncated to %d", length, input.WordCount, textutil.CountChars(truncated)))
+		return truncated
+
+	case LengthActionRegenerate:
+		if !withinBudget(ctx, response, "writer", "length regeneration", 2*estimateProseTokens(text, input.Language)) {
+			break
+		}
+		strict := *input
+		strict.MaxChars = limit
+		// The draft already streamed; the regeneration only shows up in
+		// the final text.
+		regenCtx, cancel := context.WithTimeout(withoutStreamSink(ctx), s.writerTimeoutFor(ctx, input.WordCount, input.Language))
+		result, err := s.writer.Execute(regenCtx, &strict)
+		cancel()
+		if err != nil {
+			log.Warn().Err(err).Msg("Length-gate regeneration failed, keeping the original output")
+			break
+		}
+		stage.DurationMs += result.DurationMs
+		stage.Tokens += result.PromptTokens + result.CompletionTokens
+		warnIfTruncated(response, "writer", result)
+
+		regenerated := textutil.CountChars(result.Text)
+		if regenerated <= limit && strings.TrimSpace(result.Text) != "" {
+			addWarning(response, models.WarningLengthRegenerated, "writer",
+				fmt.Sprintf("output was %d characters for a target of %d; regenerated at %d", length, input.WordCount, regenerated))
+			return result.Text
+		}
+		if regenerated < length && strings.TrimSpace(result.Text) != "" {
+			text, length = result.Text, regenerated
+		}
+	}
+
+	addWarning(response, models.WarningLengthExceeded, "writer",
+		fmt.Sprintf("output is %d characters for a target of %d (limit %d)", length, input.WordCount, limit))
+	return text
+}
+
+// fitLength runs one writer pass expanding or condensing text when its
+// length is outside lengthTolerance of input.WordCount. The rewrite is
+// kept when it lands closer to the target. ctx carries the writer's
+// routing but not its timeout. Time and tokens spent are added to stage.
+func (s *Swarm) fitLength(ctx context.Context, response *models.SceneResponse, stage *models.StageInfo, input *WriterInput, text string) string {
+	wordCount := input.WordCount
+	if s.lengthTolerance <= 0 || wordCount <= 0 || strings.TrimSpace(text) == "" {
+		return text
+	}
+	length := textutil.CountChars(text)
+	if withinTolerance(length, wordCount, s.lengthTolerance) {
+		return text
+	}
+	log.Info().
+		Int("length", length).
+		Int("word_count", wordCount).
+		Float64("tolerance", s.lengthTolerance).
+		Msg("Writer output is off the target length, adjusting")
+
+	if !withinBudget(ctx, response, "writer", "length adjustment", 2*estimateProseTokens(text, input.Language)) {
+		addWarning(response, models.WarningLengthOffTarget, "writer",
+			fmt.Sprintf("output is %d characters for a target of %d; not adjusted", length, wordCount))
+		return text
+	}
+	// As with regeneration, the streamed draft is not replaced live.
+	adjustCtx, cancel := context.WithTimeout(withoutStreamSink(ctx), s.writerTimeoutFor(ctx, wordCount, input.Language))
+	result, err := s.writer.AdjustLength(adjustCtx, text, length, wordCount, input.Language)
+	cancel()
+	if err != nil {
+		log.Warn().Err(err).Msg("Length adjustment failed, keeping the original output")
+		addWarning(response, models.WarningLengthOffTarget, "writer",
+			fmt.Sprintf("output is %d characters for a target of %d; adjustment failed", length, wordCount))
+		return text
+	}
+	stage.DurationMs += result.DurationMs
+	stage.Tokens += result.PromptTokens + result.CompletionTokens
+	warnIfTruncated(response, "writer", result)
+
+	adjusted := textutil.CountChars(result.Text)
+	if strings.TrimSpace(result.Text) != "" && lengthDistance(adjusted, wordCount) < lengthDistance(length, wordCount) {
+		addWarning(response, models.WarningLengthAdjusted, "writer",
+			fmt.Sprintf("output was %d characters for a target of %d; adjusted to %d", length, wordCount, adjusted))
+		if withinTolerance(adjusted, wordCount, s.lengthTolerance) {
+			return result.Text
+		}
+		text, length = result.Text, adjusted
+	}
+	addWarning(response, models.WarningLengthOffTarget, "writer",
+		fmt.Sprintf("output is %d characters for a target of %d (tolerance ±%.0f%%)", length, wordCount, s.lengthTolerance*100))
+	return text
+}
+
+func withinTolerance(length, target int, tolerance float64) bool {
+	return float64(lengthDistance(length, target)) <= float64(target)*tolerance
+}
+
+func lengthDistance(length, target int) int {
+	if length > target {
+		return length - target
+	}
+	return target - length
+}
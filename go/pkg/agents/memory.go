@@ -0,0 +1,367 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Foreshadowing statuses, as in the project's memory/foreshadow.json.
+const (
+	ForeshadowingUnresolved = "unresolved"
+	ForeshadowingResolved   = "resolved"
+	ForeshadowingAbandoned  = "abandoned"
+)
+
+// MemoryStore persists committed scenes and the project memory derived
+// from them.
+type MemoryStore interface {
+	// SaveScene writes a scene's prose and the facts its spec carries.
+	SaveScene(ctx context.Context, scene *SceneRecord) error
+	// GetFacts returns the facts of every saved scene, in scene order.
+	GetFacts(ctx context.Context) ([]SceneFact, error)
+	// UpdateForeshadowing marks the resolve IDs resolved and plants new
+	// entries, returning the IDs actually resolved and planted.
+	UpdateForeshadowing(ctx context.Context, chapter int, resolve, plant []string) (resolved, planted []string, err error)
+}
+
+// SceneRecord is one committed scene.
+type SceneRecord struct {
+	Chapter  int
+	Scene    int
+	Title    string
+	Text     string
+	Summary  string
+	Spec     *models.SceneSpec
+	Metadata map[string]string
+	// ForeshadowingResolved and ForeshadowingPlanted are the IDs the
+	// scene's commit changed.
+	ForeshadowingResolved []string
+	ForeshadowingPlanted  []string
+}
+
+// SceneFact is a fact recorded by a saved scene.
+type SceneFact struct {
+	Chapter int    `json:"chapter"`
+	Scene   int    `json:"scene"`
+	Content string `json:"content"`
+}
+
+// FileMemoryStore keeps memory under a project directory:
+// chapters/ch{N}/scene{M}.md with a scene{M}.json facts sidecar, and
+// foreshadowing in memory/foreshadow.json.
+type FileMemoryStore struct {
+	root string
+	mu   sync.Mutex
+}
+
+// NewFileMemoryStore creates a store rooted at the project directory.
+func NewFileMemoryStore(root string) *FileMemoryStore {
+	return &FileMemoryStore{root: root}
+}
+
+// sceneSidecar is the JSON written next to each scene's prose.
+type sceneSidecar struct {
+	Chapter               int               `json:"chapter"`
+	Scene                 int               `json:"scene"`
+	Title                 string            `json:"title,omitempty"`
+	Summary               string            `json:"summary,omitempty"`
+	Facts                 []string          `json:"facts"`
+	ForeshadowingResolved []string          `json:"foreshadowing_resolved,omitempty"`
+	ForeshadowingPlanted  []string          `json:"foreshadowing_planted,omitempty"`
+	Metadata              map[string]string `json:"metadata,omitempty"`
+	SavedAt               time.Time         `json:"saved_at"`
+}
+
+// foreshadowing is one entry of memory/foreshadow.json.
+type foreshadowing struct {
+	ID                string   `json:"id"`
+	Content           string   `json:"content"`
+	Status            string   `json:"status"`
+	CreatedIn         string   `json:"created_in"`
+	CreatedAt         string   `json:"created_at,omitempty"`
+	TargetResolution  *string  `json:"target_resolution"`
+	RelatedChapters   []string `json:"related_chapters"`
+	ResolutionChapter *string  `json:"resolution_chapter"`
+	ResolutionNote    *string  `json:"resolution_note"`
+	Priority          string   `json:"priority"`
+	Tags              []string `json:"tags"`
+}
+
+type foreshadowFile struct {
+	Meta           map[string]any  `json:"_meta"`
+	Foreshadowings []foreshadowing `json:"foreshadowings"`
+}
+
+func (s *FileMemoryStore) scenePath(chapter, scene int, ext string) string {
+	return filepath.Join(s.root, "chapters", fmt.Sprintf("ch%d", chapter), fmt.Sprintf("scene%d%s", scene, ext))
+}
+
+func (s *FileMemoryStore) foreshadowPath() string {
+	return filepath.Join(s.root, "memory", "foreshadow.json")
+}
+
+// SaveScene writes the prose as Markdown and the facts sidecar, replacing
+// an earlier save of the same scene.
+func (s *FileMemoryStore) SaveScene(ctx context.Context, scene *SceneRecord) error {
+	if scene.Chapter <= 0 || scene.Scene <= 0 {
+		return fmt.Errorf("scene needs a positive chapter and scene number, got ch%d/scene%d", scene.Chapter, scene.Scene)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var prose strings.Builder
+	if title := strings.TrimSpace(scene.Title); title != "" {
+		prose.WriteString("# " + title + "\n\n")
+	}
+	prose.WriteString(strings.TrimSpace(scene.Text))
+	prose.WriteString("\n")
+
+	sidecar := sceneSidecar{
+		Chapter:               scene.Chapter,
+		Scene:                 scene.Scene,
+		Title:                 scene.Title,
+		Summary:               scene.Summary,
+		Facts:                 []string{},
+		ForeshadowingResolved: scene.ForeshadowingResolved,
+		ForeshadowingPlanted:  scene.ForeshadowingPlanted,
+		Metadata:              scene.Metadata,
+		SavedAt:               time.Now().UTC(),
+	}
+	if scene.Spec != nil {
+		for _, fact := range scene.Spec.Continuity.FactsToReinforce {
+			if fact = strings.TrimSpace(fact); fact != "" {
+				sidecar.Facts = append(sidecar.Facts, fact)
+			}
+		}
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode scene sidecar: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeFileAtomic(s.scenePath(scene.Chapter, scene.Scene, ".md"), []byte(prose.String())); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.scenePath(scene.Chapter, scene.Scene, ".json"), data)
+}
+
+// GetFacts reads the facts from every scene sidecar, ordered by chapter
+// and scene. Unreadable sidecars are skipped with a warning.
+func (s *FileMemoryStore) GetFacts(ctx context.Context) ([]SceneFact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.root, "chapters", "ch*", "scene*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var sidecars []sceneSidecar
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scene sidecar: %w", err)
+		}
+		var sidecar sceneSidecar
+		if err := json.Unmarshal(data, &sidecar); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Skipping unreadable scene sidecar")
+			continue
+		}
+		sidecars = append(sidecars, sidecar)
+	}
+	sort.Slice(sidecars, func(i, j int) bool {
+		if sidecars[i].Chapter != sidecars[j].Chapter {
+			return sidecars[i].Chapter < sidecars[j].Chapter
+		}
+		return sidecars[i].Scene < sidecars[j].Scene
+	})
+
+	var facts []SceneFact
+	for _, sidecar := range sidecars {
+		for _, fact := range sidecar.Facts {
+			facts = append(facts, SceneFact{Chapter: sidecar.Chapter, Scene: sidecar.Scene, Content: fact})
+		}
+	}
+	return facts, nil
+}
+
+// UpdateForeshadowing resolves entries by ID and plants new unresolved
+// ones with the next free fsNNN ID. Unknown IDs are skipped, as are
+// plants matching an entry that is still unresolved, so a repeated commit
+// does not duplicate them.
+func (s *FileMemoryStore) UpdateForeshadowing(ctx context.Context, chapter int, resolve, plant []string) ([]string, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := s.loadForeshadowing()
+	if err != nil {
+		return nil, nil, err
+	}
+	label := fmt.Sprintf("chapter_%03d", chapter)
+
+	var resolved []string
+	for _, id := range resolve {
+		id = strings.TrimSpace(id)
+		found := false
+		for i := range file.Foreshadowings {
+			entry := &file.Foreshadowings[i]
+			if entry.ID != id {
+				continue
+			}
+			found = true
+			if entry.Status == ForeshadowingResolved {
+				break
+			}
+			entry.Status = ForeshadowingResolved
+			entry.ResolutionChapter = &label
+			if !containsString(entry.RelatedChapters, label) {
+				entry.RelatedChapters = append(entry.RelatedChapters, label)
+			}
+			resolved = append(resolved, id)
+			break
+		}
+		if !found && id != "" {
+			log.Warn().Str("id", id).Msg("Ignoring unknown foreshadowing ID")
+		}
+	}
+
+	var planted []string
+	next := nextForeshadowingNumber(file.Foreshadowings)
+	for _, content := range plant {
+		content = strings.TrimSpace(content)
+		if content == "" || hasUnresolvedForeshadowing(file.Foreshadowings, content) {
+			continue
+		}
+		id := fmt.Sprintf("fs%03d", next)
+		next++
+		file.Foreshadowings = append(file.Foreshadowings, foreshadowing{
+			ID:              id,
+			Content:         content,
+			Status:          ForeshadowingUnresolved,
+			CreatedIn:       label,
+			CreatedAt:       time.Now().UTC().Format("2006-01-02"),
+			RelatedChapters: []string{label},
+			Priority:        "medium",
+			Tags:            []string{},
+		})
+		planted = append(planted, id)
+	}
+
+	if len(resolved) == 0 && len(planted) == 0 {
+		return nil, nil, nil
+	}
+	if err := s.saveForeshadowing(file); err != nil {
+		return nil, nil, err
+	}
+	return resolved, planted, nil
+}
+
+func (s *FileMemoryStore) loadForeshadowing() (*foreshadowFile, error) {
+	data, err := os.ReadFile(s.foreshadowPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return &foreshadowFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreshadowing: %w", err)
+	}
+	var file foreshadowFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse foreshadowing: %w", err)
+	}
+	return &file, nil
+}
+
+func (s *FileMemoryStore) saveForeshadowing(file *foreshadowFile) error {
+	counts := map[string]int{}
+	for _, entry := range file.Foreshadowings {
+		counts[entry.Status]++
+	}
+	// Refresh the counts but keep whatever else the file's _meta holds.
+	if file.Meta == nil {
+		file.Meta = map[string]any{"description": "Foreshadowing Tracker - SSOT"}
+	}
+	file.Meta["total"] = len(file.Foreshadowings)
+	file.Meta["unresolved"] = counts[ForeshadowingUnresolved]
+	file.Meta["resolved"] = counts[ForeshadowingResolved]
+	file.Meta["abandoned"] = counts[ForeshadowingAbandoned]
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode foreshadowing: %w", err)
+	}
+	return writeFileAtomic(s.foreshadowPath(), data)
+}
+
+// nextForeshadowingNumber returns one past the highest fsNNN ID in use.
+func nextForeshadowingNumber(entries []foreshadowing) int {
+	highest := 0
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(strings.TrimPrefix(entry.ID, "fs")); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest + 1
+}
+
+func hasUnresolvedForeshadowing(entries []foreshadowing, content string) bool {
+	for _, entry := range entries {
+		if entry.Status == ForeshadowingUnresolved && entry.Content == content {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic writes data through a temporary file in the same
+// directory, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
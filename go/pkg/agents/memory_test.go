@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestCommitterPersistsScenes(t *testing.T) {
+	root := t.TempDir()
+	store := NewFileMemoryStore(root)
+	committer := NewCommitterAgent(AgentConfig{Provider: &stubProvider{}})
+	committer.store = store
+
+	first := &models.SceneSpec{}
+	first.Scene.Title = "出会い"
+	first.Continuity.FactsToReinforce = []string{"葵は十七歳", " "}
+	first.Continuity.ForeshadowingToPlant = []string{"古井戸の音", "赤い手紙"}
+	if err := committer.Commit(context.Background(), &CommitterInput{Text: "本文一。", Chapter: 1, Scene: 2, SceneSpec: first}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prose, err := os.ReadFile(filepath.Join(root, "chapters", "ch1", "scene2.md"))
+	if err != nil || string(prose) != "# 出会い\n\n本文一。\n" {
+		t.Fatalf("unexpected prose %q (%v)", prose, err)
+	}
+
+	second := &models.SceneSpec{}
+	second.Continuity.FactsToReinforce = []string{"蓮は剣士"}
+	second.Continuity.ForeshadowingToResolve = []string{"fs001", "fs999"}
+	second.Continuity.ForeshadowingToPlant = []string{"赤い手紙"}
+	if err := committer.Commit(context.Background(), &CommitterInput{Text: "本文二。", Chapter: 1, Scene: 1, SceneSpec: second}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	facts, err := store.GetFacts(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(facts) != 2 || facts[0].Content != "蓮は剣士" || facts[1].Content != "葵は十七歳" || facts[1].Scene != 2 {
+		t.Fatalf("expected facts in scene order, got %+v", facts)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "memory", "foreshadow.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var file foreshadowFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(file.Foreshadowings) != 2 {
+		t.Fatalf("expected the repeated plant to be skipped, got %+v", file.Foreshadowings)
+	}
+	if fs := file.Foreshadowings[0]; fs.ID != "fs001" || fs.Status != ForeshadowingResolved || *fs.ResolutionChapter != "chapter_001" {
+		t.Fatalf("expected fs001 resolved, got %+v", fs)
+	}
+	if fs := file.Foreshadowings[1]; fs.ID != "fs002" || fs.Status != ForeshadowingUnresolved {
+		t.Fatalf("expected fs002 unresolved, got %+v", fs)
+	}
+
+	var sidecar sceneSidecar
+	data, _ = os.ReadFile(filepath.Join(root, "chapters", "ch1", "scene1.json"))
+	if err := json.Unmarshal(data, &sidecar); err != nil || len(sidecar.ForeshadowingResolved) != 1 || len(sidecar.ForeshadowingPlanted) != 0 {
+		t.Fatalf("unexpected sidecar %+v (%v)", sidecar, err)
+	}
+}
+
+func TestForeshadowingKeepsCallerMeta(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "memory", "foreshadow.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	existing := `{"_meta": {"description": "Tracker", "version": 2}, "foreshadowings": []}`
+	if err := os.WriteFile(path, []byte(existing), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store := NewFileMemoryStore(root)
+	if _, _, err := store.UpdateForeshadowing(context.Background(), 1, nil, []string{"古井戸の音"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	var file foreshadowFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if file.Meta["description"] != "Tracker" || file.Meta["version"] != float64(2) {
+		t.Fatalf("expected the existing _meta kept, got %v", file.Meta)
+	}
+	if file.Meta["total"] != float64(1) || file.Meta["unresolved"] != float64(1) {
+		t.Fatalf("expected the counts refreshed, got %v", file.Meta)
+	}
+}
@@ -0,0 +1,161 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+const defaultModerationModel = "omni-moderation-latest"
+
+// Moderator screens text against a content policy.
+type Moderator interface {
+	Check(ctx context.Context, text string) (flagged bool, categories []string, err error)
+}
+
+// ModerationError reports that user input was rejected by the moderator.
+type ModerationError struct {
+	Categories []string
+}
+
+func (e *ModerationError) Error() string {
+	if len(e.Categories) == 0 {
+		return "content flagged by moderation"
+	}
+	return "content flagged by moderation: " + strings.Join(e.Categories, ", ")
+}
+
+// NewModerator builds the configured moderator. It returns nil when
+// moderation is disabled so callers can skip screening entirely.
+func NewModerator(config models.ModerationConfig) (Moderator, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(config.Type)) {
+	case "", "openai":
+		return NewOpenAIModerator(config)
+	default:
+		return nil, fmt.Errorf("moderation type not supported: %s", config.Type)
+	}
+}
+
+type openAIModerator struct {
+	model   string
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+type openAIModerationRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type openAIModerationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// NewOpenAIModerator creates a moderator backed by the OpenAI moderation endpoint.
+func NewOpenAIModerator(config models.ModerationConfig) (Moderator, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(config.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+
+	model := strings.TrimSpace(config.Model)
+	if model == "" {
+		model = defaultModerationModel
+	}
+
+	apiKeyEnv := strings.TrimSpace(config.APIKeyEnv)
+	if apiKeyEnv == "" {
+		apiKeyEnv = models.DefaultAPIKeyEnv(models.ProviderTypeOpenAI)
+	}
+	apiKey := strings.TrimSpace(os.Getenv(apiKeyEnv))
+	if apiKey == "" {
+		return nil, fmt.Errorf("moderation API key is missing in env %s", apiKeyEnv)
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &openAIModerator{
+		model:   model,
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (m *openAIModerator) Check(ctx context.Context, text string) (bool, []string, error) {
+	body, err := json.Marshal(openAIModerationRequest{Model: m.model, Input: text})
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to marshal moderation request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAIAPIRoot(m.baseURL)+"/moderations", bytes.NewReader(body))
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to build moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, nil, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return false, nil, fmt.Errorf("failed reading moderation response: %w", err)
+	}
+
+	var out openAIModerationResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return false, nil, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg := strings.TrimSpace(string(raw))
+		if out.Error != nil && out.Error.Message != "" {
+			msg = out.Error.Message
+		}
+		return false, nil, fmt.Errorf("moderation returned %d: %s", resp.StatusCode, msg)
+	}
+
+	flagged := false
+	var categories []string
+	for _, result := range out.Results {
+		if !result.Flagged {
+			continue
+		}
+		flagged = true
+		for category, hit := range result.Categories {
+			if hit {
+				categories = append(categories, category)
+			}
+		}
+	}
+	sort.Strings(categories)
+
+	return flagged, categories, nil
+}
@@ -0,0 +1,49 @@
+package agents
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestNewModeratorDisabledByDefault(t *testing.T) {
+	moderator, err := NewModerator(models.ModerationConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if moderator != nil {
+		t.Fatal("expected no moderator when disabled")
+	}
+}
+
+func TestOpenAIModeratorCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/moderations" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[{"flagged":true,"categories":{"violence":true,"hate":false}}]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("TEST_MODERATION_KEY", "secret")
+	moderator, err := NewModerator(models.ModerationConfig{
+		Enabled:   true,
+		BaseURL:   server.URL,
+		APIKeyEnv: "TEST_MODERATION_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	flagged, categories, err := moderator.Check(context.Background(), "text")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !flagged || len(categories) != 1 || categories[0] != "violence" {
+		t.Fatalf("unexpected result flagged=%v categories=%v", flagged, categories)
+	}
+}
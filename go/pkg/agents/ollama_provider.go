@@ -1,6 +1,7 @@
 package agents
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,17 +9,26 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
 )
 
 const defaultOllamaBaseURL = "http://localhost:11434"
 
 type ollamaProvider struct {
-	model   string
-	baseURL string
-	client  *http.Client
+	model          string
+	embeddingModel string
+	baseURL        string
+	client         *http.Client
+	retry          models.RetryConfig
+
+	// chatEmbeddings is set when no embedding_model is configured and
+	// Embed falls back to the chat model, warned about on first use.
+	chatEmbeddings bool
+	warnOnce       sync.Once
 }
 
 type ollamaChatRequest struct {
@@ -35,11 +45,23 @@ type ollamaChatResponse struct {
 	} `json:"message"`
 	PromptEvalCount int    `json:"prompt_eval_count"`
 	EvalCount       int    `json:"eval_count"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
 	Error           string `json:"error"`
 }
 
+type ollamaEmbeddingRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+}
+
+type ollamaEmbeddingResponse struct {
+	Embedding []float32 `json:"embedding"`
+	Error     string    `json:"error"`
+}
+
 func init() {
-	RegisterProviderFactory("ollama", NewOllamaProvider)
+	RegisterProviderFactory(models.ProviderTypeOllama, NewOllamaProvider)
 }
 
 // NewOllamaProvider creates a provider backed by Ollama HTTP API.
@@ -58,10 +80,19 @@ func NewOllamaProvider(config models.ProviderConfig) (Provider, error) {
 		timeout = 120 * time.Second
 	}
 
+	embeddingModel := strings.TrimSpace(config.EmbeddingModel)
+	chatEmbeddings := embeddingModel == ""
+	if chatEmbeddings {
+		embeddingModel = config.Model
+	}
+
 	return &ollamaProvider{
-		model:   config.Model,
-		baseURL: baseURL,
-		client:  &http.Client{Timeout: timeout},
+		model:          config.Model,
+		embeddingModel: embeddingModel,
+		chatEmbeddings: chatEmbeddings,
+		baseURL:        baseURL,
+		client:         newProviderClient(timeout),
+		retry:          config.Retry,
 	}, nil
 }
 
@@ -69,17 +100,84 @@ func (p *ollamaProvider) Name() string {
 	return "ollama"
 }
 
+func (p *ollamaProvider) retryConfig() models.RetryConfig {
+	return p.retry
+}
+
+func (p *ollamaProvider) modelName() string {
+	return p.model
+}
+
 func (p *ollamaProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	body, err := p.requestBody(messages, params, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.post(ctx, "/api/chat", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+	if out.Error != "" {
+		return nil, fmt.Errorf("ollama error: %s", out.Error)
+	}
+
+	promptTokens := out.PromptEvalCount
+	completionTokens := out.EvalCount
+	if promptTokens <= 0 {
+		promptTokens = estimateTokensFromMessages(messages)
+	}
+	if completionTokens <= 0 {
+		completionTokens = estimateTokensFromText(out.Message.Content)
+	}
+
+	return &models.GenerationResult{
+		Text:             strings.TrimSpace(out.Message.Content),
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		FinishReason:     out.DoneReason,
+	}, nil
+}
+
+// GenerateStream requests a streamed chat and delivers its message deltas
+// as they arrive. A stream that breaks off before its done line ends with
+// an error chunk.
+func (p *ollamaProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	body, err := p.requestBody(messages, params, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.post(ctx, "/api/chat", body)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(chan StreamChunk)
+	go readOllamaStream(ctx, resp.Body, chunks)
+	return chunks, nil
+}
+
+func (p *ollamaProvider) requestBody(messages []Message, params GenerateParams, stream bool) ([]byte, error) {
 	reqPayload := ollamaChatRequest{
 		Model:    p.model,
 		Messages: messages,
-		Stream:   false,
+		Stream:   stream,
 		Options: map[string]interface{}{
 			"temperature": params.Temperature,
 			"num_predict": params.MaxTokens,
-			"top_p":       params.TopP,
 		},
 	}
+	// Zero means unset; Ollama would otherwise take top_p=0 literally.
+	if params.TopP > 0 {
+		reqPayload.Options["top_p"] = params.TopP
+	}
+	if params.Seed != 0 {
+		reqPayload.Options["seed"] = params.Seed
+	}
 	if params.JSONMode {
 		reqPayload.Format = "json"
 	}
@@ -88,8 +186,13 @@ func (p *ollamaProvider) Generate(ctx context.Context, messages []Message, param
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal ollama request: %w", err)
 	}
+	return body, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/chat", bytes.NewReader(body))
+// post sends a request to an API path and returns the response for a 2xx
+// status. The caller closes its body.
+func (p *ollamaProvider) post(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to build ollama request: %w", err)
 	}
@@ -99,35 +202,102 @@ func (p *ollamaProvider) Generate(ctx context.Context, messages []Message, param
 	if err != nil {
 		return nil, fmt.Errorf("ollama request failed: %w", err)
 	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= http.StatusBadRequest {
-		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("ollama returned %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, &ProviderHTTPError{
+		Provider:   "ollama",
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(raw)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
 	}
+}
 
-	var out ollamaChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, fmt.Errorf("failed to decode ollama response: %w", err)
+// Embed requests an embedding per text; /api/embeddings takes one prompt
+// per call. Without an embedding_model it embeds with the chat model,
+// which most chat models do poorly, and warns once.
+func (p *ollamaProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if p.chatEmbeddings {
+		p.warnOnce.Do(func() {
+			log.Warn().
+				Str("model", p.model).
+				Msg("Ollama embedding_model is not set, embedding with the chat model; set a dedicated embedding model such as nomic-embed-text")
+		})
 	}
-	if out.Error != "" {
-		return nil, fmt.Errorf("ollama error: %s", out.Error)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		body, err := json.Marshal(ollamaEmbeddingRequest{Model: p.embeddingModel, Prompt: text})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ollama embeddings request: %w", err)
+		}
+		resp, err := p.post(ctx, "/api/embeddings", body)
+		if err != nil {
+			return nil, err
+		}
+		var out ollamaEmbeddingResponse
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode ollama embeddings response: %w", err)
+		}
+		if out.Error != "" {
+			return nil, fmt.Errorf("ollama error: %s", out.Error)
+		}
+		if len(out.Embedding) == 0 {
+			return nil, fmt.Errorf("ollama returned an empty embedding for input %d", i)
+		}
+		vectors[i] = out.Embedding
 	}
+	return vectors, nil
+}
 
-	promptTokens := out.PromptEvalCount
-	completionTokens := out.EvalCount
-	if promptTokens <= 0 {
-		promptTokens = estimateTokensFromMessages(messages)
+// readOllamaStream parses the newline-delimited JSON objects of a streamed
+// chat from body into chunks until the one marked done, then closes body
+// and chunks. Lines split across reads are buffered until complete, and
+// eval counts are summed over the lines that carry them.
+func readOllamaStream(ctx context.Context, body io.ReadCloser, chunks chan<- StreamChunk) {
+	defer close(chunks)
+	defer body.Close()
+
+	done := StreamChunk{Done: true}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var event ollamaChatResponse
+		if err := json.Unmarshal(line, &event); err != nil {
+			sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("failed to decode ollama stream line: %w", err)})
+			return
+		}
+		if event.Error != "" {
+			sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("ollama error: %s", event.Error)})
+			return
+		}
+		done.PromptTokens += event.PromptEvalCount
+		done.CompletionTokens += event.EvalCount
+		if event.Message.Content != "" {
+			if !sendChunk(ctx, chunks, StreamChunk{Text: event.Message.Content}) {
+				return
+			}
+		}
+		if event.Done {
+			done.FinishReason = event.DoneReason
+			sendChunk(ctx, chunks, done)
+			return
+		}
 	}
-	if completionTokens <= 0 {
-		completionTokens = estimateTokensFromText(out.Message.Content)
+	if err := scanner.Err(); err != nil {
+		sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("failed reading ollama stream: %w", err)})
+		return
 	}
-
-	return &models.GenerationResult{
-		Text:             strings.TrimSpace(out.Message.Content),
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-	}, nil
+	sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("ollama stream ended before done")})
 }
 
 func (p *ollamaProvider) Capabilities() ProviderCapabilities {
@@ -136,7 +306,8 @@ func (p *ollamaProvider) Capabilities() ProviderCapabilities {
 		SupportsTools:     false,
 		SupportsJSONMode:  true,
 		SupportsThinking:  true,
-		SupportsStreaming: false,
+		SupportsStreaming: true,
+		MaxTemperature:    2,
 	}
 }
 
@@ -0,0 +1,125 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestOllamaGenerateStaysUnstreamed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["stream"] != false {
+			t.Errorf("expected a non-streaming request, got %v (%v)", body, err)
+		}
+		_, _ = w.Write([]byte(`{"message":{"content":" 雨が降る。 "},"prompt_eval_count":12,"eval_count":3,"done":true,"done_reason":"stop"}`))
+	}))
+	defer server.Close()
+
+	provider, err := NewOllamaProvider(models.ProviderConfig{Type: "ollama", Model: "qwen3", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := provider.Generate(context.Background(), []Message{{Role: "user", Content: "hi"}}, GenerateParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Text != "雨が降る。" || result.PromptTokens != 12 || result.CompletionTokens != 3 || result.FinishReason != "stop" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestOllamaGenerateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["stream"] != true {
+			t.Errorf("expected a streaming request, got %v (%v)", body, err)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher := w.(http.Flusher)
+		// The second line arrives in two writes, split inside a rune.
+		second := []byte(`{"message":{"content":"降る。"},"done":false}` + "\n")
+		for _, part := range [][]byte{
+			[]byte(`{"message":{"content":"雨が"},"done":false}` + "\n"),
+			second[:25],
+			second[25:],
+			[]byte(`{"message":{"content":""},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":3}`),
+		} {
+			_, _ = w.Write(part)
+			flusher.Flush()
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewOllamaProvider(models.ProviderConfig{Type: "ollama", Model: "qwen3", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The agent forwards each delta to the sink and assembles the result.
+	var tokens []string
+	ctx := WithStreamSink(context.Background(), "writer", func(text string) { tokens = append(tokens, text) })
+	result, err := NewBaseAgent("writer", provider).Generate(ctx, "s", "u", GenerateParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(tokens, "|") != "雨が|降る。" {
+		t.Fatalf("expected two streamed deltas, got %q", tokens)
+	}
+	if result.Text != "雨が降る。" || result.FinishReason != "stop" || result.PromptTokens != 12 || result.CompletionTokens != 3 {
+		t.Fatalf("unexpected assembled result %+v", result)
+	}
+}
+
+func TestOllamaStreamWithoutDoneFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"message":{"content":"途中"},"done":false}` + "\n"))
+	}))
+	defer server.Close()
+
+	provider, err := NewOllamaProvider(models.ProviderConfig{Type: "ollama", Model: "qwen3", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	chunks, err := provider.GenerateStream(context.Background(), []Message{{Role: "user", Content: "hi"}}, GenerateParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var last StreamChunk
+	for chunk := range chunks {
+		last = chunk
+	}
+	if last.Err == nil {
+		t.Fatalf("expected a truncated stream to end with an error, got %+v", last)
+	}
+}
+
+func TestOllamaEmbed(t *testing.T) {
+	var prompts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body ollamaEmbeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.URL.Path != "/api/embeddings" || body.Model != "nomic-embed-text" {
+			t.Errorf("unexpected request %s %+v (%v)", r.URL.Path, body, err)
+		}
+		prompts = append(prompts, body.Prompt)
+		_, _ = w.Write([]byte(`{"embedding":[0.5,0.5]}`))
+	}))
+	defer server.Close()
+
+	provider, err := NewOllamaProvider(models.ProviderConfig{Type: "ollama", Model: "qwen3", EmbeddingModel: "nomic-embed-text", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vectors, err := provider.Embed(context.Background(), []string{"雨", "晴れ"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vectors) != 2 || strings.Join(prompts, "|") != "雨|晴れ" {
+		t.Fatalf("expected one call per text, got %v for %q", vectors, prompts)
+	}
+}
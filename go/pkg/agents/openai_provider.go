@@ -1,44 +1,79 @@
 package agents
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/novelist/novelist/pkg/models"
 )
 
-const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+const (
+	defaultOpenAIBaseURL        = "https://api.openai.com/v1"
+	defaultOpenAIEmbeddingModel = "text-embedding-3-small"
+)
 
 type openAIProvider struct {
-	model      string
-	baseURL    string
-	apiKey     string
-	client     *http.Client
-	chatPath   string
-	modelsPath string
+	model          string
+	embeddingModel string
+	apiKey         string
+	client         *http.Client
+	endpoints      []openAIEndpoint
+	pool           *endpointPool
+	retry          models.RetryConfig
+}
+
+// openAIEndpoint is one base URL's resolved chat, models and embeddings
+// URLs.
+type openAIEndpoint struct {
+	chatURL       string
+	modelsURL     string
+	embeddingsURL string
 }
 
 type openAIRequest struct {
-	Model          string    `json:"model"`
-	Messages       []Message `json:"messages"`
-	Temperature    float64   `json:"temperature,omitempty"`
-	MaxTokens      int       `json:"max_tokens,omitempty"`
-	TopP           float64   `json:"top_p,omitempty"`
-	ResponseFormat any       `json:"response_format,omitempty"`
+	Model          string       `json:"model"`
+	Messages       []Message    `json:"messages"`
+	Temperature    float64      `json:"temperature,omitempty"`
+	MaxTokens      int          `json:"max_tokens,omitempty"`
+	TopP           float64      `json:"top_p,omitempty"`
+	Seed           int64        `json:"seed,omitempty"`
+	ResponseFormat any          `json:"response_format,omitempty"`
+	Tools          []openAITool `json:"tools,omitempty"`
+	ToolChoice     any          `json:"tool_choice,omitempty"`
+	Stream         bool         `json:"stream,omitempty"`
+	StreamOptions  any          `json:"stream_options,omitempty"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function ToolDefinition `json:"function"`
+}
+
+// openAIToolCall is a function call in a chat completion message.
+type openAIToolCall struct {
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
 }
 
 type openAIResponse struct {
 	Choices []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
 	Usage struct {
 		PromptTokens     int `json:"prompt_tokens"`
@@ -49,8 +84,38 @@ type openAIResponse struct {
 	} `json:"error,omitempty"`
 }
 
+// openAIStreamEvent is the payload of one "data:" line of a streamed chat
+// completion.
+type openAIStreamEvent struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type openAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
 func init() {
-	RegisterProviderFactory("openai", NewOpenAIProvider)
+	RegisterProviderFactory(models.ProviderTypeOpenAI, NewOpenAIProvider)
 }
 
 // NewOpenAIProvider creates a provider backed by OpenAI-compatible API.
@@ -66,7 +131,7 @@ func NewOpenAIProvider(config models.ProviderConfig) (Provider, error) {
 
 	apiKeyEnv := strings.TrimSpace(config.APIKeyEnv)
 	if apiKeyEnv == "" {
-		apiKeyEnv = "OPENAI_API_KEY"
+		apiKeyEnv = models.DefaultAPIKeyEnv(models.ProviderTypeOpenAI)
 	}
 	apiKey := strings.TrimSpace(os.Getenv(apiKeyEnv))
 	if apiKey == "" {
@@ -78,15 +143,44 @@ func NewOpenAIProvider(config models.ProviderConfig) (Provider, error) {
 		timeout = 60 * time.Second
 	}
 
-	chatPath, modelsPath := openAIPaths(baseURL)
+	baseURLs := []string{baseURL}
+	if len(config.BaseURLs) > 0 {
+		baseURLs = baseURLs[:0]
+		for _, raw := range config.BaseURLs {
+			if trimmed := strings.TrimRight(strings.TrimSpace(raw), "/"); trimmed != "" {
+				baseURLs = append(baseURLs, trimmed)
+			}
+		}
+		if len(baseURLs) == 0 {
+			return nil, fmt.Errorf("openai provider base_urls has no usable entry")
+		}
+	}
+
+	endpoints := make([]openAIEndpoint, 0, len(baseURLs))
+	chatURLs := make([]string, 0, len(baseURLs))
+	for _, base := range baseURLs {
+		chatURL, modelsURL := openAIEndpoints(base, config.ChatPath, config.ModelsPath)
+		endpoints = append(endpoints, openAIEndpoint{
+			chatURL:       chatURL,
+			modelsURL:     modelsURL,
+			embeddingsURL: openAIAPIRoot(base) + "/embeddings",
+		})
+		chatURLs = append(chatURLs, chatURL)
+	}
+
+	embeddingModel := strings.TrimSpace(config.EmbeddingModel)
+	if embeddingModel == "" {
+		embeddingModel = defaultOpenAIEmbeddingModel
+	}
 
 	return &openAIProvider{
-		model:      config.Model,
-		baseURL:    baseURL,
-		apiKey:     apiKey,
-		client:     &http.Client{Timeout: timeout},
-		chatPath:   chatPath,
-		modelsPath: modelsPath,
+		model:          config.Model,
+		embeddingModel: embeddingModel,
+		apiKey:         apiKey,
+		client:         newProviderClient(timeout),
+		endpoints:      endpoints,
+		pool:           newEndpointPool(chatURLs),
+		retry:          config.Retry,
 	}, nil
 }
 
@@ -94,24 +188,122 @@ func (p *openAIProvider) Name() string {
 	return "openai"
 }
 
+func (p *openAIProvider) retryConfig() models.RetryConfig {
+	return p.retry
+}
+
+func (p *openAIProvider) modelName() string {
+	return p.model
+}
+
 func (p *openAIProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	body, err := p.requestBody(messages, params, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail over across endpoints on connection errors and 5xx responses.
+	var lastErr error
+	for _, idx := range p.pool.order() {
+		out, err := p.chat(ctx, p.endpoints[idx].chatURL, body)
+		if err == nil {
+			p.pool.markSuccess(idx)
+			return openAIResult(out, messages)
+		}
+		lastErr = err
+		if !isEndpointFailure(ctx, err) {
+			return nil, err
+		}
+		p.pool.markFailure(idx, err)
+	}
+	return nil, lastErr
+}
+
+// GenerateStream requests a streamed chat completion and delivers its
+// deltas as they arrive. Endpoints fail over until one accepts the
+// request; a stream that breaks off later ends with an error chunk.
+func (p *openAIProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	body, err := p.requestBody(messages, params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, idx := range p.pool.order() {
+		resp, err := p.post(ctx, p.endpoints[idx].chatURL, body)
+		if err == nil {
+			p.pool.markSuccess(idx)
+			chunks := make(chan StreamChunk)
+			go readOpenAIStream(ctx, resp.Body, chunks)
+			return chunks, nil
+		}
+		lastErr = err
+		if !isEndpointFailure(ctx, err) {
+			return nil, err
+		}
+		p.pool.markFailure(idx, err)
+	}
+	return nil, lastErr
+}
+
+func (p *openAIProvider) requestBody(messages []Message, params GenerateParams, stream bool) ([]byte, error) {
 	payload := openAIRequest{
 		Model:       p.model,
 		Messages:    messages,
 		Temperature: params.Temperature,
 		MaxTokens:   params.MaxTokens,
 		TopP:        params.TopP,
+		Seed:        params.Seed,
+		Stream:      stream,
+	}
+	if stream {
+		// Without include_usage a stream carries no token counts.
+		payload.StreamOptions = map[string]bool{"include_usage": true}
 	}
 	if params.JSONMode {
 		payload.ResponseFormat = map[string]string{"type": "json_object"}
 	}
+	for _, tool := range params.Tools {
+		payload.Tools = append(payload.Tools, openAITool{Type: "function", Function: tool})
+	}
+	if params.ToolChoice != "" && len(payload.Tools) > 0 {
+		payload.ToolChoice = map[string]any{
+			"type":     "function",
+			"function": map[string]string{"name": params.ToolChoice},
+		}
+	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal openai request: %w", err)
 	}
+	return body, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+p.chatPath, bytes.NewReader(body))
+// chat posts one chat completion request to chatURL.
+func (p *openAIProvider) chat(ctx context.Context, chatURL string, body []byte) (*openAIResponse, error) {
+	resp, err := p.post(ctx, chatURL, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
+	if err != nil {
+		return nil, &endpointError{err: fmt.Errorf("failed reading openai response: %w", err)}
+	}
+
+	var out openAIResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode openai response: %w", err)
+	}
+	return &out, nil
+}
+
+// post sends a request to an API URL and returns the response for a 2xx
+// status. The caller closes its body.
+func (p *openAIProvider) post(ctx context.Context, apiURL string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to build openai request: %w", err)
 	}
@@ -120,46 +312,166 @@ func (p *openAIProvider) Generate(ctx context.Context, messages []Message, param
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("openai request failed: %w", err)
+		return nil, &endpointError{err: fmt.Errorf("openai request failed: %w", err)}
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
 	}
 	defer resp.Body.Close()
 
+	// Gateways answer 5xx with non-JSON pages, so fall back to the raw body.
 	raw, err := io.ReadAll(io.LimitReader(resp.Body, 2*1024*1024))
 	if err != nil {
-		return nil, fmt.Errorf("failed reading openai response: %w", err)
+		return nil, &endpointError{err: fmt.Errorf("failed reading openai response: %w", err)}
 	}
-
+	msg := strings.TrimSpace(string(raw))
 	var out openAIResponse
-	if err := json.Unmarshal(raw, &out); err != nil {
-		return nil, fmt.Errorf("failed to decode openai response: %w", err)
+	if json.Unmarshal(raw, &out) == nil && out.Error != nil && out.Error.Message != "" {
+		msg = out.Error.Message
+	}
+	return nil, &ProviderHTTPError{
+		Provider:   "openai",
+		StatusCode: resp.StatusCode,
+		Message:    msg,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// Embed requests embeddings for texts in one call, failing over across
+// endpoints like Generate.
+func (p *openAIProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(openAIEmbeddingRequest{Model: p.embeddingModel, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal openai embeddings request: %w", err)
+	}
+
+	var lastErr error
+	for _, idx := range p.pool.order() {
+		vectors, err := p.embed(ctx, p.endpoints[idx].embeddingsURL, body, len(texts))
+		if err == nil {
+			p.pool.markSuccess(idx)
+			return vectors, nil
+		}
+		lastErr = err
+		if !isEndpointFailure(ctx, err) {
+			return nil, err
+		}
+		p.pool.markFailure(idx, err)
+	}
+	return nil, lastErr
+}
+
+// embed posts one embeddings request and returns count vectors, ordered
+// by their input index.
+func (p *openAIProvider) embed(ctx context.Context, embeddingsURL string, body []byte, count int) ([][]float32, error) {
+	resp, err := p.post(ctx, embeddingsURL, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out openAIEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode openai embeddings response: %w", err)
+	}
+	vectors := make([][]float32, count)
+	for _, item := range out.Data {
+		if item.Index < 0 || item.Index >= count {
+			return nil, fmt.Errorf("openai embeddings response has index %d for %d inputs", item.Index, count)
+		}
+		vectors[item.Index] = item.Embedding
 	}
+	for i, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, fmt.Errorf("openai embeddings response is missing input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// readOpenAIStream parses server-sent "data:" lines from body into chunks
+// until "[DONE]", then closes body and chunks.
+func readOpenAIStream(ctx context.Context, body io.ReadCloser, chunks chan<- StreamChunk) {
+	defer close(chunks)
+	defer body.Close()
 
-	if resp.StatusCode >= http.StatusBadRequest {
-		msg := strings.TrimSpace(string(raw))
-		if out.Error != nil && out.Error.Message != "" {
-			msg = out.Error.Message
+	done := StreamChunk{Done: true}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			sendChunk(ctx, chunks, done)
+			return
+		}
+
+		var event openAIStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("failed to decode openai stream event: %w", err)})
+			return
+		}
+		if event.Error != nil {
+			sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("openai stream error: %s", event.Error.Message)})
+			return
+		}
+		if event.Usage != nil {
+			done.PromptTokens = event.Usage.PromptTokens
+			done.CompletionTokens = event.Usage.CompletionTokens
+		}
+		for _, choice := range event.Choices {
+			if choice.FinishReason != "" {
+				done.FinishReason = choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if !sendChunk(ctx, chunks, StreamChunk{Text: choice.Delta.Content}) {
+				return
+			}
 		}
-		return nil, fmt.Errorf("openai returned %d: %s", resp.StatusCode, msg)
 	}
+	if err := scanner.Err(); err != nil {
+		sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("failed reading openai stream: %w", err)})
+		return
+	}
+	sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("openai stream ended without [DONE]")})
+}
 
+// openAIResult converts a chat completion into a GenerationResult.
+func openAIResult(out *openAIResponse, messages []Message) (*models.GenerationResult, error) {
 	if len(out.Choices) == 0 {
 		return nil, fmt.Errorf("openai response missing choices")
 	}
 
 	text := strings.TrimSpace(out.Choices[0].Message.Content)
+	var toolCalls []models.ToolCall
+	estimated := text
+	for _, call := range out.Choices[0].Message.ToolCalls {
+		toolCalls = append(toolCalls, models.ToolCall{Name: call.Function.Name, Arguments: call.Function.Arguments})
+		estimated += call.Function.Arguments
+	}
 	promptTokens := out.Usage.PromptTokens
 	completionTokens := out.Usage.CompletionTokens
 	if promptTokens <= 0 {
 		promptTokens = estimateTokensFromMessages(messages)
 	}
 	if completionTokens <= 0 {
-		completionTokens = estimateTokensFromText(text)
+		completionTokens = estimateTokensFromText(estimated)
 	}
 
 	return &models.GenerationResult{
 		Text:             text,
 		PromptTokens:     promptTokens,
 		CompletionTokens: completionTokens,
+		FinishReason:     out.Choices[0].FinishReason,
+		ToolCalls:        toolCalls,
 	}, nil
 }
 
@@ -177,12 +489,45 @@ func (p *openAIProvider) Capabilities() ProviderCapabilities {
 		SupportsTools:     true,
 		SupportsJSONMode:  true,
 		SupportsThinking:  false,
-		SupportsStreaming: false,
+		SupportsStreaming: true,
+		MaxTemperature:    2,
 	}
 }
 
+// HealthCheck passes when any endpoint answers.
 func (p *openAIProvider) HealthCheck(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+p.modelsPath, nil)
+	var failures []string
+	for _, status := range p.EndpointHealth(ctx) {
+		if status.Healthy {
+			return nil
+		}
+		failures = append(failures, status.URL+": "+status.Error)
+	}
+	return fmt.Errorf("%s", strings.Join(failures, "; "))
+}
+
+// EndpointHealth probes every endpoint's models URL.
+func (p *openAIProvider) EndpointHealth(ctx context.Context) []EndpointStatus {
+	statuses := make([]EndpointStatus, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func(i int, endpoint openAIEndpoint) {
+			defer wg.Done()
+			statuses[i] = EndpointStatus{URL: endpoint.chatURL, Healthy: true}
+			if err := p.probe(ctx, endpoint.modelsURL); err != nil {
+				statuses[i].Healthy = false
+				statuses[i].Error = err.Error()
+				p.pool.markFailure(i, err)
+			}
+		}(i, endpoint)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func (p *openAIProvider) probe(ctx context.Context, modelsURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, modelsURL, nil)
 	if err != nil {
 		return err
 	}
@@ -201,9 +546,45 @@ func (p *openAIProvider) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
-func openAIPaths(baseURL string) (chatPath string, modelsPath string) {
-	if strings.HasSuffix(baseURL, "/v1") {
-		return "/chat/completions", "/models"
+// openAIEndpoints resolves the chat and models URLs for a base URL. Explicit
+// chat/models paths win; they may be absolute URLs or paths relative to the
+// base URL.
+func openAIEndpoints(baseURL, chatPath, modelsPath string) (chatURL string, modelsURL string) {
+	root := openAIAPIRoot(baseURL)
+	chatURL = root + "/chat/completions"
+	modelsURL = root + "/models"
+
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if strings.HasSuffix(base, "/chat/completions") {
+		chatURL = base
+	}
+	if override := strings.TrimSpace(chatPath); override != "" {
+		chatURL = joinEndpoint(base, override)
+	}
+	if override := strings.TrimSpace(modelsPath); override != "" {
+		modelsURL = joinEndpoint(base, override)
+	}
+	return chatURL, modelsURL
+}
+
+// openAIAPIRoot returns the URL the OpenAI resource paths hang off. A base
+// URL with no path gets "/v1"; one that already names the chat endpoint is
+// trimmed back to its root; any other path (".../v1", "/api", a gateway
+// prefix) is used as-is.
+func openAIAPIRoot(baseURL string) string {
+	root := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	root = strings.TrimSuffix(root, "/chat/completions")
+
+	parsed, err := url.Parse(root)
+	if err == nil && strings.Trim(parsed.Path, "/") == "" {
+		return root + "/v1"
+	}
+	return root
+}
+
+func joinEndpoint(base, path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
 	}
-	return "/v1/chat/completions", "/v1/models"
+	return base + "/" + strings.TrimLeft(path, "/")
 }
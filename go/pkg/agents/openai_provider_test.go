@@ -0,0 +1,314 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestOpenAIEndpoints(t *testing.T) {
+	cases := []struct {
+		name       string
+		baseURL    string
+		chatPath   string
+		modelsPath string
+		wantChat   string
+		wantModels string
+	}{
+		{
+			name:       "host only",
+			baseURL:    "http://localhost:8000",
+			wantChat:   "http://localhost:8000/v1/chat/completions",
+			wantModels: "http://localhost:8000/v1/models",
+		},
+		{
+			name:       "openai default",
+			baseURL:    "https://api.openai.com/v1",
+			wantChat:   "https://api.openai.com/v1/chat/completions",
+			wantModels: "https://api.openai.com/v1/models",
+		},
+		{
+			name:       "gateway prefix with trailing slash",
+			baseURL:    "https://gw.example.com/openai/v1/",
+			wantChat:   "https://gw.example.com/openai/v1/chat/completions",
+			wantModels: "https://gw.example.com/openai/v1/models",
+		},
+		{
+			name:       "unversioned api prefix",
+			baseURL:    "https://host/api",
+			wantChat:   "https://host/api/chat/completions",
+			wantModels: "https://host/api/models",
+		},
+		{
+			name:       "full chat path",
+			baseURL:    "https://gw.example.com/openai/v1/chat/completions/",
+			wantChat:   "https://gw.example.com/openai/v1/chat/completions",
+			wantModels: "https://gw.example.com/openai/v1/models",
+		},
+		{
+			name:       "azure style overrides",
+			baseURL:    "https://res.example.com/openai/deployments/gpt4o",
+			chatPath:   "/chat/completions?api-version=2024-06-01",
+			modelsPath: "https://res.example.com/openai/models?api-version=2024-06-01",
+			wantChat:   "https://res.example.com/openai/deployments/gpt4o/chat/completions?api-version=2024-06-01",
+			wantModels: "https://res.example.com/openai/models?api-version=2024-06-01",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			chat, models := openAIEndpoints(tc.baseURL, tc.chatPath, tc.modelsPath)
+			if chat != tc.wantChat {
+				t.Errorf("chat URL = %q, want %q", chat, tc.wantChat)
+			}
+			if models != tc.wantModels {
+				t.Errorf("models URL = %q, want %q", models, tc.wantModels)
+			}
+		})
+	}
+}
+
+func TestOpenAIOmitsUnsetTopPAndSeed(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		bodies = append(bodies, body)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "test")
+	provider, err := NewOpenAIProvider(models.ProviderConfig{
+		Type:      "openai",
+		Model:     "gpt-4o",
+		BaseURL:   server.URL,
+		APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	messages := []Message{{Role: "user", Content: "hi"}}
+	if _, err := provider.Generate(context.Background(), messages, GenerateParams{Temperature: 0.8}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := provider.Generate(context.Background(), messages, GenerateParams{Temperature: 0.8, TopP: 0.9, Seed: 42}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := bodies[0]["top_p"]; ok {
+		t.Fatalf("expected zero top_p to be omitted, got %v", bodies[0]["top_p"])
+	}
+	if bodies[1]["top_p"] != 0.9 {
+		t.Fatalf("expected top_p 0.9, got %v", bodies[1]["top_p"])
+	}
+	if _, ok := bodies[0]["seed"]; ok || bodies[1]["seed"] != float64(42) {
+		t.Fatalf("expected the seed only when set, got %v and %v", bodies[0]["seed"], bodies[1]["seed"])
+	}
+	if _, ok := bodies[0]["stream_options"]; ok {
+		t.Fatalf("expected no stream_options without streaming, got %v", bodies[0]["stream_options"])
+	}
+}
+
+func TestOpenAICheckerReportsIssuesThroughTool(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":null,"tool_calls":[{"type":"function","function":{"name":"report_issues","arguments":"{\"issues\":[{\"category\":\"pov\",\"severity\":\"error\",\"description\":\"視点が揺れている\"}]}"}}]},"finish_reason":"tool_calls"}]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "test")
+	provider, err := NewOpenAIProvider(models.ProviderConfig{
+		Type:      "openai",
+		Model:     "gpt-4o",
+		BaseURL:   server.URL,
+		APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checker := NewCheckerAgent(AgentConfig{Provider: provider})
+	result, err := checker.Check(context.Background(), &CheckerInput{Text: "本文", Categories: []string{"pov"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Checked || result.Attempts != 1 || len(result.Issues) != 1 || result.Issues[0].Description != "視点が揺れている" {
+		t.Fatalf("expected the tool call's issue, got %+v", result)
+	}
+
+	tools, _ := body["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("expected one tool in the request, got %v", body["tools"])
+	}
+	function := tools[0].(map[string]interface{})["function"].(map[string]interface{})
+	if function["name"] != "report_issues" {
+		t.Fatalf("expected the report_issues tool, got %v", function["name"])
+	}
+	choice, _ := body["tool_choice"].(map[string]interface{})
+	if choice["type"] != "function" {
+		t.Fatalf("expected a forced tool choice, got %v", body["tool_choice"])
+	}
+}
+
+func TestOpenAIFailsOverAcrossEndpoints(t *testing.T) {
+	var downCalls, upCalls int
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downCalls++
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upCalls++
+		if r.URL.Path == "/v1/models" {
+			_, _ = w.Write([]byte(`{"data":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer up.Close()
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "test")
+	provider, err := NewOpenAIProvider(models.ProviderConfig{
+		Type:      "openai",
+		Model:     "gpt-4o",
+		BaseURLs:  []string{down.URL, up.URL},
+		APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	messages := []Message{{Role: "user", Content: "hi"}}
+	for i := 0; i < 3; i++ {
+		result, err := provider.Generate(context.Background(), messages, GenerateParams{})
+		if err != nil || result.Text != "ok" {
+			t.Fatalf("call %d: expected failover to succeed, got %+v, %v", i, result, err)
+		}
+	}
+	if downCalls != 1 || upCalls != 3 {
+		t.Fatalf("expected the failed endpoint skipped during cooldown, got down=%d up=%d", downCalls, upCalls)
+	}
+
+	statuses := endpointHealthOf(context.Background(), &cachingProvider{Provider: provider})
+	if len(statuses) != 2 || statuses[0].Healthy || !statuses[1].Healthy {
+		t.Fatalf("expected per-endpoint health, got %+v", statuses)
+	}
+	if err := provider.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("expected healthy with one endpoint up, got %v", err)
+	}
+}
+
+func TestOpenAIGenerateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["stream"] != true {
+			t.Errorf("expected a streaming request, got %v (%v)", body, err)
+		}
+		if options, _ := body["stream_options"].(map[string]interface{}); options["include_usage"] != true {
+			t.Errorf("expected stream usage requested, got %v", body["stream_options"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(": keep-alive\n\n" +
+			`data: {"choices":[{"delta":{"role":"assistant"}}]}` + "\n\n" +
+			`data: {"choices":[{"delta":{"content":"雨が"}}]}` + "\n\n" +
+			`data: {"choices":[{"delta":{"content":"降る。"},"finish_reason":"stop"}]}` + "\n\n" +
+			`data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3}}` + "\n\n" +
+			"data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "test")
+	provider, err := NewOpenAIProvider(models.ProviderConfig{
+		Type:      "openai",
+		Model:     "gpt-4o",
+		BaseURL:   server.URL,
+		APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The agent forwards each delta to the sink and assembles the result.
+	var tokens []string
+	ctx := WithStreamSink(context.Background(), "writer", func(text string) { tokens = append(tokens, text) })
+	result, err := NewBaseAgent("writer", provider).Generate(ctx, "s", "u", GenerateParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(tokens, "|") != "雨が|降る。" {
+		t.Fatalf("expected two streamed deltas, got %q", tokens)
+	}
+	if result.Text != "雨が降る。" || result.FinishReason != "stop" || result.PromptTokens != 12 || result.CompletionTokens != 3 {
+		t.Fatalf("unexpected assembled result %+v", result)
+	}
+}
+
+func TestOpenAIStreamWithoutDoneFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`data: {"choices":[{"delta":{"content":"途中"}}]}` + "\n\n"))
+	}))
+	defer server.Close()
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "test")
+	provider, err := NewOpenAIProvider(models.ProviderConfig{
+		Type:      "openai",
+		Model:     "gpt-4o",
+		BaseURL:   server.URL,
+		APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	chunks, err := provider.GenerateStream(context.Background(), []Message{{Role: "user", Content: "hi"}}, GenerateParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var last StreamChunk
+	for chunk := range chunks {
+		last = chunk
+	}
+	if last.Err == nil {
+		t.Fatalf("expected a truncated stream to end with an error, got %+v", last)
+	}
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body openAIEmbeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.URL.Path != "/v1/embeddings" {
+			t.Errorf("unexpected request %s %+v (%v)", r.URL.Path, body, err)
+		}
+		if body.Model != defaultOpenAIEmbeddingModel || len(body.Input) != 2 {
+			t.Errorf("expected both inputs for the default model, got %+v", body)
+		}
+		// Out of order, as the API allows.
+		_, _ = w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("NOVELIST_TEST_EMBED_KEY", "sk-test")
+	provider, err := NewOpenAIProvider(models.ProviderConfig{Type: "openai", Model: "gpt-4o", BaseURL: server.URL, APIKeyEnv: "NOVELIST_TEST_EMBED_KEY"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vectors, err := provider.Embed(context.Background(), []string{"雨", "晴れ"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Fatalf("expected the vectors in input order, got %v", vectors)
+	}
+}
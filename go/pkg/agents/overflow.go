@@ -0,0 +1,175 @@
+package agents
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultOverflowThreshold   = 3
+	defaultOverflowWindowSec   = 300
+	defaultOverflowCooldownSec = 600
+)
+
+// contextOverflowMarkers are lowercase fragments of the errors providers
+// return for prompts longer than their context window.
+var contextOverflowMarkers = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"exceeds the context",
+	"context window",
+}
+
+// IsContextOverflow reports whether err is a provider rejecting a prompt
+// that does not fit its context window.
+func IsContextOverflow(err error) bool {
+	var httpErr *ProviderHTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	message := strings.ToLower(httpErr.Message)
+	for _, marker := range contextOverflowMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContextOverflowStatus reports an agent's context-overflow adaptation.
+type ContextOverflowStatus struct {
+	// Overflows counts the overflows inside the current window.
+	Overflows  int        `json:"overflows"`
+	Threshold  int        `json:"threshold"`
+	Fallback   string     `json:"fallback"`
+	Downgraded bool       `json:"downgraded"`
+	Until      *time.Time `json:"until,omitempty"`
+}
+
+// overflowAdapter moves an agent off its primary provider once that
+// provider overflows its context window threshold times within window,
+// and moves it back after cooldown.
+type overflowAdapter struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	fallback  string
+	// resolve returns the fallback provider; nil means none is available.
+	resolve func(name string) (Provider, error)
+	now     func() time.Time
+
+	mu        sync.Mutex
+	overflows []time.Time
+	until     time.Time
+}
+
+// newOverflowAdapter returns an adapter switching to fallback, or nil when
+// the adaptation is disabled or there is nothing to switch to.
+func newOverflowAdapter(config models.ContextOverflowConfig, fallback string, resolve func(string) (Provider, error)) *overflowAdapter {
+	if !config.Enabled || fallback == "" {
+		return nil
+	}
+	a := &overflowAdapter{
+		threshold: config.Threshold,
+		window:    time.Duration(config.WindowSec) * time.Second,
+		cooldown:  time.Duration(config.CooldownSec) * time.Second,
+		fallback:  fallback,
+		resolve:   resolve,
+		now:       time.Now,
+	}
+	if a.threshold <= 0 {
+		a.threshold = defaultOverflowThreshold
+	}
+	if a.window <= 0 {
+		a.window = defaultOverflowWindowSec * time.Second
+	}
+	if a.cooldown <= 0 {
+		a.cooldown = defaultOverflowCooldownSec * time.Second
+	}
+	return a
+}
+
+// record notes a failed call to the agent's primary provider.
+func (a *overflowAdapter) record(agent string, err error) {
+	if a == nil || !IsContextOverflow(err) {
+		return
+	}
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.overflows = append(a.prune(now), now)
+	if len(a.overflows) < a.threshold || now.Before(a.until) {
+		return
+	}
+	a.until = now.Add(a.cooldown)
+	a.overflows = nil
+	log.Warn().
+		Str("agent", agent).
+		Str("fallback", a.fallback).
+		Int("threshold", a.threshold).
+		Time("until", a.until).
+		Msg("Repeated context overflows, switching agent to fallback provider")
+}
+
+// prune drops overflows older than the window. Callers hold mu.
+func (a *overflowAdapter) prune(now time.Time) []time.Time {
+	kept := a.overflows[:0]
+	for _, at := range a.overflows {
+		if now.Sub(at) < a.window {
+			kept = append(kept, at)
+		}
+	}
+	return kept
+}
+
+// active reports whether the agent is currently downgraded.
+func (a *overflowAdapter) active() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.now().Before(a.until)
+}
+
+// provider returns the fallback provider while downgraded.
+func (a *overflowAdapter) provider() (Provider, bool) {
+	if !a.active() || a.resolve == nil {
+		return nil, false
+	}
+	provider, err := a.resolve(a.fallback)
+	if err != nil || provider == nil {
+		log.Warn().Err(err).Str("fallback", a.fallback).Msg("Context overflow fallback is unavailable")
+		return nil, false
+	}
+	return provider, true
+}
+
+// status reports the adapter's state for health checks.
+func (a *overflowAdapter) status() *ContextOverflowStatus {
+	if a == nil {
+		return nil
+	}
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.overflows = a.prune(now)
+	status := &ContextOverflowStatus{
+		Overflows: len(a.overflows),
+		Threshold: a.threshold,
+		Fallback:  a.fallback,
+	}
+	if now.Before(a.until) {
+		until := a.until
+		status.Downgraded = true
+		status.Until = &until
+	}
+	return status
+}
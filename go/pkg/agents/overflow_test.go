@@ -0,0 +1,149 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// overflowingProvider rejects every prompt as too long.
+type overflowingProvider struct {
+	stubProvider
+	calls int
+}
+
+func (p *overflowingProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	p.calls++
+	return nil, &ProviderHTTPError{
+		Provider:   "small",
+		StatusCode: http.StatusBadRequest,
+		Message:    `{"error":{"code":"context_length_exceeded","message":"This model's maximum context length is 4096 tokens"}}`,
+	}
+}
+
+func TestIsContextOverflow(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("writer: %w", &ProviderHTTPError{StatusCode: 400, Message: "context_length_exceeded"}), true},
+		{&ProviderHTTPError{StatusCode: 500, Message: "input length exceeds the context length"}, true},
+		{&ProviderHTTPError{StatusCode: 400, Message: "invalid temperature"}, false},
+		{errors.New("context_length_exceeded"), false},
+	}
+	for _, tt := range tests {
+		if got := IsContextOverflow(tt.err); got != tt.want {
+			t.Errorf("IsContextOverflow(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRepeatedOverflowsSwitchToFallback(t *testing.T) {
+	primary := &overflowingProvider{}
+	fallback := &stubProvider{text: "from fallback"}
+	now := time.Unix(1_700_000_000, 0)
+
+	agent := NewBaseAgent("writer", primary)
+	agent.overflow = newOverflowAdapter(
+		models.ContextOverflowConfig{Enabled: true, Threshold: 2, WindowSec: 60, CooldownSec: 120},
+		"large",
+		func(name string) (Provider, error) { return fallback, nil },
+	)
+	agent.overflow.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := agent.Generate(context.Background(), "s", "u", GenerateParams{}); err == nil {
+			t.Fatal("expected the primary to fail")
+		}
+	}
+	status := agent.overflow.status()
+	if !status.Downgraded || status.Fallback != "large" || status.Until == nil {
+		t.Fatalf("expected a downgrade to large, got %+v", status)
+	}
+
+	result, err := agent.Generate(context.Background(), "s", "u", GenerateParams{})
+	if err != nil || result.Text != "from fallback" {
+		t.Fatalf("expected the fallback to serve, got %v, %v", result, err)
+	}
+	if primary.calls != 2 {
+		t.Fatalf("expected the primary to be skipped while downgraded, got %d calls", primary.calls)
+	}
+
+	now = now.Add(121 * time.Second)
+	if agent.overflow.active() {
+		t.Fatal("expected the downgrade to end after the cooldown")
+	}
+	if _, err := agent.Generate(context.Background(), "s", "u", GenerateParams{}); err == nil || primary.calls != 3 {
+		t.Fatalf("expected the primary to be retried, got calls=%d err=%v", primary.calls, err)
+	}
+}
+
+func TestOverflowsOutsideWindowDoNotSwitch(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	adapter := newOverflowAdapter(models.ContextOverflowConfig{Enabled: true, Threshold: 2, WindowSec: 60}, "large", nil)
+	adapter.now = func() time.Time { return now }
+	overflow := &ProviderHTTPError{StatusCode: 400, Message: "context_length_exceeded"}
+
+	adapter.record("writer", overflow)
+	now = now.Add(61 * time.Second)
+	adapter.record("writer", overflow)
+	adapter.record("writer", &ProviderHTTPError{StatusCode: 400, Message: "bad request"})
+
+	if status := adapter.status(); status.Downgraded || status.Overflows != 1 {
+		t.Fatalf("expected one overflow in the window and no downgrade, got %+v", status)
+	}
+}
+
+func TestOverflowFallbackFromChain(t *testing.T) {
+	config := AgentConfig{ProviderChain: []string{"small", "large"}}
+	if got := overflowFallback(models.ContextOverflowConfig{}, config); got != "large" {
+		t.Fatalf("expected the next chain provider, got %q", got)
+	}
+	if got := overflowFallback(models.ContextOverflowConfig{Fallback: "huge"}, config); got != "huge" {
+		t.Fatalf("expected the configured fallback, got %q", got)
+	}
+	if got := overflowFallback(models.ContextOverflowConfig{Fallback: "small"}, config); got != "" {
+		t.Fatalf("expected no fallback onto the primary, got %q", got)
+	}
+	if newOverflowAdapter(models.ContextOverflowConfig{Enabled: true}, "", nil) != nil {
+		t.Fatal("expected no adapter without a fallback")
+	}
+}
+
+func TestOverflowsRecordedWhenChainRecovers(t *testing.T) {
+	primary := &overflowingProvider{}
+	large := &stubProvider{text: "from large"}
+	chain, err := NewFallbackProvider([]string{"small", "large"}, []Provider{primary, large})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	agent := NewBaseAgent("writer", chain)
+	agent.overflow = newOverflowAdapter(
+		models.ContextOverflowConfig{Enabled: true, Threshold: 2, WindowSec: 60, CooldownSec: 120},
+		"large",
+		func(name string) (Provider, error) { return large, nil },
+	)
+
+	for i := 0; i < 2; i++ {
+		result, err := agent.Generate(context.Background(), "s", "u", GenerateParams{})
+		if err != nil || result.Text != "from large" {
+			t.Fatalf("expected the chain's next member to answer, got %v, %v", result, err)
+		}
+	}
+	if status := agent.overflow.status(); !status.Downgraded {
+		t.Fatalf("expected the primary's overflows to trigger a downgrade, got %+v", status)
+	}
+
+	if _, err := agent.Generate(context.Background(), "s", "u", GenerateParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if primary.calls != 2 {
+		t.Fatalf("expected the primary to be skipped while downgraded, got %d calls", primary.calls)
+	}
+}
@@ -0,0 +1,141 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// overrideAllAgents is the ProviderOverrides key that applies to every
+// agent.
+const overrideAllAgents = "*"
+
+// ProviderOverrides maps agent names, or "*" for all agents, to the provider
+// names they should use for a single request.
+type ProviderOverrides map[string]string
+
+// ParseProviderOverrides parses an X-Provider-Override value: either a
+// provider name for every agent ("ollama") or comma-separated agent=provider
+// pairs ("writer=ollama, checker=openai").
+func ParseProviderOverrides(raw string) (ProviderOverrides, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if !strings.Contains(raw, "=") {
+		return ProviderOverrides{overrideAllAgents: raw}, nil
+	}
+
+	overrides := make(ProviderOverrides)
+	for _, pair := range strings.Split(raw, ",") {
+		agent, provider, ok := strings.Cut(pair, "=")
+		agent, provider = strings.TrimSpace(agent), strings.TrimSpace(provider)
+		if !ok || agent == "" || provider == "" {
+			return nil, fmt.Errorf("provider override %q must be agent=provider", strings.TrimSpace(pair))
+		}
+		if !isKnownAgent(agent) {
+			return nil, fmt.Errorf("provider override names unknown agent %s", agent)
+		}
+		if _, dup := overrides[agent]; dup {
+			return nil, fmt.Errorf("provider override lists %s more than once", agent)
+		}
+		overrides[agent] = provider
+	}
+	return overrides, nil
+}
+
+func isKnownAgent(name string) bool {
+	for _, agent := range append(append([]string{}, defaultAgentList...), optionalAgentList...) {
+		if agent == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ProviderCatalog hands out providers from the configured Available set,
+// taking instances from a ProviderPool.
+type ProviderCatalog struct {
+	available map[string]models.ProviderConfig
+	pool      *ProviderPool
+}
+
+// NewProviderCatalog returns a catalog over the available providers with
+// its own pool.
+func NewProviderCatalog(provider models.ProviderSection) *ProviderCatalog {
+	return NewProviderCatalogWithPool(provider, NewProviderPool())
+}
+
+// NewProviderCatalogWithPool returns a catalog that takes instances from
+// pool, so overrides reuse the agents' providers.
+func NewProviderCatalogWithPool(provider models.ProviderSection, pool *ProviderPool) *ProviderCatalog {
+	return &ProviderCatalog{available: provider.Available, pool: pool}
+}
+
+// Get returns the named provider, creating it on first use.
+func (c *ProviderCatalog) Get(name string) (Provider, error) {
+	config, ok := c.available[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %s", name)
+	}
+	instance, err := c.pool.Get(name, config)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", name, err)
+	}
+	return instance, nil
+}
+
+// resolvedOverride is a provider chosen by a request override.
+type resolvedOverride struct {
+	name     string
+	provider Provider
+}
+
+type providerOverridesKey struct{}
+
+// WithProviderOverrides resolves overrides against catalog and returns a
+// context under which the named agents use them. Unknown providers are an
+// error.
+func WithProviderOverrides(ctx context.Context, catalog *ProviderCatalog, overrides ProviderOverrides) (context.Context, error) {
+	if len(overrides) == 0 {
+		return ctx, nil
+	}
+	if catalog == nil {
+		return nil, fmt.Errorf("provider overrides are not available")
+	}
+
+	resolved := make(map[string]resolvedOverride, len(overrides))
+	for agent, name := range overrides {
+		provider, err := catalog.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		resolved[agent] = resolvedOverride{name: name, provider: provider}
+	}
+	return context.WithValue(ctx, providerOverridesKey{}, resolved), nil
+}
+
+// providerOverrideFor returns the request override for agent, if any.
+func providerOverrideFor(ctx context.Context, agent string) (resolvedOverride, bool) {
+	resolved, ok := ctx.Value(providerOverridesKey{}).(map[string]resolvedOverride)
+	if !ok {
+		return resolvedOverride{}, false
+	}
+	if override, ok := resolved[agent]; ok {
+		return override, true
+	}
+	override, ok := resolved[overrideAllAgents]
+	return override, ok
+}
+
+// annotateOverrides records request overrides on the stages they applied to.
+func annotateOverrides(ctx context.Context, stages []models.StageInfo) {
+	for i := range stages {
+		if override, ok := providerOverrideFor(ctx, stages[i].Agent); ok {
+			stages[i].Provider = override.name
+			stages[i].Override = true
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// tokensPerPricingUnit is the token count ModelPricing prices are quoted
+// per.
+const tokensPerPricingUnit = 1_000_000
+
+// PricingTable estimates the USD cost of generations from per-model token
+// prices. Local providers (mock, ollama) are free; other provider and
+// model pairs without a price cost zero and log a warning once.
+type PricingTable struct {
+	prices map[string]models.ModelPricing
+	warned sync.Map
+}
+
+// NewPricingTable indexes entries by provider type and model. Later
+// entries replace earlier ones for the same pair.
+func NewPricingTable(entries []models.ModelPricing) *PricingTable {
+	table := &PricingTable{prices: make(map[string]models.ModelPricing, len(entries))}
+	for _, entry := range entries {
+		table.prices[pricingKey(entry.Provider, entry.Model)] = entry
+	}
+	return table
+}
+
+func pricingKey(provider, model string) string {
+	return strings.ToLower(strings.TrimSpace(provider)) + "/" + strings.TrimSpace(model)
+}
+
+// Cost returns the estimated cost of result generated by provider.
+// Results served from the response cache cost nothing.
+func (t *PricingTable) Cost(provider Provider, result *models.GenerationResult) float64 {
+	if t == nil || provider == nil || result == nil || result.Cached {
+		return 0
+	}
+	if group, ok := provider.(providerGroup); ok {
+		if provider = group.member(result.ServedBy); provider == nil {
+			return 0
+		}
+	}
+	name, model := providerModel(provider)
+	switch name {
+	case "mock", "ollama":
+		return 0
+	}
+
+	key := pricingKey(name, model)
+	price, ok := t.prices[key]
+	if !ok {
+		if _, warned := t.warned.LoadOrStore(key, true); !warned {
+			log.Warn().
+				Str("provider", name).
+				Str("model", model).
+				Msg("No pricing configured for model; reporting zero cost")
+		}
+		return 0
+	}
+	return (float64(result.PromptTokens)*price.InputPerMTokUSD +
+		float64(result.CompletionTokens)*price.OutputPerMTokUSD) / tokensPerPricingUnit
+}
+
+// providerModel returns the type and model of provider, looking through
+// wrapping providers.
+func providerModel(provider Provider) (name, model string) {
+	name = provider.Name()
+	for provider != nil {
+		if named, ok := provider.(interface{ modelName() string }); ok {
+			return provider.Name(), named.modelName()
+		}
+		wrapper, ok := provider.(interface{ Unwrap() Provider })
+		if !ok {
+			break
+		}
+		provider = wrapper.Unwrap()
+	}
+	return name, ""
+}
+
+// UsageRecorder is told the provider, agent and token counts of each
+// generation that reached a provider.
+type UsageRecorder func(provider, agent string, promptTokens, completionTokens int)
+
+// servedBy names the configured provider that produced result: the group
+// member that served it, or provider itself.
+func servedBy(provider Provider, result *models.GenerationResult) string {
+	if _, ok := provider.(providerGroup); ok && result.ServedBy != "" {
+		return result.ServedBy
+	}
+	return provider.Name()
+}
+
+// costRecorder sums the cost of the generations made during one request.
+type costRecorder struct {
+	mu    sync.Mutex
+	total float64
+}
+
+type costRecorderKey struct{}
+
+func withCostRecorder(ctx context.Context) (context.Context, *costRecorder) {
+	recorder := &costRecorder{}
+	return context.WithValue(ctx, costRecorderKey{}, recorder), recorder
+}
+
+func recordCost(ctx context.Context, cost float64) {
+	recorder, ok := ctx.Value(costRecorderKey{}).(*costRecorder)
+	if !ok || cost == 0 {
+		return
+	}
+	recorder.mu.Lock()
+	recorder.total += cost
+	recorder.mu.Unlock()
+}
+
+func (r *costRecorder) sum() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
@@ -0,0 +1,118 @@
+package agents
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// pricedProvider reports a fixed provider type, model and token usage.
+type pricedProvider struct {
+	stubProvider
+	name  string
+	model string
+	calls int
+}
+
+func (p *pricedProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	p.calls++
+	result, err := p.stubProvider.Generate(ctx, messages, params)
+	result.PromptTokens = 2000
+	result.CompletionTokens = 1000
+	return result, err
+}
+
+func (p *pricedProvider) Name() string      { return p.name }
+func (p *pricedProvider) modelName() string { return p.model }
+
+func TestPricingTableCost(t *testing.T) {
+	table := NewPricingTable([]models.ModelPricing{
+		{Provider: "OpenAI", Model: "gpt-4o-mini", InputPerMTokUSD: 0.5, OutputPerMTokUSD: 2},
+	})
+	result := &models.GenerationResult{PromptTokens: 2000, CompletionTokens: 1000}
+
+	tests := []struct {
+		name     string
+		provider Provider
+		result   *models.GenerationResult
+		want     float64
+	}{
+		{"priced", &pricedProvider{name: "openai", model: "gpt-4o-mini"}, result, 0.003},
+		{"wrapped", &cachingProvider{Provider: &pricedProvider{name: "openai", model: "gpt-4o-mini"}}, result, 0.003},
+		{"cached", &pricedProvider{name: "openai", model: "gpt-4o-mini"}, &models.GenerationResult{PromptTokens: 2000, Cached: true}, 0},
+		{"unknown model", &pricedProvider{name: "openai", model: "gpt-5"}, result, 0},
+		{"local", &pricedProvider{name: "ollama", model: "qwen3:1.7b"}, result, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := table.Cost(tt.provider, tt.result); math.Abs(got-tt.want) > 1e-12 {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+	if got := (*PricingTable)(nil).Cost(&pricedProvider{name: "openai"}, result); got != 0 {
+		t.Fatalf("expected zero cost without a table, got %v", got)
+	}
+}
+
+func TestGenerateSceneTotalsCost(t *testing.T) {
+	provider := &pricedProvider{name: "openai", model: "gpt-4o-mini"}
+	configs := map[string]AgentConfig{}
+	for _, agent := range []string{"director", "writer", "checker", "editor"} {
+		configs[agent] = AgentConfig{Provider: provider}
+	}
+	// The commit runs after the response, so it cannot count toward it.
+	configs["committer"] = AgentConfig{Provider: &stubProvider{}}
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	swarm.SetPricing(NewPricingTable([]models.ModelPricing{
+		{Provider: "openai", Model: "gpt-4o-mini", InputPerMTokUSD: 0.5, OutputPerMTokUSD: 2},
+	}))
+
+	response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := provider.calls
+	if want := float64(calls) * 0.003; calls == 0 || math.Abs(response.TotalCostUSD-want) > 1e-9 {
+		t.Fatalf("expected %d calls at $0.003 (%v), got %v", calls, want, response.TotalCostUSD)
+	}
+}
+
+func TestSwarmRecordsUsagePerGeneration(t *testing.T) {
+	provider := &pricedProvider{name: "openai", model: "gpt-4o-mini"}
+	configs := map[string]AgentConfig{}
+	for _, agent := range []string{"director", "writer", "checker", "editor"} {
+		configs[agent] = AgentConfig{Provider: provider}
+	}
+	configs["committer"] = AgentConfig{Provider: &stubProvider{}}
+	swarm := NewSwarm(configs, models.SwarmSection{})
+
+	var mu sync.Mutex
+	generations := map[string]int{}
+	promptTokens, completionTokens := 0, 0
+	swarm.SetUsageRecorder(func(provider, agent string, prompt, completion int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if provider == "openai" {
+			generations[agent]++
+			promptTokens += prompt
+			completionTokens += completion
+		}
+	})
+
+	if _, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if generations["director"] == 0 || generations["writer"] == 0 {
+		t.Fatalf("expected director and writer generations, got %v", generations)
+	}
+	calls := provider.calls
+	if promptTokens != calls*2000 || completionTokens != calls*1000 {
+		t.Fatalf("expected %d calls' tokens, got %d prompt and %d completion", calls, promptTokens, completionTokens)
+	}
+}
@@ -0,0 +1,93 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultPromptLanguage is the prompt language of requests that set none.
+const DefaultPromptLanguage = "ja"
+
+// PromptLanguages lists the languages with built-in prompt templates.
+var PromptLanguages = []string{"ja", "en"}
+
+// IsValidPromptLanguage reports whether l is empty or one of
+// PromptLanguages.
+func IsValidPromptLanguage(l string) bool {
+	if l == "" {
+		return true
+	}
+	for _, language := range PromptLanguages {
+		if language == l {
+			return true
+		}
+	}
+	return false
+}
+
+// PromptTemplate is one agent's prompts in one language: its system prompt
+// and the text/template sources of its user prompt parts, by part name.
+type PromptTemplate struct {
+	System string
+	parts  map[string]*template.Template
+}
+
+var promptFuncs = template.FuncMap{
+	"inc":  func(i int) int { return i + 1 },
+	"join": strings.Join,
+}
+
+// NewPromptTemplate parses the part sources of a template.
+func NewPromptTemplate(system string, parts map[string]string) (*PromptTemplate, error) {
+	t := &PromptTemplate{
+		System: strings.TrimSpace(system),
+		parts:  make(map[string]*template.Template, len(parts)),
+	}
+	for name, source := range parts {
+		parsed, err := template.New(name).Funcs(promptFuncs).Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("prompt part %s: %w", name, err)
+		}
+		t.parts[name] = parsed
+	}
+	return t, nil
+}
+
+func mustPromptTemplate(system string, parts map[string]string) *PromptTemplate {
+	t, err := NewPromptTemplate(system, parts)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// render executes a part with data and trims the result. A part that is
+// missing or fails renders as far as it got, and the failure is logged.
+func (t *PromptTemplate) render(part string, data any) string {
+	parsed, ok := t.parts[part]
+	if !ok {
+		log.Error().Str("part", part).Msg("Prompt template has no such part")
+		return ""
+	}
+	var b strings.Builder
+	if err := parsed.Execute(&b, data); err != nil {
+		log.Error().Err(err).Str("part", part).Msg("Failed to render prompt template")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// PromptTemplates holds prompt templates by agent, then language.
+type PromptTemplates map[string]map[string]*PromptTemplate
+
+// For returns agent's template in language, falling back to
+// DefaultPromptLanguage when it has none.
+func (p PromptTemplates) For(agent, language string) *PromptTemplate {
+	byLanguage := p[agent]
+	if t, ok := byLanguage[language]; ok {
+		return t
+	}
+	return byLanguage[DefaultPromptLanguage]
+}
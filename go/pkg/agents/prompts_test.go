@@ -0,0 +1,89 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestBuiltinPromptTemplatesCoverEveryLanguage(t *testing.T) {
+	for agent, byLanguage := range builtinPromptTemplates {
+		for _, language := range PromptLanguages {
+			if _, ok := byLanguage[language]; !ok {
+				t.Errorf("%s has no %s prompts", agent, language)
+			}
+		}
+		if len(byLanguage["ja"].parts) != len(byLanguage["en"].parts) {
+			t.Errorf("%s has different parts in ja and en", agent)
+		}
+	}
+}
+
+func TestPromptsFollowRequestLanguage(t *testing.T) {
+	swarm := NewSwarm(map[string]AgentConfig{}, models.SwarmSection{})
+	req := &models.SceneRequest{Intention: "A reunion", WordCount: 800, Pacing: "fast", Language: "en"}
+
+	preview := swarm.PreviewPrompts(req, nil)
+	if !strings.HasPrefix(preview.Director.System, "You are the director") || !strings.Contains(preview.Director.User, "## User's intention") {
+		t.Fatalf("expected English director prompts, got %+v", preview.Director)
+	}
+	if !strings.Contains(preview.Writer.User, "- Pacing: fast") || strings.Contains(preview.Writer.User, "テンポ") {
+		t.Fatalf("expected English writer prompts, got %q", preview.Writer.User)
+	}
+
+	req.Language = ""
+	if preview := swarm.PreviewPrompts(req, nil); !strings.Contains(preview.Writer.User, "- テンポ: 速い") {
+		t.Fatalf("expected Japanese prompts by default, got %q", preview.Writer.User)
+	}
+
+	provider := &stubProvider{text: `[]`}
+	editor := NewEditorAgent(AgentConfig{Provider: provider})
+	input := &EditorInput{Text: "It was raining.", Issues: []models.Issue{{Category: "fact", Severity: "error", Description: "wrong day"}}, Language: "en"}
+	if _, err := editor.Execute(context.Background(), input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt := provider.lastMessages[1].Content; !strings.Contains(prompt, "## Problems to fix\n- [fact] wrong day") {
+		t.Fatalf("expected the English editor prompt, got %q", prompt)
+	}
+
+	titler := NewTitleAgent(AgentConfig{Provider: provider})
+	if _, _, err := titler.GenerateTitle(context.Background(), "It was raining.", "en"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt := provider.lastMessages[1].Content; !strings.HasPrefix(prompt, "## Text\nIt was raining.") {
+		t.Fatalf("expected the English titler prompt, got %q", prompt)
+	}
+	committer := NewCommitterAgent(AgentConfig{Provider: provider})
+	if _, _, err := committer.Summarize(context.Background(), "It was raining.", 2, "en"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt := provider.lastMessages[1].Content; !strings.HasSuffix(prompt, "Summary in at most 2 sentences:") {
+		t.Fatalf("expected the English summary prompt, got %q", prompt)
+	}
+}
+
+func TestIssueDescriptionsFollowRequestLanguage(t *testing.T) {
+	english := builtinPromptTemplates.For("checker", "en")
+	if issue := povPresenceIssue("Aoi", []string{"Ren"}, english); issue == nil || issue.Description != `The POV character "Aoi" is not among the characters present (characters_present)` {
+		t.Fatalf("expected an English pov issue, got %+v", issue)
+	}
+
+	aoi := models.Character{Name: models.CharacterName{Full: "Aoi"}}
+	aoi.Language.ForbiddenWords = []string{"dude"}
+	issues := lintCharacters("Dude, she thought. No, dude.", "Aoi", []models.Character{aoi}, english)
+	if len(issues) != 1 || issues[0].Description != `Aoi's forbidden word "dude" is used` {
+		t.Fatalf("expected an English forbidden-word issue, got %+v", issues)
+	}
+
+	checker := NewCheckerAgent(AgentConfig{Provider: &stubProvider{text: "not json"}})
+	checker.parseRetries = 0
+	result, err := checker.Check(context.Background(), &CheckerInput{Text: "It was raining.", Language: "en"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Issues) != 1 || !strings.HasPrefix(result.Issues[0].Description, "The checker's reply could not be parsed") {
+		t.Fatalf("expected an English inconclusive-check issue, got %+v", result.Issues)
+	}
+}
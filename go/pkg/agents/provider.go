@@ -3,13 +3,17 @@ package agents
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"math/rand"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
 )
 
 // ProviderRegistry stores provider factories by type.
@@ -30,6 +34,18 @@ func RegisterProviderFactory(providerType string, factory ProviderFactory) {
 	ProviderRegistry.factories[strings.ToLower(providerType)] = factory
 }
 
+// RegisteredProviderTypes returns the registered provider types, sorted.
+func RegisteredProviderTypes() []string {
+	ProviderRegistry.RLock()
+	defer ProviderRegistry.RUnlock()
+	types := make([]string, 0, len(ProviderRegistry.factories))
+	for providerType := range ProviderRegistry.factories {
+		types = append(types, providerType)
+	}
+	sort.Strings(types)
+	return types
+}
+
 // CreateProvider creates a provider from config.
 func CreateProvider(config models.ProviderConfig) (Provider, error) {
 	ProviderRegistry.RLock()
@@ -42,23 +58,56 @@ func CreateProvider(config models.ProviderConfig) (Provider, error) {
 }
 
 func init() {
-	RegisterProviderFactory("mock", NewMockProvider)
+	RegisterProviderFactory(models.ProviderTypeMock, NewMockProvider)
 }
 
 // MockProvider is a deterministic provider for tests and fallback usage.
 type MockProvider struct {
-	rand *rand.Rand
+	mu        sync.Mutex
+	rand      *rand.Rand
+	responses []string
+}
+
+// MockConfig configures a MockProvider directly, without env vars.
+type MockConfig struct {
+	// Seed drives the token counts. Zero means time-based.
+	Seed int64
+	// Responses are returned in order by Generate; once exhausted the
+	// canned response is used.
+	Responses []string
 }
 
-// NewMockProvider creates a mock provider.
+// NewMockProvider creates a mock provider seeded from NOVELIST_MOCK_SEED.
 func NewMockProvider(config models.ProviderConfig) (Provider, error) {
-	seed := time.Now().UnixNano()
-	if envSeed := os.Getenv("NOVELIST_MOCK_SEED"); envSeed != "" {
-		if parsed, err := time.Parse(time.RFC3339Nano, envSeed); err == nil {
-			seed = parsed.UnixNano()
-		}
+	return NewMockProviderWithConfig(MockConfig{Seed: mockSeedFromEnv()}), nil
+}
+
+// NewMockProviderWithConfig creates a mock provider from an explicit config.
+func NewMockProviderWithConfig(config MockConfig) *MockProvider {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &MockProvider{
+		rand:      rand.New(rand.NewSource(seed)),
+		responses: append([]string(nil), config.Responses...),
+	}
+}
+
+// mockSeedFromEnv reads NOVELIST_MOCK_SEED as an integer. RFC3339 timestamps
+// are still accepted for compatibility; anything else yields zero.
+func mockSeedFromEnv() int64 {
+	envSeed := strings.TrimSpace(os.Getenv("NOVELIST_MOCK_SEED"))
+	if envSeed == "" {
+		return 0
+	}
+	if seed, err := strconv.ParseInt(envSeed, 10, 64); err == nil {
+		return seed
+	}
+	if parsed, err := time.Parse(time.RFC3339Nano, envSeed); err == nil {
+		return parsed.UnixNano()
 	}
-	return &MockProvider{rand: rand.New(rand.NewSource(seed))}, nil
+	return 0
 }
 
 // Generate returns a canned response based on params.
@@ -69,11 +118,17 @@ func (p *MockProvider) Generate(ctx context.Context, messages []Message, params
 	default:
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	text := "Mock response."
-	if params.JSONMode {
+	if len(p.responses) > 0 {
+		text = p.responses[0]
+		p.responses = p.responses[1:]
+	} else if params.JSONMode {
 		text = `{"scene":{"id":"mock","chapter":1,"sequence_in_chapter":1,"title":"Mock Scene"},` +
-			`"narrative":{"objective":"Mock objective","summary":"Mock summary","key_events":[],"revelations":[],"hooks":[]},` +
-			`"constraints":{"pov_character":"", "location":"", "mood":""},` +
+			`"narrative":{"objective":"Mock objective","summary":"Mock summary","key_events":["Mock event"],"revelations":[],"hooks":[]},` +
+			`"constraints":{"pov_character":"Mock POV", "characters_present":["Mock POV"], "location":"", "mood":""},` +
 			`"continuity":{"facts_to_reinforce":[],"foreshadowing_to_resolve":[],"foreshadowing_to_plant":[]}}`
 	}
 
@@ -84,6 +139,35 @@ func (p *MockProvider) Generate(ctx context.Context, messages []Message, params
 	}, nil
 }
 
+// GenerateStream returns the canned response in small chunks.
+func (p *MockProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	return generateAsStream(ctx, p.Generate, messages, params)
+}
+
+// mockEmbeddingDims is the length of the mock provider's vectors.
+const mockEmbeddingDims = 64
+
+// Embed hashes each text's search terms into a fixed-size unit vector, so
+// the same text always gets the same vector and texts sharing terms are
+// similar. The seed does not affect it.
+func (p *MockProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, mockEmbeddingDims)
+		for _, term := range textutil.SearchTerms(text) {
+			hash := fnv.New32a()
+			_, _ = hash.Write([]byte(term))
+			vector[hash.Sum32()%mockEmbeddingDims]++
+		}
+		normalize(vector)
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
 // Capabilities returns mock provider capabilities.
 func (p *MockProvider) Capabilities() ProviderCapabilities {
 	return ProviderCapabilities{
@@ -91,7 +175,8 @@ func (p *MockProvider) Capabilities() ProviderCapabilities {
 		SupportsTools:     false,
 		SupportsJSONMode:  true,
 		SupportsThinking:  false,
-		SupportsStreaming: false,
+		SupportsStreaming: true,
+		MaxTemperature:    2,
 	}
 }
 
@@ -0,0 +1,76 @@
+package agents
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMockSeedFromEnv(t *testing.T) {
+	cases := map[string]int64{
+		"":                     0,
+		"42":                   42,
+		"1970-01-01T00:00:01Z": 1_000_000_000,
+		"not-a-seed":           0,
+	}
+	for env, want := range cases {
+		t.Setenv("NOVELIST_MOCK_SEED", env)
+		if got := mockSeedFromEnv(); got != want {
+			t.Errorf("NOVELIST_MOCK_SEED=%q: got %d, want %d", env, got, want)
+		}
+	}
+}
+
+func TestMockProviderScriptedResponses(t *testing.T) {
+	first := NewMockProviderWithConfig(MockConfig{Seed: 7, Responses: []string{"one", "two"}})
+	second := NewMockProviderWithConfig(MockConfig{Seed: 7})
+
+	var texts []string
+	for i := 0; i < 3; i++ {
+		a, err := first.Generate(context.Background(), nil, GenerateParams{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, _ := second.Generate(context.Background(), nil, GenerateParams{})
+		if a.PromptTokens != b.PromptTokens || a.CompletionTokens != b.CompletionTokens {
+			t.Fatalf("expected same seed to give same token counts, got %+v and %+v", a, b)
+		}
+		texts = append(texts, a.Text)
+	}
+
+	if texts[0] != "one" || texts[1] != "two" || texts[2] != "Mock response." {
+		t.Fatalf("unexpected scripted sequence: %v", texts)
+	}
+}
+
+func TestProviderClientTimeoutYieldsToDeadlines(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := newProviderClient(50 * time.Millisecond)
+
+	get := func(ctx context.Context) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := get(context.Background()); err == nil {
+		t.Fatal("expected the provider timeout to bound a request without a deadline")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := get(ctx); err != nil {
+		t.Fatalf("expected a longer request deadline to take precedence, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
+)
+
+const (
+	defaultRetrievalTopK   = 3
+	defaultRetrievalTokens = 800
+	// maxDocumentChunkRunes bounds the chunks loaded files are split into,
+	// so one long file cannot take the whole prompt budget.
+	maxDocumentChunkRunes = 800
+)
+
+// Retriever finds documents relevant to a query.
+type Retriever interface {
+	Search(ctx context.Context, query string, topK int) ([]models.SearchResult, error)
+}
+
+// TFIDFRetriever is an in-memory Retriever ranking documents by TF-IDF
+// over words and CJK character bigrams.
+type TFIDFRetriever struct {
+	mu      sync.RWMutex
+	docs    []models.Document
+	terms   []map[string]int
+	lengths []int
+	docFreq map[string]int
+}
+
+// NewTFIDFRetriever creates an empty retriever.
+func NewTFIDFRetriever() *TFIDFRetriever {
+	return &TFIDFRetriever{docFreq: make(map[string]int)}
+}
+
+// Add indexes docs. Documents without content are ignored.
+func (r *TFIDFRetriever) Add(docs ...models.Document) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, doc := range docs {
+		if strings.TrimSpace(doc.Content) == "" {
+			continue
+		}
+		counts := make(map[string]int)
+		length := 0
+		for _, term := range textutil.SearchTerms(doc.Content) {
+			counts[term]++
+			length++
+		}
+		for term := range counts {
+			r.docFreq[term]++
+		}
+		r.docs = append(r.docs, doc)
+		r.terms = append(r.terms, counts)
+		r.lengths = append(r.lengths, length)
+	}
+}
+
+// Len returns the number of indexed documents.
+func (r *TFIDFRetriever) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.docs)
+}
+
+// Search returns up to topK documents sharing terms with query, best
+// first. Scores are summed TF-IDF weights normalized by document length.
+func (r *TFIDFRetriever) Search(ctx context.Context, query string, topK int) ([]models.SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	queryTerms := make(map[string]bool)
+	for _, term := range textutil.SearchTerms(query) {
+		queryTerms[term] = true
+	}
+	if len(queryTerms) == 0 || topK <= 0 {
+		return nil, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	total := float64(len(r.docs))
+	var results []models.SearchResult
+	for i, counts := range r.terms {
+		score := 0.0
+		for term := range queryTerms {
+			if count := counts[term]; count > 0 {
+				idf := math.Log(1 + total/float64(r.docFreq[term]))
+				score += float64(count) * idf
+			}
+		}
+		if score == 0 {
+			continue
+		}
+		results = append(results, models.SearchResult{
+			Document: r.docs[i],
+			Score:    score / math.Sqrt(float64(r.lengths[i]+1)),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+	return results, nil
+}
+
+// LoadDocuments reads .md and .txt files, walking directories, and splits
+// each into paragraph-aligned chunks. Chunk IDs are "path#n".
+func LoadDocuments(paths []string) ([]models.Document, error) {
+	var docs []models.Document
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			if entry.IsDir() || (ext != ".md" && ext != ".txt") {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			for i, chunk := range chunkDocument(string(data), maxDocumentChunkRunes) {
+				docs = append(docs, models.Document{
+					ID:      fmt.Sprintf("%s#%d", path, i+1),
+					Content: chunk,
+					Source:  path,
+					DocType: strings.TrimPrefix(ext, "."),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load documents from %s: %w", root, err)
+		}
+	}
+	return docs, nil
+}
+
+// chunkDocument groups paragraphs into chunks of at most maxRunes. A
+// longer paragraph becomes a chunk on its own.
+func chunkDocument(text string, maxRunes int) []string {
+	var chunks []string
+	var current strings.Builder
+	size := 0
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		runes := len([]rune(paragraph))
+		if size > 0 && size+runes > maxRunes {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			size = 0
+		}
+		if size > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+		size += runes
+	}
+	if size > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// selectRetrieved keeps results in rank order while their estimated size
+// fits budget tokens, skipping any that would overflow it.
+func selectRetrieved(results []models.SearchResult, budget int) []string {
+	var selected []string
+	used := 0
+	for _, result := range results {
+		tokens := estimateTokensFromText(result.Document.Content)
+		if used+tokens > budget {
+			continue
+		}
+		used += tokens
+		selected = append(selected, strings.TrimSpace(result.Document.Content))
+	}
+	return selected
+}
@@ -0,0 +1,91 @@
+package agents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestTFIDFRetrieverRanksRelevantDocuments(t *testing.T) {
+	retriever := NewTFIDFRetriever()
+	retriever.Add(
+		models.Document{ID: "magic", Content: "魔導国では魔法の使用に許可証が要る。"},
+		models.Document{ID: "city", Content: "王都の市場は朝から賑わう。"},
+		models.Document{ID: "sword", Content: "光の剣は王家に伝わる宝剣で、闇の魔法に強い。"},
+		models.Document{ID: "empty", Content: "  "},
+	)
+	if retriever.Len() != 3 {
+		t.Fatalf("expected 3 documents, got %d", retriever.Len())
+	}
+
+	results, err := retriever.Search(context.Background(), "魔導国の魔法", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || results[0].Document.ID != "magic" || results[0].Rank != 1 || results[1].Document.ID != "sword" {
+		t.Fatalf("expected magic then sword, got %+v", results)
+	}
+	if results, _ := retriever.Search(context.Background(), "宇宙船", 3); len(results) != 0 {
+		t.Fatalf("expected no match, got %+v", results)
+	}
+}
+
+func TestRetrievedContextReachesWriterWithinBudget(t *testing.T) {
+	retriever := NewTFIDFRetriever()
+	retriever.Add(
+		models.Document{ID: "short", Content: "再会の場所は古井戸の前。"},
+		models.Document{ID: "long", Content: "再会" + strings.Repeat("長い資料。", 200)},
+	)
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writer := &stubProvider{text: "本文。"}
+	configs["writer"] = AgentConfig{Provider: writer}
+	configs["checker"] = AgentConfig{Provider: &stubProvider{text: "[]"}}
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	swarm.SetRetriever(retriever, models.ContextSection{Budgets: map[string]int{"writer": 100, "director": 0}})
+
+	req := &models.SceneRequest{Intention: "再会", WordCount: 10}
+	if _, err := swarm.GenerateScene(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prompt := writer.lastMessages[1].Content
+	if !strings.Contains(prompt, "古井戸の前") || strings.Contains(prompt, "長い資料") {
+		t.Fatalf("expected only the short document in the writer prompt, got %q", prompt)
+	}
+	if preview := swarm.PreviewPrompts(req, nil); strings.Contains(preview.Director.User, "設定資料") {
+		t.Fatal("expected no director context with a zero budget")
+	}
+
+	req.Language = "en"
+	if _, err := swarm.GenerateScene(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt := writer.lastMessages[1].Content; !strings.Contains(prompt, "## Related reference material") || strings.Contains(prompt, "設定資料") {
+		t.Fatalf("expected an English reference header for an English request, got %q", prompt)
+	}
+}
+
+func TestLoadDocumentsChunksFiles(t *testing.T) {
+	dir := t.TempDir()
+	bible := strings.Repeat("あ", 500) + "\n\n" + strings.Repeat("い", 500) + "\n\n短い段落。"
+	if err := os.WriteFile(filepath.Join(dir, "bible.md"), []byte(bible), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := LoadDocuments([]string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 2 || !strings.HasSuffix(docs[1].ID, "bible.md#2") || docs[1].DocType != "md" {
+		t.Fatalf("expected two chunks of bible.md, got %+v", docs)
+	}
+}
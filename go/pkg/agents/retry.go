@@ -0,0 +1,281 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRetryBudget   = 3
+	maxRetriesPerCall    = 2
+	maxRetryBackoffDelay = 5 * time.Second
+)
+
+// retryBaseDelay is the first retry's backoff; later retries double it.
+var retryBaseDelay = 500 * time.Millisecond
+
+// ProviderHTTPError is a non-2xx response from a provider API.
+type ProviderHTTPError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+	// RetryAfter is the wait the provider asked for, if any.
+	RetryAfter time.Duration
+}
+
+func (e *ProviderHTTPError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the request may succeed if sent again: rate
+// limits and transient gateway errors are, other statuses are not.
+func (e *ProviderHTTPError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRateLimited reports whether err is a provider's 429 response.
+func IsRateLimited(err error) bool {
+	var httpErr *ProviderHTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests
+}
+
+// IsAuthError reports whether err is a provider rejecting our credentials,
+// which retrying or falling back to the same account will not fix.
+func IsAuthError(err error) bool {
+	var httpErr *ProviderHTTPError
+	return errors.As(err, &httpErr) &&
+		(httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date, returning 0 when it is absent or invalid.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// IsRetryable reports whether a failed generation is worth retrying. Only
+// transport failures (timeouts, refused or dropped connections) and
+// transient HTTP statuses are; cancellation, client-side HTTP errors and
+// replies that could not be decoded are not, since sending the same request
+// again would fail the same way. retryDo separately stops once the
+// request's own context has ended.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var httpErr *ProviderHTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Retryable()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryPolicy is a provider's resolved retry settings.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// retryPolicyFor resolves provider's RetryConfig, looking through wrapping
+// providers, and fills in the defaults for unset fields.
+func retryPolicyFor(provider Provider) retryPolicy {
+	var config models.RetryConfig
+	for provider != nil {
+		if configured, ok := provider.(interface{ retryConfig() models.RetryConfig }); ok {
+			config = configured.retryConfig()
+			break
+		}
+		wrapper, ok := provider.(interface{ Unwrap() Provider })
+		if !ok {
+			break
+		}
+		provider = wrapper.Unwrap()
+	}
+
+	policy := retryPolicy{
+		maxAttempts: maxRetriesPerCall + 1,
+		baseDelay:   retryBaseDelay,
+		maxDelay:    maxRetryBackoffDelay,
+	}
+	if config.MaxAttempts > 0 {
+		policy.maxAttempts = config.MaxAttempts
+	}
+	if config.BaseDelayMs > 0 {
+		policy.baseDelay = time.Duration(config.BaseDelayMs) * time.Millisecond
+	}
+	if config.MaxDelayMs > 0 {
+		policy.maxDelay = time.Duration(config.MaxDelayMs) * time.Millisecond
+	}
+	if policy.baseDelay > policy.maxDelay {
+		policy.baseDelay = policy.maxDelay
+	}
+	return policy
+}
+
+// backoff returns the wait before the given retry (0-based): the base
+// delay doubled per retry, capped at maxDelay, with the upper half
+// jittered so concurrent requests spread out.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	delay := p.maxDelay
+	if retry < 32 {
+		if d := p.baseDelay << retry; d > 0 && d < p.maxDelay {
+			delay = d
+		}
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryDo runs attempt until it succeeds, reports its error as not
+// retryable, ctx ends, or policy.maxAttempts is reached. Each retry takes one unit
+// from the request's retry budget in ctx; without a budget nothing is
+// retried. A Retry-After from the provider replaces the backoff, and one
+// longer than maxDelay ends the retries. Waiting stops when ctx ends, and
+// the last attempt's error is returned.
+func retryDo(ctx context.Context, agent string, policy retryPolicy, attempt func() (retryable bool, err error)) error {
+	budget := retryBudgetFrom(ctx)
+	for retry := 0; ; retry++ {
+		retryable, err := attempt()
+		if err == nil || !retryable || ctx.Err() != nil || budget == nil || retry+1 >= policy.maxAttempts {
+			return err
+		}
+
+		delay := policy.backoff(retry)
+		var httpErr *ProviderHTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			if httpErr.RetryAfter > policy.maxDelay {
+				log.Warn().
+					Str("agent", agent).
+					Dur("retry_after", httpErr.RetryAfter).
+					Msg("Provider asked to wait longer than the retry limit")
+				return err
+			}
+			delay = httpErr.RetryAfter
+		}
+		if !budget.TryConsume() {
+			log.Warn().
+				Str("agent", agent).
+				Int("retries_used", budget.Used()).
+				Msg("Retry budget exhausted")
+			return err
+		}
+
+		log.Warn().
+			Str("agent", agent).
+			Err(err).
+			Int("retry", retry+1).
+			Dur("delay", delay).
+			Msg("Retrying generation")
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// RetryBudget caps the provider retries spent across all stages of one
+// request.
+type RetryBudget struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+}
+
+// NewRetryBudget creates a budget allowing limit retries in total.
+func NewRetryBudget(limit int) *RetryBudget {
+	if limit < 0 {
+		limit = 0
+	}
+	return &RetryBudget{limit: limit}
+}
+
+// TryConsume takes one retry from the budget, reporting false when none
+// are left.
+func (b *RetryBudget) TryConsume() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// Used returns the number of retries consumed so far.
+func (b *RetryBudget) Used() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Limit returns the total number of retries allowed.
+func (b *RetryBudget) Limit() int {
+	return b.limit
+}
+
+type retryBudgetKey struct{}
+
+// WithRetryBudget attaches a shared retry budget to ctx.
+func WithRetryBudget(ctx context.Context, budget *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+// retryBudgetFrom returns the budget attached to ctx, if any. Without one,
+// generation is not retried.
+func retryBudgetFrom(ctx context.Context) *RetryBudget {
+	budget, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return budget
+}
+
+// sleepContext waits for d or until ctx ends.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
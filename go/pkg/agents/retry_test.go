@@ -0,0 +1,185 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// flakyProvider fails with the given status until failures run out.
+type flakyProvider struct {
+	stubProvider
+	failures   int
+	status     int
+	retryAfter time.Duration
+	retry      models.RetryConfig
+	calls      int
+}
+
+func (p *flakyProvider) retryConfig() models.RetryConfig { return p.retry }
+
+func (p *flakyProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	p.calls++
+	if p.failures > 0 {
+		p.failures--
+		return nil, &ProviderHTTPError{Provider: "flaky", StatusCode: p.status, Message: "boom", RetryAfter: p.retryAfter}
+	}
+	return &models.GenerationResult{Text: "ok"}, nil
+}
+
+func TestRetryBudgetSharedAcrossAgents(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	budget := NewRetryBudget(3)
+	ctx := WithRetryBudget(context.Background(), budget)
+
+	first := &flakyProvider{failures: 2, status: http.StatusServiceUnavailable}
+	if _, err := NewBaseAgent("director", first).Generate(ctx, "s", "u", GenerateParams{}); err != nil {
+		t.Fatalf("expected director to recover, got %v", err)
+	}
+	if first.calls != 3 || budget.Used() != 2 {
+		t.Fatalf("expected 2 retries, got calls=%d used=%d", first.calls, budget.Used())
+	}
+
+	second := &flakyProvider{failures: 2, status: http.StatusTooManyRequests}
+	if _, err := NewBaseAgent("writer", second).Generate(ctx, "s", "u", GenerateParams{}); err == nil {
+		t.Fatal("expected writer to fail once the shared budget ran out")
+	}
+	if second.calls != 2 || budget.Used() != 3 {
+		t.Fatalf("expected one retry left for writer, got calls=%d used=%d", second.calls, budget.Used())
+	}
+}
+
+func TestGenerateDoesNotRetryClientErrors(t *testing.T) {
+	budget := NewRetryBudget(3)
+	ctx := WithRetryBudget(context.Background(), budget)
+
+	provider := &flakyProvider{failures: 1, status: http.StatusBadRequest}
+	_, err := NewBaseAgent("writer", provider).Generate(ctx, "s", "u", GenerateParams{})
+	var httpErr *ProviderHTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected ProviderHTTPError, got %v", err)
+	}
+	if provider.calls != 1 || budget.Used() != 0 {
+		t.Fatalf("expected no retry for 400, got calls=%d used=%d", provider.calls, budget.Used())
+	}
+
+	provider = &flakyProvider{failures: 1, status: http.StatusServiceUnavailable}
+	if _, err := NewBaseAgent("writer", provider).Generate(context.Background(), "s", "u", GenerateParams{}); err == nil {
+		t.Fatal("expected no retry without a budget in the context")
+	}
+}
+
+func TestRetryFollowsProviderConfig(t *testing.T) {
+	ctx := WithRetryBudget(context.Background(), NewRetryBudget(10))
+
+	provider := &flakyProvider{
+		failures: 5,
+		status:   http.StatusBadGateway,
+		retry:    models.RetryConfig{MaxAttempts: 4, BaseDelayMs: 1, MaxDelayMs: 2},
+	}
+	if _, err := NewBaseAgent("writer", provider).Generate(ctx, "s", "u", GenerateParams{}); err == nil {
+		t.Fatal("expected failure after max attempts")
+	}
+	if provider.calls != 4 {
+		t.Fatalf("expected 4 attempts, got %d", provider.calls)
+	}
+
+	// A Retry-After beyond the max delay ends the retries.
+	provider = &flakyProvider{
+		failures:   1,
+		status:     http.StatusTooManyRequests,
+		retryAfter: time.Minute,
+		retry:      models.RetryConfig{MaxDelayMs: 10},
+	}
+	if _, err := NewBaseAgent("writer", provider).Generate(ctx, "s", "u", GenerateParams{}); err == nil || provider.calls != 1 {
+		t.Fatalf("expected no retry past Retry-After, got calls=%d err=%v", provider.calls, err)
+	}
+
+	expired, cancel := context.WithCancel(ctx)
+	cancel()
+	provider = &flakyProvider{failures: 1, status: http.StatusServiceUnavailable}
+	if _, err := NewBaseAgent("writer", provider).Generate(expired, "s", "u", GenerateParams{}); err == nil || provider.calls != 1 {
+		t.Fatalf("expected no retry once the context ended, got calls=%d err=%v", provider.calls, err)
+	}
+
+	provider = &flakyProvider{failures: 1, status: http.StatusNotImplemented}
+	if _, err := NewBaseAgent("writer", provider).Generate(ctx, "s", "u", GenerateParams{}); err == nil || provider.calls != 1 {
+		t.Fatalf("expected 501 not to be retried, got calls=%d err=%v", provider.calls, err)
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 5, baseDelay: 100 * time.Millisecond, maxDelay: 300 * time.Millisecond}
+	for i := 0; i < 50; i++ {
+		if d := policy.backoff(0); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("first backoff out of range: %s", d)
+		}
+		if d := policy.backoff(5); d < 150*time.Millisecond || d > 300*time.Millisecond {
+			t.Fatalf("capped backoff out of range: %s", d)
+		}
+	}
+}
+
+func TestRetryableErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{context.Canceled, false},
+		{os.ErrDeadlineExceeded, true},
+		{&ProviderHTTPError{StatusCode: http.StatusGatewayTimeout}, true},
+		{&ProviderHTTPError{StatusCode: http.StatusNotImplemented}, false},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), true},
+		{fmt.Errorf("decode response: %w", &json.SyntaxError{}), false},
+		{errors.New("malformed reply"), false},
+	}
+	for _, tc := range cases {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestProviderErrorPredicates(t *testing.T) {
+	limited := fmt.Errorf("writer generation failed: %w", &ProviderHTTPError{Provider: "openai", StatusCode: http.StatusTooManyRequests})
+	if !IsRateLimited(limited) || IsAuthError(limited) || !IsRetryable(limited) {
+		t.Fatal("expected a wrapped 429 to be a retryable rate limit")
+	}
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		err := &ProviderHTTPError{Provider: "openai", StatusCode: status}
+		if !IsAuthError(err) || IsRateLimited(err) || IsRetryable(err) {
+			t.Fatalf("expected %d to be a non-retryable auth error", status)
+		}
+	}
+	if IsAuthError(errors.New("401 unauthorized")) {
+		t.Fatal("expected only provider responses to count as auth errors")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Fatalf("expected 3s, got %s", got)
+	}
+	at := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(at); got <= 50*time.Second || got > time.Minute {
+		t.Fatalf("expected about a minute, got %s", got)
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Fatalf("expected 0 for %q, got %s", value, got)
+		}
+	}
+}
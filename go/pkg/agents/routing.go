@@ -0,0 +1,148 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// ScenePriorities lists the accepted values for SceneRequest.Priority.
+var ScenePriorities = []string{"low", "normal", "high"}
+
+// IsValidPriority reports whether p is empty or one of ScenePriorities.
+func IsValidPriority(p string) bool {
+	if p == "" {
+		return true
+	}
+	for _, priority := range ScenePriorities {
+		if strings.EqualFold(p, priority) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelRouter picks the writer's provider per request from routing rules.
+type ModelRouter struct {
+	rules []routeEntry
+}
+
+type routeEntry struct {
+	rule     models.RoutingRule
+	provider Provider
+}
+
+// Route is the provider chosen for a request.
+type Route struct {
+	Rule         string
+	ProviderName string
+	Provider     Provider
+}
+
+// BuildModelRouter validates routing rules and creates their providers.
+// Rules naming unknown providers are an error in strict mode and are dropped
+// with a warning otherwise. It returns nil when no rules are configured.
+func BuildModelRouter(provider models.ProviderSection) (*ModelRouter, error) {
+	return BuildModelRouterWithPool(provider, NewProviderPool())
+}
+
+// BuildModelRouterWithPool is BuildModelRouter taking the rules' providers
+// from pool.
+func BuildModelRouterWithPool(provider models.ProviderSection, pool *ProviderPool) (*ModelRouter, error) {
+	if len(provider.RoutingRules) == 0 {
+		return nil, nil
+	}
+
+	router := &ModelRouter{}
+	for i, rule := range provider.RoutingRules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule_%d", i+1)
+		}
+		if rule.MaxWordCount > 0 && rule.MinWordCount > rule.MaxWordCount {
+			return nil, fmt.Errorf("routing rule %s: min_word_count exceeds max_word_count", rule.Name)
+		}
+		if !IsValidPriority(rule.Priority) {
+			return nil, fmt.Errorf("routing rule %s: priority must be one of %s", rule.Name, strings.Join(ScenePriorities, ", "))
+		}
+
+		providerConfig, ok := provider.Available[rule.Provider]
+		if !ok {
+			if provider.Strict {
+				return nil, fmt.Errorf("routing rule %s references unknown provider %s", rule.Name, rule.Provider)
+			}
+			log.Warn().
+				Str("rule", rule.Name).
+				Str("provider", rule.Provider).
+				Msg("Routing rule references unknown provider, ignoring")
+			continue
+		}
+
+		instance, err := pool.Get(rule.Provider, providerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %s: %w", rule.Name, err)
+		}
+
+		router.rules = append(router.rules, routeEntry{rule: rule, provider: instance})
+	}
+	return router, nil
+}
+
+// Route returns the first rule matching the request.
+func (r *ModelRouter) Route(req *models.SceneRequest) (Route, bool) {
+	if r == nil || req == nil {
+		return Route{}, false
+	}
+	for _, entry := range r.rules {
+		if ruleMatches(entry.rule, req) {
+			return Route{
+				Rule:         entry.rule.Name,
+				ProviderName: entry.rule.Provider,
+				Provider:     entry.provider,
+			}, true
+		}
+	}
+	return Route{}, false
+}
+
+func ruleMatches(rule models.RoutingRule, req *models.SceneRequest) bool {
+	if rule.MinWordCount > 0 && req.WordCount < rule.MinWordCount {
+		return false
+	}
+	if rule.MaxWordCount > 0 && req.WordCount > rule.MaxWordCount {
+		return false
+	}
+	if rule.Priority != "" && !strings.EqualFold(rule.Priority, req.Priority) {
+		return false
+	}
+	return true
+}
+
+type providerOverrideKey struct{}
+
+// withProvider makes agents generating under ctx use provider instead of
+// their configured one.
+func withProvider(ctx context.Context, provider Provider) context.Context {
+	return context.WithValue(ctx, providerOverrideKey{}, provider)
+}
+
+// providerFor returns the provider overridden in ctx, or the agent's own.
+// A request's X-Provider-Override wins over routing rules, and both win
+// over a context-overflow fallback and then a model-unavailable fallback.
+func (a *BaseAgent) providerFor(ctx context.Context) Provider {
+	if override, ok := providerOverrideFor(ctx, a.name); ok {
+		return override.provider
+	}
+	if provider, ok := ctx.Value(providerOverrideKey{}).(Provider); ok && provider != nil {
+		return provider
+	}
+	if provider, ok := a.overflow.provider(); ok {
+		return provider
+	}
+	if provider, ok := a.switchover.provider(); ok {
+		return provider
+	}
+	return a.provider
+}
@@ -0,0 +1,142 @@
+package agents
+
+import (
+	"context"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestModelRouterRoute(t *testing.T) {
+	router, err := BuildModelRouter(models.ProviderSection{
+		Available: map[string]models.ProviderConfig{
+			"cheap":   {Type: "mock"},
+			"premium": {Type: "mock"},
+		},
+		RoutingRules: []models.RoutingRule{
+			{Name: "pivotal", Priority: "high", MinWordCount: 2000, Provider: "premium"},
+			{Name: "short", MaxWordCount: 800, Provider: "cheap"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		req      models.SceneRequest
+		wantRule string
+	}{
+		{models.SceneRequest{WordCount: 3000, Priority: "high"}, "pivotal"},
+		{models.SceneRequest{WordCount: 3000}, ""},
+		{models.SceneRequest{WordCount: 500, Priority: "high"}, "short"},
+		{models.SceneRequest{WordCount: 1200, Priority: "low"}, ""},
+	}
+	for _, tc := range cases {
+		route, ok := router.Route(&tc.req)
+		if ok != (tc.wantRule != "") || route.Rule != tc.wantRule {
+			t.Errorf("request %+v: got rule %q (ok=%v), want %q", tc.req, route.Rule, ok, tc.wantRule)
+		}
+	}
+}
+
+func TestBuildModelRouterValidatesRules(t *testing.T) {
+	available := map[string]models.ProviderConfig{"cheap": {Type: "mock"}}
+
+	if _, err := BuildModelRouter(models.ProviderSection{
+		Available:    available,
+		RoutingRules: []models.RoutingRule{{MinWordCount: 900, MaxWordCount: 100, Provider: "cheap"}},
+	}); err == nil {
+		t.Fatal("expected error for inverted word count range")
+	}
+
+	if _, err := BuildModelRouter(models.ProviderSection{
+		Available:    available,
+		RoutingRules: []models.RoutingRule{{Priority: "urgent", Provider: "cheap"}},
+	}); err == nil {
+		t.Fatal("expected error for unknown priority")
+	}
+
+	unknown := []models.RoutingRule{{Name: "missing", Provider: "nope"}}
+	if _, err := BuildModelRouter(models.ProviderSection{Available: available, RoutingRules: unknown, Strict: true}); err == nil {
+		t.Fatal("expected strict mode to reject unknown provider")
+	}
+	router, err := BuildModelRouter(models.ProviderSection{Available: available, RoutingRules: unknown})
+	if err != nil {
+		t.Fatalf("lax mode should drop the rule, got %v", err)
+	}
+	if _, ok := router.Route(&models.SceneRequest{}); ok {
+		t.Fatal("expected dropped rule not to match")
+	}
+}
+
+func TestProviderOverrideFromContext(t *testing.T) {
+	configured := &stubProvider{}
+	override := &stubProvider{}
+	agent := NewBaseAgent("writer", configured)
+
+	ctx := withProvider(context.Background(), override)
+	if _, err := agent.Generate(ctx, "system", "user", GenerateParams{MaxTokens: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if override.lastParams.MaxTokens != 10 || configured.lastParams.MaxTokens != 0 {
+		t.Fatal("expected generation to use the provider from context")
+	}
+}
+
+func TestParseProviderOverrides(t *testing.T) {
+	all, err := ParseProviderOverrides(" ollama ")
+	if err != nil || all["*"] != "ollama" {
+		t.Fatalf("expected all-agent override, got %v, %v", all, err)
+	}
+	pairs, err := ParseProviderOverrides("writer=ollama, checker = openai")
+	if err != nil || pairs["writer"] != "ollama" || pairs["checker"] != "openai" || len(pairs) != 2 {
+		t.Fatalf("expected per-agent overrides, got %v, %v", pairs, err)
+	}
+	for _, raw := range []string{"writer=", "painter=ollama", "writer=a,writer=b", "writer=a,openai"} {
+		if _, err := ParseProviderOverrides(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestProviderOverridesApplyPerRequest(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	swarm.SetProviderCatalog(NewProviderCatalog(models.ProviderSection{
+		Available: map[string]models.ProviderConfig{"alt": {Type: "mock"}},
+	}))
+
+	if _, err := swarm.WithProviderOverrides(context.Background(), ProviderOverrides{"writer": "missing"}); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+
+	ctx, err := swarm.WithProviderOverrides(context.Background(), ProviderOverrides{"writer": "alt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	overridden, err := swarm.GenerateScene(ctx, &models.SceneRequest{Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plain, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, stage := range overridden.Stages {
+		if stage.Agent == "writer" && (stage.Provider != "alt" || !stage.Override) {
+			t.Fatalf("expected writer stage to record the override, got %+v", stage)
+		}
+		if stage.Agent == "director" && stage.Override {
+			t.Fatalf("expected director untouched, got %+v", stage)
+		}
+	}
+	for _, stage := range plain.Stages {
+		if stage.Override {
+			t.Fatalf("expected no override on a plain request, got %+v", stage)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package agents
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	inputFilterModeDelimit = "delimit"
+	inputFilterModeStrip   = "strip"
+
+	userInputOpenTag  = "<user_input>"
+	userInputCloseTag = "</user_input>"
+)
+
+// defaultInjectionPatterns match common instruction-override phrases in
+// English and Japanese.
+var defaultInjectionPatterns = []string{
+	`(ignore|disregard|forget)\s+(all\s+|any\s+)?(of\s+)?(the\s+|your\s+)?(previous|prior|above|earlier|preceding)\s+(instructions?|prompts?|rules|directions)`,
+	`(reveal|print|show|repeat|output)\s+(your|the)\s+(system\s+)?(prompt|instructions)`,
+	`(?m)^\s*(system|assistant)\s*:`,
+	`(以前|前|上記|これまで)の(指示|命令|プロンプト|ルール)を(すべて|全て)?(無視|忘れ)`,
+	`システムプロンプトを(表示|出力|教え|見せ)`,
+}
+
+// InputSanitizer neutralizes prompt-injection attempts in user-supplied
+// request fields before they reach the director.
+type InputSanitizer struct {
+	mode     string
+	patterns []*regexp.Regexp
+}
+
+// NewInputSanitizer builds a sanitizer from config. It returns nil when the
+// filter is disabled.
+func NewInputSanitizer(config models.InputFilterConfig) (*InputSanitizer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(config.Mode))
+	switch mode {
+	case "":
+		mode = inputFilterModeDelimit
+	case inputFilterModeDelimit, inputFilterModeStrip:
+	default:
+		return nil, fmt.Errorf("input filter mode must be %q or %q, got %q", inputFilterModeDelimit, inputFilterModeStrip, config.Mode)
+	}
+
+	sources := config.Patterns
+	if len(sources) == 0 {
+		sources = defaultInjectionPatterns
+	}
+	patterns := make([]*regexp.Regexp, 0, len(sources))
+	for _, source := range sources {
+		pattern, err := regexp.Compile("(?i)" + source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input filter pattern %q: %w", source, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return &InputSanitizer{mode: mode, patterns: patterns}, nil
+}
+
+// Delimits reports whether user text is wrapped in delimiters rather than
+// stripped.
+func (s *InputSanitizer) Delimits() bool {
+	return s != nil && s.mode == inputFilterModeDelimit
+}
+
+// SanitizeRequest returns a copy of req with injection patterns neutralized
+// in the intention, required events and style examples. The original is
+// left untouched.
+func (s *InputSanitizer) SanitizeRequest(req *models.SceneRequest) *models.SceneRequest {
+	if s == nil {
+		return req
+	}
+
+	sanitized := *req
+	sanitized.Intention = s.sanitizeField("intention", req.Intention)
+	sanitized.RequiredEvents = make([]string, len(req.RequiredEvents))
+	for i, event := range req.RequiredEvents {
+		sanitized.RequiredEvents[i] = s.sanitizeField(fmt.Sprintf("required_events[%d]", i), event)
+	}
+	if len(req.StyleExamples) > 0 {
+		sanitized.StyleExamples = make([]string, len(req.StyleExamples))
+		for i, example := range req.StyleExamples {
+			sanitized.StyleExamples[i] = s.sanitizeField(fmt.Sprintf("style_examples[%d]", i), example)
+		}
+	}
+	if len(req.AgentHints) > 0 {
+		sanitized.AgentHints = make(map[string]string, len(req.AgentHints))
+		for agent, hint := range req.AgentHints {
+			sanitized.AgentHints[agent] = s.sanitizeField("agent_hints."+agent, hint)
+		}
+	}
+	return &sanitized
+}
+
+// SanitizeValidation returns a copy of req with the POV character
+// sanitized like SanitizeRequest's fields. The submitted text is what the
+// checker must report on, so only delimiter tags are removed from it: it
+// is never stripped of matches or reflowed, even in strip mode.
+func (s *InputSanitizer) SanitizeValidation(req *models.ValidateSceneRequest) *models.ValidateSceneRequest {
+	if s == nil {
+		return req
+	}
+
+	sanitized := *req
+	sanitized.Text = stripDelimiterTags(req.Text)
+	sanitized.POVCharacter = s.sanitizeField("pov_character", req.POVCharacter)
+	return &sanitized
+}
+
+func (s *InputSanitizer) sanitizeField(field, text string) string {
+	text = stripDelimiterTags(text)
+
+	matched := 0
+	for _, pattern := range s.patterns {
+		if !pattern.MatchString(text) {
+			continue
+		}
+		matched++
+		if s.mode == inputFilterModeStrip {
+			text = pattern.ReplaceAllString(text, "")
+		}
+	}
+
+	if matched > 0 {
+		log.Warn().
+			Str("field", field).
+			Str("mode", s.mode).
+			Int("patterns", matched).
+			Msg("Neutralized prompt injection pattern in user input")
+		if s.mode == inputFilterModeStrip {
+			text = strings.Join(strings.Fields(text), " ")
+		}
+	}
+	return text
+}
+
+// stripDelimiterTags removes user-input delimiter tags, which would let
+// user text break out of its block.
+func stripDelimiterTags(text string) string {
+	return strings.NewReplacer(userInputOpenTag, "", userInputCloseTag, "").Replace(text)
+}
+
+// delimitUserInput wraps user-supplied text in a clearly marked block.
+func delimitUserInput(text string) string {
+	return userInputOpenTag + "\n" + text + "\n" + userInputCloseTag
+}
@@ -0,0 +1,42 @@
+package agents
+
+import (
+	"context"
+	"sync"
+)
+
+// StageTracker records which pipeline stage a request is currently in, so
+// failures such as panics can be attributed to a stage.
+type StageTracker struct {
+	mu    sync.Mutex
+	stage string
+}
+
+type stageTrackerKey struct{}
+
+// WithStageTracker attaches a new StageTracker to ctx.
+func WithStageTracker(ctx context.Context) (context.Context, *StageTracker) {
+	tracker := &StageTracker{}
+	return context.WithValue(ctx, stageTrackerKey{}, tracker), tracker
+}
+
+// Current returns the stage last entered, or "" before the pipeline starts.
+func (t *StageTracker) Current() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stage
+}
+
+// enterStage records stage on the tracker attached to ctx, if any.
+func enterStage(ctx context.Context, stage string) {
+	tracker, ok := ctx.Value(stageTrackerKey{}).(*StageTracker)
+	if !ok {
+		return
+	}
+	tracker.mu.Lock()
+	tracker.stage = stage
+	tracker.mu.Unlock()
+}
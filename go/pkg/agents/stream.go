@@ -0,0 +1,160 @@
+package agents
+
+import (
+	"context"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// StreamChunk is one piece of a streamed generation. The stream ends with
+// a chunk that has Done set, carrying the finish reason and any token
+// usage the provider reported, or with a chunk carrying Err.
+type StreamChunk struct {
+	Text             string
+	Done             bool
+	FinishReason     string
+	PromptTokens     int
+	CompletionTokens int
+	Err              error
+}
+
+// streamChunkRunes is the chunk size providers without token-level
+// streaming split their text into.
+const streamChunkRunes = 8
+
+// generateAsStream runs generate and delivers its text as a stream, for
+// providers without native streaming.
+func generateAsStream(ctx context.Context, generate func(context.Context, []Message, GenerateParams) (*models.GenerationResult, error), messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	result, err := generate(ctx, messages, params)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make(chan StreamChunk)
+	go func() {
+		defer close(chunks)
+		text := []rune(result.Text)
+		for start := 0; start < len(text); start += streamChunkRunes {
+			end := min(start+streamChunkRunes, len(text))
+			if !sendChunk(ctx, chunks, StreamChunk{Text: string(text[start:end])}) {
+				return
+			}
+		}
+		sendChunk(ctx, chunks, StreamChunk{
+			Done:             true,
+			FinishReason:     result.FinishReason,
+			PromptTokens:     result.PromptTokens,
+			CompletionTokens: result.CompletionTokens,
+		})
+	}()
+	return chunks, nil
+}
+
+// sendChunk delivers chunk unless ctx ends first.
+func sendChunk(ctx context.Context, chunks chan<- StreamChunk, chunk StreamChunk) bool {
+	select {
+	case chunks <- chunk:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// collectStream forwards streamed text to sink and assembles the result.
+// streamed reports whether any text reached sink, after which a failed
+// call must not be retried.
+func collectStream(ctx context.Context, chunks <-chan StreamChunk, messages []Message, sink func(string)) (result *models.GenerationResult, streamed bool, err error) {
+	var text strings.Builder
+	result = &models.GenerationResult{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, streamed, ctx.Err()
+		case chunk, ok := <-chunks:
+			if !ok {
+				return finishStream(result, text.String(), messages), streamed, nil
+			}
+			if chunk.Err != nil {
+				return nil, streamed, chunk.Err
+			}
+			if chunk.Text != "" {
+				text.WriteString(chunk.Text)
+				sink(chunk.Text)
+				streamed = true
+			}
+			if chunk.Done {
+				result.FinishReason = chunk.FinishReason
+				result.PromptTokens = chunk.PromptTokens
+				result.CompletionTokens = chunk.CompletionTokens
+				return finishStream(result, text.String(), messages), streamed, nil
+			}
+		}
+	}
+}
+
+// finishStream fills in the text and estimates usage the provider did not
+// report.
+func finishStream(result *models.GenerationResult, text string, messages []Message) *models.GenerationResult {
+	result.Text = strings.TrimSpace(text)
+	if result.PromptTokens <= 0 {
+		result.PromptTokens = estimateTokensFromMessages(messages)
+	}
+	if result.CompletionTokens <= 0 {
+		result.CompletionTokens = estimateTokensFromText(result.Text)
+	}
+	return result
+}
+
+type streamSinkKey struct{}
+
+type streamSink struct {
+	agent string
+	sink  func(string)
+}
+
+// WithStreamSink makes agent pass its generated text to sink as it
+// arrives, for providers that stream. Other providers deliver the whole
+// text to sink once it is complete.
+func WithStreamSink(ctx context.Context, agent string, sink func(text string)) context.Context {
+	return context.WithValue(ctx, streamSinkKey{}, &streamSink{agent: agent, sink: sink})
+}
+
+// withoutStreamSink stops generations under ctx from streaming, for calls
+// whose text should not reach the client.
+func withoutStreamSink(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamSinkKey{}, (*streamSink)(nil))
+}
+
+// streamSinkFor returns the stream sink for agent, if any.
+func streamSinkFor(ctx context.Context, agent string) func(string) {
+	s, _ := ctx.Value(streamSinkKey{}).(*streamSink)
+	if s == nil || s.agent != agent {
+		return nil
+	}
+	return s.sink
+}
+
+// call makes one provider call, streaming it when ctx carries a sink for
+// the agent. streamed reports whether text already reached the sink.
+func (a *BaseAgent) call(ctx context.Context, provider Provider, messages []Message, params GenerateParams) (result *models.GenerationResult, streamed bool, err error) {
+	sink := streamSinkFor(ctx, a.name)
+	if sink == nil {
+		result, err = provider.Generate(ctx, messages, params)
+		return result, false, err
+	}
+	if !provider.Capabilities().SupportsStreaming {
+		result, err = provider.Generate(ctx, messages, params)
+		if err != nil {
+			return nil, false, err
+		}
+		sink(result.Text)
+		return result, true, nil
+	}
+
+	chunks, err := provider.GenerateStream(ctx, messages, params)
+	if err != nil {
+		return nil, false, err
+	}
+	return collectStream(ctx, chunks, messages, sink)
+}
@@ -1,12 +1,19 @@
 package agents
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,162 +24,1520 @@ type Swarm struct {
 	checker   *CheckerAgent
 	editor    *EditorAgent
 	committer *CommitterAgent
+	titler    *TitleAgent
 
-	maxRevision int
+	maxRevision     int
+	writerTimeout   models.ScaledTimeout
+	titleGeneration models.TitleGeneration
+	summary         models.SummaryGeneration
+	stageTimeouts   map[string]time.Duration
+	retryBudget     int
+	// maxTotalTokens caps each request's tokens; zero is no cap.
+	maxTotalTokens  int
+	maxDraftCount   int
+	checkCategories []string
+	// paragraphStyle normalizes writer and editor output when set.
+	paragraphStyle string
+	// trimIncomplete cuts writer and editor output back to the last
+	// complete sentence.
+	trimIncomplete bool
+	// styleExamples are the writer's default few-shot examples.
+	styleExamples []string
+	// lengthFactor gates writer output longer than this multiple of
+	// word_count with lengthAction. Zero disables the gate.
+	lengthFactor float64
+	lengthAction string
+	// lengthTolerance is the accepted deviation from word_count as a
+	// fraction; output outside it gets one expand or condense pass. Zero
+	// disables the pass.
+	lengthTolerance float64
+
+	characters *CharacterStore
+
+	router         *ModelRouter
+	catalog        *ProviderCatalog
+	writerProvider string
+
+	moderator           Moderator
+	blockFlaggedCommits bool
+
+	sanitizer *InputSanitizer
+
+	// retriever supplies reference documents to the director and writer,
+	// at most retrievalTopK per prompt within contextBudgets tokens.
+	retriever      Retriever
+	retrievalTopK  int
+	contextBudgets map[string]int
+
+	// committerLiteThreshold switches the committer to lite mode while
+	// load() reports more in-flight requests than this. Zero disables it.
+	committerLiteThreshold int
+	load                   func() int
+	liteMode               atomic.Bool
+
+	// loadShedThreshold skips the checker and editor while load() reports
+	// more in-flight requests than this. Zero disables it.
+	loadShedThreshold int
+	shedding          atomic.Bool
+	shedCount         atomic.Int64
+
+	// degraded marks a swarm serving from mock providers by accident.
+	degraded bool
+
+	// health caches ProviderHealth results between probes.
+	health *providerHealthCache
+
+	// onPanic is called after a panic in a background stage is recovered.
+	onPanic func()
+
+	// syncCommit runs the committer inline; otherwise commits run in the
+	// background, tracked by commits so shutdown can wait for them.
+	syncCommit bool
+	commits    sync.WaitGroup
+}
+
+const (
+	defaultWriterTimeoutBaseSec    = 20
+	defaultWriterTimeoutPerTokenMs = 30
+	defaultSummaryMaxSentences     = 2
+	defaultMaxRevision             = 1
+	// maxRevisionLimit bounds configured editor passes.
+	maxRevisionLimit = 5
+)
+
+// stageWeights splits the remaining request time between pipeline stages
+// that have no configured timeout, in pipeline order.
+var stageWeights = []struct {
+	stage  string
+	weight int
+}{
+	{"director", 2},
+	{"writer", 5},
+	{"checker", 1},
+	{"editor", 2},
 }
 
 // ProviderHealthStatus represents current provider health by agent role.
 type ProviderHealthStatus struct {
 	Provider string `json:"provider"`
-	Healthy  bool   `json:"healthy"`
-	Error    string `json:"error,omitempty"`
+	// Type and Model identify the provider behind any wrapping ones.
+	Type    string `json:"type,omitempty"`
+	Model   string `json:"model,omitempty"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	// LatencyMs is how long the check took; CheckedAt is when it ran.
+	LatencyMs int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	// LastError is the most recent failed check's error, kept after the
+	// provider recovers.
+	LastError   string           `json:"last_error,omitempty"`
+	LastErrorAt *time.Time       `json:"last_error_at,omitempty"`
+	Endpoints   []EndpointStatus `json:"endpoints,omitempty"`
+	// ContextOverflow is set when context-overflow adaptation is enabled
+	// for the agent.
+	ContextOverflow *ContextOverflowStatus `json:"context_overflow,omitempty"`
+	// ModelUnavailable is set once the agent's model was rejected and it
+	// moved to its fallback; the config needs updating.
+	ModelUnavailable *ModelSwitchoverStatus `json:"model_unavailable,omitempty"`
 }
 
 // NewSwarm creates a new agent swarm
-func NewSwarm(configs map[string]AgentConfig) *Swarm {
-	return &Swarm{
-		director:    NewDirectorAgent(configs["director"]),
-		writer:      NewWriterAgent(configs["writer"]),
-		checker:     NewCheckerAgent(configs["checker"]),
-		editor:      NewEditorAgent(configs["editor"]),
-		committer:   NewCommitterAgent(configs["committer"]),
-		maxRevision: 1, // Max 1 revision as per spec
+func NewSwarm(configs map[string]AgentConfig, section models.SwarmSection) *Swarm {
+	writerTimeout := section.WriterTimeout
+	if writerTimeout.BaseSec <= 0 {
+		writerTimeout.BaseSec = defaultWriterTimeoutBaseSec
+	}
+	if writerTimeout.PerTokenMs <= 0 {
+		writerTimeout.PerTokenMs = defaultWriterTimeoutPerTokenMs
+	}
+
+	stageTimeouts := make(map[string]time.Duration)
+	for stage, sec := range section.StageTimeouts {
+		switch stage {
+		case "director", "checker", "editor":
+			if sec > 0 {
+				stageTimeouts[stage] = time.Duration(sec) * time.Second
+			}
+		default:
+			log.Warn().Str("stage", stage).Msg("Ignoring stage timeout for unknown stage")
+		}
+	}
+
+	retryBudget := section.RetryBudget
+	if retryBudget == 0 {
+		retryBudget = defaultRetryBudget
+	} else if retryBudget < 0 {
+		retryBudget = 0
+	}
+
+	summary := section.Summary
+	if summary.MaxSentences <= 0 {
+		summary.MaxSentences = defaultSummaryMaxSentences
+	}
+
+	titlerConfig := configs["titler"]
+	if titlerConfig.Provider == nil {
+		// Titles are cheap; reuse the committer's provider unless routed.
+		committer := configs["committer"]
+		titlerConfig.Provider, titlerConfig.ProviderChain = committer.Provider, committer.ProviderChain
+	}
+	for agent := range section.Agents {
+		if !isKnownAgent(agent) {
+			log.Warn().Str("agent", agent).Msg("Ignoring generation parameters for unknown agent")
+		}
+	}
+	agentConfig := func(agent string) AgentConfig {
+		return withAgentParams(agent, configs[agent], section.Agents)
+	}
+
+	s := &Swarm{
+		director:        NewDirectorAgent(agentConfig("director")),
+		writer:          NewWriterAgent(agentConfig("writer")),
+		checker:         NewCheckerAgent(agentConfig("checker")),
+		editor:          NewEditorAgent(agentConfig("editor")),
+		committer:       NewCommitterAgent(agentConfig("committer")),
+		titler:          NewTitleAgent(withAgentParams("titler", titlerConfig, section.Agents)),
+		maxRevision:     defaultMaxRevision,
+		writerTimeout:   writerTimeout,
+		titleGeneration: section.TitleGeneration,
+		summary:         summary,
+		stageTimeouts:   stageTimeouts,
+		retryBudget:     retryBudget,
+		maxTotalTokens:  max(section.MaxTotalTokens, 0),
+		maxDraftCount:   MaxDraftCount,
+
+		committerLiteThreshold: section.CommitterLite.InFlightThreshold,
+		loadShedThreshold:      section.LoadShedding.InFlightThreshold,
+		characters:             NewCharacterStore(),
+		writerProvider:         primaryProviderName(configs["writer"]),
+		health:                 newProviderHealthCache(DefaultHealthCacheTTL),
+	}
+	s.trimIncomplete = section.TrimIncompleteSentences
+	s.syncCommit = section.SyncCommit
+	if section.Paragraphs.Enabled {
+		s.paragraphStyle = strings.ToLower(strings.TrimSpace(section.Paragraphs.Style))
+		if s.paragraphStyle == "" {
+			s.paragraphStyle = textutil.StyleWeb
+		} else if !textutil.IsValidParagraphStyle(s.paragraphStyle) {
+			log.Warn().Str("style", section.Paragraphs.Style).Msg("Unknown paragraph style, using web")
+			s.paragraphStyle = textutil.StyleWeb
+		}
+	}
+	switch {
+	case section.MaxRevision < 0:
+		s.maxRevision = 0
+	case section.MaxRevision > maxRevisionLimit:
+		log.Warn().Int("max_revision", section.MaxRevision).Int("limit", maxRevisionLimit).Msg("Capping max_revision")
+		s.maxRevision = maxRevisionLimit
+	case section.MaxRevision > 0:
+		s.maxRevision = section.MaxRevision
+	}
+	if section.CheckerMaxIssues < 0 {
+		s.checker.maxIssues = 0
+	} else if section.CheckerMaxIssues > 0 {
+		s.checker.maxIssues = section.CheckerMaxIssues
+	}
+	if section.CheckerParseRetries < 0 {
+		s.checker.parseRetries = 0
+	} else if section.CheckerParseRetries > 0 {
+		s.checker.parseRetries = section.CheckerParseRetries
+	}
+	for _, category := range section.CheckCategories {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if !IsValidCheckCategory(category) {
+			log.Warn().Str("category", category).Msg("Ignoring unknown check category")
+			continue
+		}
+		s.checkCategories = append(s.checkCategories, category)
+	}
+	if section.MaxDraftCount > 0 {
+		if section.MaxDraftCount > MaxDraftCount {
+			log.Warn().Int("max_draft_count", section.MaxDraftCount).Int("limit", MaxDraftCount).Msg("Ignoring max_draft_count above the limit")
+		} else {
+			s.maxDraftCount = section.MaxDraftCount
+		}
+	}
+	if section.EditorMaxExpansion > 0 {
+		if section.EditorMaxExpansion < 1 {
+			log.Warn().Float64("editor_max_expansion", section.EditorMaxExpansion).Msg("Ignoring editor_max_expansion below 1")
+		} else {
+			s.editor.maxExpansion = section.EditorMaxExpansion
+		}
+	}
+	s.styleExamples = section.StyleExamples.Examples
+	if section.StyleExamples.MaxExamples > 0 {
+		s.writer.maxStyleExamples = section.StyleExamples.MaxExamples
+	}
+	if section.StyleExamples.MaxTokens > 0 {
+		s.writer.styleExampleTokens = section.StyleExamples.MaxTokens
+	}
+	if gate := section.LengthGate; gate.MaxFactor > 0 {
+		action := strings.ToLower(strings.TrimSpace(gate.Action))
+		if action == "" {
+			action = LengthActionWarn
+		}
+		switch {
+		case gate.MaxFactor < 1:
+			log.Warn().Float64("max_factor", gate.MaxFactor).Msg("Ignoring length_gate max_factor below 1")
+		case !IsValidLengthAction(action):
+			log.Warn().Str("action", gate.Action).Msg("Unknown length_gate action, using warn")
+			action = LengthActionWarn
+			fallthrough
+		default:
+			s.lengthFactor = gate.MaxFactor
+			s.lengthAction = action
+		}
+	}
+	switch tolerance := section.LengthGate.Tolerance; {
+	case tolerance == 0:
+	case tolerance < 0 || tolerance >= 1:
+		log.Warn().Float64("tolerance", tolerance).Msg("Ignoring length_gate tolerance outside (0, 1)")
+	default:
+		s.lengthTolerance = tolerance
+	}
+	if section.TopP < 0 || section.TopP > 1 {
+		log.Warn().Float64("top_p", section.TopP).Msg("Ignoring swarm top_p outside (0, 1]")
+		section.TopP = 0
+	}
+	for _, agent := range s.baseAgents() {
+		agent.promptTokenWarn = section.PromptTokenWarn
+		if ms, ok := section.MinTimeRemainingMs[agent.name]; ok && ms >= 0 {
+			agent.minTimeRemaining = time.Duration(ms) * time.Millisecond
+		}
+		if agent.defaultTopP == 0 {
+			agent.defaultTopP = section.TopP
+		}
+		if agent.defaultSeed == 0 {
+			agent.defaultSeed = section.Seed
+		}
+		agent.overflow = newOverflowAdapter(section.ContextOverflow, overflowFallback(section.ContextOverflow, configs[agent.name]), s.catalogProvider)
+		agent.switchover = newModelSwitchover(modelFallback(section.ModelUnavailable, configs[agent.name]), s.catalogProvider)
+	}
+	return s
+}
+
+// overflowFallback returns the provider an agent switches to after
+// repeated context overflows: the configured fallback, else the next
+// provider in its routing chain.
+func overflowFallback(config models.ContextOverflowConfig, agent AgentConfig) string {
+	primary := primaryProviderName(agent)
+	if config.Fallback != "" && config.Fallback != primary {
+		return config.Fallback
+	}
+	if config.Fallback == "" {
+		return nextInChain(agent)
+	}
+	return ""
+}
+
+// nextInChain returns the provider after the primary in an agent's
+// fallback chain, or "" when it has none. The members of a weighted
+// router are not a chain.
+func nextInChain(agent AgentConfig) string {
+	if _, weighted := agent.Provider.(*WeightedRouterProvider); weighted || len(agent.ProviderChain) < 2 {
+		return ""
+	}
+	return agent.ProviderChain[1]
+}
+
+// catalogProvider resolves a provider by name through the catalog.
+func (s *Swarm) catalogProvider(name string) (Provider, error) {
+	if s.catalog == nil {
+		return nil, errors.New("no provider catalog is set")
+	}
+	return s.catalog.Get(name)
+}
+
+// styleExamplesFor returns the request's style examples, or the configured
+// defaults when it has none.
+func (s *Swarm) styleExamplesFor(req *models.SceneRequest) []string {
+	if len(req.StyleExamples) > 0 {
+		return req.StyleExamples
+	}
+	return s.styleExamples
+}
+
+// baseAgents returns every agent in the swarm.
+func (s *Swarm) baseAgents() []*BaseAgent {
+	return []*BaseAgent{
+		s.director.BaseAgent,
+		s.writer.BaseAgent,
+		s.checker.BaseAgent,
+		s.editor.BaseAgent,
+		s.committer.BaseAgent,
+		s.titler.BaseAgent,
+	}
+}
+
+// Characters returns the story's character store.
+func (s *Swarm) Characters() *CharacterStore {
+	return s.characters
+}
+
+// SetCharacters replaces the character store, e.g. to carry imported
+// characters over to a swarm rebuilt from reloaded config.
+func (s *Swarm) SetCharacters(store *CharacterStore) {
+	s.characters = store
+}
+
+// primaryProviderName returns the configured name of an agent's provider.
+func primaryProviderName(config AgentConfig) string {
+	if len(config.ProviderChain) > 0 {
+		return config.ProviderChain[0]
+	}
+	if config.Provider != nil {
+		return config.Provider.Name()
+	}
+	return ""
+}
+
+// SetProviderCatalog enables per-request provider overrides resolved
+// against catalog.
+func (s *Swarm) SetProviderCatalog(catalog *ProviderCatalog) {
+	s.catalog = catalog
+}
+
+// WithProviderOverrides returns a context applying overrides to the agents
+// of one request. Unknown providers, or overrides without a catalog, are
+// an error.
+func (s *Swarm) WithProviderOverrides(ctx context.Context, overrides ProviderOverrides) (context.Context, error) {
+	return WithProviderOverrides(ctx, s.catalog, overrides)
+}
+
+// SetPricing makes every agent estimate generation cost from table.
+func (s *Swarm) SetPricing(table *PricingTable) {
+	for _, agent := range s.baseAgents() {
+		agent.pricing = table
+	}
+}
+
+// SetUsageRecorder makes every agent report the tokens of its generations
+// to fn. Responses served from the cache are not reported.
+func (s *Swarm) SetUsageRecorder(fn UsageRecorder) {
+	for _, agent := range s.baseAgents() {
+		agent.usage = fn
+	}
+}
+
+// SetModelRouter enables per-request writer provider selection. Requests
+// matching no rule keep the static routing.
+func (s *Swarm) SetModelRouter(router *ModelRouter) {
+	s.router = router
+}
+
+// SetInputSanitizer enables prompt-injection filtering of the intention and
+// required events before they reach the director.
+func (s *Swarm) SetInputSanitizer(sanitizer *InputSanitizer) {
+	s.sanitizer = sanitizer
+	s.director.delimitInput = sanitizer.Delimits()
+}
+
+// SetLoadSignal registers fn as the source of the current in-flight request
+// count, used to switch the committer into lite mode and to shed checks
+// under load.
+func (s *Swarm) SetLoadSignal(fn func() int) {
+	s.load = fn
+}
+
+// commitMode returns CommitModeLite while load exceeds the configured
+// threshold and CommitModeFull otherwise, logging each switch.
+func (s *Swarm) commitMode() string {
+	lite := false
+	load := 0
+	if s.committerLiteThreshold > 0 && s.load != nil {
+		load = s.load()
+		lite = load > s.committerLiteThreshold
+	}
+
+	if s.liteMode.Swap(lite) != lite {
+		if lite {
+			log.Warn().
+				Int("in_flight", load).
+				Int("threshold", s.committerLiteThreshold).
+				Msg("Committer switching to lite mode under load")
+		} else {
+			log.Info().
+				Int("in_flight", load).
+				Msg("Committer restored to full mode")
+		}
+	}
+
+	if lite {
+		return CommitModeLite
 	}
+	return CommitModeFull
+}
+
+// shedLoad reports whether a request should skip its checks because load
+// exceeds the load-shedding threshold, counting and logging each switch.
+func (s *Swarm) shedLoad() (bool, int) {
+	if s.loadShedThreshold <= 0 || s.load == nil {
+		return false, 0
+	}
+	load := s.load()
+	shed := load > s.loadShedThreshold
+	if s.shedding.Swap(shed) != shed {
+		if shed {
+			log.Warn().
+				Int("in_flight", load).
+				Int("threshold", s.loadShedThreshold).
+				Msg("Shedding checker and editor under load")
+		} else {
+			log.Info().
+				Int("in_flight", load).
+				Msg("Load shedding ended, checks restored")
+		}
+	}
+	if shed {
+		s.shedCount.Add(1)
+	}
+	return shed, load
+}
+
+// LoadShedCount returns how many requests have skipped their checks under
+// load since start.
+func (s *Swarm) LoadShedCount() int {
+	return int(s.shedCount.Load())
+}
+
+// SetDegraded marks the swarm as running on unintended mock providers.
+// Every scene response then carries degraded: true and a warning.
+func (s *Swarm) SetDegraded(degraded bool) {
+	s.degraded = degraded
+}
+
+// Degraded reports whether the swarm is running on unintended mock
+// providers.
+func (s *Swarm) Degraded() bool {
+	return s.degraded
+}
+
+// SetHealthCacheTTL sets how long ProviderHealth reuses its results. Zero
+// keeps DefaultHealthCacheTTL; a negative TTL checks the providers on every
+// call.
+func (s *Swarm) SetHealthCacheTTL(ttl time.Duration) {
+	if ttl == 0 {
+		ttl = DefaultHealthCacheTTL
+	}
+	s.health.setTTL(ttl)
+}
+
+// SetPanicHandler registers fn to be called when a panic in a background
+// stage, such as the async committer, is recovered.
+func (s *Swarm) SetPanicHandler(fn func()) {
+	s.onPanic = fn
+}
+
+// SetMemoryStore makes the committer persist scenes to store.
+func (s *Swarm) SetMemoryStore(store MemoryStore) {
+	s.committer.store = store
+}
+
+// SetRetriever enables retrieval of reference documents into the
+// director's and writer's prompts.
+func (s *Swarm) SetRetriever(retriever Retriever, config models.ContextSection) {
+	s.retriever = retriever
+	s.retrievalTopK = config.TopK
+	if s.retrievalTopK <= 0 {
+		s.retrievalTopK = defaultRetrievalTopK
+	}
+	s.contextBudgets = config.Budgets
+}
+
+// retrieveContext searches for query and keeps what fits agent's token
+// budget; a budget of 0 disables retrieval for the agent. A failed search
+// is reported as a warning on response, when given, and yields nothing.
+func (s *Swarm) retrieveContext(ctx context.Context, response *models.SceneResponse, agent, query string) []string {
+	if s.retriever == nil || strings.TrimSpace(query) == "" {
+		return nil
+	}
+	budget, ok := s.contextBudgets[agent]
+	if !ok {
+		budget = defaultRetrievalTokens
+	}
+	if budget <= 0 {
+		return nil
+	}
+	results, err := s.retriever.Search(ctx, query, s.retrievalTopK)
+	if err != nil {
+		log.Warn().Err(err).Str("agent", agent).Msg("Retrieval failed, continuing without context")
+		if response != nil {
+			addWarning(response, models.WarningRetrievalFailed, agent, "reference retrieval failed: "+err.Error())
+		}
+		return nil
+	}
+	return selectRetrieved(results, budget)
+}
+
+// directorQuery is the retrieval query for a scene request.
+func directorQuery(req *models.SceneRequest) string {
+	parts := append([]string{req.Intention, req.POVCharacter}, req.CharactersPresent...)
+	return strings.Join(append(parts, req.RequiredEvents...), "\n")
+}
+
+// writerQuery is the retrieval query for a designed scene.
+func writerQuery(req *models.SceneRequest, spec *models.SceneSpec) string {
+	parts := []string{
+		req.Intention,
+		spec.Scene.Title,
+		spec.Narrative.Objective,
+		spec.Narrative.Summary,
+		spec.Constraints.Location,
+	}
+	parts = append(parts, spec.Narrative.KeyEvents...)
+	return strings.Join(append(parts, spec.Constraints.CharactersPresent...), "\n")
+}
+
+// SetModerator enables content moderation of intentions and final prose.
+// When blockCommit is set, flagged prose is returned but not committed.
+func (s *Swarm) SetModerator(moderator Moderator, blockCommit bool) {
+	s.moderator = moderator
+	s.blockFlaggedCommits = blockCommit
 }
 
 // GenerateScene runs the full pipeline
 func (s *Swarm) GenerateScene(ctx context.Context, req *models.SceneRequest) (*models.SceneResponse, error) {
 	start := time.Now()
 
+	retryBudget := NewRetryBudget(s.retryBudget)
+	ctx = WithRetryBudget(ctx, retryBudget)
+	ctx, cacheHits := withCacheHitRecorder(ctx)
+	ctx, costs := withCostRecorder(ctx)
+	ctx, tokens := withTokenBudget(ctx, s.tokenLimit(req))
+
 	response := &models.SceneResponse{
 		RequestID: req.ID,
 		Timestamp: time.Now(),
 		Stages:    []models.StageInfo{},
+		Metadata:  req.Metadata,
+		Degraded:  s.degraded,
+	}
+	// finish fills in the totals and diagnostics of a response about to be
+	// returned.
+	finish := func() {
+		for _, agent := range cacheHits.hits() {
+			addWarning(response, models.WarningCacheHit, agent, "response served from cache")
+		}
+		response.Debug = &models.DebugInfo{
+			RetriesUsed: retryBudget.Used(),
+			RetryBudget: retryBudget.Limit(),
+		}
+		annotateOverrides(ctx, response.Stages)
+		response.TotalCostUSD = costs.sum()
+		response.TotalTokens = tokens.spent()
+		response.TotalDurationMs = time.Since(start).Milliseconds()
+	}
+	// partial returns what the stages before stage produced, with text as
+	// the prose when there is any, once the request deadline has passed.
+	partial := func(stage, text string) (*models.SceneResponse, error) {
+		log.Warn().Str("request_id", req.ID).Str("stage", stage).Msg("Request deadline passed, returning a partial scene")
+		if text != "" {
+			response.Text = text
+			response.Chars = textutil.CountChars(text)
+		}
+		response.TimedOut = true
+		addWarning(response, models.WarningTimedOut, stage,
+			fmt.Sprintf("the request deadline passed during %s; the stages after it did not run and the scene was not committed", stage))
+		finish()
+		return response, nil
+	}
+	if s.degraded {
+		addWarning(response, models.WarningProviderDegraded, "",
+			"all agents are using the mock provider; output is not real")
+	}
+
+	if s.moderator != nil {
+		input := strings.Join(append([]string{req.Intention}, req.RequiredEvents...), "\n")
+		flagged, categories, err := s.moderator.Check(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("moderation failed: %w", err)
+		}
+		if flagged {
+			log.Warn().Strs("categories", categories).Msg("Intention flagged by moderation")
+			return nil, &ModerationError{Categories: categories}
+		}
 	}
 
 	// Stage 1: Director
+	enterStage(ctx, "director")
 	log.Info().Str("stage", "director").Msg("Starting scene design")
 
-	directorResult, err := s.director.Execute(ctx, req)
+	sanitized := s.sanitizer.SanitizeRequest(req)
+	ctx = withAgentHints(ctx, sanitized.AgentHints, req.Language)
+	directorCtx, cancelDirector := s.stageContext(ctx, "director")
+	directorInput := &DirectorInput{
+		Request: sanitized,
+		Context: s.retrieveContext(directorCtx, response, "director", directorQuery(sanitized)),
+	}
+	directorResult, err := s.director.Execute(directorCtx, directorInput)
+	directorTimedOut := stageTimedOut(ctx, directorCtx)
+	cancelDirector()
 	if err != nil {
+		if directorTimedOut {
+			return nil, fmt.Errorf("director exceeded its stage timeout: %w", err)
+		}
 		return nil, fmt.Errorf("director failed: %w", err)
 	}
 
-	response.Stages = append(response.Stages, models.StageInfo{
+	directorStage := models.StageInfo{
 		Agent:      "director",
 		Operation:  "design_scene",
 		DurationMs: directorResult.DurationMs,
 		Tokens:     directorResult.PromptTokens + directorResult.CompletionTokens,
-	})
+		Reprompts:  directorResult.Reprompts,
+	}
 
 	// Parse SceneSpec
 	sceneSpec, err := parseSceneSpec(directorResult.Text)
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to parse SceneSpec, using raw")
 		sceneSpec = &models.SceneSpec{}
+		directorStage.Error = err.Error()
+		addWarning(response, models.WarningDirectorFallback, "director",
+			"scene spec could not be parsed; the writer used an empty spec")
+	}
+	response.Stages = append(response.Stages, directorStage)
+	specIssues := normalizeSceneSpec(sceneSpec, s.checker.promptsFor(req.Language))
+	if err == nil {
+		for _, violation := range ValidateSceneSpec(sceneSpec) {
+			addWarning(response, models.WarningSpecIncomplete, "director", violation)
+		}
 	}
 	response.SceneSpec = sceneSpec
+	if !withinBudget(ctx, response, "writer", "writing", 1) {
+		// Nothing is left for the prose; return the spec alone.
+		response.Stages = append(response.Stages, models.StageInfo{Agent: "writer", Operation: "generate_prose", SkipReason: "budget_exceeded"})
+		finish()
+		return response, nil
+	}
 
 	// Stage 2: Writer
+	enterStage(ctx, "writer")
 	log.Info().Str("stage", "writer").Msg("Generating prose")
 
 	writerInput := &WriterInput{
-		SceneSpec:    sceneSpec,
-		WordCount:    req.WordCount,
-		POVCharacter: req.POVCharacter,
+		SceneSpec:     sceneSpec,
+		WordCount:     req.WordCount,
+		POVCharacter:  req.POVCharacter,
+		Pacing:        req.Pacing,
+		DialogueRatio: req.DialogueRatio,
+		StyleExamples: s.styleExamplesFor(sanitized),
+		Context:       s.retrieveContext(ctx, response, "writer", writerQuery(sanitized, sceneSpec)),
+		Language:      req.Language,
+	}
+
+	writerStage := models.StageInfo{
+		Agent:     "writer",
+		Operation: "generate_prose",
+		Provider:  s.writerProvider,
+	}
+	// A request override beats routing rules; annotateOverrides records it.
+	route, routed := Route{}, false
+	if _, overridden := providerOverrideFor(ctx, "writer"); !overridden {
+		route, routed = s.router.Route(req)
+	}
+	writerBase := ctx
+	if routed {
+		log.Info().
+			Str("rule", route.Rule).
+			Str("provider", route.ProviderName).
+			Msg("Routing writer by rule")
+		writerBase = withProvider(ctx, route.Provider)
+		writerStage.Provider = route.ProviderName
+		writerStage.Route = route.Rule
+	} else if s.writer.overflow.active() {
+		writerStage.Provider = s.writer.overflow.fallback
+	} else if s.writer.switchover.state() != nil {
+		writerStage.Provider = s.writer.switchover.fallback
+	}
+	checkerInput := &CheckerInput{
+		Chapter:           req.Chapter,
+		Scene:             req.Scene,
+		POVCharacter:      req.POVCharacter,
+		CharactersPresent: sceneSpec.Constraints.CharactersPresent,
+		Characters:        s.characters.Find(append([]string{req.POVCharacter}, sceneSpec.Constraints.CharactersPresent...)...),
+		Categories:        s.checkCategories,
+		Language:          req.Language,
+	}
+	if len(req.CheckCategories) > 0 {
+		checkerInput.Categories = req.CheckCategories
 	}
 
-	writerResult, err := s.writer.Execute(ctx, writerInput)
+	writerCtx, cancelWriter := context.WithTimeout(writerBase, s.writerTimeoutFor(ctx, req.WordCount, req.Language))
+	var writerResult *models.GenerationResult
+	var drafted *checkedDraft
+	if draftCount := affordableDrafts(ctx, response, writerInput, min(req.DraftCount, s.maxDraftCount)); draftCount > 1 {
+		drafting := time.Now()
+		drafted, response.Drafts, err = s.writeDrafts(ctx, writerCtx, req.ID, writerInput, checkerInput, draftCount)
+		if drafted != nil {
+			writerResult = drafted.result
+		}
+		writerStage.DurationMs = time.Since(drafting).Milliseconds()
+	} else {
+		writerResult, err = s.writer.Execute(writerCtx, writerInput)
+		if err == nil {
+			writerStage.DurationMs = writerResult.DurationMs
+		}
+	}
+	cancelWriter()
 	if err != nil {
+		if deadlinePassed(ctx) || errors.Is(err, ErrInsufficientTimeRemaining) {
+			writerStage.Error = err.Error()
+			response.Stages = append(response.Stages, writerStage)
+			return partial("writer", "")
+		}
 		return nil, fmt.Errorf("writer failed: %w", err)
 	}
 
-	response.Stages = append(response.Stages, models.StageInfo{
-		Agent:      "writer",
-		Operation:  "generate_prose",
-		DurationMs: writerResult.DurationMs,
-		Tokens:     writerResult.PromptTokens + writerResult.CompletionTokens,
-	})
+	warnIfTruncated(response, "writer", writerResult)
+	if writerResult.ServedBy != "" {
+		writerStage.Provider = writerResult.ServedBy
+	}
+	writerStage.Tokens = writerResult.PromptTokens + writerResult.CompletionTokens
+	if response.Drafts != nil {
+		writerStage.Tokens = 0
+		for _, draft := range response.Drafts.Drafts {
+			writerStage.Tokens += draft.Tokens
+		}
+	}
+	text := s.gateLength(writerBase, response, &writerStage, writerInput, writerResult.Text)
+	text = s.fitLength(writerBase, response, &writerStage, writerInput, text)
+	writerStage.Chars = textutil.CountChars(text)
+	response.Stages = append(response.Stages, writerStage)
 
-	text := writerResult.Text
+	text = s.finishProse(response, "writer", text)
 
 	// Stage 3: Checker
+	enterStage(ctx, "checker")
 	log.Info().Str("stage", "checker").Msg("Validating content")
 
-	checkerInput := &CheckerInput{
-		Text:         text,
+	checkerInput.Text = text
+	checkerStage := models.StageInfo{
+		Agent:     "checker",
+		Operation: "validate",
+	}
+	var checkResult *CheckerResult
+	checkerTimedOut, checkerSkipped := false, false
+	if drafted != nil && drafted.check != nil && drafted.result.Text == text {
+		// The selected draft reached the checker unchanged; its check stands.
+		checkResult, err = drafted.check, nil
+	} else if shed, load := s.shedLoad(); shed {
+		// With no issues found, the editor does not run either.
+		log.Warn().Str("request_id", req.ID).Int("in_flight", load).Msg("Skipping checker and editor under load")
+		checkerStage.SkipReason = "load_shed"
+		checkerSkipped = true
+		response.Degraded = true
+		response.LoadShed = true
+		addWarning(response, models.WarningLoadShed, "checker",
+			fmt.Sprintf("checks and revision were skipped under load (%d requests in flight, threshold %d); the text is the writer's draft", load, s.loadShedThreshold))
+	} else if !withinBudget(ctx, response, "checker", "content checking", estimateProseTokens(text, req.Language)) {
+		checkerStage.SkipReason = "budget_exceeded"
+		checkerSkipped = true
+	} else {
+		checkerCtx, cancelChecker := s.stageContext(ctx, "checker")
+		checkResult, err = s.checker.Check(checkerCtx, checkerInput)
+		checkerTimedOut = stageTimedOut(ctx, checkerCtx)
+		cancelChecker()
+	}
+	var issues []models.Issue
+	if err == nil && !checkerSkipped {
+		issues = checkResult.Issues
+		if checkResult.Truncated {
+			response.IssuesTruncated = true
+			response.IssuesTotal = checkResult.TotalIssues
+		}
+		if !checkResult.Checked {
+			addWarning(response, models.WarningCheckerInconclusive, "checker",
+				"checker output unparseable ("+checkResult.ParseError+"); the scene was not verified")
+		}
+	} else if err != nil {
+		if errors.Is(err, ErrInsufficientTimeRemaining) {
+			checkerStage.SkipReason = "insufficient_time"
+			addWarning(response, models.WarningStageTimeout, "checker", "content checks were skipped: "+err.Error())
+		} else if checkerTimedOut {
+			log.Warn().Msg("Checker exceeded its stage timeout, skipping")
+			checkerStage.SkipReason = "timeout"
+			addWarning(response, models.WarningStageTimeout, "checker", "content checks were skipped after exceeding the stage timeout")
+		} else {
+			log.Warn().Err(err).Msg("Checker encountered error, continuing")
+			addWarning(response, models.WarningCheckerFailed, "checker", "content checks were skipped: "+err.Error())
+		}
+	}
+
+	// Spec issues are already corrected, so only checker issues reach the
+	// editor.
+	response.Issues = append(specIssues, issues...)
+	response.Stages = append(response.Stages, checkerStage)
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+	if deadlinePassed(ctx) {
+		return partial("checker", text)
+	}
+
+	// Stage 4: Editor, re-checking after each pass while revisions remain.
+	// A re-check that flags the same issues again ends the loop.
+	for revision := 1; revision <= s.maxRevision && hasActionableIssues(issues); revision++ {
+		log.Info().
+			Int("issues", len(issues)).
+			Int("revision", revision).
+			Msg("Issues found, running editor")
+
+		// The editor reads the text and writes it out again.
+		if !withinBudget(ctx, response, "editor", "revision", 2*estimateProseTokens(text, req.Language)) {
+			response.Stages = append(response.Stages, models.StageInfo{Agent: "editor", Operation: "fix_issues", SkipReason: "budget_exceeded"})
+			break
+		}
+		edited, ok := s.revise(ctx, response, text, issues, req.Language)
+		if !ok {
+			break
+		}
+		text = edited
+		response.RevisionMade = true
+		response.Revisions = revision
+		if revision == s.maxRevision {
+			break
+		}
+
+		checkerInput.Text = text
+		if !withinBudget(ctx, response, "checker", "re-checking", estimateProseTokens(text, req.Language)) {
+			response.Stages = append(response.Stages, models.StageInfo{Agent: "checker", Operation: "recheck", SkipReason: "budget_exceeded"})
+			break
+		}
+		rechecked, ok := s.recheck(ctx, response, checkerInput)
+		if !ok {
+			break
+		}
+		response.Issues = append(specIssues[:len(specIssues):len(specIssues)], rechecked...)
+		if hasActionableIssues(rechecked) && sameActionableIssues(issues, rechecked) {
+			log.Warn().Int("revision", revision).Msg("Checker flagged the same issues after revision, stopping")
+			addWarning(response, models.WarningRevisionStalled, "editor",
+				"the checker flagged the same issues after revision; no further passes were made")
+			issues = rechecked
+			break
+		}
+		issues = rechecked
+	}
+
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+	if deadlinePassed(ctx) {
+		return partial("editor", text)
+	}
+	response.Text = text
+	response.Chars = textutil.CountChars(text)
+
+	if strings.TrimSpace(sceneSpec.Scene.Title) == "" {
+		sceneSpec.Scene.Title = s.sceneTitle(ctx, req, text, response)
+	}
+
+	if s.moderator != nil {
+		enterStage(ctx, "moderation")
+		flagged, categories, err := s.moderator.Check(ctx, text)
+		if err != nil {
+			log.Warn().Err(err).Msg("Prose moderation failed, continuing")
+			addWarning(response, models.WarningModerationSkipped, "moderation", "prose moderation failed: "+err.Error())
+		} else {
+			response.Moderation = &models.ModerationResult{
+				Flagged:    flagged,
+				Categories: categories,
+			}
+		}
+	}
+
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+	if deadlinePassed(ctx) {
+		stage := "titler"
+		if s.moderator != nil {
+			stage = "moderation"
+		}
+		return partial(stage, text)
+	}
+	if response.Moderation != nil && response.Moderation.Flagged && s.blockFlaggedCommits {
+		log.Warn().
+			Strs("categories", response.Moderation.Categories).
+			Msg("Prose flagged by moderation, skipping commit")
+	} else {
+		mode := s.commitMode()
+		if mode == CommitModeFull && !withinBudget(ctx, response, "committer", "the full commit", 1) {
+			// Lite mode makes no LLM calls.
+			mode = CommitModeLite
+		}
+		response.CommitMode = mode
+		response.Summary = s.sceneSummary(ctx, text, req.Language, sceneSpec, mode, response)
+		committerInput := &CommitterInput{
+			Text:      text,
+			Chapter:   req.Chapter,
+			Scene:     req.Scene,
+			SceneSpec: sceneSpec,
+			Summary:   response.Summary,
+			Metadata:  req.Metadata,
+			Mode:      mode,
+		}
+		if s.syncCommit {
+			s.commitSync(ctx, response, committerInput)
+		} else {
+			s.commitAsync(req.ID, committerInput)
+		}
+	}
+
+	finish()
+	log.Info().
+		Int64("duration_ms", response.TotalDurationMs).
+		Int("issues", len(issues)).
+		Bool("revision", response.RevisionMade).
+		Msg("Scene generation complete")
+
+	return response, nil
+}
+
+// ValidateScene runs only the checker over prose written elsewhere.
+func (s *Swarm) ValidateScene(ctx context.Context, requestID string, req *models.ValidateSceneRequest) (*models.ValidationResponse, error) {
+	start := time.Now()
+	ctx = WithRetryBudget(ctx, NewRetryBudget(s.retryBudget))
+	req = s.sanitizer.SanitizeValidation(req)
+
+	input := &CheckerInput{
+		Text:         req.Text,
 		Chapter:      req.Chapter,
 		Scene:        req.Scene,
 		POVCharacter: req.POVCharacter,
+		Characters:   s.characters.Find(req.POVCharacter),
+		Categories:   s.checkCategories,
+		Language:     req.Language,
+	}
+	if len(req.CheckCategories) > 0 {
+		input.Categories = req.CheckCategories
 	}
 
-	issues, err := s.checker.Check(ctx, checkerInput)
+	enterStage(ctx, "checker")
+	checkerCtx, cancel := s.stageContext(ctx, "checker")
+	result, err := s.checker.Check(checkerCtx, input)
+	timedOut := stageTimedOut(ctx, checkerCtx)
+	cancel()
 	if err != nil {
-		log.Warn().Err(err).Msg("Checker encountered error, continuing")
+		if timedOut {
+			return nil, fmt.Errorf("checker exceeded its stage timeout: %w", err)
+		}
+		return nil, fmt.Errorf("checker failed: %w", err)
+	}
+
+	response := &models.ValidationResponse{
+		RequestID: requestID,
+		Timestamp: time.Now(),
+		Stages: []models.StageInfo{{
+			Agent:      "checker",
+			Operation:  "validate",
+			DurationMs: time.Since(start).Milliseconds(),
+		}},
+		Issues:          result.Issues,
+		Checked:         result.Checked,
+		IssuesTruncated: result.Truncated,
+	}
+	if result.Truncated {
+		response.IssuesTotal = result.TotalIssues
+	}
+	if response.Issues == nil {
+		response.Issues = []models.Issue{}
+	}
+	if !result.Checked {
+		response.Warnings = append(response.Warnings, models.Warning{
+			Code:    models.WarningCheckerInconclusive,
+			Stage:   "checker",
+			Message: "checker output unparseable (" + result.ParseError + "); the text was not verified",
+		})
+	}
+	response.TotalDurationMs = time.Since(start).Milliseconds()
+	return response, nil
+}
+
+// stageTimeout returns the time budget for a stage: its configured timeout,
+// else its weighted share of the time left before the request deadline.
+// Budgets never exceed the remaining time. It returns false when the stage is
+// unbounded.
+func (s *Swarm) stageTimeout(ctx context.Context, stage string) (time.Duration, bool) {
+	budget := s.stageTimeouts[stage]
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return budget, budget > 0
 	}
+	remaining := time.Until(deadline)
 
-	response.Issues = issues
+	if budget == 0 {
+		weight, total := 0, 0
+		for _, w := range stageWeights {
+			if w.stage == stage {
+				weight = w.weight
+			}
+			if weight > 0 {
+				total += w.weight
+			}
+		}
+		if total == 0 {
+			return remaining, true
+		}
+		budget = remaining * time.Duration(weight) / time.Duration(total)
+	}
+	if budget > remaining {
+		budget = remaining
+	}
+	return budget, true
+}
+
+// stageContext derives a context bounded by the stage's time budget.
+func (s *Swarm) stageContext(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	if budget, ok := s.stageTimeout(ctx, stage); ok {
+		return context.WithTimeout(ctx, budget)
+	}
+	return context.WithCancel(ctx)
+}
+
+// revise runs one editor pass over text, returning the edited text, or
+// false when the edit failed or was discarded. Skips and failures are
+// recorded on response.
+func (s *Swarm) revise(ctx context.Context, response *models.SceneResponse, text string, issues []models.Issue, language string) (string, bool) {
+	editorInput := &EditorInput{
+		Text:     text,
+		Issues:   issues,
+		Language: language,
+	}
+
+	enterStage(ctx, "editor")
+	editorCtx, cancelEditor := s.stageContext(ctx, "editor")
+	editorResult, err := s.editor.Execute(editorCtx, editorInput)
+	editorTimedOut := stageTimedOut(ctx, editorCtx)
+	cancelEditor()
+	switch {
+	case err != nil && editorTimedOut:
+		log.Warn().Msg("Editor exceeded its stage timeout, using original text")
+		response.Stages = append(response.Stages, models.StageInfo{
+			Agent:      "editor",
+			Operation:  "fix_issues",
+			SkipReason: "timeout",
+		})
+		addWarning(response, models.WarningStageTimeout, "editor", "revision was skipped after exceeding the stage timeout")
+	case errors.Is(err, ErrInsufficientTimeRemaining):
+		response.Stages = append(response.Stages, models.StageInfo{
+			Agent:      "editor",
+			Operation:  "fix_issues",
+			SkipReason: "insufficient_time",
+		})
+		addWarning(response, models.WarningStageTimeout, "editor", "revision was skipped: "+err.Error())
+	case errors.Is(err, ErrEditorExpansion):
+		log.Warn().Err(err).Msg("Editor output too long, using original text")
+		response.Stages = append(response.Stages, models.StageInfo{
+			Agent:      "editor",
+			Operation:  "fix_issues",
+			SkipReason: "over_expansion",
+		})
+		addWarning(response, models.WarningEditorDiscarded, "editor", "revision was discarded for growing the text too much: "+err.Error())
+	case errors.Is(err, ErrEditorTruncated):
+		log.Warn().Err(err).Msg("Editor output truncated, using original text")
+		response.Stages = append(response.Stages, models.StageInfo{
+			Agent:      "editor",
+			Operation:  "fix_issues",
+			SkipReason: "truncated",
+		})
+		addWarning(response, models.WarningEditorDiscarded, "editor", "revision was discarded: "+err.Error())
+	case err != nil:
+		log.Warn().Err(err).Msg("Editor failed, using original text")
+		addWarning(response, models.WarningEditorFailed, "editor", "revision failed; returning the unrevised text")
+	default:
+		warnIfTruncated(response, "editor", editorResult)
+		response.Stages = append(response.Stages, models.StageInfo{
+			Agent:      "editor",
+			Operation:  "fix_issues",
+			DurationMs: editorResult.DurationMs,
+		})
+		return s.finishProse(response, "editor", editorResult.Text), true
+	}
+	return "", false
+}
+
+// recheck runs the checker over revised text, returning false when it
+// failed or could not parse a response.
+func (s *Swarm) recheck(ctx context.Context, response *models.SceneResponse, input *CheckerInput) ([]models.Issue, bool) {
+	enterStage(ctx, "checker")
+	checkerCtx, cancelChecker := s.stageContext(ctx, "checker")
+	result, err := s.checker.Check(checkerCtx, input)
+	cancelChecker()
+	if err != nil {
+		log.Warn().Err(err).Msg("Re-check after revision failed, stopping revisions")
+		addWarning(response, models.WarningCheckerFailed, "checker", "re-check after revision was skipped: "+err.Error())
+		return nil, false
+	}
 	response.Stages = append(response.Stages, models.StageInfo{
 		Agent:     "checker",
-		Operation: "validate",
+		Operation: "recheck",
 	})
+	if !result.Checked {
+		addWarning(response, models.WarningCheckerInconclusive, "checker",
+			"re-check output unparseable ("+result.ParseError+"); the revision was not verified")
+		return nil, false
+	}
+	response.IssuesTruncated = result.Truncated
+	response.IssuesTotal = 0
+	if result.Truncated {
+		response.IssuesTotal = result.TotalIssues
+	}
+	return result.Issues, true
+}
 
-	// Stage 4: Editor (if issues found and maxRevision > 0)
-	if len(issues) > 0 && s.maxRevision > 0 {
-		log.Info().
-			Int("issues", len(issues)).
-			Msg("Issues found, running editor")
+// sameActionableIssues reports whether two checks flagged the same error
+// and warning issues.
+func sameActionableIssues(before, after []models.Issue) bool {
+	type issueKey struct{ category, severity, description string }
+	actionable := func(issues []models.Issue) map[issueKey]bool {
+		keys := make(map[issueKey]bool)
+		for _, issue := range issues {
+			if issue.Severity == "error" || issue.Severity == "warning" {
+				keys[issueKey{issue.Category, issue.Severity, strings.TrimSpace(issue.Description)}] = true
+			}
+		}
+		return keys
+	}
+	a, b := actionable(before), actionable(after)
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+	return true
+}
 
-		editorInput := &EditorInput{
-			Text:   text,
-			Issues: issues,
+// hasActionableIssues reports whether any issue is an error or warning;
+// the editor ignores info issues.
+func hasActionableIssues(issues []models.Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == "error" || issue.Severity == "warning" {
+			return true
 		}
+	}
+	return false
+}
 
-		editorResult, err := s.editor.Execute(ctx, editorInput)
-		if err != nil {
-			log.Warn().Err(err).Msg("Editor failed, using original text")
-		} else {
-			text = editorResult.Text
-			response.RevisionMade = true
+// finishProse post-processes a stage's prose: it trims a trailing sentence
+// fragment when enabled, with a warning, then applies the paragraph style.
+func (s *Swarm) finishProse(response *models.SceneResponse, stage, text string) string {
+	if s.trimIncomplete {
+		if trimmed, ok := textutil.TrimIncompleteSentence(text); ok {
+			log.Warn().Str("stage", stage).Msg("Trimmed incomplete trailing sentence")
+			addWarning(response, models.WarningSentenceTrimmed, stage,
+				"the prose ended mid-sentence; the fragment was removed")
+			text = trimmed
+		}
+	}
+	if s.paragraphStyle == "" {
+		return text
+	}
+	return textutil.NormalizeParagraphs(text, s.paragraphStyle)
+}
+
+// stageTimedOut reports whether a stage ran out of its own budget while the
+// request itself still had time left.
+func stageTimedOut(parent, stageCtx context.Context) bool {
+	return errors.Is(stageCtx.Err(), context.DeadlineExceeded) && parent.Err() == nil
+}
+
+// sceneTitle generates a title for text when enabled, falling back to a
+// deterministic "Chapter N, Scene M" title.
+func (s *Swarm) sceneTitle(ctx context.Context, req *models.SceneRequest, text string, response *models.SceneResponse) string {
+	if s.titleGeneration.Enabled && strings.TrimSpace(text) != "" && withinBudget(ctx, response, "titler", "title generation", 1) {
+		enterStage(ctx, "titler")
+		title, result, err := s.titler.GenerateTitle(ctx, text, req.Language)
+		if result != nil {
 			response.Stages = append(response.Stages, models.StageInfo{
-				Agent:      "editor",
-				Operation:  "fix_issues",
-				DurationMs: editorResult.DurationMs,
+				Agent:      "titler",
+				Operation:  "generate_title",
+				DurationMs: result.DurationMs,
+				Tokens:     result.PromptTokens + result.CompletionTokens,
 			})
 		}
+		if err == nil {
+			return title
+		}
+		log.Warn().Err(err).Msg("Title generation failed, using fallback title")
+		addWarning(response, models.WarningTitleFallback, "titler", "title generation failed; using a fallback title")
 	}
+	return fallbackTitle(req.Chapter, req.Scene)
+}
 
-	response.Text = text
+// sceneSummary asks the committer for a short summary when enabled and the
+// committer is in full mode, falling back to the spec's narrative summary.
+func (s *Swarm) sceneSummary(ctx context.Context, text, language string, sceneSpec *models.SceneSpec, mode string, response *models.SceneResponse) string {
+	if s.summary.Enabled && mode != CommitModeLite && strings.TrimSpace(text) != "" {
+		enterStage(ctx, "summary")
+		summary, result, err := s.committer.Summarize(ctx, text, s.summary.MaxSentences, language)
+		if result != nil {
+			response.Stages = append(response.Stages, models.StageInfo{
+				Agent:      "committer",
+				Operation:  "summarize",
+				DurationMs: result.DurationMs,
+				Tokens:     result.PromptTokens + result.CompletionTokens,
+			})
+		}
+		if err == nil {
+			return summary
+		}
+		log.Warn().Err(err).Msg("Summary generation failed, using scene spec summary")
+		addWarning(response, models.WarningSummaryFallback, "committer", "summary generation failed; using the scene spec summary")
+	}
+	return limitSentences(sceneSpec.Narrative.Summary, s.summary.MaxSentences)
+}
+
+// normalizeSceneSpec applies deterministic fixes to the director's spec and
+// returns an issue, described from the checker's prompts, for each
+// correction made.
+func normalizeSceneSpec(spec *models.SceneSpec, prompts *PromptTemplate) []models.Issue {
+	var issues []models.Issue
+
+	pov := strings.TrimSpace(spec.Constraints.POVCharacter)
+	if issue := povPresenceIssue(pov, spec.Constraints.CharactersPresent, prompts); issue != nil {
+		log.Warn().
+			Str("pov_character", pov).
+			Strs("characters_present", spec.Constraints.CharactersPresent).
+			Msg("POV character missing from characters_present, adding it")
+		spec.Constraints.CharactersPresent = append(spec.Constraints.CharactersPresent, pov)
+		issue.Severity = "warning"
+		issue.Description = prompts.render("pov_fixed_issue", pov)
+		issues = append(issues, *issue)
+	}
 
-	// Stage 5: Committer (async)
+	return issues
+}
+
+// ValidateSceneSpec returns a message for each required field spec is
+// missing: the objective, at least one key event and the POV character.
+func ValidateSceneSpec(spec *models.SceneSpec) []string {
+	if spec == nil {
+		return []string{"scene spec is missing"}
+	}
+	var violations []string
+	if strings.TrimSpace(spec.Narrative.Objective) == "" {
+		violations = append(violations, "narrative.objective is empty")
+	}
+	hasEvent := false
+	for _, event := range spec.Narrative.KeyEvents {
+		hasEvent = hasEvent || strings.TrimSpace(event) != ""
+	}
+	if !hasEvent {
+		violations = append(violations, "narrative.key_events has no events")
+	}
+	if strings.TrimSpace(spec.Constraints.POVCharacter) == "" {
+		violations = append(violations, "constraints.pov_character is empty")
+	}
+	return violations
+}
+
+// cancelled returns an error once the caller has gone away, so that a
+// disconnected client stops the pipeline instead of each remaining stage
+// failing in turn and the scene being committed anyway. A passed deadline
+// instead ends it with the partial response (see deadlinePassed).
+func cancelled(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("scene generation cancelled: %w", ctx.Err())
+	}
+	return nil
+}
+
+// deadlinePassed reports whether the request deadline has passed, after
+// which GenerateScene returns what it has instead of running more stages.
+func deadlinePassed(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// addWarning records an operational warning on the response.
+func addWarning(response *models.SceneResponse, code, stage, message string) {
+	response.Warnings = append(response.Warnings, models.Warning{
+		Code:    code,
+		Stage:   stage,
+		Message: message,
+	})
+}
+
+// warnIfTruncated adds a truncated warning when a stage hit max_tokens.
+func warnIfTruncated(response *models.SceneResponse, stage string, result *models.GenerationResult) {
+	if result.FinishReason == "length" {
+		addWarning(response, models.WarningTruncated, stage, "output was cut off at the token limit")
+	}
+}
+
+// commitSync runs the committer stage inline and records it on response.
+// A failed commit is reported as a warning; the scene is still returned.
+func (s *Swarm) commitSync(ctx context.Context, response *models.SceneResponse, committerInput *CommitterInput) {
+	enterStage(ctx, "committer")
+	log.Info().Str("stage", "committer").Msg("Updating memory")
+
+	start := time.Now()
+	stage := models.StageInfo{Agent: "committer", Operation: "commit"}
+	if err := s.committer.Commit(ctx, committerInput); err != nil {
+		log.Error().Err(err).Msg("Committer failed")
+		stage.Error = err.Error()
+		addWarning(response, models.WarningCommitterFailed, "committer", "memory was not updated: "+err.Error())
+	}
+	stage.DurationMs = time.Since(start).Milliseconds()
+	response.Stages = append(response.Stages, stage)
+}
+
+// commitAsync runs the committer stage in the background.
+func (s *Swarm) commitAsync(requestID string, committerInput *CommitterInput) {
 	log.Info().Str("stage", "committer").Msg("Updating memory")
 
+	s.commits.Add(1)
 	go func() {
-		committerInput := &CommitterInput{
-			Text:      text,
-			Chapter:   req.Chapter,
-			Scene:     req.Scene,
-			SceneSpec: sceneSpec,
-		}
+		defer s.commits.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error().
+					Str("request_id", requestID).
+					Str("stage", "committer").
+					Interface("panic", r).
+					Bytes("stack", debug.Stack()).
+					Msg("Recovered panic in committer")
+				if s.onPanic != nil {
+					s.onPanic()
+				}
+			}
+		}()
 
 		if err := s.committer.Commit(context.Background(), committerInput); err != nil {
 			log.Error().Err(err).Msg("Committer failed")
 		}
 	}()
+}
 
-	response.TotalDurationMs = time.Since(start).Milliseconds()
+// WaitForCommits blocks until background commits finish or ctx ends,
+// returning ctx's error in the latter case.
+func (s *Swarm) WaitForCommits(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.commits.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	log.Info().
-		Int64("duration_ms", response.TotalDurationMs).
-		Int("issues", len(issues)).
-		Bool("revision", response.RevisionMade).
-		Msg("Scene generation complete")
+// PreviewPrompts returns the prompts the director, writer and checker would
+// be sent for req without calling any provider. The writer prompt is built
+// from spec when given, otherwise from a dry-run spec derived from the
+// request; the checker prompt checks an empty text.
+func (s *Swarm) PreviewPrompts(req *models.SceneRequest, spec *models.SceneSpec) *models.PromptPreview {
+	if spec == nil {
+		spec = draftSceneSpec(req)
+	}
 
-	return response, nil
+	sanitized := s.sanitizer.SanitizeRequest(req)
+	writerInput := &WriterInput{
+		SceneSpec:     spec,
+		WordCount:     req.WordCount,
+		POVCharacter:  req.POVCharacter,
+		Pacing:        req.Pacing,
+		DialogueRatio: req.DialogueRatio,
+		StyleExamples: s.styleExamplesFor(sanitized),
+		Context:       s.retrieveContext(context.Background(), nil, "writer", writerQuery(sanitized, spec)),
+		Language:      req.Language,
+	}
+	directorInput := &DirectorInput{
+		Request: sanitized,
+		Context: s.retrieveContext(context.Background(), nil, "director", directorQuery(sanitized)),
+	}
+	checkerInput := &CheckerInput{
+		POVCharacter:      req.POVCharacter,
+		CharactersPresent: spec.Constraints.CharactersPresent,
+		Characters:        s.characters.Find(append([]string{req.POVCharacter}, spec.Constraints.CharactersPresent...)...),
+		Categories:        s.checkCategories,
+		Language:          req.Language,
+	}
+	if len(req.CheckCategories) > 0 {
+		checkerInput.Categories = req.CheckCategories
+	}
+
+	return &models.PromptPreview{
+		Director: models.AgentPrompt{
+			System: s.director.systemPrompt(req.Language),
+			User:   appendAgentHint(joinPromptParts(s.director.promptParts(directorInput)), sanitized.AgentHints["director"], req.Language),
+		},
+		Writer: models.AgentPrompt{
+			System: s.writer.promptsFor(req.Language).System,
+			User:   appendAgentHint(joinPromptParts(s.writer.promptParts(writerInput, s.writer.provider)), sanitized.AgentHints["writer"], req.Language),
+		},
+		Checker: models.AgentPrompt{
+			System: s.checker.promptsFor(req.Language).System,
+			User:   appendAgentHint(s.checker.checkPrompt(checkerInput, "", selectCheckCategories(checkerInput.Categories)), sanitized.AgentHints["checker"], req.Language),
+		},
+		SceneSpec: spec,
+	}
+}
+
+// draftSceneSpec builds a SceneSpec straight from the request, standing in
+// for the director's output when no model is called.
+func draftSceneSpec(req *models.SceneRequest) *models.SceneSpec {
+	spec := &models.SceneSpec{}
+	spec.Scene.Chapter = req.Chapter
+	spec.Scene.SequenceInChapter = req.Scene
+	spec.Narrative.Objective = req.Intention
+	spec.Narrative.KeyEvents = req.RequiredEvents
+	spec.Constraints.POVCharacter = req.POVCharacter
+	spec.Constraints.CharactersPresent = req.CharactersPresent
+	spec.Constraints.Mood = req.Mood
+	return spec
 }
 
-// ProviderHealth checks each agent provider status.
+// AgentProviderInfo describes the provider an agent routes to. It carries
+// no credentials.
+type AgentProviderInfo struct {
+	// Provider is the configured provider name, or the provider type when
+	// the agent has no routing.
+	Provider string `json:"provider"`
+	// Chain lists the configured providers tried in order, primary first.
+	Chain        []string             `json:"chain,omitempty"`
+	Type         string               `json:"type"`
+	Model        string               `json:"model,omitempty"`
+	Capabilities ProviderCapabilities `json:"capabilities"`
+}
+
+// ProviderInfo describes each agent's provider, by agent name.
+func (s *Swarm) ProviderInfo() map[string]AgentProviderInfo {
+	info := make(map[string]AgentProviderInfo)
+	for _, agent := range s.baseAgents() {
+		if agent.provider == nil {
+			continue
+		}
+		providerType, model := providerModel(agent.provider)
+		entry := AgentProviderInfo{
+			Provider:     agent.provider.Name(),
+			Type:         providerType,
+			Model:        model,
+			Capabilities: agent.provider.Capabilities(),
+		}
+		if len(agent.providerChain) > 0 {
+			entry.Provider = agent.providerChain[0]
+			entry.Chain = append([]string(nil), agent.providerChain...)
+		}
+		info[agent.name] = entry
+	}
+	return info
+}
+
+// ProviderHealth checks each agent provider status, reusing results
+// younger than the health cache TTL.
 func (s *Swarm) ProviderHealth(ctx context.Context) map[string]ProviderHealthStatus {
+	return s.health.get(ctx, s.checkProviders)
+}
+
+func (s *Swarm) checkProviders(ctx context.Context) map[string]ProviderHealthStatus {
 	checks := map[string]ProviderHealthStatus{
 		"director":  checkProvider(ctx, s.director.BaseAgent),
 		"writer":    checkProvider(ctx, s.writer.BaseAgent),
@@ -183,37 +1548,165 @@ func (s *Swarm) ProviderHealth(ctx context.Context) map[string]ProviderHealthSta
 	return checks
 }
 
+// writerTimeoutFor returns the writer call budget for the requested word count:
+// a fixed base plus a per-token allowance for the writer's max_tokens. The
+// result is clamped to the time left on ctx, which carries the overall
+// request deadline set by TimeoutMiddleware, so long scenes can never outlive
+// the request itself.
+func (s *Swarm) writerTimeoutFor(ctx context.Context, wordCount int, language string) time.Duration {
+	timeout := time.Duration(s.writerTimeout.BaseSec)*time.Second +
+		time.Duration(s.writerTimeout.PerTokenMs*s.writer.maxTokensFor(wordCount, language))*time.Millisecond
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
+
 func parseSceneSpec(text string) (*models.SceneSpec, error) {
-	// Try to extract JSON first
-	jsonStr := extractJSON(text)
-	if jsonStr == "" {
-		jsonStr = text
+	// Try the raw text first so top-level arrays survive, then fall back to
+	// extracting JSON from surrounding prose or markdown.
+	jsonStr := strings.TrimSpace(text)
+	if !json.Valid([]byte(jsonStr)) {
+		if extracted := extractJSON(text); extracted != "" {
+			jsonStr = extracted
+		}
 	}
 
-	var spec models.SceneSpec
-	if err := json.Unmarshal([]byte(jsonStr), &spec); err != nil {
+	spec, shape, err := decodeSceneSpec([]byte(jsonStr))
+	if err != nil {
 		return nil, err
 	}
+	if shape != sceneSpecShapeObject {
+		log.Info().Str("shape", shape).Msg("Normalized non-standard SceneSpec shape")
+	}
 
-	return &spec, nil
+	return spec, nil
+}
+
+const (
+	sceneSpecShapeObject  = "object"
+	sceneSpecShapeArray   = "array"
+	sceneSpecShapeWrapped = "wrapped"
+)
+
+var sceneSpecWrapperKeys = []string{"scene_spec", "scenespec", "sceneSpec"}
+
+// decodeSceneSpec accepts a plain SceneSpec object, an array of candidate
+// specs (the most complete one wins) or a {"scene_spec": {...}} wrapper, and
+// reports which shape it found.
+func decodeSceneSpec(raw []byte) (*models.SceneSpec, string, error) {
+	raw = bytes.TrimSpace(raw)
+
+	if len(raw) > 0 && raw[0] == '[' {
+		var candidates []json.RawMessage
+		if err := json.Unmarshal(raw, &candidates); err != nil {
+			return nil, "", err
+		}
+
+		var best *models.SceneSpec
+		bestScore := -1
+		for _, candidate := range candidates {
+			spec, _, err := decodeSceneSpec(candidate)
+			if err != nil {
+				continue
+			}
+			if score := sceneSpecScore(spec); score > bestScore {
+				best = spec
+				bestScore = score
+			}
+		}
+		if best == nil {
+			return nil, "", fmt.Errorf("scenespec array contains no valid object")
+		}
+		return best, sceneSpecShapeArray, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, "", err
+	}
+	if _, hasScene := fields["scene"]; !hasScene {
+		for _, key := range sceneSpecWrapperKeys {
+			if inner, ok := fields[key]; ok {
+				spec, _, err := decodeSceneSpec(inner)
+				if err != nil {
+					return nil, "", err
+				}
+				return spec, sceneSpecShapeWrapped, nil
+			}
+		}
+	}
+
+	var spec models.SceneSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, "", err
+	}
+	return &spec, sceneSpecShapeObject, nil
+}
+
+// sceneSpecScore ranks candidate specs by how many fields the director filled.
+func sceneSpecScore(spec *models.SceneSpec) int {
+	score := len(spec.Narrative.KeyEvents)
+	for _, value := range []string{
+		spec.Scene.Title,
+		spec.Narrative.Objective,
+		spec.Narrative.Summary,
+		spec.Constraints.POVCharacter,
+		spec.Constraints.Location,
+		spec.Constraints.Mood,
+	} {
+		if strings.TrimSpace(value) != "" {
+			score++
+		}
+	}
+	return score
 }
 
 func checkProvider(ctx context.Context, agent *BaseAgent) ProviderHealthStatus {
+	started := time.Now()
 	if agent == nil {
 		return ProviderHealthStatus{
-			Provider: "unknown",
-			Healthy:  false,
-			Error:    "agent is not initialized",
+			Provider:  "unknown",
+			Healthy:   false,
+			Error:     "agent is not initialized",
+			CheckedAt: started,
 		}
 	}
 
 	status := ProviderHealthStatus{
-		Provider: agent.ProviderName(),
-		Healthy:  true,
+		Provider:         agent.ProviderName(),
+		Healthy:          true,
+		CheckedAt:        started,
+		ContextOverflow:  agent.overflow.status(),
+		ModelUnavailable: agent.switchover.state(),
+	}
+	if agent.provider != nil {
+		status.Type, status.Model = providerModel(agent.provider)
+	}
+	if endpoints := endpointHealthOf(ctx, agent.provider); endpoints != nil {
+		if len(endpoints) > 1 {
+			status.Endpoints = endpoints
+		}
+		status.Healthy = false
+		for _, endpoint := range endpoints {
+			status.Healthy = status.Healthy || endpoint.Healthy
+		}
+		if !status.Healthy {
+			status.Error = endpoints[0].Error
+			if len(endpoints) > 1 {
+				status.Error = "all endpoints are unhealthy"
+			}
+		}
+		status.LatencyMs = time.Since(started).Milliseconds()
+		return status
 	}
 	if err := agent.HealthCheck(ctx); err != nil {
 		status.Healthy = false
 		status.Error = err.Error()
 	}
+	status.LatencyMs = time.Since(started).Milliseconds()
 	return status
 }
@@ -0,0 +1,1124 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
+)
+
+func TestWriterTimeoutScalesWithWordCount(t *testing.T) {
+	swarm := NewSwarm(map[string]AgentConfig{}, models.SwarmSection{
+		WriterTimeout: models.ScaledTimeout{BaseSec: 10, PerTokenMs: 5},
+	})
+
+	short := swarm.writerTimeoutFor(context.Background(), 300, "ja")
+	long := swarm.writerTimeoutFor(context.Background(), 5000, "ja")
+	if short != 11800*time.Millisecond {
+		t.Fatalf("expected 11.8s for 300 characters, got %s", short)
+	}
+	if long != 40*time.Second {
+		t.Fatalf("expected 40s for 5000 characters, got %s", long)
+	}
+	if english := swarm.writerTimeoutFor(context.Background(), 5000, "en"); english != 18750*time.Millisecond {
+		t.Fatalf("expected 18.75s for 5000 English characters, got %s", english)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if clamped := swarm.writerTimeoutFor(ctx, 5000, "ja"); clamped > 5*time.Second {
+		t.Fatalf("expected timeout clamped to request deadline, got %s", clamped)
+	}
+}
+
+func TestParseSceneSpecShapes(t *testing.T) {
+	cases := map[string]string{
+		"object":   `{"scene":{"title":"A"},"narrative":{"objective":"obj"}}`,
+		"array":    `[{"scene":{"title":"thin"}},{"scene":{"title":"A"},"narrative":{"objective":"obj"}}]`,
+		"wrapped":  `{"scene_spec":{"scene":{"title":"A"},"narrative":{"objective":"obj"}}}`,
+		"markdown": "```json\n{\"scene\":{\"title\":\"A\"},\"narrative\":{\"objective\":\"obj\"}}\n```",
+	}
+
+	for name, text := range cases {
+		spec, err := parseSceneSpec(text)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if spec.Scene.Title != "A" || spec.Narrative.Objective != "obj" {
+			t.Fatalf("%s: unexpected spec %+v", name, spec)
+		}
+	}
+
+	if _, err := parseSceneSpec(`[1, 2]`); err == nil {
+		t.Fatal("expected error for array without objects")
+	}
+}
+
+func TestValidateSceneSpec(t *testing.T) {
+	spec := &models.SceneSpec{}
+	spec.Narrative.Objective = "obj"
+	spec.Narrative.KeyEvents = []string{" "}
+	if got := ValidateSceneSpec(spec); len(got) != 2 {
+		t.Fatalf("expected missing key_events and pov_character, got %v", got)
+	}
+	spec.Narrative.KeyEvents = []string{"再会"}
+	spec.Constraints.POVCharacter = "葵"
+	if got := ValidateSceneSpec(spec); len(got) != 0 {
+		t.Fatalf("expected a complete spec, got %v", got)
+	}
+}
+
+func TestGenerateSceneFlagsUnparseableSpec(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["director"] = AgentConfig{Provider: NewMockProviderWithConfig(MockConfig{Seed: 1, Responses: []string{"no spec here", "still no spec"}})}
+
+	response, err := NewSwarm(configs, models.SwarmSection{}).GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if director := response.Stages[0]; director.Agent != "director" || director.Error == "" || director.Reprompts != 1 {
+		t.Fatalf("expected the director stage to carry the parse error after one re-prompt, got %+v", director)
+	}
+	if len(response.Warnings) == 0 || response.Warnings[0].Code != models.WarningDirectorFallback {
+		t.Fatalf("expected a director_fallback warning, got %+v", response.Warnings)
+	}
+}
+
+func TestSceneTitleFallsBackWhenDisabled(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := &models.SceneRequest{Chapter: 2, Scene: 3}
+
+	disabled := NewSwarm(configs, models.SwarmSection{})
+	title := disabled.sceneTitle(context.Background(), req, "本文", &models.SceneResponse{})
+	if title != "Chapter 2, Scene 3" {
+		t.Fatalf("expected fallback title, got %q", title)
+	}
+
+	enabled := NewSwarm(configs, models.SwarmSection{
+		TitleGeneration: models.TitleGeneration{Enabled: true},
+	})
+	response := &models.SceneResponse{}
+	title = enabled.sceneTitle(context.Background(), req, "本文", response)
+	if title != "Mock response." {
+		t.Fatalf("expected generated title, got %q", title)
+	}
+	if len(response.Stages) != 1 || response.Stages[0].Agent != "titler" {
+		t.Fatalf("expected titler stage, got %+v", response.Stages)
+	}
+}
+
+func TestPreviewPromptsUsesDryRunSpec(t *testing.T) {
+	swarm := NewSwarm(map[string]AgentConfig{}, models.SwarmSection{})
+	req := &models.SceneRequest{
+		Intention:      "主人公が魔法を発見する",
+		POVCharacter:   "アリス",
+		WordCount:      800,
+		RequiredEvents: []string{"古書を開く"},
+	}
+
+	preview := swarm.PreviewPrompts(req, nil)
+	if !strings.Contains(preview.Director.User, req.Intention) {
+		t.Fatalf("expected director prompt to include intention, got %q", preview.Director.User)
+	}
+	if !strings.Contains(preview.Writer.User, req.Intention) || !strings.Contains(preview.Writer.User, "古書を開く") {
+		t.Fatalf("expected writer prompt built from dry-run spec, got %q", preview.Writer.User)
+	}
+	if preview.Writer.System == "" || preview.Director.System == "" {
+		t.Fatal("expected system prompts to be populated")
+	}
+	if strings.Contains(preview.Writer.User, "テンポ") {
+		t.Fatalf("expected no pacing line when unset, got %q", preview.Writer.User)
+	}
+	if preview.Checker.System == "" || !strings.Contains(preview.Checker.User, "視点違反") {
+		t.Fatalf("expected the checker prompt over every category, got %+v", preview.Checker)
+	}
+
+	req.Pacing = "fast"
+	req.DialogueRatio = "low"
+	preview = swarm.PreviewPrompts(req, nil)
+	if !strings.Contains(preview.Writer.User, "速い（短い文で畳みかける）") || !strings.Contains(preview.Writer.User, "少なめ（地の文中心）") {
+		t.Fatalf("expected pacing and dialogue ratio in writer prompt, got %q", preview.Writer.User)
+	}
+
+	supplied := &models.SceneSpec{}
+	supplied.Narrative.Objective = "supplied objective"
+	preview = swarm.PreviewPrompts(req, supplied)
+	if !strings.Contains(preview.Writer.User, "supplied objective") {
+		t.Fatalf("expected writer prompt built from supplied spec, got %q", preview.Writer.User)
+	}
+}
+
+func TestGenerateSceneReportsWarnings(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["director"] = AgentConfig{Provider: &stubProvider{}}
+	configs["writer"] = AgentConfig{Provider: &stubProvider{finishReason: "length"}}
+	configs["checker"] = AgentConfig{Provider: &stubProvider{text: "[]"}}
+
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{
+		Intention: "再会",
+		Chapter:   1,
+		Scene:     1,
+		WordCount: 100,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	codes := make(map[string]string)
+	for _, warning := range response.Warnings {
+		codes[warning.Code] = warning.Stage
+	}
+	if codes[models.WarningDirectorFallback] != "director" {
+		t.Fatalf("expected director_fallback warning, got %+v", response.Warnings)
+	}
+	if codes[models.WarningTruncated] != "writer" {
+		t.Fatalf("expected truncated writer warning, got %+v", response.Warnings)
+	}
+	if len(response.Issues) != 0 {
+		t.Fatalf("expected operational problems kept out of issues, got %+v", response.Issues)
+	}
+}
+
+func TestSceneSummary(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spec := &models.SceneSpec{Narrative: models.SceneSpecNarrative{
+		Summary: "二人は駅で再会する。彼女は手紙を渡す。雨が止む。",
+	}}
+
+	disabled := NewSwarm(configs, models.SwarmSection{})
+	summary := disabled.sceneSummary(context.Background(), "本文", "", spec, CommitModeFull, &models.SceneResponse{})
+	if summary != "二人は駅で再会する。彼女は手紙を渡す。" {
+		t.Fatalf("expected spec summary limited to two sentences, got %q", summary)
+	}
+
+	enabled := NewSwarm(configs, models.SwarmSection{
+		Summary: models.SummaryGeneration{Enabled: true, MaxSentences: 1},
+	})
+	response := &models.SceneResponse{}
+	summary = enabled.sceneSummary(context.Background(), "本文", "", spec, CommitModeFull, response)
+	if summary != "Mock response." {
+		t.Fatalf("expected generated summary, got %q", summary)
+	}
+	if len(response.Stages) != 1 || response.Stages[0].Operation != "summarize" {
+		t.Fatalf("expected summarize stage, got %+v", response.Stages)
+	}
+}
+
+func TestLimitSentences(t *testing.T) {
+	cases := map[string]string{
+		"":                 "",
+		"One. Two. Three.": "One. Two.",
+		"一文目！二文目？三文目。":     "一文目！二文目？",
+		"no terminator":    "no terminator",
+	}
+	for in, want := range cases {
+		if got := limitSentences(in, 2); got != want {
+			t.Errorf("limitSentences(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// blockingProvider waits for its context to end.
+type blockingProvider struct{ stubProvider }
+
+func (p *blockingProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStageTimeoutBudgets(t *testing.T) {
+	swarm := NewSwarm(map[string]AgentConfig{}, models.SwarmSection{
+		StageTimeouts: map[string]int{"director": 5},
+	})
+
+	if _, ok := swarm.stageTimeout(context.Background(), "checker"); ok {
+		t.Fatal("expected unconfigured stage without deadline to be unbounded")
+	}
+	if budget, ok := swarm.stageTimeout(context.Background(), "director"); !ok || budget != 5*time.Second {
+		t.Fatalf("expected configured director budget, got %s", budget)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if budget, _ := swarm.stageTimeout(ctx, "checker"); budget > 4*time.Second || budget < 3*time.Second {
+		t.Fatalf("expected checker to get a third of the remaining time, got %s", budget)
+	}
+	if budget, _ := swarm.stageTimeout(ctx, "editor"); budget < 9*time.Second {
+		t.Fatalf("expected editor to get the remaining time, got %s", budget)
+	}
+}
+
+// shortDeadlines lets every stage start a provider call however little
+// time is left, for tests that run whole requests under short deadlines.
+var shortDeadlines = map[string]int{"director": 0, "writer": 0, "checker": 0, "editor": 0, "committer": 0}
+
+func TestGenerateSceneSkipsCheckerOnStageTimeout(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["checker"] = AgentConfig{Provider: &blockingProvider{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	swarm := NewSwarm(configs, models.SwarmSection{MinTimeRemainingMs: shortDeadlines})
+	response, err := swarm.GenerateScene(ctx, &models.SceneRequest{Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("expected request to survive checker timeout, got %v", err)
+	}
+
+	var checker *models.StageInfo
+	for i := range response.Stages {
+		if response.Stages[i].Agent == "checker" {
+			checker = &response.Stages[i]
+		}
+	}
+	if checker == nil || checker.SkipReason != "timeout" {
+		t.Fatalf("expected checker stage skipped for timeout, got %+v", response.Stages)
+	}
+	if len(response.Warnings) == 0 || response.Warnings[0].Code != models.WarningStageTimeout {
+		t.Fatalf("expected stage_timeout warning, got %+v", response.Warnings)
+	}
+}
+
+// lateProvider waits for its context to end and returns a little later,
+// once the request deadline has surely passed too.
+type lateProvider struct{ stubProvider }
+
+func (p *lateProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	<-ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	return nil, ctx.Err()
+}
+
+func TestGenerateSceneReturnsPartialOnDeadline(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["writer"] = AgentConfig{Provider: &lateProvider{}}
+	root := t.TempDir()
+	swarm := NewSwarm(configs, models.SwarmSection{MinTimeRemainingMs: shortDeadlines})
+	swarm.SetMemoryStore(NewFileMemoryStore(root))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	response, err := swarm.GenerateScene(ctx, &models.SceneRequest{Intention: "再会", Chapter: 1, Scene: 1, WordCount: 100})
+	if err != nil {
+		t.Fatalf("expected a partial response past the deadline, got %v", err)
+	}
+	if !response.TimedOut || response.SceneSpec == nil {
+		t.Fatalf("expected a timed out response with the director's spec, got %+v", response)
+	}
+	last := response.Stages[len(response.Stages)-1]
+	if last.Agent != "writer" || last.Error == "" {
+		t.Fatalf("expected the failed writer stage last, got %+v", response.Stages)
+	}
+	if !hasWarning(response, models.WarningTimedOut) {
+		t.Fatalf("expected timed_out warning, got %+v", response.Warnings)
+	}
+	if err := swarm.WaitForCommits(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "chapters", "ch1", "scene1.md")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing committed for a timed out request, got %v", err)
+	}
+}
+
+func TestGenerateSceneStopsWhenCancelled(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["checker"] = AgentConfig{Provider: &blockingProvider{}}
+	root := t.TempDir()
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	swarm.SetMemoryStore(NewFileMemoryStore(root))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := swarm.GenerateScene(ctx, &models.SceneRequest{Intention: "再会", Chapter: 1, Scene: 1, WordCount: 100}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled request to stop, got %v", err)
+	}
+	if err := swarm.WaitForCommits(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "chapters", "ch1", "scene1.md")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing committed for a cancelled request, got %v", err)
+	}
+}
+
+func TestGenerateSceneSkipsCheckerShortOnTime(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checker := &countingProvider{}
+	configs["checker"] = AgentConfig{Provider: checker}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	swarm := NewSwarm(configs, models.SwarmSection{
+		MinTimeRemainingMs: map[string]int{"director": 0, "writer": 0, "checker": 60000},
+	})
+	response, err := swarm.GenerateScene(ctx, &models.SceneRequest{Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("expected partial result, got %v", err)
+	}
+	if response.Text == "" {
+		t.Fatal("expected writer prose in the partial result")
+	}
+	if checker.calls != 0 {
+		t.Fatalf("expected no checker provider call, got %d", checker.calls)
+	}
+	for _, stage := range response.Stages {
+		if stage.Agent == "checker" && stage.SkipReason != "insufficient_time" {
+			t.Fatalf("expected checker skipped for insufficient time, got %+v", stage)
+		}
+	}
+}
+
+func TestStageTrackerFollowsPipeline(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, tracker := WithStageTracker(context.Background())
+	if tracker.Current() != "" {
+		t.Fatalf("expected no stage before the pipeline runs, got %q", tracker.Current())
+	}
+
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	if _, err := swarm.GenerateScene(ctx, &models.SceneRequest{Intention: "再会", WordCount: 100}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tracker.Current() != "checker" {
+		t.Fatalf("expected last synchronous stage recorded, got %q", tracker.Current())
+	}
+}
+
+func TestCommitModeFollowsLoad(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	swarm := NewSwarm(configs, models.SwarmSection{
+		CommitterLite: models.CommitterLiteConfig{InFlightThreshold: 4},
+		Summary:       models.SummaryGeneration{Enabled: true},
+	})
+	if mode := swarm.commitMode(); mode != CommitModeFull {
+		t.Fatalf("expected full mode without a load signal, got %s", mode)
+	}
+
+	load := 10
+	swarm.SetLoadSignal(func() int { return load })
+	if mode := swarm.commitMode(); mode != CommitModeLite {
+		t.Fatalf("expected lite mode above threshold, got %s", mode)
+	}
+
+	spec := &models.SceneSpec{Narrative: models.SceneSpecNarrative{Summary: "仕様の要約。"}}
+	response := &models.SceneResponse{}
+	if summary := swarm.sceneSummary(context.Background(), "本文", "", spec, CommitModeLite, response); summary != "仕様の要約。" {
+		t.Fatalf("expected lite mode to skip summary generation, got %q", summary)
+	}
+	if len(response.Stages) != 0 {
+		t.Fatalf("expected no summarize stage in lite mode, got %+v", response.Stages)
+	}
+
+	load = 2
+	if mode := swarm.commitMode(); mode != CommitModeFull {
+		t.Fatalf("expected full mode restored when load drops, got %s", mode)
+	}
+}
+
+func TestGenerateSceneShedsChecksUnderLoad(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	swarm := NewSwarm(configs, models.SwarmSection{
+		LoadShedding: models.LoadSheddingConfig{InFlightThreshold: 4},
+	})
+	load := 10
+	swarm.SetLoadSignal(func() int { return load })
+
+	response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{ID: "shed", Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !response.Degraded || !response.LoadShed || response.Text == "" {
+		t.Fatalf("expected load-shed writer prose, got degraded=%v load_shed=%v text=%q", response.Degraded, response.LoadShed, response.Text)
+	}
+	if !hasWarning(response, models.WarningLoadShed) {
+		t.Fatalf("expected a load_shed warning, got %+v", response.Warnings)
+	}
+	for _, stage := range response.Stages {
+		if stage.Agent == "checker" && stage.SkipReason != "load_shed" {
+			t.Fatalf("expected the checker skipped under load, got %+v", stage)
+		}
+		if stage.Agent == "editor" {
+			t.Fatalf("expected no editor stage under load, got %+v", stage)
+		}
+	}
+	if swarm.LoadShedCount() != 1 {
+		t.Fatalf("expected one shed request counted, got %d", swarm.LoadShedCount())
+	}
+
+	load = 2
+	response, err = swarm.GenerateScene(context.Background(), &models.SceneRequest{ID: "full", Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.Degraded || response.LoadShed || hasWarning(response, models.WarningLoadShed) || swarm.LoadShedCount() != 1 {
+		t.Fatalf("expected checks restored when load drops, got %+v", response)
+	}
+}
+
+func hasWarning(response *models.SceneResponse, code string) bool {
+	for _, warning := range response.Warnings {
+		if warning.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNormalizeSceneSpecAddsPOVToCharactersPresent(t *testing.T) {
+	spec := &models.SceneSpec{Constraints: models.SceneSpecConstraints{
+		POVCharacter:      "葵",
+		CharactersPresent: []string{"蓮", "紗季"},
+	}}
+
+	issues := normalizeSceneSpec(spec, builtinPromptTemplates.For("checker", "ja"))
+	if len(issues) != 1 || issues[0].Category != "pov" {
+		t.Fatalf("expected one pov issue, got %+v", issues)
+	}
+	if got := spec.Constraints.CharactersPresent; len(got) != 3 || got[2] != "葵" {
+		t.Fatalf("expected POV character added, got %v", got)
+	}
+	if issues := normalizeSceneSpec(spec, builtinPromptTemplates.For("checker", "ja")); len(issues) != 0 {
+		t.Fatalf("expected corrected spec to pass, got %+v", issues)
+	}
+
+	unspecified := &models.SceneSpec{Constraints: models.SceneSpecConstraints{POVCharacter: "葵"}}
+	if issues := normalizeSceneSpec(unspecified, builtinPromptTemplates.For("checker", "ja")); len(issues) != 0 || unspecified.Constraints.CharactersPresent != nil {
+		t.Fatalf("expected empty characters_present left alone, got %+v", unspecified.Constraints)
+	}
+}
+
+func TestGenerateSceneNormalizesParagraphs(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["writer"] = AgentConfig{Provider: &stubProvider{text: "夜が明けた。「行こう」\n空は青かった。"}}
+
+	swarm := NewSwarm(configs, models.SwarmSection{
+		Paragraphs: models.ParagraphConfig{Enabled: true, Style: "print"},
+	})
+	response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.Text != "　夜が明けた。\n「行こう」\n　空は青かった。" {
+		t.Fatalf("expected print-style paragraphs, got %q", response.Text)
+	}
+}
+
+func TestGenerateSceneTrimsIncompleteSentence(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["writer"] = AgentConfig{Provider: &stubProvider{text: "夜が明けた。彼は振り返って", finishReason: "length"}}
+	configs["checker"] = AgentConfig{Provider: &stubProvider{text: "[]"}}
+
+	swarm := NewSwarm(configs, models.SwarmSection{TrimIncompleteSentences: true})
+	response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.Text != "夜が明けた。" {
+		t.Fatalf("expected the fragment trimmed, got %q", response.Text)
+	}
+	found := false
+	for _, warning := range response.Warnings {
+		found = found || (warning.Code == models.WarningSentenceTrimmed && warning.Stage == "writer")
+	}
+	if !found {
+		t.Fatalf("expected sentence_trimmed warning, got %+v", response.Warnings)
+	}
+}
+
+func TestGenerateSceneLengthGate(t *testing.T) {
+	const long = "一文目です。二文目です。三文目です。四文目です。"
+	const short = "短い文です。"
+
+	cases := []struct {
+		name      string
+		action    string
+		responses []string
+		wantText  string
+		wantCode  string
+	}{
+		{"acceptable", LengthActionTruncate, []string{short}, short, ""},
+		{"warn", LengthActionWarn, []string{long}, long, models.WarningLengthExceeded},
+		{"truncate", LengthActionTruncate, []string{long}, "一文目です。二文目です。", models.WarningLengthTruncated},
+		{"regenerate", LengthActionRegenerate, []string{long, short}, short, models.WarningLengthRegenerated},
+		{"regenerate still long", LengthActionRegenerate, []string{long, long}, long, models.WarningLengthExceeded},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			configs, err := BuildAgentConfigs(models.ProviderSection{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			writer := NewMockProviderWithConfig(MockConfig{Seed: 1, Responses: tc.responses})
+			configs["writer"] = AgentConfig{Provider: writer}
+			configs["checker"] = AgentConfig{Provider: &stubProvider{text: "[]"}}
+
+			swarm := NewSwarm(configs, models.SwarmSection{
+				LengthGate: models.LengthGateConfig{MaxFactor: 1.5, Action: tc.action},
+			})
+			response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if response.Text != tc.wantText {
+				t.Fatalf("expected %q, got %q", tc.wantText, response.Text)
+			}
+			var codes []string
+			for _, warning := range response.Warnings {
+				if strings.HasPrefix(warning.Code, "length_") {
+					codes = append(codes, warning.Code)
+				}
+			}
+			if tc.wantCode == "" && len(codes) > 0 || tc.wantCode != "" && (len(codes) != 1 || codes[0] != tc.wantCode) {
+				t.Fatalf("expected length warning %q, got %v", tc.wantCode, codes)
+			}
+		})
+	}
+}
+
+func TestGenerateSceneFitsLength(t *testing.T) {
+	const within = "一文目です。二文目です。三文目です。"     // 18 characters
+	const onTarget = "一文目です。二文目です。三文目です。了。" // 20 characters
+	const short = "短い文です。"
+
+	cases := []struct {
+		name      string
+		responses []string
+		wantText  string
+		wantCode  string
+	}{
+		{"within tolerance", []string{within}, within, ""},
+		{"expanded", []string{short, onTarget}, onTarget, models.WarningLengthAdjusted},
+		{"adjustment worse", []string{short, "短い。"}, short, models.WarningLengthOffTarget},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			configs, err := BuildAgentConfigs(models.ProviderSection{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			configs["writer"] = AgentConfig{Provider: NewMockProviderWithConfig(MockConfig{Seed: 1, Responses: tc.responses})}
+			configs["checker"] = AgentConfig{Provider: &stubProvider{text: "[]"}}
+
+			swarm := NewSwarm(configs, models.SwarmSection{
+				LengthGate: models.LengthGateConfig{Tolerance: 0.2},
+			})
+			response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 20})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if response.Text != tc.wantText || response.Chars != textutil.CountChars(tc.wantText) {
+				t.Fatalf("expected %q (%d characters), got %q (%d)", tc.wantText, textutil.CountChars(tc.wantText), response.Text, response.Chars)
+			}
+			if response.Stages[1].Agent != "writer" || response.Stages[1].Chars != response.Chars {
+				t.Fatalf("expected the writer stage to report %d characters, got %+v", response.Chars, response.Stages[1])
+			}
+			var codes []string
+			for _, warning := range response.Warnings {
+				if strings.HasPrefix(warning.Code, "length_") {
+					codes = append(codes, warning.Code)
+				}
+			}
+			if tc.wantCode == "" && len(codes) > 0 || tc.wantCode != "" && (len(codes) != 1 || codes[0] != tc.wantCode) {
+				t.Fatalf("expected length warning %q, got %v", tc.wantCode, codes)
+			}
+		})
+	}
+}
+
+func TestPreviewPromptsIncludesStyleExamples(t *testing.T) {
+	swarm := NewSwarm(map[string]AgentConfig{}, models.SwarmSection{
+		StyleExamples: models.StyleExamplesConfig{Examples: []string{"既定の例文。"}},
+	})
+	req := &models.SceneRequest{Intention: "再会", WordCount: 800}
+
+	preview := swarm.PreviewPrompts(req, nil)
+	if !strings.HasPrefix(preview.Writer.User, "## 文体の参考例") || !strings.Contains(preview.Writer.User, "既定の例文。") {
+		t.Fatalf("expected default examples leading the writer prompt, got %q", preview.Writer.User)
+	}
+
+	req.StyleExamples = []string{"依頼の例文。"}
+	preview = swarm.PreviewPrompts(req, nil)
+	if strings.Contains(preview.Writer.User, "既定の例文。") || !strings.Contains(preview.Writer.User, "<example_1>\n依頼の例文。\n</example_1>") {
+		t.Fatalf("expected request examples to replace the defaults, got %q", preview.Writer.User)
+	}
+}
+
+func TestAgentHintsReachOnlyTheirAgent(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writer := &stubProvider{text: "本文。"}
+	checker := &stubProvider{text: "[]"}
+	configs["writer"] = AgentConfig{Provider: writer}
+	configs["checker"] = AgentConfig{Provider: checker}
+	swarm := NewSwarm(configs, models.SwarmSection{})
+
+	req := &models.SceneRequest{
+		Intention:  "再会",
+		WordCount:  10,
+		AgentHints: map[string]string{"writer": "不穏さを強調する", "narrator": "無視される"},
+	}
+	if _, err := swarm.GenerateScene(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt := writer.lastMessages[1].Content; !strings.HasSuffix(prompt, "追加の指示（このリクエストのみ）:\n不穏さを強調する") {
+		t.Fatalf("expected the writer hint at the end of the prompt, got %q", prompt)
+	}
+	if prompt := checker.lastMessages[1].Content; strings.Contains(prompt, "追加の指示") {
+		t.Fatalf("expected no hint in the checker prompt, got %q", prompt)
+	}
+
+	preview := swarm.PreviewPrompts(req, nil)
+	if !strings.Contains(preview.Writer.User, "不穏さを強調する") || strings.Contains(preview.Director.User, "追加の指示") {
+		t.Fatalf("expected the preview to show the writer hint only, got %+v", preview)
+	}
+
+	req.Language = "en"
+	if _, err := swarm.GenerateScene(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt := writer.lastMessages[1].Content; !strings.HasSuffix(prompt, "Additional instructions (this request only):\n不穏さを強調する") {
+		t.Fatalf("expected an English hint header for an English request, got %q", prompt)
+	}
+}
+
+func TestGenerateSceneRevisesUntilClean(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["writer"] = AgentConfig{Provider: &stubProvider{text: "本文。"}}
+	configs["editor"] = AgentConfig{Provider: &stubProvider{text: "本文。"}}
+	configs["checker"] = AgentConfig{Provider: NewMockProviderWithConfig(MockConfig{
+		Seed: 1,
+		Responses: []string{
+			`[{"category":"fact","severity":"warning","description":"a"}]`,
+			`[{"category":"fact","severity":"warning","description":"b"}]`,
+			`[{"category":"fact","severity":"info","description":"c"}]`,
+		},
+	})}
+	swarm := NewSwarm(configs, models.SwarmSection{MaxRevision: 3})
+
+	resp, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.RevisionMade || resp.Revisions != 2 {
+		t.Fatalf("expected two revisions, got %d", resp.Revisions)
+	}
+	if len(resp.Issues) != 1 || resp.Issues[0].Description != "c" {
+		t.Fatalf("expected the last check's issues, got %+v", resp.Issues)
+	}
+
+	// A checker that keeps flagging the same issue stops the loop.
+	configs["checker"] = AgentConfig{Provider: &stubProvider{text: `[{"category":"fact","severity":"error","description":"a"}]`}}
+	swarm = NewSwarm(configs, models.SwarmSection{MaxRevision: 3})
+	resp, err = swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stalled := false
+	for _, warning := range resp.Warnings {
+		stalled = stalled || warning.Code == models.WarningRevisionStalled
+	}
+	if resp.Revisions != 1 || !stalled {
+		t.Fatalf("expected one revision and a stall warning, got %d, %+v", resp.Revisions, resp.Warnings)
+	}
+
+	swarm = NewSwarm(configs, models.SwarmSection{MaxRevision: -1})
+	resp, err = swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.RevisionMade {
+		t.Fatal("expected no revision with max_revision -1")
+	}
+}
+
+// replyProvider answers each call with reply(messages, params); it is
+// safe for concurrent use when reply is.
+type replyProvider struct {
+	stubProvider
+	reply func(messages []Message, params GenerateParams) string
+}
+
+func (p *replyProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	return &models.GenerationResult{Text: p.reply(messages, params), CompletionTokens: 10}, nil
+}
+
+func TestGenerateSceneSelectsCleanestDraft(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var mu sync.Mutex
+	var temperatures []float64
+	configs["writer"] = AgentConfig{Provider: &replyProvider{reply: func(messages []Message, params GenerateParams) string {
+		mu.Lock()
+		temperatures = append(temperatures, params.Temperature)
+		mu.Unlock()
+		return fmt.Sprintf("温度%.1fの本文。", params.Temperature)
+	}}}
+	// Only the second draft comes back clean.
+	configs["checker"] = AgentConfig{Provider: &replyProvider{reply: func(messages []Message, params GenerateParams) string {
+		if strings.Contains(messages[len(messages)-1].Content, "温度0.9") {
+			return `[{"category":"fact","severity":"warning","description":"w"}]`
+		}
+		return `[{"category":"fact","severity":"error","description":"e"}]`
+	}}}
+	swarm := NewSwarm(configs, models.SwarmSection{MaxRevision: -1})
+
+	resp, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10, DraftCount: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(temperatures) != 3 {
+		t.Fatalf("expected three drafts, got temperatures %v", temperatures)
+	}
+	if resp.Drafts == nil || resp.Drafts.Selected != 1 || len(resp.Drafts.Drafts) != 3 || resp.Drafts.Drafts[0].Errors != 1 {
+		t.Fatalf("expected the second draft selected, got %+v", resp.Drafts)
+	}
+	if resp.Text != "温度0.9の本文。" || len(resp.Issues) != 1 || resp.Issues[0].Severity != "warning" {
+		t.Fatalf("expected the selected draft and its check, got %q, %+v", resp.Text, resp.Issues)
+	}
+	if writer := resp.Stages[1]; writer.Agent != "writer" || writer.Tokens != 30 {
+		t.Fatalf("expected the writer stage to count every draft, got %+v", writer)
+	}
+}
+
+func TestDraftsStayWithinProviderLimits(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var mu sync.Mutex
+	var temperatures []float64
+	writer := &replyProvider{reply: func(messages []Message, params GenerateParams) string {
+		mu.Lock()
+		temperatures = append(temperatures, params.Temperature)
+		mu.Unlock()
+		return "本文。"
+	}}
+	writer.caps = ProviderCapabilities{MaxTemperature: 1}
+	base := 0.95
+	configs["writer"] = AgentConfig{Provider: writer, Temperature: &base}
+	swarm := NewSwarm(configs, models.SwarmSection{MaxRevision: -1, MaxDraftCount: 3})
+
+	resp, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10, DraftCount: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(temperatures) != 3 || resp.Drafts == nil || len(resp.Drafts.Drafts) != 3 {
+		t.Fatalf("expected drafts cut to the configured cap, got temperatures %v", temperatures)
+	}
+	for i, draft := range resp.Drafts.Drafts {
+		if draft.Temperature > 1 || temperatures[i] > 1 {
+			t.Fatalf("expected temperatures within the provider's range, got %v and %+v", temperatures, resp.Drafts.Drafts)
+		}
+	}
+}
+
+func TestStyleExamplesFitTheContextWindow(t *testing.T) {
+	writer := NewWriterAgent(AgentConfig{})
+	long := strings.Repeat("長い例文です。", 200)
+	input := &WriterInput{
+		SceneSpec:     &models.SceneSpec{},
+		WordCount:     100,
+		StyleExamples: []string{"一つ目。", long, "三つ目。", "四つ目。"},
+	}
+
+	// The long second example overflows the budget, so it and everything
+	// after it are dropped; the fourth is past the count cap anyway.
+	roomy := &stubProvider{caps: ProviderCapabilities{CtxLen: 100000}}
+	writer.styleExampleTokens = 200
+	prompt := joinPromptParts(writer.promptParts(input, roomy))
+	if !strings.Contains(prompt, "一つ目。") || strings.Contains(prompt, "長い例文") || strings.Contains(prompt, "三つ目。") {
+		t.Fatalf("expected only the first example to fit, got %q", prompt)
+	}
+
+	// Japanese is budgeted at about a token per character, so 300
+	// characters overflow 200 tokens.
+	medium := &WriterInput{SceneSpec: &models.SceneSpec{}, WordCount: 100, StyleExamples: []string{strings.Repeat("中", 300)}}
+	if prompt := joinPromptParts(writer.promptParts(medium, roomy)); strings.Contains(prompt, "中中中") {
+		t.Fatalf("expected a 300-character example over a 200-token budget dropped, got %q", prompt)
+	}
+
+	// A context window with no room left drops every example.
+	tight := &stubProvider{caps: ProviderCapabilities{CtxLen: 150}}
+	writer.styleExampleTokens = defaultStyleExampleTokens
+	if prompt := joinPromptParts(writer.promptParts(input, tight)); strings.Contains(prompt, "文体の参考例") {
+		t.Fatalf("expected no examples in a full context window, got %q", prompt)
+	}
+}
+
+func TestWriterMaxTokensFollowLanguage(t *testing.T) {
+	for _, tt := range []struct {
+		language string
+		want     int
+	}{{"ja", 1200}, {"en", 350}, {"", 1200}, {"fr", 1200}} {
+		if got := tokensForWordCount(1000, tt.language); got != tt.want {
+			t.Errorf("tokensForWordCount(1000, %q) = %d, want %d", tt.language, got, tt.want)
+		}
+	}
+
+	// The budget never exceeds what the context window leaves.
+	provider := &stubProvider{caps: ProviderCapabilities{CtxLen: 1000}}
+	writer := NewWriterAgent(AgentConfig{Provider: provider})
+	if _, err := writer.Execute(context.Background(), &WriterInput{SceneSpec: &models.SceneSpec{}, WordCount: 1000, Language: "en"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := provider.lastParams.MaxTokens; got <= 0 || got >= 1000 {
+		t.Fatalf("expected max_tokens clamped below the context length, got %d", got)
+	}
+}
+
+func TestAgentParamsOverrideDefaults(t *testing.T) {
+	director := &stubProvider{text: "{}"}
+	committer := &stubProvider{text: "タイトル"}
+	zero := 0.0
+	swarm := NewSwarm(map[string]AgentConfig{
+		"director":  {Provider: director},
+		"committer": {Provider: committer},
+	}, models.SwarmSection{
+		TopP: 0.5,
+		Seed: 7,
+		Agents: map[string]models.AgentParams{
+			"director":  {Temperature: &zero, MaxTokens: 500, TopP: 0.9, Seed: 42},
+			"committer": {MaxTokens: 999},
+			"writer":    {MaxTokens: -1},
+		},
+	})
+
+	_, _ = swarm.director.Execute(context.Background(), &DirectorInput{Request: &models.SceneRequest{Intention: "再会"}})
+	if got := director.lastParams; got.Temperature != 0 || got.MaxTokens != 500 || got.TopP != 0.9 || got.Seed != 42 {
+		t.Fatalf("expected configured director params, got %+v", got)
+	}
+
+	// The titler shares the committer's provider but not its parameters.
+	if _, _, err := swarm.titler.GenerateTitle(context.Background(), "本文", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := committer.lastParams; got.Temperature != 0.3 || got.MaxTokens != 32 || got.TopP != 0.5 || got.Seed != 7 {
+		t.Fatalf("expected default titler params, got %+v", got)
+	}
+	if swarm.writer.maxTokensFor(100, "ja") != 120 {
+		t.Fatalf("expected invalid writer max_tokens to be ignored, got %d", swarm.writer.maxTokensFor(100, "ja"))
+	}
+}
+
+func TestGenerateSceneCommitsSyncOrTracked(t *testing.T) {
+	configs, err := BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := &models.SceneRequest{Intention: "再会", Chapter: 1, Scene: 1, WordCount: 100}
+
+	root := t.TempDir()
+	swarm := NewSwarm(configs, models.SwarmSection{SyncCommit: true})
+	swarm.SetMemoryStore(NewFileMemoryStore(root))
+	response, err := swarm.GenerateScene(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last := response.Stages[len(response.Stages)-1]; last.Agent != "committer" || last.Error != "" {
+		t.Fatalf("expected a successful committer stage, got %+v", response.Stages)
+	}
+	if _, err := os.Stat(filepath.Join(root, "chapters", "ch1", "scene1.md")); err != nil {
+		t.Fatalf("expected the scene saved before responding: %v", err)
+	}
+
+	root = t.TempDir()
+	swarm = NewSwarm(configs, models.SwarmSection{})
+	swarm.SetMemoryStore(NewFileMemoryStore(root))
+	if _, err := swarm.GenerateScene(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := swarm.WaitForCommits(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "chapters", "ch1", "scene1.md")); err != nil {
+		t.Fatalf("expected the background commit to be done: %v", err)
+	}
+}
+
+// flakyHealthProvider fails its health checks with err and counts them.
+type flakyHealthProvider struct {
+	stubProvider
+	err    error
+	checks int
+}
+
+func (p *flakyHealthProvider) HealthCheck(ctx context.Context) error {
+	p.checks++
+	return p.err
+}
+
+func TestProviderHealthCachesResults(t *testing.T) {
+	provider := &flakyHealthProvider{err: errors.New("connection refused")}
+	configs := map[string]AgentConfig{}
+	for _, agent := range []string{"director", "writer", "checker", "editor", "committer"} {
+		configs[agent] = AgentConfig{Provider: provider}
+	}
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	swarm.SetHealthCacheTTL(time.Hour)
+
+	health := swarm.ProviderHealth(context.Background())
+	writer := health["writer"]
+	if writer.Healthy || writer.Type != "stub" || writer.CheckedAt.IsZero() || writer.LastError != "connection refused" {
+		t.Fatalf("expected a failed check with its detail, got %+v", writer)
+	}
+	swarm.ProviderHealth(context.Background())
+	if provider.checks != 5 {
+		t.Fatalf("expected the second probe served from cache, got %d checks", provider.checks)
+	}
+
+	swarm.SetHealthCacheTTL(-1)
+	provider.err = nil
+	swarm.ProviderHealth(context.Background())
+	health = swarm.ProviderHealth(context.Background())
+	if provider.checks != 15 {
+		t.Fatalf("expected every probe to check without a cache, got %d checks", provider.checks)
+	}
+	if writer := health["writer"]; !writer.Healthy || writer.Error != "" || writer.LastError != "connection refused" || writer.LastErrorAt == nil {
+		t.Fatalf("expected a recovered provider keeping its last error, got %+v", writer)
+	}
+}
+
+// ctxHealthProvider fails its health checks only once ctx is done.
+type ctxHealthProvider struct {
+	stubProvider
+}
+
+func (p *ctxHealthProvider) HealthCheck(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func TestProviderHealthIgnoresCallerCancellation(t *testing.T) {
+	configs := map[string]AgentConfig{}
+	for _, agent := range []string{"director", "writer", "checker", "editor", "committer"} {
+		configs[agent] = AgentConfig{Provider: &ctxHealthProvider{}}
+	}
+	swarm := NewSwarm(configs, models.SwarmSection{})
+	swarm.SetHealthCacheTTL(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	swarm.ProviderHealth(ctx)
+	health := swarm.ProviderHealth(context.Background())
+	if writer := health["writer"]; !writer.Healthy || writer.LastError != "" {
+		t.Fatalf("expected a cancelled caller not to cache a failure, got %+v", writer)
+	}
+}
+
+func TestGenerateSceneStopsAtTokenBudget(t *testing.T) {
+	spec := `{"narrative":{"objective":"再会","key_events":["再会"]},"constraints":{"pov_character":"葵"}}`
+	configs := map[string]AgentConfig{}
+	for _, agent := range []string{"director", "writer", "checker", "editor", "committer"} {
+		configs[agent] = AgentConfig{Provider: &replyProvider{reply: func([]Message, GenerateParams) string { return spec }}}
+	}
+	swarm := NewSwarm(configs, models.SwarmSection{MaxTotalTokens: 1000})
+
+	// The director and writer spend the whole budget; the checker and the
+	// committer's LLM work are skipped.
+	response, err := swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10, MaxTotalTokens: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !response.BudgetExceeded || response.TotalTokens != 20 || response.Text == "" {
+		t.Fatalf("expected prose within a spent budget, got exceeded=%v tokens=%d text=%q", response.BudgetExceeded, response.TotalTokens, response.Text)
+	}
+	checker := response.Stages[2]
+	if checker.Agent != "checker" || checker.SkipReason != "budget_exceeded" {
+		t.Fatalf("expected the checker skipped for budget, got %+v", checker)
+	}
+	if response.CommitMode != CommitModeLite {
+		t.Fatalf("expected a lite commit, got %q", response.CommitMode)
+	}
+
+	// Spent by the director alone, the request returns the spec only.
+	response, err = swarm.GenerateScene(context.Background(), &models.SceneRequest{Intention: "再会", WordCount: 10, MaxTotalTokens: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !response.BudgetExceeded || response.Text != "" || response.SceneSpec.Narrative.Objective != "再会" {
+		t.Fatalf("expected the spec alone, got exceeded=%v text=%q spec=%+v", response.BudgetExceeded, response.Text, response.SceneSpec)
+	}
+	if last := response.Stages[len(response.Stages)-1]; last.Agent != "writer" || last.SkipReason != "budget_exceeded" {
+		t.Fatalf("expected the writer skipped for budget, got %+v", last)
+	}
+
+	// The configured cap bounds larger requests.
+	if got := swarm.tokenLimit(&models.SceneRequest{MaxTotalTokens: 5000}); got != 1000 {
+		t.Fatalf("expected the request capped at 1000 tokens, got %d", got)
+	}
+}
+
+func TestAffordableDraftsFollowsTokenBudget(t *testing.T) {
+	// Each 100-character Japanese draft is budgeted 240 tokens.
+	ctx, _ := withTokenBudget(context.Background(), 500)
+	input := &WriterInput{WordCount: 100, Language: "ja"}
+	response := &models.SceneResponse{}
+
+	if got := affordableDrafts(ctx, response, input, 4); got != 2 {
+		t.Fatalf("expected 2 drafts to fit 500 tokens, got %d", got)
+	}
+	if !response.BudgetExceeded || !hasWarning(response, models.WarningBudgetExceeded) {
+		t.Fatalf("expected the dropped drafts warned about, got %+v", response.Warnings)
+	}
+	if got := affordableDrafts(context.Background(), &models.SceneResponse{}, input, 4); got != 4 {
+		t.Fatalf("expected every draft without a budget, got %d", got)
+	}
+}
@@ -0,0 +1,75 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+const (
+	titleSourceMaxRunes = 1500
+	titleMaxRunes       = 40
+)
+
+// TitleAgent derives a short scene title from finished prose
+type TitleAgent struct {
+	*BaseAgent
+}
+
+// NewTitleAgent creates a new title agent
+func NewTitleAgent(config AgentConfig) *TitleAgent {
+	return &TitleAgent{
+		BaseAgent: newConfiguredAgent("titler", config),
+	}
+}
+
+// GenerateTitle asks the provider for a concise title for text, prompting
+// in language.
+func (a *TitleAgent) GenerateTitle(ctx context.Context, text, language string) (string, *models.GenerationResult, error) {
+	prompts := a.promptsFor(language)
+
+	runes := []rune(text)
+	if len(runes) > titleSourceMaxRunes {
+		runes = runes[:titleSourceMaxRunes]
+	}
+	userPrompt := prompts.render("title", string(runes))
+
+	params := GenerateParams{
+		Temperature: a.temperatureOr(0.3),
+		MaxTokens:   a.maxTokensOr(32),
+	}
+
+	result, err := a.Generate(ctx, prompts.System, userPrompt, params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	title := cleanTitle(result.Text)
+	if title == "" {
+		return "", result, fmt.Errorf("titler returned an empty title")
+	}
+	return title, result, nil
+}
+
+func cleanTitle(raw string) string {
+	title := strings.TrimSpace(raw)
+	if idx := strings.IndexAny(title, "\r\n"); idx >= 0 {
+		title = title[:idx]
+	}
+	title = strings.TrimPrefix(title, "タイトル:")
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(strings.TrimSpace(title), "\"'「」『』#* ")
+
+	runes := []rune(title)
+	if len(runes) > titleMaxRunes {
+		title = string(runes[:titleMaxRunes])
+	}
+	return title
+}
+
+// fallbackTitle returns the deterministic title used when none was generated.
+func fallbackTitle(chapter, scene int) string {
+	return fmt.Sprintf("Chapter %d, Scene %d", chapter, scene)
+}
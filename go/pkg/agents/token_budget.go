@@ -0,0 +1,98 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// MaxTotalTokensLimit bounds SceneRequest.MaxTotalTokens.
+const MaxTotalTokensLimit = 1_000_000
+
+// tokenBudget sums the tokens of the generations made during one request
+// against its cap. A limit of zero or less is no cap.
+type tokenBudget struct {
+	limit int
+
+	mu   sync.Mutex
+	used int
+}
+
+type tokenBudgetKey struct{}
+
+func withTokenBudget(ctx context.Context, limit int) (context.Context, *tokenBudget) {
+	budget := &tokenBudget{limit: limit}
+	return context.WithValue(ctx, tokenBudgetKey{}, budget), budget
+}
+
+func tokenBudgetFrom(ctx context.Context) *tokenBudget {
+	budget, _ := ctx.Value(tokenBudgetKey{}).(*tokenBudget)
+	return budget
+}
+
+func recordTokens(ctx context.Context, tokens int) {
+	budget := tokenBudgetFrom(ctx)
+	if budget == nil || tokens <= 0 {
+		return
+	}
+	budget.mu.Lock()
+	budget.used += tokens
+	budget.mu.Unlock()
+}
+
+func (b *tokenBudget) spent() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// allows reports whether a generation expected to cost estimate tokens
+// fits in what is left. A nil budget allows everything.
+func (b *tokenBudget) allows(estimate int) bool {
+	if b == nil || b.limit <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used+estimate <= b.limit
+}
+
+// tokenLimit returns req's total-token cap: its own when set, but never
+// above the configured cap.
+func (s *Swarm) tokenLimit(req *models.SceneRequest) int {
+	switch {
+	case req.MaxTotalTokens <= 0:
+		return s.maxTotalTokens
+	case s.maxTotalTokens > 0 && req.MaxTotalTokens > s.maxTotalTokens:
+		log.Warn().
+			Int("requested", req.MaxTotalTokens).
+			Int("cap", s.maxTotalTokens).
+			Msg("Request token budget above the configured cap, using the cap")
+		return s.maxTotalTokens
+	default:
+		return req.MaxTotalTokens
+	}
+}
+
+// withinBudget reports whether a step of agent's, expected to cost
+// estimate tokens, fits in the request's token budget. When it does not,
+// the response is flagged and warned about, and the caller skips the step.
+func withinBudget(ctx context.Context, response *models.SceneResponse, agent, step string, estimate int) bool {
+	budget := tokenBudgetFrom(ctx)
+	if budget.allows(estimate) {
+		return true
+	}
+	log.Warn().
+		Str("agent", agent).
+		Int("used", budget.spent()).
+		Int("limit", budget.limit).
+		Int("estimate", estimate).
+		Msg("Token budget exhausted, skipping stage")
+	response.BudgetExceeded = true
+	addWarning(response, models.WarningBudgetExceeded, agent,
+		fmt.Sprintf("%s was skipped: %d of the %d-token budget used", step, budget.spent(), budget.limit))
+	return false
+}
@@ -0,0 +1,156 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// WeightedRouterProvider sends each generation to one of several
+// providers, picked at random in proportion to their weights, e.g. to A/B
+// test two models. Unlike a fallback chain it does not try another member
+// when the picked one fails; the result's ServedBy names the member that
+// answered.
+type WeightedRouterProvider struct {
+	names   []string
+	members []Provider
+	weights []float64
+	total   float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewWeightedRouterProvider routes between members, named by their
+// configured provider names, with the given positive weights.
+func NewWeightedRouterProvider(names []string, members []Provider, weights []float64) (*WeightedRouterProvider, error) {
+	if len(members) == 0 {
+		return nil, errors.New("weighted routing has no providers")
+	}
+	if len(names) != len(members) || len(weights) != len(members) {
+		return nil, fmt.Errorf("weighted routing has %d names and %d weights for %d providers", len(names), len(weights), len(members))
+	}
+	total := 0.0
+	for i, weight := range weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("weighted routing gives %s weight %v; weights must be positive", names[i], weight)
+		}
+		total += weight
+	}
+	return &WeightedRouterProvider{
+		names:   append([]string(nil), names...),
+		members: append([]Provider(nil), members...),
+		weights: append([]float64(nil), weights...),
+		total:   total,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// pick returns the index of a member drawn by weight.
+func (p *WeightedRouterProvider) pick() int {
+	p.mu.Lock()
+	r := p.rng.Float64() * p.total
+	p.mu.Unlock()
+	for i, weight := range p.weights {
+		if r < weight {
+			return i
+		}
+		r -= weight
+	}
+	return len(p.members) - 1
+}
+
+// Generate generates with a member picked by weight.
+func (p *WeightedRouterProvider) Generate(ctx context.Context, messages []Message, params GenerateParams) (*models.GenerationResult, error) {
+	return p.route(func(member Provider) (*models.GenerationResult, error) {
+		return member.Generate(ctx, messages, params)
+	})
+}
+
+// GenerateStream streams from a member picked by weight.
+func (p *WeightedRouterProvider) GenerateStream(ctx context.Context, messages []Message, params GenerateParams) (<-chan StreamChunk, error) {
+	i := p.pick()
+	chunks, err := p.members[i].GenerateStream(ctx, messages, params)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.names[i], err)
+	}
+	return chunks, nil
+}
+
+// route calls generate with a member picked by weight and records the
+// member on the result.
+func (p *WeightedRouterProvider) route(generate func(member Provider) (*models.GenerationResult, error)) (*models.GenerationResult, error) {
+	i := p.pick()
+	result, err := generate(p.members[i])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.names[i], err)
+	}
+	result.ServedBy = p.names[i]
+	return result, nil
+}
+
+// Embed embeds with the first member that supports embeddings, not one
+// picked by weight, so every vector comes from the same model.
+func (p *WeightedRouterProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedWithFirst(ctx, p.names, p.members, texts)
+}
+
+// Capabilities returns what every member supports, so a request fits
+// whichever member is picked.
+func (p *WeightedRouterProvider) Capabilities() ProviderCapabilities {
+	return sharedCapabilities(p.members)
+}
+
+// HealthCheck fails while any member is unreachable, since each one takes
+// a share of the calls.
+func (p *WeightedRouterProvider) HealthCheck(ctx context.Context) error {
+	var errs []error
+	for i, member := range p.members {
+		if err := member.HealthCheck(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Name returns the provider name.
+func (p *WeightedRouterProvider) Name() string {
+	return "weighted"
+}
+
+// member returns the member configured under name, or nil.
+func (p *WeightedRouterProvider) member(name string) Provider {
+	for i, candidate := range p.names {
+		if candidate == name {
+			return p.members[i]
+		}
+	}
+	return nil
+}
+
+// mapMembers returns a router with each member replaced by wrap's result.
+func (p *WeightedRouterProvider) mapMembers(wrap func(name string, member Provider) Provider) *WeightedRouterProvider {
+	mapped := &WeightedRouterProvider{
+		names:   p.names,
+		members: make([]Provider, len(p.members)),
+		weights: p.weights,
+		total:   p.total,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for i, member := range p.members {
+		mapped.members[i] = wrap(p.names[i], member)
+	}
+	return mapped
+}
+
+// providerGroup is a provider made of named members, a fallback chain or
+// a weighted router, whose results name the member that served them.
+type providerGroup interface {
+	Provider
+	member(name string) Provider
+}
@@ -0,0 +1,101 @@
+package agents
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestWeightedRouterSplitsCallsByWeight(t *testing.T) {
+	router, err := NewWeightedRouterProvider(
+		[]string{"local_ollama", "openai"},
+		[]Provider{&stubProvider{text: "ollama"}, &stubProvider{text: "openai"}},
+		[]float64{1, 3},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	router.rng = rand.New(rand.NewSource(1))
+
+	agent := NewBaseAgent("writer", router)
+	served := map[string]int{}
+	for i := 0; i < 400; i++ {
+		result, err := agent.Generate(context.Background(), "s", "u", GenerateParams{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Text == "ollama" && result.ServedBy != "local_ollama" || result.Text == "openai" && result.ServedBy != "openai" {
+			t.Fatalf("expected ServedBy to name the member that answered, got %+v", result)
+		}
+		served[result.ServedBy]++
+	}
+	if served["local_ollama"] < 60 || served["local_ollama"] > 140 || served["local_ollama"]+served["openai"] != 400 {
+		t.Fatalf("expected about a quarter of the calls on local_ollama, got %v", served)
+	}
+}
+
+func TestWeightedRouterCapabilitiesIntersect(t *testing.T) {
+	router, err := NewWeightedRouterProvider([]string{"a", "b"}, []Provider{
+		&stubProvider{caps: ProviderCapabilities{CtxLen: 32768, SupportsTools: true, SupportsJSONMode: true, SupportsStreaming: true}},
+		&stubProvider{caps: ProviderCapabilities{CtxLen: 8192, SupportsJSONMode: true}},
+	}, []float64{1, 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	caps := router.Capabilities()
+	if caps.CtxLen != 8192 || caps.SupportsTools || !caps.SupportsJSONMode || caps.SupportsStreaming {
+		t.Fatalf("expected the most conservative capabilities, got %+v", caps)
+	}
+
+	if _, err := NewWeightedRouterProvider([]string{"a"}, []Provider{&stubProvider{}}, []float64{0}); err == nil {
+		t.Fatal("expected a zero weight to be rejected")
+	}
+}
+
+func TestParseProviderWeights(t *testing.T) {
+	names, weights, err := ParseProviderWeights(" ollama=1, openai = 2.5 ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 2 || names[0] != "ollama" || names[1] != "openai" || weights[0] != 1 || weights[1] != 2.5 {
+		t.Fatalf("unexpected weights %v %v", names, weights)
+	}
+
+	for _, raw := range []string{"ollama=1,openai", "ollama=0", "ollama=-1", "ollama=x", "=1", "ollama=1,ollama=2", "ollama=NaN"} {
+		if _, _, err := ParseProviderWeights(raw); err == nil {
+			t.Fatalf("%q: expected error for malformed weights", raw)
+		}
+	}
+}
+
+func TestBuildAgentConfigsWeightedRouting(t *testing.T) {
+	section := models.ProviderSection{
+		Default: "mock",
+		Available: map[string]models.ProviderConfig{
+			"local_ollama": {Type: "ollama", Model: "qwen3"},
+			"other":        {Type: "ollama", Model: "llama3"},
+		},
+		Routing: map[string]string{"writer": "local_ollama=1,other=1", "checker": "local_ollama=1,missing=1"},
+	}
+	configs, err := BuildAgentConfigs(section)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writer := configs["writer"]
+	if _, ok := writer.Provider.(*WeightedRouterProvider); !ok {
+		t.Fatalf("expected a weighted router for the writer, got %T", writer.Provider)
+	}
+	if nextInChain(writer) != "" {
+		t.Fatal("expected weighted members not to act as a fallback chain")
+	}
+	if checker := configs["checker"]; checker.Provider.Name() != "ollama" || len(checker.ProviderChain) != 1 {
+		t.Fatalf("expected the unknown member dropped, got %T %v", checker.Provider, checker.ProviderChain)
+	}
+
+	section.Strict = true
+	if _, err := BuildAgentConfigs(section); err == nil {
+		t.Fatal("expected strict mode to reject an unknown weighted member")
+	}
+}
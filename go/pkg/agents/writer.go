@@ -3,26 +3,75 @@ package agents
 import (
 	"context"
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
+	"github.com/rs/zerolog/log"
 )
 
 // WriterInput represents input for writer
 type WriterInput struct {
-	SceneSpec    *models.SceneSpec
-	WordCount    int
-	POVCharacter string
+	SceneSpec     *models.SceneSpec
+	WordCount     int
+	POVCharacter  string
+	Pacing        string
+	DialogueRatio string
+	// MaxChars, when set, instructs the writer to stay strictly under it.
+	MaxChars int
+	// StyleExamples are few-shot voice examples, most important first.
+	StyleExamples []string
+	// Context is retrieved reference text, already fitted to the budget.
+	Context []string
+	// Language selects the prompt templates; empty means
+	// DefaultPromptLanguage.
+	Language string
+	// Temperature, when set, replaces the agent's temperature.
+	Temperature *float64
+}
+
+const (
+	defaultMaxStyleExamples   = 3
+	defaultStyleExampleTokens = 1500
+	defaultWriterTemperature  = 0.8
+	// defaultAdjustTemperature is a little cooler than drafting, since a
+	// length adjustment should keep the draft's wording where it can.
+	defaultAdjustTemperature = 0.7
+)
+
+// ScenePacings lists the accepted values for SceneRequest.Pacing.
+var ScenePacings = []string{"slow", "medium", "fast"}
+
+// DialogueRatios lists the accepted values for SceneRequest.DialogueRatio.
+var DialogueRatios = []string{"low", "medium", "high"}
+
+// IsValidPacing reports whether p is empty or one of ScenePacings.
+func IsValidPacing(p string) bool {
+	return p == "" || containsString(ScenePacings, p)
+}
+
+// IsValidDialogueRatio reports whether r is empty or one of DialogueRatios.
+func IsValidDialogueRatio(r string) bool {
+	return r == "" || containsString(DialogueRatios, r)
 }
 
 // WriterAgent generates prose
 type WriterAgent struct {
 	*BaseAgent
+
+	// maxStyleExamples and styleExampleTokens cap the few-shot examples
+	// placed in the prompt.
+	maxStyleExamples   int
+	styleExampleTokens int
 }
 
 // NewWriterAgent creates a new writer agent
 func NewWriterAgent(config AgentConfig) *WriterAgent {
 	return &WriterAgent{
-		BaseAgent: NewBaseAgent("writer", config.Provider),
+		BaseAgent:          newConfiguredAgent("writer", config),
+		maxStyleExamples:   defaultMaxStyleExamples,
+		styleExampleTokens: defaultStyleExampleTokens,
 	}
 }
 
@@ -33,50 +82,146 @@ func (a *WriterAgent) Execute(ctx context.Context, input interface{}) (*models.G
 		return nil, fmt.Errorf("invalid input type")
 	}
 
-	systemPrompt := `あなたはプロの小説家です。
-与えられた設計図に従って、小説の本文を書いてください。
+	systemPrompt := a.promptsFor(in.Language).System
+	parts := a.promptParts(in, a.providerFor(ctx))
 
-重要な制約：
-- 本文のみを出力してください
-- メタ的な言及（「この物語では」「読者の皆さん」）は禁止
-- 与えられた文体・世界観に厳密に従う
-- キャラクターの口調・禁則事項を遵守
+	params := GenerateParams{
+		Temperature: a.temperatureOr(defaultWriterTemperature),
+		MaxTokens:   a.maxTokensFor(in.WordCount, in.Language),
+	}
+	if in.Temperature != nil {
+		params.Temperature = *in.Temperature
+	}
 
-自然な小説の文章を出力してください。`
+	return a.GenerateParts(ctx, systemPrompt, parts, params)
+}
 
-	userPrompt := a.buildPrompt(in)
+// AdjustLength rewrites draft, currently length characters long, toward
+// target characters: expanding a short draft, condensing a long one, and
+// keeping its events and voice.
+func (a *WriterAgent) AdjustLength(ctx context.Context, draft string, length, target int, language string) (*models.GenerationResult, error) {
+	prompts := a.promptsFor(language)
+	prompt := prompts.render("adjust_length", struct {
+		Length, Target int
+		Condense       bool
+		Draft          string
+	}{length, target, length > target, strings.TrimSpace(draft)})
 
 	params := GenerateParams{
-		Temperature: 0.8,
-		MaxTokens:   in.WordCount * 2,
+		Temperature: a.temperatureOr(defaultAdjustTemperature),
+		MaxTokens:   a.maxTokensFor(target, language),
 	}
+	return a.GenerateParts(ctx, prompts.System, []PromptPart{{Name: "adjust_length", Text: prompt}}, params)
+}
 
-	return a.Generate(ctx, systemPrompt, userPrompt, params)
+// promptParts builds the user prompt, led by as many style examples as fit
+// provider's context window and the example budget.
+func (a *WriterAgent) promptParts(in *WriterInput, provider Provider) []PromptPart {
+	parts := a.buildPromptParts(in)
+	if len(in.StyleExamples) == 0 {
+		return parts
+	}
+
+	budget := a.styleExampleTokens
+	if provider != nil {
+		if ctxLen := provider.Capabilities().CtxLen; ctxLen > 0 {
+			used := estimateProseTokens(a.promptsFor(in.Language).System, in.Language) + a.maxTokensFor(in.WordCount, in.Language)
+			for _, part := range parts {
+				used += estimateProseTokens(part.Text, in.Language)
+			}
+			budget = min(budget, ctxLen-used)
+		}
+	}
+
+	examples := selectStyleExamples(in.StyleExamples, a.maxStyleExamples, budget, in.Language)
+	if dropped := len(in.StyleExamples) - len(examples); dropped > 0 {
+		log.Warn().
+			Int("dropped", dropped).
+			Int("kept", len(examples)).
+			Int("token_budget", budget).
+			Msg("Dropped style examples that do not fit the prompt budget")
+	}
+	if len(examples) == 0 {
+		return parts
+	}
+	trimmed := make([]string, len(examples))
+	for i, example := range examples {
+		trimmed[i] = strings.TrimSpace(example)
+	}
+	styleExamples := a.promptsFor(in.Language).render("style_examples", trimmed)
+	return append([]PromptPart{{Name: "style_examples", Text: styleExamples}}, parts...)
+}
+
+// selectStyleExamples keeps the leading examples, at most maxExamples,
+// dropping from the end until their estimated size in language fits budget
+// tokens.
+func selectStyleExamples(examples []string, maxExamples, budget int, language string) []string {
+	selected := examples[:min(len(examples), maxExamples)]
+	total := 0
+	for _, example := range selected {
+		total += estimateProseTokens(example, language)
+	}
+	for len(selected) > 0 && total > budget {
+		total -= estimateProseTokens(selected[len(selected)-1], language)
+		selected = selected[:len(selected)-1]
+	}
+	return selected
+}
+
+// wordTokenRatios is the completion tokens budgeted per unit of word count,
+// by prompt language. Word counts are characters in every language: a
+// Japanese character is about one token, an English one about a quarter
+// of a token. Both leave room for the writer to overshoot its target.
+var wordTokenRatios = map[string]float64{
+	"ja": 1.2,
+	"en": 0.35,
+}
+
+// tokenRatio returns the wordTokenRatios entry of language, falling back
+// to DefaultPromptLanguage.
+func tokenRatio(language string) float64 {
+	ratio, ok := wordTokenRatios[language]
+	if !ok {
+		ratio = wordTokenRatios[DefaultPromptLanguage]
+	}
+	return ratio
 }
 
-func (a *WriterAgent) buildPrompt(input *WriterInput) string {
-	ss := input.SceneSpec
+// tokensForWordCount returns the completion budget requested for a scene
+// of wordCount in language, falling back to DefaultPromptLanguage.
+func tokensForWordCount(wordCount int, language string) int {
+	return int(math.Ceil(float64(wordCount) * tokenRatio(language)))
+}
 
-	prompt := fmt.Sprintf(`## Scene Design
-目的: %s
-概要: %s
-必須の出来事: %v
-雰囲気: %s
-場所: %s
+// charsForTokens is the inverse of tokensForWordCount: how many characters
+// of prose in language fit in tokens.
+func charsForTokens(tokens int, language string) int {
+	return int(float64(tokens) / tokenRatio(language))
+}
 
-## Requirements
-- 視点: %s
-- 目標文字数: %d文字程度
+// estimateProseTokens estimates the tokens of prose text in language from
+// its character count, like a scene's word count. Unlike
+// estimateTokensFromText it does not undercount Japanese, at about a token
+// per character.
+func estimateProseTokens(text, language string) int {
+	return tokensForWordCount(textutil.CountChars(text), language)
+}
 
-上記の設計に従って、シーンの本文を書いてください。`,
-		ss.Narrative.Objective,
-		ss.Narrative.Summary,
-		ss.Narrative.KeyEvents,
-		ss.Constraints.Mood,
-		ss.Constraints.Location,
-		input.POVCharacter,
-		input.WordCount,
-	)
+// maxTokensFor returns the configured max tokens, else the budget for a
+// scene of wordCount in language. Generate clamps it to what the
+// provider's context window leaves after the prompt.
+func (a *WriterAgent) maxTokensFor(wordCount int, language string) int {
+	return a.maxTokensOr(tokensForWordCount(wordCount, language))
+}
 
-	return prompt
+func (a *WriterAgent) buildPromptParts(input *WriterInput) []PromptPart {
+	prompts := a.promptsFor(input.Language)
+	parts := []PromptPart{
+		{Name: "scenespec", Text: prompts.render("scenespec", input.SceneSpec)},
+		{Name: "requirements", Text: prompts.render("requirements", input)},
+	}
+	if len(input.Context) > 0 {
+		parts = append([]PromptPart{{Name: "context", Text: prompts.render("context", input.Context)}}, parts...)
+	}
+	return parts
 }
@@ -2,24 +2,64 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/novelist/novelist/pkg/agents"
+	"github.com/novelist/novelist/pkg/apierr"
 	"github.com/novelist/novelist/pkg/models"
 	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	maxMetadataEntries     = 16
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 256
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	maxCharacterImport = 500
+	// maxCharacterNameLength bounds pov_character and characters_present.
+	maxCharacterNameLength = 100
+
+	maxStyleExamples      = 10
+	maxStyleExampleLength = 2000
+
+	defaultMaxRequiredEventsChars = 2000
 )
 
 // Handler represents API handlers
 type Handler struct {
-	swarm  *agents.Swarm
+	// swarm is swapped by SwapSwarm on config reload; each request uses
+	// the swarm current when it started.
+	swarm  atomic.Pointer[agents.Swarm]
 	logger *zerolog.Logger
 	stats  *StatsStore
+	scenes *SceneStore
+	// repository, when set, persists every stored scene.
+	repository SceneRepository
+
+	maxRequiredEventsChars int
+	defaultSchemaVersion   string
+	adminToken             string
+	presets                map[string]models.ScenePreset
+	health                 *HealthPolicy
+	streams                *StreamReplayBuffer
+	jobs                   *JobQueue
 }
 
 // NewHandler creates a new handler
@@ -27,127 +67,709 @@ func NewHandler(swarm *agents.Swarm, logger *zerolog.Logger, stats *StatsStore)
 	if stats == nil {
 		stats = NewStatsStore()
 	}
-	return &Handler{
-		swarm:  swarm,
+	h := &Handler{
 		logger: logger,
 		stats:  stats,
+		scenes: NewSceneStore(defaultSceneStoreCap),
+
+		maxRequiredEventsChars: defaultMaxRequiredEventsChars,
+		defaultSchemaVersion:   defaultSchemaVersion,
+		health:                 NewHealthPolicy(models.HealthConfig{}),
+		streams:                NewStreamReplayBuffer(0, 0),
+	}
+	h.swarm.Store(swarm)
+	return h.WithJobQueue(NewJobQueue(0, 0, 0, 0))
+}
+
+// Swarm returns the swarm new requests are served by.
+func (h *Handler) Swarm() *agents.Swarm {
+	return h.swarm.Load()
+}
+
+// SwapSwarm makes new requests use swarm and returns the previous one.
+// Requests already started, including queued jobs, finish on the swarm
+// they started with.
+func (h *Handler) SwapSwarm(swarm *agents.Swarm) *agents.Swarm {
+	return h.swarm.Swap(swarm)
+}
+
+// WithMaxRequiredEventsChars sets the combined character limit across all
+// required events. Non-positive values keep the default.
+func (h *Handler) WithMaxRequiredEventsChars(n int) *Handler {
+	if n > 0 {
+		h.maxRequiredEventsChars = n
+	}
+	return h
+}
+
+// WithDefaultSchemaVersion sets the scene response version served to clients
+// that send no Accept-Version header. Unknown versions keep the default.
+func (h *Handler) WithDefaultSchemaVersion(version string) *Handler {
+	if normalized, ok := normalizeSchemaVersion(version); ok {
+		h.defaultSchemaVersion = normalized
+	}
+	return h
+}
+
+// WithAdminToken enables admin-only request features, such as
+// X-Provider-Override, for callers sending the token in X-Admin-Token. An
+// empty token leaves them disabled.
+func (h *Handler) WithAdminToken(token string) *Handler {
+	h.adminToken = token
+	return h
+}
+
+// WithHealthPolicy sets how provider health is weighted into the /health
+// status and /ready.
+func (h *Handler) WithHealthPolicy(config models.HealthConfig) *Handler {
+	h.health = NewHealthPolicy(config)
+	return h
+}
+
+// WithUniqueSceneNumbers rejects generating a chapter and scene number
+// that a stored scene already holds, unless the request passes
+// ?overwrite=true.
+func (h *Handler) WithUniqueSceneNumbers(unique bool) *Handler {
+	h.scenes.SetUniqueSceneNumbers(unique)
+	return h
+}
+
+// WithSceneRepository persists generated scenes to repository, which
+// then serves scenes generated before a restart. Scenes the in-memory
+// store replaces or evicts are deleted from it.
+func (h *Handler) WithSceneRepository(repository SceneRepository) *Handler {
+	h.repository = repository
+	return h
+}
+
+// WithJobQueue sets the queue that runs asynchronous scene jobs.
+func (h *Handler) WithJobQueue(queue *JobQueue) *Handler {
+	queue.run = h.runRecovered
+	h.jobs = queue
+	return h
+}
+
+// isAdmin reports whether the request carries the admin token.
+func (h *Handler) isAdmin(c *gin.Context) bool {
+	if h.adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(h.adminToken)) == 1
+}
+
+// sceneCall is a validated scene generation request, ready to run.
+type sceneCall struct {
+	req           models.SceneRequest
+	ctx           context.Context
+	swarm         *agents.Swarm
+	schemaVersion string
+	overwrite     bool
+}
+
+// prepareScene binds and validates a scene request and applies its
+// headers. It writes the error response and returns false on failure.
+func (h *Handler) prepareScene(c *gin.Context) (*sceneCall, bool) {
+	call := &sceneCall{swarm: h.Swarm()}
+	req := &call.req
+	if err := c.ShouldBindJSON(req); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return nil, false
+	}
+
+	if err := h.applyPreset(req); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return nil, false
+	}
+	if err := validateSceneRequest(req, h.maxRequiredEventsChars); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return nil, false
+	}
+
+	schemaVersion, err := negotiateSchemaVersion(c, h.defaultSchemaVersion)
+	if err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.UnsupportedVersion, err))
+		return nil, false
+	}
+	call.schemaVersion = schemaVersion
+
+	applySceneDefaults(c, req)
+
+	call.overwrite, _ = strconv.ParseBool(c.Query("overwrite"))
+	if !call.overwrite {
+		if err := h.scenes.CheckSlot(req.Chapter, req.Scene, req.ID); err != nil {
+			apierr.RespondError(c, apierr.Wrap(apierr.SceneConflict, err))
+			return nil, false
+		}
+	}
+
+	h.logger.Info().
+		Str("request_id", req.ID).
+		Int("chapter", req.Chapter).
+		Int("scene", req.Scene).
+		Msg("Generating scene")
+
+	call.ctx = c.Request.Context()
+	if bypassCache(c) {
+		call.ctx = agents.WithCacheBypass(call.ctx)
+	}
+	if raw := c.GetHeader("X-Provider-Override"); raw != "" {
+		if !h.isAdmin(c) {
+			apierr.RespondError(c, apierr.New(apierr.Forbidden, "X-Provider-Override requires a valid X-Admin-Token"))
+			return nil, false
+		}
+		overrides, err := agents.ParseProviderOverrides(raw)
+		if err == nil {
+			call.ctx, err = call.swarm.WithProviderOverrides(call.ctx, overrides)
+		}
+		if err != nil {
+			apierr.RespondError(c, apierr.Wrap(apierr.InvalidProviderOverride, err))
+			return nil, false
+		}
+		h.logger.Info().
+			Str("request_id", req.ID).
+			Str("provider_override", raw).
+			Msg("Applying provider override")
+	}
+	return call, true
+}
+
+// run generates the scene and stores it. A scene cut short by the
+// deadline, or without prose because the token budget ran out, is
+// returned but not stored, leaving its slot free for a retry.
+func (h *Handler) run(call *sceneCall) (*models.SceneResponse, error) {
+	resp, err := call.swarm.GenerateScene(call.ctx, &call.req)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Scene generation failed")
+		return nil, err
+	}
+	if resp.TimedOut || resp.Text == "" {
+		return resp, nil
+	}
+	scene, removed, err := h.scenes.Save(&call.req, resp, call.overwrite)
+	if err != nil {
+		return nil, apierr.Wrap(apierr.SceneConflict, err)
+	}
+	h.persist(scene)
+	h.unpersist(removed)
+	return resp, nil
+}
+
+// runRecovered runs call like run, turning a panic into an internal_error
+// after logging and counting it. Generations off the handler goroutine use
+// it, since RecoveryMiddleware does not cover them.
+func (h *Handler) runRecovered(call *sceneCall) (resp *models.SceneResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Error().
+				Str("request_id", call.req.ID).
+				Interface("panic", r).
+				Bytes("stack", debug.Stack()).
+				Msg("Recovered panic in scene generation")
+			h.stats.RecordPanic()
+			resp, err = nil, apierr.New(apierr.InternalError, "internal server error")
+		}
+	}()
+	return h.run(call)
+}
+
+// persist saves scene to the repository, if any. Failures are logged: the
+// scene was generated and is still served from memory.
+func (h *Handler) persist(scene *StoredScene) {
+	if h.repository == nil {
+		return
+	}
+	if err := h.repository.Save(context.Background(), scene); err != nil {
+		h.logger.Error().Err(err).Str("scene_id", scene.ID).Msg("Failed to persist scene")
+	}
+}
+
+// unpersist deletes scenes the store replaced or evicted from the
+// repository, if any, so it holds the same scenes as memory. Failures are
+// logged.
+func (h *Handler) unpersist(ids []string) {
+	if h.repository == nil {
+		return
+	}
+	for _, id := range ids {
+		if err := h.repository.Delete(context.Background(), id); err != nil {
+			h.logger.Error().Err(err).Str("scene_id", id).Msg("Failed to delete persisted scene")
+		}
+	}
+}
+
+// GetScene returns a generated scene's response by its request ID.
+func (h *Handler) GetScene(c *gin.Context) {
+	schemaVersion, err := negotiateSchemaVersion(c, h.defaultSchemaVersion)
+	if err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.UnsupportedVersion, err))
+		return
+	}
+
+	id := c.Param("id")
+	scene, ok := h.scenes.Get(id)
+	if !ok && h.repository != nil {
+		scene, err = h.repository.Get(c.Request.Context(), id)
+		if err != nil && !errors.Is(err, ErrSceneNotFound) {
+			apierr.RespondError(c, err)
+			return
+		}
+		ok = err == nil
+	}
+	if !ok || scene.Response == nil {
+		apierr.RespondError(c, apierr.New(apierr.NotFound, "scene not found"))
+		return
+	}
+
+	c.Header("Content-Version", schemaVersion)
+	renderJSON(c, http.StatusOK, encodeSceneResponse(scene.Response, schemaVersion))
+}
+
+// ListChapterScenes summarizes a chapter's generated scenes in scene
+// order.
+func (h *Handler) ListChapterScenes(c *gin.Context) {
+	chapter, err := strconv.Atoi(c.Param("n"))
+	if err != nil || chapter <= 0 {
+		apierr.RespondError(c, apierr.New(apierr.InvalidRequest, "chapter must be a positive integer"))
+		return
 	}
+
+	var scenes []SceneSummary
+	if h.repository != nil {
+		scenes, err = h.repository.ListByChapter(c.Request.Context(), chapter)
+		if err != nil {
+			apierr.RespondError(c, err)
+			return
+		}
+	} else {
+		scenes = h.scenes.ListByChapter(chapter)
+	}
+	renderJSON(c, http.StatusOK, gin.H{
+		"chapter": chapter,
+		"total":   len(scenes),
+		"scenes":  scenes,
+	})
 }
 
 // GenerateScene handles scene generation requests
 func (h *Handler) GenerateScene(c *gin.Context) {
-	var req models.SceneRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		statusCode := http.StatusBadRequest
-		errorCode := "invalid_request"
-		if strings.Contains(err.Error(), "http: request body too large") {
-			statusCode = http.StatusRequestEntityTooLarge
-			errorCode = "payload_too_large"
+	call, ok := h.prepareScene(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.run(call)
+	if err != nil {
+		if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+			err = apierr.Wrap(apierr.RequestTimeout, err)
 		}
-		c.JSON(statusCode, gin.H{
-			"error": err.Error(),
-			"code":  errorCode,
-		})
+		apierr.RespondError(c, err)
 		return
 	}
 
-	if err := validateSceneRequest(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  "invalid_request",
-		})
+	// A scene cut short by the request deadline is still returned, as
+	// partial content.
+	status := http.StatusOK
+	if resp.TimedOut {
+		status = http.StatusPartialContent
+	}
+	c.Header("Content-Version", call.schemaVersion)
+	renderJSON(c, status, encodeSceneResponse(resp, call.schemaVersion))
+}
+
+// GenerateSceneStream generates a scene like GenerateScene, streaming the
+// writer's draft as server-sent "token" events before a final "result"
+// (the full scene response) or "error" event. The draft may differ from
+// the final text when later stages revise it.
+//
+// Events are buffered per request ID, so a client that reconnects with
+// the same X-Request-ID and a Last-Event-ID resumes the stream instead of
+// starting a new generation.
+func (h *Handler) GenerateSceneStream(c *gin.Context) {
+	streamID := c.GetHeader("X-Request-ID")
+	if lastID, ok := parseLastEventID(c.GetHeader("Last-Event-ID")); ok && streamID != "" && h.streams.Has(streamID) {
+		h.logger.Info().
+			Str("request_id", streamID).
+			Int("last_event_id", lastID).
+			Msg("Resuming scene stream")
+		h.writeStream(c, streamID, lastID, nil)
 		return
 	}
 
-	// Generate ID if not provided
-	if req.ID == "" {
-		if fromCtx, ok := c.Get("request_id"); ok {
-			if requestID, ok := fromCtx.(string); ok && requestID != "" {
-				req.ID = requestID
+	call, ok := h.prepareScene(c)
+	if !ok {
+		return
+	}
+	streamID = call.req.ID
+	if !h.streams.Open(streamID) {
+		apierr.RespondError(c, apierr.New(apierr.SceneConflict, "a stream for request "+streamID+" already exists; resume it with Last-Event-ID"))
+		return
+	}
+
+	// Keep generating if the client drops so it can resume; the request
+	// deadline still applies.
+	ctx := context.WithoutCancel(call.ctx)
+	cancel := context.CancelFunc(func() {})
+	if deadline, ok := call.ctx.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	call.ctx = agents.WithStreamSink(ctx, "writer", func(text string) {
+		h.streams.Append(streamID, streamEventToken, eventData(gin.H{"text": text}))
+	})
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer cancel()
+		defer h.streams.Finish(streamID)
+		resp, err := h.runRecovered(call)
+		if err != nil {
+			if errors.Is(call.ctx.Err(), context.DeadlineExceeded) {
+				err = apierr.Wrap(apierr.RequestTimeout, err)
 			}
+			h.streams.Append(streamID, streamEventError, eventData(gin.H{
+				"error":      err.Error(),
+				"code":       apierr.CodeOf(err),
+				"request_id": streamID,
+			}))
+			return
 		}
-		if req.ID == "" {
-			req.ID = uuid.New().String()
+		h.streams.Append(streamID, streamEventResult, eventData(encodeSceneResponse(resp, call.schemaVersion)))
+	}()
+
+	c.Header("Content-Version", call.schemaVersion)
+	h.writeStream(c, streamID, 0, finished)
+}
+
+// SubmitJob validates a scene request like GenerateScene and queues it,
+// answering 202 with the job to poll at GET /api/v1/jobs/{id}. The job ID
+// is the request ID.
+func (h *Handler) SubmitJob(c *gin.Context) {
+	call, ok := h.prepareScene(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.jobs.submit(call)
+	switch {
+	case errors.Is(err, errJobQueueFull):
+		setRetryAfter(c, h.jobs.retryAfter)
+		apierr.RespondError(c, apierr.Wrap(apierr.TooManyRequests, err))
+		return
+	case errors.Is(err, errJobExists):
+		apierr.RespondError(c, apierr.Wrap(apierr.SceneConflict, err))
+		return
+	}
+
+	c.Header("Location", "/api/v1/jobs/"+job.ID)
+	renderJSON(c, http.StatusAccepted, job)
+}
+
+// GetJob returns a job's status, with the scene response once done.
+func (h *Handler) GetJob(c *gin.Context) {
+	job, ok := h.jobs.Get(c.Param("id"))
+	if !ok {
+		apierr.RespondError(c, apierr.New(apierr.NotFound, "job not found or expired"))
+		return
+	}
+	renderJSON(c, http.StatusOK, job)
+}
+
+// PreviewPrompts returns the effective director and writer prompts for a
+// request without calling any model
+func (h *Handler) PreviewPrompts(c *gin.Context) {
+	preview, ok := h.previewPrompts(c)
+	if !ok {
+		return
+	}
+	renderJSON(c, http.StatusOK, preview)
+}
+
+// PreviewScene is PreviewPrompts with the prompts as flat keys, such as
+// director_system and writer_user.
+func (h *Handler) PreviewScene(c *gin.Context) {
+	preview, ok := h.previewPrompts(c)
+	if !ok {
+		return
+	}
+	renderJSON(c, http.StatusOK, &models.ScenePreview{
+		DirectorSystem: preview.Director.System,
+		DirectorUser:   preview.Director.User,
+		WriterSystem:   preview.Writer.System,
+		WriterUser:     preview.Writer.User,
+		CheckerSystem:  preview.Checker.System,
+		CheckerUser:    preview.Checker.User,
+		SceneSpec:      preview.SceneSpec,
+	})
+}
+
+// previewPrompts builds the prompt preview for the request in c. It writes
+// the error response and returns false on failure.
+func (h *Handler) previewPrompts(c *gin.Context) (*models.PromptPreview, bool) {
+	var req models.PromptPreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return nil, false
+	}
+
+	if err := h.applyPreset(&req.SceneRequest); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return nil, false
+	}
+	if err := validateSceneRequest(&req.SceneRequest, h.maxRequiredEventsChars); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return nil, false
+	}
+	applySceneDefaults(c, &req.SceneRequest)
+
+	return h.Swarm().PreviewPrompts(&req.SceneRequest, req.SceneSpec), true
+}
+
+// ValidateScene runs only the checker over submitted prose and returns
+// its issues.
+func (h *Handler) ValidateScene(c *gin.Context) {
+	var req models.ValidateSceneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return
+	}
+	if err := validateValidateSceneRequest(&req); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return
+	}
+
+	resp, err := h.Swarm().ValidateScene(c.Request.Context(), c.GetString("request_id"), &req)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Scene validation failed")
+		if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+			err = apierr.Wrap(apierr.RequestTimeout, err)
 		}
+		apierr.RespondError(c, err)
+		return
 	}
+	renderJSON(c, http.StatusOK, resp)
+}
 
-	// Set defaults
-	if req.Chapter == 0 {
-		req.Chapter = 1
+// RenumberScenesRequest selects the chapter to resequence.
+type RenumberScenesRequest struct {
+	Chapter int `json:"chapter"`
+}
+
+// RenumberScenes resequences a chapter's stored scenes as 1..n, keeping
+// their order and breaking ties by creation time. Admin only.
+func (h *Handler) RenumberScenes(c *gin.Context) {
+	if !h.isAdmin(c) {
+		apierr.RespondError(c, apierr.New(apierr.Forbidden, "renumbering scenes requires a valid X-Admin-Token"))
+		return
 	}
-	if req.Scene == 0 {
-		req.Scene = 1
+	var req RenumberScenesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return
 	}
-	if req.WordCount == 0 {
-		req.WordCount = 1000
+	if req.Chapter <= 0 {
+		apierr.RespondError(c, apierr.New(apierr.InvalidRequest, "chapter must be a positive integer"))
+		return
 	}
 
+	moved := h.scenes.Renumber(req.Chapter)
+	for _, renumbered := range moved {
+		if scene, ok := h.scenes.Get(renumbered.ID); ok {
+			h.persist(scene)
+		}
+	}
 	h.logger.Info().
-		Str("request_id", req.ID).
 		Int("chapter", req.Chapter).
-		Int("scene", req.Scene).
-		Msg("Generating scene")
+		Int("moved", len(moved)).
+		Msg("Renumbered scenes")
+	renderJSON(c, http.StatusOK, gin.H{
+		"chapter":    req.Chapter,
+		"renumbered": moved,
+	})
+}
+
+// CharacterImportResult reports the outcome for one imported character.
+type CharacterImportResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
 
-	// Generate
-	resp, err := h.swarm.GenerateScene(c, &req)
+// ImportCharacters handles bulk character import from a JSON or YAML array.
+// Each entry is validated and stored independently so one bad entry does not
+// abort the import; ?overwrite=true replaces existing characters.
+func (h *Handler) ImportCharacters(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Scene generation failed")
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return
+	}
+
+	entries, err := decodeCharacterEntries(c.ContentType(), body)
+	if err != nil {
+		apierr.RespondError(c, apierr.Wrap(apierr.InvalidRequest, err))
+		return
+	}
 
-		statusCode := http.StatusInternalServerError
-		errorCode := "generation_failed"
-		if errors.Is(err, context.DeadlineExceeded) || errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
-			statusCode = http.StatusRequestTimeout
-			errorCode = "request_timeout"
+	overwrite, _ := strconv.ParseBool(c.Query("overwrite"))
+	store := h.Swarm().Characters()
+
+	results := make([]CharacterImportResult, 0, len(entries))
+	imported := 0
+	for i, entry := range entries {
+		result := CharacterImportResult{Index: i}
+		character, err := entry()
+		if err == nil {
+			result.ID = character.ID
+			var replaced bool
+			replaced, err = store.Put(character, overwrite)
+			if err == nil {
+				result.Status = "created"
+				if replaced {
+					result.Status = "updated"
+				}
+				imported++
+			}
+		}
+		if err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
 		}
-		c.JSON(statusCode, gin.H{
-			"error": err.Error(),
-			"code":  errorCode,
-		})
+		results = append(results, result)
+	}
+
+	renderJSON(c, http.StatusOK, gin.H{
+		"imported": imported,
+		"failed":   len(entries) - imported,
+		"results":  results,
+	})
+}
+
+// decodeCharacterEntries splits a JSON or YAML array into per-entry decoders
+// so malformed entries fail individually.
+func decodeCharacterEntries(contentType string, body []byte) ([]func() (models.Character, error), error) {
+	var entries []func() (models.Character, error)
+
+	switch contentType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		var nodes []yaml.Node
+		if err := yaml.Unmarshal(body, &nodes); err != nil {
+			return nil, fmt.Errorf("body must be a YAML array of characters: %w", err)
+		}
+		for i := range nodes {
+			node := nodes[i]
+			entries = append(entries, func() (models.Character, error) {
+				var character models.Character
+				err := node.Decode(&character)
+				return character, err
+			})
+		}
+	default:
+		var raws []json.RawMessage
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, fmt.Errorf("body must be a JSON array of characters: %w", err)
+		}
+		for i := range raws {
+			raw := raws[i]
+			entries = append(entries, func() (models.Character, error) {
+				var character models.Character
+				err := json.Unmarshal(raw, &character)
+				return character, err
+			})
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("no characters to import")
+	}
+	if len(entries) > maxCharacterImport {
+		return nil, fmt.Errorf("at most %d characters can be imported at once", maxCharacterImport)
+	}
+	return entries, nil
+}
+
+// SearchScenes handles full-text search over generated scenes
+func (h *Handler) SearchScenes(c *gin.Context) {
+	query := SceneSearchQuery{
+		Text:     strings.TrimSpace(c.Query("q")),
+		Metadata: c.QueryMap("metadata"),
+		Limit:    defaultSearchLimit,
+	}
+	if query.Text == "" {
+		apierr.RespondError(c, apierr.New(apierr.InvalidRequest, "q is required"))
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	if raw := c.Query("chapter"); raw != "" {
+		chapter, err := strconv.Atoi(raw)
+		if err != nil || chapter <= 0 {
+			apierr.RespondError(c, apierr.New(apierr.InvalidRequest, "chapter must be a positive integer"))
+			return
+		}
+		query.Chapter = chapter
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxSearchLimit {
+			apierr.RespondError(c, apierr.New(apierr.InvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit)))
+			return
+		}
+		query.Limit = limit
+	}
+
+	results := h.scenes.Search(query)
+	renderJSON(c, http.StatusOK, gin.H{
+		"query":   query.Text,
+		"total":   len(results),
+		"results": results,
+	})
 }
 
-// Health handles health check
+// Health handles health check. Status weighs each agent's provider by
+// the health policy, so an optional provider being down only degrades it.
+// Provider checks are reused for the swarm's health cache TTL.
 func (h *Handler) Health(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
 	defer cancel()
 
-	dependencies := h.swarm.ProviderHealth(ctx)
-	healthy := true
-	for _, dep := range dependencies {
-		if !dep.Healthy {
-			healthy = false
-			break
-		}
-	}
-
-	status := "healthy"
-	if !healthy {
-		status = "degraded"
+	swarm := h.Swarm()
+	dependencies := swarm.ProviderHealth(ctx)
+	report := h.health.Evaluate(dependencies)
+	degraded := swarm.Degraded()
+	status := report.Status
+	if degraded && status == HealthStatusHealthy {
+		status = HealthStatusDegraded
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	body := gin.H{
 		"status":       status,
+		"score":        report.Score,
 		"version":      "2.0.0",
+		"degraded":     degraded,
 		"dependencies": dependencies,
-	})
+	}
+	if len(report.CriticalFailures) > 0 {
+		body["critical_failures"] = report.CriticalFailures
+	}
+	if len(report.OptionalFailures) > 0 {
+		body["optional_failures"] = report.OptionalFailures
+	}
+	if len(report.ModelUnavailable) > 0 {
+		body["model_unavailable"] = report.ModelUnavailable
+	}
+	c.JSON(http.StatusOK, body)
 }
 
-// Ready handles readiness check.
+// Ready handles readiness check. The service stays ready while only
+// optional providers are down.
 func (h *Handler) Ready(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
 	defer cancel()
 
-	dependencies := h.swarm.ProviderHealth(ctx)
-	ready := true
-	for _, dep := range dependencies {
-		if !dep.Healthy {
-			ready = false
-			break
-		}
-	}
+	dependencies := h.Swarm().ProviderHealth(ctx)
+	report := h.health.Evaluate(dependencies)
+	ready := report.Status != HealthStatusUnhealthy
 
 	statusCode := http.StatusOK
 	status := "ready"
@@ -159,16 +781,79 @@ func (h *Handler) Ready(c *gin.Context) {
 	c.JSON(statusCode, gin.H{
 		"status":       status,
 		"ready":        ready,
+		"health":       report.Status,
+		"score":        report.Score,
 		"dependencies": dependencies,
 	})
 }
 
+// Providers lists each agent's provider with its model and capabilities,
+// and the provider types that can be configured.
+func (h *Handler) Providers(c *gin.Context) {
+	renderJSON(c, http.StatusOK, gin.H{
+		"agents": h.Swarm().ProviderInfo(),
+		"types":  agents.RegisteredProviderTypes(),
+	})
+}
+
 // Stats handles stats request
 func (h *Handler) Stats(c *gin.Context) {
-	c.JSON(http.StatusOK, h.stats.Snapshot())
+	renderJSON(c, http.StatusOK, h.stats.Snapshot())
+}
+
+// bypassCache reports whether the client asked to skip the response cache
+// with "Cache-Control: no-cache".
+func bypassCache(c *gin.Context) bool {
+	for _, directive := range strings.Split(c.GetHeader("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
 }
 
-func validateSceneRequest(req *models.SceneRequest) error {
+// renderJSON writes obj as compact JSON, or indented JSON when the client
+// asks for ?pretty=1. Field order follows the struct definitions and map keys
+// are sorted, so both forms are stable.
+func renderJSON(c *gin.Context, status int, obj interface{}) {
+	switch strings.ToLower(c.Query("pretty")) {
+	case "1", "true", "yes":
+		c.IndentedJSON(status, obj)
+	default:
+		c.JSON(status, obj)
+	}
+}
+
+// applySceneDefaults fills in the request ID and default scene parameters,
+// and caps the priority at what the caller's API key allows.
+func applySceneDefaults(c *gin.Context, req *models.SceneRequest) {
+	req.Priority = capPriority(c, req.Priority)
+
+	// Generate ID if not provided
+	if req.ID == "" {
+		if fromCtx, ok := c.Get("request_id"); ok {
+			if requestID, ok := fromCtx.(string); ok && requestID != "" {
+				req.ID = requestID
+			}
+		}
+		if req.ID == "" {
+			req.ID = uuid.New().String()
+		}
+	}
+
+	// Set defaults
+	if req.Chapter == 0 {
+		req.Chapter = 1
+	}
+	if req.Scene == 0 {
+		req.Scene = 1
+	}
+	if req.WordCount == 0 {
+		req.WordCount = 1000
+	}
+}
+
+func validateSceneRequest(req *models.SceneRequest, maxRequiredEventsChars int) error {
 	req.Intention = strings.TrimSpace(req.Intention)
 	req.POVCharacter = strings.TrimSpace(req.POVCharacter)
 	req.Mood = strings.TrimSpace(req.Mood)
@@ -176,6 +861,9 @@ func validateSceneRequest(req *models.SceneRequest) error {
 	if req.Intention == "" {
 		return errors.New("intention is required")
 	}
+	if utf8.RuneCountInString(req.POVCharacter) > maxCharacterNameLength {
+		return fmt.Errorf("pov_character must be %d characters or less", maxCharacterNameLength)
+	}
 	if utf8.RuneCountInString(req.Intention) > 4000 {
 		return errors.New("intention must be 4000 characters or less")
 	}
@@ -197,10 +885,134 @@ func validateSceneRequest(req *models.SceneRequest) error {
 	if len(req.RequiredEvents) > 20 {
 		return errors.New("required_events must be 20 items or less")
 	}
+	totalEventChars := 0
 	for _, event := range req.RequiredEvents {
-		if utf8.RuneCountInString(strings.TrimSpace(event)) > 300 {
+		eventChars := utf8.RuneCountInString(strings.TrimSpace(event))
+		if eventChars > 300 {
 			return errors.New("each required_event must be 300 characters or less")
 		}
+		totalEventChars += eventChars
+	}
+	if totalEventChars > maxRequiredEventsChars {
+		return fmt.Errorf("required_events must total %d characters or less (got %d)", maxRequiredEventsChars, totalEventChars)
+	}
+
+	if len(req.CharactersPresent) > 20 {
+		return errors.New("characters_present must be 20 items or less")
+	}
+	for i, name := range req.CharactersPresent {
+		req.CharactersPresent[i] = strings.TrimSpace(name)
+		if req.CharactersPresent[i] == "" {
+			return errors.New("characters_present entries must not be empty")
+		}
+		if utf8.RuneCountInString(req.CharactersPresent[i]) > maxCharacterNameLength {
+			return fmt.Errorf("each characters_present entry must be %d characters or less", maxCharacterNameLength)
+		}
+	}
+
+	req.Priority = strings.ToLower(strings.TrimSpace(req.Priority))
+	if !agents.IsValidPriority(req.Priority) {
+		return fmt.Errorf("priority must be one of %s", strings.Join(agents.ScenePriorities, ", "))
+	}
+
+	req.Pacing = strings.ToLower(strings.TrimSpace(req.Pacing))
+	if !agents.IsValidPacing(req.Pacing) {
+		return fmt.Errorf("pacing must be one of %s", strings.Join(agents.ScenePacings, ", "))
+	}
+	req.DialogueRatio = strings.ToLower(strings.TrimSpace(req.DialogueRatio))
+	if !agents.IsValidDialogueRatio(req.DialogueRatio) {
+		return fmt.Errorf("dialogue_ratio must be one of %s", strings.Join(agents.DialogueRatios, ", "))
+	}
+	req.Language = strings.ToLower(strings.TrimSpace(req.Language))
+	if !agents.IsValidPromptLanguage(req.Language) {
+		return fmt.Errorf("language must be one of %s", strings.Join(agents.PromptLanguages, ", "))
+	}
+	if req.DraftCount < 0 || req.DraftCount > agents.MaxDraftCount {
+		return fmt.Errorf("draft_count must be between 0 and %d", agents.MaxDraftCount)
+	}
+	if req.MaxTotalTokens < 0 || req.MaxTotalTokens > agents.MaxTotalTokensLimit {
+		return fmt.Errorf("max_total_tokens must be between 0 and %d", agents.MaxTotalTokensLimit)
+	}
+
+	if len(req.StyleExamples) > maxStyleExamples {
+		return fmt.Errorf("style_examples must be %d items or less", maxStyleExamples)
+	}
+	for i, example := range req.StyleExamples {
+		req.StyleExamples[i] = strings.TrimSpace(example)
+		if req.StyleExamples[i] == "" {
+			return errors.New("style_examples entries must not be empty")
+		}
+		if utf8.RuneCountInString(req.StyleExamples[i]) > maxStyleExampleLength {
+			return fmt.Errorf("each style_examples entry must be %d characters or less", maxStyleExampleLength)
+		}
+	}
+
+	for agent, hint := range req.AgentHints {
+		hint = strings.TrimSpace(hint)
+		if hint == "" || !agents.IsHintAgent(agent) {
+			// Hints for agents that do not read them are dropped rather
+			// than rejected.
+			delete(req.AgentHints, agent)
+			continue
+		}
+		if utf8.RuneCountInString(hint) > agents.MaxAgentHintLength {
+			return fmt.Errorf("agent_hints.%s must be %d characters or less", agent, agents.MaxAgentHintLength)
+		}
+		req.AgentHints[agent] = hint
+	}
+
+	for i, category := range req.CheckCategories {
+		req.CheckCategories[i] = strings.ToLower(strings.TrimSpace(category))
+		if !agents.IsValidCheckCategory(req.CheckCategories[i]) {
+			return fmt.Errorf("check_categories entries must be one of %s", strings.Join(agents.CheckCategories, ", "))
+		}
+	}
+
+	if len(req.Metadata) > maxMetadataEntries {
+		return fmt.Errorf("metadata must have %d entries or less", maxMetadataEntries)
+	}
+	for key, value := range req.Metadata {
+		if strings.TrimSpace(key) == "" {
+			return errors.New("metadata keys must not be empty")
+		}
+		if utf8.RuneCountInString(key) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata keys must be %d characters or less", maxMetadataKeyLength)
+		}
+		if utf8.RuneCountInString(value) > maxMetadataValueLength {
+			return fmt.Errorf("metadata values must be %d characters or less", maxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+func validateValidateSceneRequest(req *models.ValidateSceneRequest) error {
+	req.Text = strings.TrimSpace(req.Text)
+	req.POVCharacter = strings.TrimSpace(req.POVCharacter)
+
+	if req.Text == "" {
+		return errors.New("text is required")
+	}
+	if utf8.RuneCountInString(req.Text) > agents.CheckerMaxTextChars {
+		return fmt.Errorf("text must be %d characters or less", agents.CheckerMaxTextChars)
+	}
+	if utf8.RuneCountInString(req.POVCharacter) > maxCharacterNameLength {
+		return fmt.Errorf("pov_character must be %d characters or less", maxCharacterNameLength)
+	}
+	if req.Chapter < 0 {
+		return errors.New("chapter must be positive")
+	}
+	if req.Scene < 0 {
+		return errors.New("scene must be positive")
+	}
+	for i, category := range req.CheckCategories {
+		req.CheckCategories[i] = strings.ToLower(strings.TrimSpace(category))
+		if !agents.IsValidCheckCategory(req.CheckCategories[i]) {
+			return fmt.Errorf("check_categories entries must be one of %s", strings.Join(agents.CheckCategories, ", "))
+		}
+	}
+	req.Language = strings.ToLower(strings.TrimSpace(req.Language))
+	if !agents.IsValidPromptLanguage(req.Language) {
+		return fmt.Errorf("language must be one of %s", strings.Join(agents.PromptLanguages, ", "))
 	}
 	return nil
 }
@@ -1,10 +1,20 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/novelist/novelist/pkg/agents"
 	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog"
 )
 
 func TestValidateSceneRequest(t *testing.T) {
@@ -12,22 +22,771 @@ func TestValidateSceneRequest(t *testing.T) {
 		Intention: "Hero discovers hidden magic.",
 		WordCount: 1200,
 	}
-	if err := validateSceneRequest(valid); err != nil {
+	if err := validateSceneRequest(valid, defaultMaxRequiredEventsChars); err != nil {
 		t.Fatalf("expected valid request, got error: %v", err)
 	}
 
 	tooLong := &models.SceneRequest{
 		Intention: strings.Repeat("x", 4001),
 	}
-	if err := validateSceneRequest(tooLong); err == nil {
+	if err := validateSceneRequest(tooLong, defaultMaxRequiredEventsChars); err == nil {
 		t.Fatal("expected error for overly long intention")
 	}
 
+	english := &models.SceneRequest{Intention: "test", Language: " EN "}
+	if err := validateSceneRequest(english, defaultMaxRequiredEventsChars); err != nil || english.Language != "en" {
+		t.Fatalf("expected en normalized and accepted, got %q (err=%v)", english.Language, err)
+	}
+	if err := validateSceneRequest(&models.SceneRequest{Intention: "test", Language: "fr"}, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for a language without prompt templates")
+	}
+	if err := validateSceneRequest(&models.SceneRequest{Intention: "test", DraftCount: 5}, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for too many drafts")
+	}
+	if err := validateSceneRequest(&models.SceneRequest{Intention: "test", MaxTotalTokens: -1}, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected a negative max_total_tokens to be rejected")
+	}
+
 	tooManyEvents := &models.SceneRequest{
 		Intention:      "test",
 		RequiredEvents: make([]string, 21),
 	}
-	if err := validateSceneRequest(tooManyEvents); err == nil {
+	if err := validateSceneRequest(tooManyEvents, defaultMaxRequiredEventsChars); err == nil {
 		t.Fatal("expected error for too many required events")
 	}
+
+	tooMuchMetadata := &models.SceneRequest{
+		Intention: "test",
+		Metadata:  map[string]string{},
+	}
+	for i := 0; i <= maxMetadataEntries; i++ {
+		tooMuchMetadata.Metadata[strings.Repeat("k", i+1)] = "v"
+	}
+	manyMediumEvents := &models.SceneRequest{
+		Intention:      "test",
+		RequiredEvents: []string{strings.Repeat("x", 250), strings.Repeat("y", 250), strings.Repeat("z", 250)},
+	}
+	if err := validateSceneRequest(manyMediumEvents, defaultMaxRequiredEventsChars); err != nil {
+		t.Fatalf("expected events within the total limit, got %v", err)
+	}
+	err := validateSceneRequest(manyMediumEvents, 700)
+	if err == nil || !strings.Contains(err.Error(), "700") {
+		t.Fatalf("expected total length error naming the limit, got %v", err)
+	}
+
+	categories := &models.SceneRequest{Intention: "test", CheckCategories: []string{" POV ", "fact"}}
+	if err := validateSceneRequest(categories, defaultMaxRequiredEventsChars); err != nil || categories.CheckCategories[0] != "pov" {
+		t.Fatalf("expected normalized check categories, got %v, %v", categories.CheckCategories, err)
+	}
+	styled := &models.SceneRequest{Intention: "test", Pacing: " Fast ", DialogueRatio: "high"}
+	if err := validateSceneRequest(styled, defaultMaxRequiredEventsChars); err != nil || styled.Pacing != "fast" {
+		t.Fatalf("expected normalized pacing, got %q, %v", styled.Pacing, err)
+	}
+	styled.DialogueRatio = "all"
+	if err := validateSceneRequest(styled, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for unknown dialogue_ratio")
+	}
+
+	examples := &models.SceneRequest{Intention: "test", StyleExamples: []string{"  雨が降っていた。  ", ""}}
+	if err := validateSceneRequest(examples, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for an empty style example")
+	}
+	examples.StyleExamples = []string{strings.Repeat("あ", maxStyleExampleLength+1)}
+	if err := validateSceneRequest(examples, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for an overly long style example")
+	}
+
+	hinted := &models.SceneRequest{Intention: "test", AgentHints: map[string]string{"writer": " 不穏に ", "narrator": "x", "editor": " "}}
+	if err := validateSceneRequest(hinted, defaultMaxRequiredEventsChars); err != nil || len(hinted.AgentHints) != 1 || hinted.AgentHints["writer"] != "不穏に" {
+		t.Fatalf("expected only the trimmed writer hint to remain, got %v, %v", hinted.AgentHints, err)
+	}
+	hinted.AgentHints["writer"] = strings.Repeat("あ", agents.MaxAgentHintLength+1)
+	if err := validateSceneRequest(hinted, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for an overly long agent hint")
+	}
+
+	categories.CheckCategories = []string{"style"}
+	if err := validateSceneRequest(categories, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for unknown check category")
+	}
+
+	if err := validateSceneRequest(tooMuchMetadata, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for too many metadata entries")
+	}
+
+	longMetadataValue := &models.SceneRequest{
+		Intention: "test",
+		Metadata:  map[string]string{"author_id": strings.Repeat("x", maxMetadataValueLength+1)},
+	}
+	if err := validateSceneRequest(longMetadataValue, defaultMaxRequiredEventsChars); err == nil {
+		t.Fatal("expected error for overly long metadata value")
+	}
+}
+
+func TestRenderJSONPretty(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	render := func(target string) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+		renderJSON(c, http.StatusOK, gin.H{"b": 1, "a": 2})
+		return w.Body.String()
+	}
+
+	if compact := render("/"); compact != `{"a":2,"b":1}` {
+		t.Fatalf("expected compact sorted JSON, got %q", compact)
+	}
+	if pretty := render("/?pretty=1"); pretty != "{\n    \"a\": 2,\n    \"b\": 1\n}" {
+		t.Fatalf("expected indented sorted JSON, got %q", pretty)
+	}
+}
+
+func TestDecodeCharacterEntries(t *testing.T) {
+	jsonBody := []byte(`[{"id":"a","name":{"full":"A"}},{"id":5}]`)
+	entries, err := decodeCharacterEntries("application/json", jsonBody)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if character, err := entries[0](); err != nil || character.ID != "a" {
+		t.Fatalf("entry 0: %+v, %v", character, err)
+	}
+	if _, err := entries[1](); err == nil {
+		t.Fatalf("expected entry 1 to fail decoding")
+	}
+
+	yamlBody := []byte("- id: b\n  name:\n    full: B\n")
+	entries, err = decodeCharacterEntries("application/yaml", yamlBody)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("yaml decode: %d entries, %v", len(entries), err)
+	}
+	if character, err := entries[0](); err != nil || character.Name.Full != "B" {
+		t.Fatalf("yaml entry: %+v, %v", character, err)
+	}
+
+	if _, err := decodeCharacterEntries("application/json", []byte(`{"id":"a"}`)); err == nil {
+		t.Fatalf("expected non-array body to fail")
+	}
+}
+
+func TestEncodeSceneResponseVersions(t *testing.T) {
+	resp := &models.SceneResponse{
+		RequestID: "r1",
+		SceneSpec: &models.SceneSpec{},
+		Text:      "prose",
+	}
+
+	v1, err := json.Marshal(encodeSceneResponse(resp, SchemaV1))
+	if err != nil {
+		t.Fatalf("marshal v1: %v", err)
+	}
+	if !strings.Contains(string(v1), `"scenespec":`) || strings.Contains(string(v1), `"scene_spec"`) {
+		t.Fatalf("v1 should use scenespec: %s", v1)
+	}
+	if !strings.Contains(string(v1), `"schema_version":"1"`) {
+		t.Fatalf("v1 missing schema_version: %s", v1)
+	}
+
+	v2, err := json.Marshal(encodeSceneResponse(resp, SchemaV2))
+	if err != nil {
+		t.Fatalf("marshal v2: %v", err)
+	}
+	if !strings.Contains(string(v2), `"scene_spec":`) || strings.Contains(string(v2), `"scenespec"`) {
+		t.Fatalf("v2 should use scene_spec: %s", v2)
+	}
+	if !strings.Contains(string(v2), `"schema_version":"2"`) || !strings.Contains(string(v2), `"text":"prose"`) {
+		t.Fatalf("v2 missing fields: %s", v2)
+	}
+	if resp.SchemaVersion != "" {
+		t.Fatalf("encoding should not mutate the response")
+	}
+}
+
+func TestNegotiateSchemaVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	negotiate := func(header string) (string, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		if header != "" {
+			c.Request.Header.Set("Accept-Version", header)
+		}
+		return negotiateSchemaVersion(c, SchemaV1)
+	}
+
+	for header, want := range map[string]string{"": SchemaV1, "2": SchemaV2, "v2": SchemaV2, "V1": SchemaV1} {
+		if got, err := negotiate(header); err != nil || got != want {
+			t.Fatalf("Accept-Version %q: got %q, %v; want %q", header, got, err, want)
+		}
+	}
+	if _, err := negotiate("3"); err == nil {
+		t.Fatal("expected error for unknown version")
+	}
+}
+
+func TestGenerateSceneProviderOverrideRequiresAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	swarm := agents.NewSwarm(configs, models.SwarmSection{})
+	swarm.SetProviderCatalog(agents.NewProviderCatalog(models.ProviderSection{
+		Available: map[string]models.ProviderConfig{"alt": {Type: "mock"}},
+	}))
+	logger := zerolog.Nop()
+	handler := NewHandler(swarm, &logger, nil).WithAdminToken("secret")
+
+	generate := func(override, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes", strings.NewReader(`{"intention":"再会","word_count":100}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Header.Set("X-Provider-Override", override)
+		if token != "" {
+			c.Request.Header.Set("X-Admin-Token", token)
+		}
+		handler.GenerateScene(c)
+		return w
+	}
+
+	if w := generate("writer=alt", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without admin token, got %d", w.Code)
+	}
+	if w := generate("writer=alt", "wrong"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 with a wrong token, got %d", w.Code)
+	}
+	if w := generate("writer=nope", "secret"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown provider, got %d", w.Code)
+	}
+	w := generate("writer=alt", "secret")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"override":true`) {
+		t.Fatalf("expected the override recorded in stages, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPreviewSceneReturnsFlatPrompts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := zerolog.Nop()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes/preview", strings.NewReader(`{"intention":"再会"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler.PreviewScene(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"director_system", "director_user", "writer_system", "writer_user", "checker_system", "checker_user"} {
+		if text, _ := body[key].(string); text == "" {
+			t.Errorf("expected a non-empty %s, got %v", key, body[key])
+		}
+	}
+	if _, nested := body["director"]; nested {
+		t.Fatalf("expected no nested prompts, got %v", body)
+	}
+	if user, _ := body["director_user"].(string); !strings.Contains(user, "再会") {
+		t.Fatalf("expected the intention in director_user, got %q", user)
+	}
+}
+
+func TestValidateScene(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["checker"] = agents.AgentConfig{Provider: agents.NewMockProviderWithConfig(agents.MockConfig{
+		Seed:      1,
+		Responses: []string{`[{"category":"pov","severity":"error","description":"視点が揺れている"}]`},
+	})}
+	logger := zerolog.Nop()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil)
+
+	validate := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes/validate", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler.ValidateScene(c)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"text":"  "}`,
+		`{"text":"` + strings.Repeat("あ", agents.CheckerMaxTextChars+1) + `"}`,
+		`{"text":"本文。","chapter":-1}`,
+		`{"text":"本文。","check_categories":["style"]}`,
+		`{"text":"本文。","pov_character":"` + strings.Repeat("名", maxCharacterNameLength+1) + `"}`,
+	} {
+		if w := validate(body); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %.40s, got %d", body, w.Code)
+		}
+	}
+
+	w := validate(`{"text":"彼女は振り返った。","chapter":1,"scene":2,"pov_character":"アリス"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var resp models.ValidationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON body: %v", err)
+	}
+	if !resp.Checked || len(resp.Issues) != 1 || resp.Issues[0].Category != "pov" {
+		t.Fatalf("expected the checker's issue, got %+v", resp)
+	}
+	if len(resp.Stages) != 1 || resp.Stages[0].Agent != "checker" {
+		t.Fatalf("expected only the checker stage, got %+v", resp.Stages)
+	}
+}
+
+func TestApplyPreset(t *testing.T) {
+	logger := zerolog.Nop()
+	handler := NewHandler(nil, &logger, nil).WithPresets(map[string]models.ScenePreset{
+		"Action": {Mood: "tense", Pacing: "Fast", WordCount: 800, DialogueRatio: "low"},
+		"broken": {Pacing: "breakneck"},
+	})
+
+	req := &models.SceneRequest{Intention: "追跡", Preset: " ACTION ", Mood: "hopeful"}
+	if err := handler.applyPreset(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Mood != "hopeful" || req.Pacing != "fast" || req.WordCount != 800 || req.DialogueRatio != "low" {
+		t.Fatalf("expected preset defaults under explicit fields, got %+v", req)
+	}
+
+	err := handler.applyPreset(&models.SceneRequest{Preset: "broken"})
+	if err == nil || !strings.Contains(err.Error(), "available: action") {
+		t.Fatalf("expected invalid presets to be dropped and unknown names rejected, got %v", err)
+	}
+}
+
+func TestGenerateSceneRejectsDuplicateSceneNumber(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := zerolog.Nop()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil).
+		WithUniqueSceneNumbers(true).
+		WithAdminToken("secret")
+
+	generate := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes"+query, strings.NewReader(`{"intention":"再会","chapter":1,"scene":3,"word_count":100}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler.GenerateScene(c)
+		return w
+	}
+
+	if w := generate(""); w.Code != http.StatusOK {
+		t.Fatalf("expected the first scene stored, got %d %s", w.Code, w.Body.String())
+	}
+	w := generate("")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"scene_conflict"`) {
+		t.Fatalf("expected 409 scene_conflict, got %d %s", w.Code, w.Body.String())
+	}
+	if w := generate("?overwrite=true"); w.Code != http.StatusOK {
+		t.Fatalf("expected overwrite to succeed, got %d %s", w.Code, w.Body.String())
+	}
+
+	renumber := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes/renumber", strings.NewReader(`{"chapter":1}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Header.Set("X-Admin-Token", token)
+		handler.RenumberScenes(c)
+		return w
+	}
+	if w := renumber("wrong"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without the admin token, got %d", w.Code)
+	}
+	w = renumber("secret")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"from":3`) {
+		t.Fatalf("expected scene 3 moved to 1, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGenerateSceneStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := zerolog.Nop()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil)
+
+	stream := func(lastEventID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes/stream", strings.NewReader(`{"intention":"再会","word_count":100}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Header.Set("X-Request-ID", "stream-1")
+		if lastEventID != "" {
+			c.Request.Header.Set("Last-Event-ID", lastEventID)
+		}
+		c.Set("request_id", "stream-1")
+		handler.GenerateSceneStream(c)
+		return w
+	}
+
+	w := stream("")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	want := "id: 1\nevent: token\ndata: {\"text\":\"Mock res\"}\n\n" +
+		"id: 2\nevent: token\ndata: {\"text\":\"ponse.\"}\n\n" +
+		"id: 3\nevent: result\ndata: {"
+	if !strings.HasPrefix(body, want) || !strings.Contains(body, `"request_id":"stream-1"`) {
+		t.Fatalf("expected writer tokens then the result, got %q", body)
+	}
+
+	// Resuming replays the buffered events instead of generating again.
+	w = stream("2")
+	if body := w.Body.String(); !strings.HasPrefix(body, "id: 3\nevent: result\n") || strings.Contains(body, "event: token") {
+		t.Fatalf("expected only the result on resume, got %q", body)
+	}
+	if w := stream(""); w.Code != http.StatusConflict {
+		t.Fatalf("expected a repeated stream ID to be rejected, got %d", w.Code)
+	}
+}
+
+// panickingProvider panics on every generation.
+type panickingProvider struct{ agents.Provider }
+
+func (p *panickingProvider) Generate(ctx context.Context, messages []agents.Message, params agents.GenerateParams) (*models.GenerationResult, error) {
+	panic("provider exploded")
+}
+
+func TestGenerateSceneStreamRecoversPanics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["director"] = agents.AgentConfig{Provider: &panickingProvider{Provider: configs["director"].Provider}}
+	logger := zerolog.Nop()
+	stats := NewStatsStore()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, stats)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes/stream", strings.NewReader(`{"intention":"再会","word_count":100}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("X-Request-ID", "stream-panic")
+	handler.GenerateSceneStream(c)
+
+	if body := w.Body.String(); !strings.Contains(body, "event: error") || !strings.Contains(body, `"code":"internal_error"`) {
+		t.Fatalf("expected an internal_error event, got %q", body)
+	}
+	if panics := stats.Snapshot().PanicsTotal; panics != 1 {
+		t.Fatalf("expected the panic counted, got %d", panics)
+	}
+}
+
+// erringProvider reports the error each Generate call returned.
+type erringProvider struct {
+	agents.Provider
+	errs chan error
+}
+
+func (p *erringProvider) Generate(ctx context.Context, messages []agents.Message, params agents.GenerateParams) (*models.GenerationResult, error) {
+	result, err := p.Provider.Generate(ctx, messages, params)
+	p.errs <- err
+	return result, err
+}
+
+func TestGenerateSceneStopsWhenClientDisconnects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	received := make(chan struct{})
+	aborted := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a closed connection once the body is read.
+		_, _ = io.Copy(io.Discard, r.Body)
+		close(received)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"{}"},"finish_reason":"stop"}]}`))
+		}
+	}))
+	defer upstream.Close()
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "test")
+	openai, err := agents.CreateProvider(models.ProviderConfig{
+		Type:      "openai",
+		Model:     "gpt-4o",
+		BaseURL:   upstream.URL,
+		APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	director := &erringProvider{Provider: openai, errs: make(chan error, 1)}
+	configs["director"] = agents.AgentConfig{Provider: director}
+
+	logger := zerolog.Nop()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil)
+	router := gin.New()
+	router.POST("/api/v1/scenes", handler.GenerateScene)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/api/v1/scenes", strings.NewReader(`{"intention":"再会","word_count":100}`))
+	req.Header.Set("Content-Type", "application/json")
+	clientErr := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		clientErr <- err
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider was never called")
+	}
+	cancel()
+
+	select {
+	case err := <-director.errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the provider call to return context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the provider call to be cancelled promptly")
+	}
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("expected the upstream request to be aborted")
+	}
+	if err := <-clientErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the client request to be cancelled, got %v", err)
+	}
+}
+
+// lateProvider returns its context's error a little after it ends, once
+// the request deadline has surely passed too.
+type lateProvider struct{ agents.Provider }
+
+func (p *lateProvider) Generate(ctx context.Context, messages []agents.Message, params agents.GenerateParams) (*models.GenerationResult, error) {
+	<-ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	return nil, ctx.Err()
+}
+
+func TestGenerateSceneReturnsPartialWithoutStoring(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configs["writer"] = agents.AgentConfig{Provider: &lateProvider{Provider: configs["writer"].Provider}}
+	logger := zerolog.Nop()
+	section := models.SwarmSection{MinTimeRemainingMs: map[string]int{"director": 0, "writer": 0}}
+	handler := NewHandler(agents.NewSwarm(configs, section), &logger, nil)
+	router := gin.New()
+	router.POST("/api/v1/scenes", handler.GenerateScene)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/scenes", strings.NewReader(`{"intention":"再会","chapter":1,"scene":1,"word_count":100}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent || !strings.Contains(w.Body.String(), `"timed_out":true`) {
+		t.Fatalf("expected a 206 partial scene, got %d %s", w.Code, w.Body.String())
+	}
+	if err := handler.scenes.CheckSlot(1, 1, "retry"); err != nil {
+		t.Fatalf("expected the partial scene to leave its slot free, got %v", err)
+	}
+}
+
+func TestGetSceneFromRepository(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := zerolog.Nop()
+	repository := NewFileSceneRepository(t.TempDir(), &logger)
+	newRouter := func() *gin.Engine {
+		handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil).
+			WithUniqueSceneNumbers(true).
+			WithSceneRepository(repository)
+		router := gin.New()
+		router.POST("/api/v1/scenes", handler.GenerateScene)
+		router.GET("/api/v1/scenes/:id", handler.GetScene)
+		router.GET("/api/v1/chapters/:n/scenes", handler.ListChapterScenes)
+		return router
+	}
+	serve := func(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	generate := func(router *gin.Engine, path string) models.SceneResponse {
+		w := serve(router, http.MethodPost, path, `{"intention":"再会","chapter":2,"scene":1,"word_count":100}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected the scene generated, got %d %s", w.Code, w.Body.String())
+		}
+		var generated models.SceneResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &generated); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return generated
+	}
+	first := newRouter()
+	replaced := generate(first, "/api/v1/scenes")
+	generated := generate(first, "/api/v1/scenes?overwrite=true")
+
+	// A new handler has an empty memory store, so these come from disk.
+	router := newRouter()
+	w := serve(router, http.MethodGet, "/api/v1/scenes/"+generated.RequestID, "")
+	var stored models.SceneResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil || w.Code != http.StatusOK || stored.Text != generated.Text {
+		t.Fatalf("expected the stored scene, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(router, http.MethodGet, "/api/v1/scenes/unknown", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown scene, got %d", w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/api/v1/scenes/"+replaced.RequestID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected the overwritten scene deleted from disk, got %d", w.Code)
+	}
+
+	w = serve(router, http.MethodGet, "/api/v1/chapters/2/scenes", "")
+	var listing struct {
+		Total  int            `json:"total"`
+		Scenes []SceneSummary `json:"scenes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil || listing.Total != 1 || listing.Scenes[0].ID != generated.RequestID {
+		t.Fatalf("expected the chapter's scene listed, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(router, http.MethodGet, "/api/v1/chapters/zero/scenes", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid chapter, got %d", w.Code)
+	}
+}
+
+func TestProvidersListsAgentsWithoutSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("NOVELIST_TEST_PROVIDERS_KEY", "sk-secret")
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{
+		Routing: map[string]string{"writer": "gpt, local"},
+		Available: map[string]models.ProviderConfig{
+			"gpt":   {Type: "openai", Model: "gpt-4o", BaseURL: "http://127.0.0.1:1", APIKeyEnv: "NOVELIST_TEST_PROVIDERS_KEY"},
+			"local": {Type: "mock"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := zerolog.Nop()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/providers", nil)
+	handler.Providers(c)
+
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "sk-secret") {
+		t.Fatalf("expected the providers without the API key, got %d %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Agents map[string]agents.AgentProviderInfo `json:"agents"`
+		Types  []string                            `json:"types"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writer := body.Agents["writer"]
+	if writer.Provider != "gpt" || len(writer.Chain) != 2 || body.Agents["director"].Type != "mock" {
+		t.Fatalf("expected the writer's chain and a mock director, got %+v", body.Agents)
+	}
+	if !strings.Contains(strings.Join(body.Types, ","), "openai") {
+		t.Fatalf("expected the registered types, got %v", body.Types)
+	}
+}
+
+func TestSwapSwarmKeepsStartedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := zerolog.Nop()
+	original := agents.NewSwarm(configs, models.SwarmSection{})
+	handler := NewHandler(original, &logger, nil)
+
+	newContext := func() *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes", strings.NewReader(`{"intention":"再会","word_count":100}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		return c
+	}
+	started, ok := handler.prepareScene(newContext())
+	if !ok {
+		t.Fatal("expected the request to be prepared")
+	}
+
+	reloaded := agents.NewSwarm(configs, models.SwarmSection{})
+	reloaded.SetDegraded(true)
+	if previous := handler.SwapSwarm(reloaded); previous != original || handler.Swarm() != reloaded {
+		t.Fatal("expected the swap to return the original swarm and serve the new one")
+	}
+
+	resp, err := handler.run(started)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Degraded {
+		t.Fatal("expected the started request to finish on the original swarm")
+	}
+	next, ok := handler.prepareScene(newContext())
+	if !ok {
+		t.Fatal("expected the request to be prepared")
+	}
+	if resp, err = handler.run(next); err != nil || !resp.Degraded {
+		t.Fatalf("expected a new request served by the new swarm, got %+v (%v)", resp, err)
+	}
 }
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/agents"
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// Health statuses reported by /health.
+const (
+	HealthStatusHealthy   = "healthy"
+	HealthStatusDegraded  = "degraded"
+	HealthStatusUnhealthy = "unhealthy"
+)
+
+const (
+	criticalHealthWeight   = 3
+	optionalHealthWeight   = 1
+	defaultHealthThreshold = 0.5
+)
+
+var defaultCriticalAgents = []string{"director", "writer"}
+
+// HealthPolicy turns per-agent provider health into a weighted score and
+// an overall status.
+type HealthPolicy struct {
+	weights   map[string]float64
+	critical  map[string]bool
+	threshold float64
+}
+
+// HealthReport is the outcome of evaluating provider health.
+type HealthReport struct {
+	Status string
+	// Score is the healthy share of the total weight, from 0 to 1.
+	Score            float64
+	CriticalFailures []string
+	OptionalFailures []string
+	// ModelUnavailable lists agents serving from a fallback because their
+	// configured model was rejected.
+	ModelUnavailable []string
+}
+
+// NewHealthPolicy builds a policy from config, filling in the defaults for
+// unset fields. Negative weights and thresholds outside (0, 1] are
+// ignored.
+func NewHealthPolicy(config models.HealthConfig) *HealthPolicy {
+	p := &HealthPolicy{
+		weights:   make(map[string]float64),
+		critical:  make(map[string]bool),
+		threshold: defaultHealthThreshold,
+	}
+	critical := config.Critical
+	if len(critical) == 0 {
+		critical = defaultCriticalAgents
+	}
+	for _, agent := range critical {
+		p.critical[strings.ToLower(strings.TrimSpace(agent))] = true
+	}
+	for agent, weight := range config.Weights {
+		if weight >= 0 {
+			p.weights[strings.ToLower(strings.TrimSpace(agent))] = weight
+		}
+	}
+	if config.Threshold > 0 && config.Threshold <= 1 {
+		p.threshold = config.Threshold
+	}
+	return p
+}
+
+// weight returns agent's configured weight, or its default.
+func (p *HealthPolicy) weight(agent string) float64 {
+	if weight, ok := p.weights[agent]; ok {
+		return weight
+	}
+	if p.critical[agent] {
+		return criticalHealthWeight
+	}
+	return optionalHealthWeight
+}
+
+// Evaluate scores dependencies. A failing critical agent, or a score below
+// the threshold, is unhealthy; any other failure, or an agent moved off an
+// unavailable model, is degraded.
+func (p *HealthPolicy) Evaluate(dependencies map[string]agents.ProviderHealthStatus) HealthReport {
+	report := HealthReport{Status: HealthStatusHealthy, Score: 1}
+
+	var total, healthy float64
+	for agent, dep := range dependencies {
+		weight := p.weight(agent)
+		total += weight
+		if dep.ModelUnavailable != nil {
+			report.ModelUnavailable = append(report.ModelUnavailable, agent)
+		}
+		switch {
+		case dep.Healthy:
+			healthy += weight
+		case p.critical[agent]:
+			report.CriticalFailures = append(report.CriticalFailures, agent)
+		default:
+			report.OptionalFailures = append(report.OptionalFailures, agent)
+		}
+	}
+	sort.Strings(report.CriticalFailures)
+	sort.Strings(report.OptionalFailures)
+	sort.Strings(report.ModelUnavailable)
+	if total > 0 {
+		report.Score = math.Round(healthy/total*1000) / 1000
+	}
+
+	switch {
+	case len(report.CriticalFailures) > 0 || report.Score < p.threshold:
+		report.Status = HealthStatusUnhealthy
+	case len(report.OptionalFailures) > 0 || len(report.ModelUnavailable) > 0:
+		report.Status = HealthStatusDegraded
+	}
+	return report
+}
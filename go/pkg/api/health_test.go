@@ -0,0 +1,75 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/novelist/novelist/pkg/agents"
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func healthDeps(down ...string) map[string]agents.ProviderHealthStatus {
+	deps := map[string]agents.ProviderHealthStatus{}
+	for _, agent := range []string{"director", "writer", "checker", "editor", "committer"} {
+		deps[agent] = agents.ProviderHealthStatus{Provider: "mock", Healthy: true}
+	}
+	for _, agent := range down {
+		deps[agent] = agents.ProviderHealthStatus{Provider: "mock", Error: "unreachable"}
+	}
+	return deps
+}
+
+func TestHealthPolicyEvaluate(t *testing.T) {
+	policy := NewHealthPolicy(models.HealthConfig{})
+
+	cases := []struct {
+		name   string
+		down   []string
+		status string
+		score  float64
+	}{
+		{"all up", nil, HealthStatusHealthy, 1},
+		{"optional down", []string{"committer"}, HealthStatusDegraded, 0.889},
+		{"all optional down", []string{"checker", "editor", "committer"}, HealthStatusDegraded, 0.667},
+		{"critical down", []string{"writer"}, HealthStatusUnhealthy, 0.667},
+	}
+	for _, tc := range cases {
+		report := policy.Evaluate(healthDeps(tc.down...))
+		if report.Status != tc.status || report.Score != tc.score {
+			t.Errorf("%s: got %s %.3f, want %s %.3f", tc.name, report.Status, report.Score, tc.status, tc.score)
+		}
+	}
+
+	report := policy.Evaluate(healthDeps("writer", "editor"))
+	if len(report.CriticalFailures) != 1 || report.CriticalFailures[0] != "writer" ||
+		len(report.OptionalFailures) != 1 || report.OptionalFailures[0] != "editor" {
+		t.Fatalf("expected failures split by criticality, got %+v", report)
+	}
+}
+
+func TestHealthPolicyConfig(t *testing.T) {
+	// Only the writer is critical; the checker weighs as much as the rest
+	// together, so losing it drops the score under the threshold.
+	policy := NewHealthPolicy(models.HealthConfig{
+		Critical:  []string{"writer"},
+		Weights:   map[string]float64{"checker": 7},
+		Threshold: 0.6,
+	})
+	if report := policy.Evaluate(healthDeps("director")); report.Status != HealthStatusDegraded {
+		t.Fatalf("expected a non-critical director failure to degrade, got %+v", report)
+	}
+	if report := policy.Evaluate(healthDeps("checker")); report.Status != HealthStatusUnhealthy {
+		t.Fatalf("expected a score under the threshold to be unhealthy, got %+v", report)
+	}
+}
+
+func TestHealthPolicyModelUnavailable(t *testing.T) {
+	deps := healthDeps()
+	writer := deps["writer"]
+	writer.ModelUnavailable = &agents.ModelSwitchoverStatus{Fallback: "gpt_new"}
+	deps["writer"] = writer
+
+	report := NewHealthPolicy(models.HealthConfig{}).Evaluate(deps)
+	if report.Status != HealthStatusDegraded || report.Score != 1 || len(report.ModelUnavailable) != 1 {
+		t.Fatalf("expected a switched-over writer to degrade health, got %+v", report)
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/novelist/novelist/pkg/apierr"
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// Job statuses.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+const (
+	defaultJobWorkers = 2
+	defaultJobDepth   = 32
+	defaultJobTTL     = time.Hour
+	defaultJobTimeout = 5 * time.Minute
+)
+
+var (
+	errJobQueueFull = errors.New("job queue is full")
+	errJobExists    = errors.New("a job with this request ID already exists")
+)
+
+// Job is the pollable state of an asynchronous scene generation.
+type Job struct {
+	ID         string      `json:"job_id"`
+	Status     string      `json:"status"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Code       apierr.Code `json:"code,omitempty"`
+	// Result is the scene response, in the schema version negotiated at
+	// submission, once the job is done.
+	Result any `json:"result,omitempty"`
+	// TimedOut marks a done job whose scene was cut short by the job
+	// timeout: the result is partial and the scene was not stored.
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// jobRunner generates a job's scene.
+type jobRunner func(call *sceneCall) (*models.SceneResponse, error)
+
+// JobQueue runs scene generations on a fixed worker pool. Waiting jobs
+// start by request priority, then in submission order. At most depth jobs
+// wait; finished jobs are kept for ttl after they finish.
+type JobQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	pending jobHeap
+	// ready is signalled when a job is queued.
+	ready   *sync.Cond
+	seq     uint64
+	depth   int
+	workers int
+	ttl     time.Duration
+	timeout time.Duration
+	// limiter, when set, is shared with synchronous generations, so jobs
+	// and requests together stay within its in-flight limit.
+	limiter *ConcurrencyLimiter
+	// retryAfter is sent with submissions turned away by a full queue.
+	retryAfter time.Duration
+	// run is set by the handler that owns the queue.
+	run   jobRunner
+	start sync.Once
+}
+
+// NewJobQueue creates a queue. Non-positive values use the defaults: 2
+// workers, 32 waiting jobs, a one-hour TTL and a five-minute timeout per
+// job.
+func NewJobQueue(workers, depth int, ttl, timeout time.Duration) *JobQueue {
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+	if depth <= 0 {
+		depth = defaultJobDepth
+	}
+	if ttl <= 0 {
+		ttl = defaultJobTTL
+	}
+	if timeout <= 0 {
+		timeout = defaultJobTimeout
+	}
+	q := &JobQueue{
+		jobs:       make(map[string]*Job),
+		depth:      depth,
+		workers:    workers,
+		ttl:        ttl,
+		timeout:    timeout,
+		retryAfter: defaultBusyRetryAfter,
+	}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// WithRetryAfter sets the Retry-After sent when the queue is full.
+// Non-positive values keep the default of two seconds.
+func (q *JobQueue) WithRetryAfter(d time.Duration) *JobQueue {
+	if d > 0 {
+		q.retryAfter = d
+	}
+	return q
+}
+
+// WithLimiter makes each job take a slot of limiter, at its priority,
+// before it runs.
+func (q *JobQueue) WithLimiter(limiter *ConcurrencyLimiter) *JobQueue {
+	q.limiter = limiter
+	return q
+}
+
+// submit enqueues call under its request ID, starting the workers on first
+// use. It fails when the queue is full or the ID is taken.
+func (q *JobQueue) submit(call *sceneCall) (Job, error) {
+	q.start.Do(func() {
+		for i := 0; i < q.workers; i++ {
+			go q.work()
+		}
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweepLocked(time.Now())
+	if _, ok := q.jobs[call.req.ID]; ok {
+		return Job{}, errJobExists
+	}
+	if len(q.pending) >= q.depth {
+		return Job{}, errJobQueueFull
+	}
+	job := &Job{ID: call.req.ID, Status: JobQueued, CreatedAt: time.Now()}
+	q.seq++
+	heap.Push(&q.pending, &pendingJob{call: call, priority: queueIndex(call.req.Priority), seq: q.seq})
+	q.jobs[job.ID] = job
+	q.ready.Signal()
+	return *job, nil
+}
+
+// Get returns a snapshot of the job with id.
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweepLocked(time.Now())
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Queued returns the number of jobs waiting for a worker.
+func (q *JobQueue) Queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// next blocks until a job is queued and takes the first one.
+func (q *JobQueue) next() *sceneCall {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 {
+		q.ready.Wait()
+	}
+	return heap.Pop(&q.pending).(*pendingJob).call
+}
+
+func (q *JobQueue) work() {
+	for {
+		call := q.next()
+		if q.limiter != nil {
+			// Job workers are few, so they may wait past the limiter's
+			// queue bound; the wait cannot be cancelled.
+			_ = q.limiter.acquire(context.Background(), call.req.Priority, false)
+		}
+		q.update(call.req.ID, func(job *Job) {
+			now := time.Now()
+			job.Status = JobRunning
+			job.StartedAt = &now
+		})
+
+		// The submitting request is long gone, so only the job timeout
+		// bounds the generation.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(call.ctx), q.timeout)
+		call.ctx = ctx
+		resp, err := q.runSafely(call)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = apierr.Wrap(apierr.RequestTimeout, err)
+		}
+		cancel()
+		if q.limiter != nil {
+			q.limiter.Release()
+		}
+
+		q.update(call.req.ID, func(job *Job) {
+			now := time.Now()
+			job.FinishedAt = &now
+			if err != nil {
+				job.Status = JobFailed
+				job.Error = err.Error()
+				job.Code = apierr.CodeOf(err)
+				return
+			}
+			job.Status = JobDone
+			job.Result = encodeSceneResponse(resp, call.schemaVersion)
+			job.TimedOut = resp.TimedOut
+		})
+	}
+}
+
+// runSafely runs call, failing the job instead of the worker on a panic.
+func (q *JobQueue) runSafely(call *sceneCall) (resp *models.SceneResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			resp, err = nil, apierr.New(apierr.InternalError, fmt.Sprintf("job panicked: %v", r))
+		}
+	}()
+	return q.run(call)
+}
+
+func (q *JobQueue) update(id string, fn func(job *Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// sweepLocked drops jobs that finished more than ttl ago.
+func (q *JobQueue) sweepLocked(now time.Time) {
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > q.ttl {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// pendingJob is a queued job; priority is its queuePriorities index.
+type pendingJob struct {
+	call     *sceneCall
+	priority int
+	seq      uint64
+}
+
+// jobHeap orders pending jobs by priority, then submission.
+type jobHeap []*pendingJob
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x any)   { *h = append(*h, x.(*pendingJob)) }
+func (h *jobHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return last
+}
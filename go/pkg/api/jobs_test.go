@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/novelist/novelist/pkg/agents"
+	"github.com/novelist/novelist/pkg/apierr"
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog"
+)
+
+func TestSceneJobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	configs, err := agents.BuildAgentConfigs(models.ProviderSection{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := zerolog.Nop()
+	handler := NewHandler(agents.NewSwarm(configs, models.SwarmSection{}), &logger, nil)
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.POST("/api/v1/jobs", handler.SubmitJob)
+	router.GET("/api/v1/jobs/:id", handler.GetJob)
+
+	submit := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", strings.NewReader(`{"intention":"再会","word_count":100}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", "job-1")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := submit()
+	if w.Code != http.StatusAccepted || w.Header().Get("Location") != "/api/v1/jobs/job-1" {
+		t.Fatalf("expected 202 with a Location, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	var job Job
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/jobs/job-1", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("unexpected body %q: %v", w.Body.String(), err)
+		}
+		if job.Status == JobDone || job.Status == JobFailed || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Status != JobDone || !strings.Contains(w.Body.String(), `"request_id":"job-1"`) {
+		t.Fatalf("expected the job to finish with the scene, got %s", w.Body.String())
+	}
+
+	if w := submit(); w.Code != http.StatusConflict {
+		t.Fatalf("expected a repeated job ID to be rejected, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/jobs/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown job, got %d", w.Code)
+	}
+}
+
+func TestJobQueueBoundsDepthAndExpires(t *testing.T) {
+	release := make(chan struct{})
+	queue := NewJobQueue(1, 1, time.Millisecond, time.Minute)
+	queue.run = func(call *sceneCall) (*models.SceneResponse, error) {
+		<-release
+		return nil, errors.New("boom")
+	}
+	call := func(id string) *sceneCall {
+		return &sceneCall{req: models.SceneRequest{ID: id}, ctx: context.Background()}
+	}
+
+	if _, err := queue.submit(call("a")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Wait for the worker to take "a", leaving the one queue slot free.
+	for deadline := time.Now().Add(time.Second); queue.Queued() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := queue.submit(call("b")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := queue.submit(call("c")); !errors.Is(err, errJobQueueFull) {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+
+	close(release)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if job, _ := queue.Get("b"); job.Status == JobFailed {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := queue.Get("a"); ok {
+		t.Fatal("expected the finished job to expire")
+	}
+}
+
+func TestJobQueueRunsByPriorityAndRecovers(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	queue := NewJobQueue(1, 4, time.Minute, time.Minute)
+	queue.run = func(call *sceneCall) (*models.SceneResponse, error) {
+		if call.req.ID == "first" {
+			<-release
+		}
+		mu.Lock()
+		order = append(order, call.req.ID)
+		mu.Unlock()
+		if call.req.ID == "panics" {
+			panic("boom")
+		}
+		return &models.SceneResponse{}, nil
+	}
+	call := func(id, priority string) *sceneCall {
+		return &sceneCall{req: models.SceneRequest{ID: id, Priority: priority}, ctx: context.Background(), schemaVersion: defaultSchemaVersion}
+	}
+
+	if _, err := queue.submit(call("first", "")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); queue.Queued() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for _, c := range []*sceneCall{call("low", "low"), call("panics", ""), call("high", "high")} {
+		if _, err := queue.submit(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	close(release)
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if job, _ := queue.Get("low"); job.Status == JobDone {
+			break
+		}
+	}
+	mu.Lock()
+	got := strings.Join(order, ",")
+	mu.Unlock()
+	if got != "first,high,panics,low" {
+		t.Fatalf("expected jobs run by priority, got %s", got)
+	}
+	if job, _ := queue.Get("panics"); job.Status != JobFailed || job.Code != apierr.InternalError {
+		t.Fatalf("expected the panicking job failed, got %+v", job)
+	}
+}
@@ -1,14 +1,25 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/novelist/novelist/pkg/agents"
+	"github.com/novelist/novelist/pkg/apierr"
+	"github.com/rs/zerolog"
 )
 
 // RequestIDMiddleware ensures each request has an ID.
@@ -24,6 +35,214 @@ func RequestIDMiddleware() gin.HandlerFunc {
 	}
 }
 
+// apiKeyIdentityKey is the gin context key holding the authenticated
+// API key's name.
+const apiKeyIdentityKey = "api_key_id"
+
+// APIKeyAuthMiddleware requires an "Authorization: Bearer <key>" header
+// matching one of keys, which maps key names to keys. A miss answers 401
+// unauthorized; a match stores the key's name in the context for later
+// middleware. With no keys configured every request passes.
+func APIKeyAuthMiddleware(keys map[string]string) gin.HandlerFunc {
+	// Keys are compared as SHA-256 digests so every comparison takes the
+	// same time whatever the key lengths.
+	digests := make(map[string][sha256.Size]byte, len(keys))
+	for name, key := range keys {
+		if key != "" {
+			digests[name] = sha256.Sum256([]byte(key))
+		}
+	}
+	return func(c *gin.Context) {
+		if len(digests) == 0 {
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		presented := sha256.Sum256([]byte(strings.TrimSpace(token)))
+		identity := ""
+		// Check every key rather than stopping at a match.
+		for name, digest := range digests {
+			if subtle.ConstantTimeCompare(presented[:], digest[:]) == 1 {
+				identity = name
+			}
+		}
+		if !ok || identity == "" {
+			c.Writer.Header().Set("WWW-Authenticate", `Bearer realm="novelist"`)
+			apierr.RespondError(c, apierr.New(apierr.Unauthorized, "a valid API key is required"))
+			return
+		}
+		c.Set(apiKeyIdentityKey, identity)
+		c.Next()
+	}
+}
+
+// maxPriorityKey is the gin context key holding the highest priority the
+// request's API key may claim.
+const maxPriorityKey = "max_priority"
+
+// PriorityMiddleware sets the highest priority a request may claim from
+// limits, which maps API key names to their highest allowed priority.
+// Requests from other keys, or unauthenticated ones, are held to normal so
+// callers cannot put themselves ahead of everyone else.
+func PriorityMiddleware(limits map[string]string) gin.HandlerFunc {
+	// viper lowercases config map keys; match key names the same way.
+	normalized := make(map[string]string, len(limits))
+	for name, limit := range limits {
+		normalized[strings.ToLower(name)] = strings.ToLower(strings.TrimSpace(limit))
+	}
+	return func(c *gin.Context) {
+		limit := defaultQueuePriority
+		if identity := c.GetString(apiKeyIdentityKey); identity != "" {
+			if configured, ok := normalized[strings.ToLower(identity)]; ok && configured != "" {
+				limit = configured
+			}
+		}
+		c.Set(maxPriorityKey, limit)
+		c.Next()
+	}
+}
+
+// capPriority lowers requested to the highest priority set by
+// PriorityMiddleware, or to normal when the middleware did not run.
+func capPriority(c *gin.Context, requested string) string {
+	limit := c.GetString(maxPriorityKey)
+	if limit == "" {
+		limit = defaultQueuePriority
+	}
+	if queueIndex(requested) < queueIndex(limit) {
+		return limit
+	}
+	return requested
+}
+
+// RecoveryMiddleware recovers panics in later handlers. It logs the panic with
+// the request ID, route and current pipeline stage, counts it in stats, and
+// responds with a 500 internal_error.
+func RecoveryMiddleware(logger *zerolog.Logger, stats *StatsStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, tracker := agents.WithStageTracker(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			requestID := c.GetString("request_id")
+			logger.Error().
+				Str("request_id", requestID).
+				Str("method", c.Request.Method).
+				Str("route", c.FullPath()).
+				Str("stage", tracker.Current()).
+				Interface("panic", r).
+				Bytes("stack", debug.Stack()).
+				Msg("Recovered panic")
+			if stats != nil {
+				stats.RecordPanic()
+			}
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			apierr.RespondError(c, apierr.New(apierr.InternalError, "internal server error"))
+		}()
+
+		c.Next()
+	}
+}
+
+// DegradedMiddleware marks every response with X-Novelist-Degraded while
+// degraded reports true.
+func DegradedMiddleware(degraded func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if degraded() {
+			c.Writer.Header().Set("X-Novelist-Degraded", "true")
+		}
+		c.Next()
+	}
+}
+
+// corsAllowedHeaders are the request headers browsers may send
+// cross-origin.
+var corsAllowedHeaders = []string{
+	"Accept-Version",
+	"Authorization",
+	"Cache-Control",
+	"Content-Type",
+	"Last-Event-ID",
+	"X-Admin-Token",
+	"X-Provider-Override",
+	"X-Request-ID",
+}
+
+// corsExposedHeaders are the response headers browser scripts may read.
+var corsExposedHeaders = []string{
+	"Content-Version",
+	"Location",
+	"Retry-After",
+	"X-Novelist-Degraded",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+	"X-Request-ID",
+}
+
+const corsAllowedMethods = "GET, POST, OPTIONS"
+
+// corsMaxAge is how long browsers may cache a preflight answer, in seconds.
+const corsMaxAge = "600"
+
+// CORSMiddleware lets browsers on allowedOrigins call the API. Requests
+// from an allowed origin get their origin echoed with credentials
+// allowed, and their OPTIONS preflights are answered with 204 here. An
+// origin of "*" allows every origin without credentials. Requests from
+// other origins pass through without CORS headers; with no origins the
+// middleware does nothing.
+func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
+	wildcard := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			wildcard = true
+		} else if origin != "" {
+			allowed[origin] = true
+		}
+	}
+	allowHeaders := strings.Join(corsAllowedHeaders, ", ")
+	exposeHeaders := strings.Join(corsExposedHeaders, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !wildcard && !allowed[origin] {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		if wildcard {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Allow-Credentials", "true")
+			header.Add("Vary", "Origin")
+		}
+		header.Set("Access-Control-Expose-Headers", exposeHeaders)
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			header.Set("Access-Control-Allow-Headers", allowHeaders)
+			header.Set("Access-Control-Max-Age", corsMaxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
 // StatsMiddleware records request metrics.
 func StatsMiddleware(stats *StatsStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -34,7 +253,7 @@ func StatsMiddleware(stats *StatsStore) gin.HandlerFunc {
 		start := time.Now()
 		stats.BeginRequest()
 		c.Next()
-		stats.EndRequest(c.Writer.Status(), time.Since(start))
+		stats.EndRequest(c.FullPath(), c.Writer.Status(), time.Since(start))
 	}
 }
 
@@ -62,51 +281,252 @@ func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 		c.Next()
 
 		if ctx.Err() == context.DeadlineExceeded && !c.Writer.Written() {
-			c.AbortWithStatusJSON(http.StatusRequestTimeout, gin.H{
-				"error": "request timeout",
-				"code":  "request_timeout",
-			})
+			apierr.RespondError(c, apierr.New(apierr.RequestTimeout, "request timeout"))
 		}
 	}
 }
 
-// ConcurrencyLimiter bounds in-flight generation requests.
+// errQueueFull is returned by ConcurrencyLimiter.Acquire when no slot is free
+// and the wait queue is at capacity.
+var errQueueFull = errors.New("concurrency queue is full")
+
+// defaultBusyRetryAfter is the Retry-After sent when a full queue turns a
+// request away.
+const defaultBusyRetryAfter = 2 * time.Second
+
+// setRetryAfter sets the Retry-After header to d in whole seconds, rounded
+// up and at least one.
+func setRetryAfter(c *gin.Context, d time.Duration) {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Writer.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+// ConcurrencyLimiter bounds in-flight generation requests. Requests beyond
+// the limit wait in a bounded queue: higher priorities are admitted first,
+// and requests of equal priority in arrival order.
 type ConcurrencyLimiter struct {
-	sem chan struct{}
+	mu          sync.Mutex
+	maxInFlight int
+	maxQueued   int
+	inFlight    int
+	// queues holds one FIFO per priority, in queuePriorities order.
+	queues [][]chan struct{}
+	// retryAfter is sent with requests turned away by a full queue.
+	retryAfter time.Duration
 }
 
-// NewConcurrencyLimiter creates a limiter with max slots.
-func NewConcurrencyLimiter(maxInFlight int) *ConcurrencyLimiter {
+// queuePriorities lists request priorities from first to last admitted.
+var queuePriorities = []string{"high", "normal", "low"}
+
+const defaultQueuePriority = "normal"
+
+// NewConcurrencyLimiter creates a limiter with max slots and max queued waiters.
+func NewConcurrencyLimiter(maxInFlight, maxQueued int) *ConcurrencyLimiter {
 	if maxInFlight <= 0 {
 		maxInFlight = 8
 	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
 	return &ConcurrencyLimiter{
-		sem: make(chan struct{}, maxInFlight),
+		maxInFlight: maxInFlight,
+		maxQueued:   maxQueued,
+		queues:      make([][]chan struct{}, len(queuePriorities)),
+		retryAfter:  defaultBusyRetryAfter,
+	}
+}
+
+// WithRetryAfter sets the Retry-After sent when the queue is full.
+// Non-positive values keep the default of two seconds.
+func (l *ConcurrencyLimiter) WithRetryAfter(d time.Duration) *ConcurrencyLimiter {
+	if d > 0 {
+		l.retryAfter = d
+	}
+	return l
+}
+
+// queueIndex returns the queue for priority; unknown or empty priorities
+// queue as normal.
+func queueIndex(priority string) int {
+	priority = strings.ToLower(strings.TrimSpace(priority))
+	if priority == "" {
+		priority = defaultQueuePriority
+	}
+	for i, p := range queuePriorities {
+		if p == priority {
+			return i
+		}
+	}
+	return queueIndex(defaultQueuePriority)
+}
+
+// Acquire takes a slot at normal priority.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	return l.AcquirePriority(ctx, defaultQueuePriority)
+}
+
+// AcquirePriority takes a slot, waiting while all slots are busy until no
+// higher-priority or earlier same-priority request is waiting. It fails
+// fast with errQueueFull when the queue is at capacity, and returns
+// ctx.Err() if the caller gives up while queued.
+func (l *ConcurrencyLimiter) AcquirePriority(ctx context.Context, priority string) error {
+	return l.acquire(ctx, priority, true)
+}
+
+// acquire takes a slot like AcquirePriority; unless bounded, the caller
+// waits even when the queue is at capacity. Job workers, themselves a
+// bounded pool, wait unbounded.
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, priority string, bounded bool) error {
+	idx := queueIndex(priority)
+
+	l.mu.Lock()
+	if l.inFlight < l.maxInFlight && l.queuedLocked() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if bounded && l.queuedLocked() >= l.maxQueued {
+		l.mu.Unlock()
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	l.queues[idx] = append(l.queues[idx], ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		for i, waiter := range l.queues[idx] {
+			if waiter == ready {
+				l.queues[idx] = append(l.queues[idx][:i], l.queues[idx][i+1:]...)
+				l.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		l.mu.Unlock()
+		// The slot was handed over while we were giving up; pass it on.
+		l.Release()
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot, handing it directly to the oldest waiter of the
+// highest waiting priority, if any.
+func (l *ConcurrencyLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, queue := range l.queues {
+		if len(queue) > 0 {
+			next := queue[0]
+			l.queues[i] = queue[1:]
+			close(next)
+			return
+		}
+	}
+	if l.inFlight > 0 {
+		l.inFlight--
+	}
+}
+
+// queuedLocked returns the number of waiters. Callers hold mu.
+func (l *ConcurrencyLimiter) queuedLocked() int {
+	total := 0
+	for _, queue := range l.queues {
+		total += len(queue)
+	}
+	return total
+}
+
+// QueueLength returns the number of requests waiting for a slot.
+func (l *ConcurrencyLimiter) QueueLength() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queuedLocked()
+}
+
+// PriorityQueueLength returns the number of requests of priority waiting
+// for a slot.
+func (l *ConcurrencyLimiter) PriorityQueueLength(priority string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queues[queueIndex(priority)])
+}
+
+// RegisterQueueGauges reports the total and per-priority queue depths in
+// stats as concurrency_queue_length and concurrency_queue_length_<priority>.
+func (l *ConcurrencyLimiter) RegisterQueueGauges(stats *StatsStore) {
+	stats.RegisterGauge("concurrency_queue_length", l.QueueLength)
+	for _, priority := range queuePriorities {
+		priority := priority
+		stats.RegisterGauge("concurrency_queue_length_"+priority, func() int {
+			return l.PriorityQueueLength(priority)
+		})
 	}
 }
 
-// Middleware returns gin middleware for concurrency limiting.
+// Middleware returns gin middleware for concurrency limiting. Requests
+// queue at the priority named in their JSON body, capped by
+// PriorityMiddleware.
 func (l *ConcurrencyLimiter) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		select {
-		case l.sem <- struct{}{}:
-			defer func() { <-l.sem }()
+		err := l.AcquirePriority(c.Request.Context(), capPriority(c, peekPriority(c)))
+		switch {
+		case err == nil:
+			defer l.Release()
 			c.Next()
+		case errors.Is(err, errQueueFull):
+			setRetryAfter(c, l.retryAfter)
+			apierr.RespondError(c, apierr.New(apierr.TooManyRequests, "too many in-flight requests"))
 		default:
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": "too many in-flight requests",
-				"code":  "too_many_requests",
-			})
+			apierr.RespondError(c, apierr.New(apierr.RequestTimeout, "request cancelled while queued"))
 		}
 	}
 }
 
+// peekPriority reads the "priority" field of a JSON request body and
+// restores the body for the handler. A read error, such as an oversized
+// body, is replayed to the handler after the bytes read so far.
+func peekPriority(c *gin.Context) string {
+	if c.Request.Body == nil || c.ContentType() != "application/json" {
+		return ""
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		return ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields struct {
+		Priority string `json:"priority"`
+	}
+	_ = json.Unmarshal(body, &fields)
+	return fields.Priority
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// rateWindow is one client's sliding-window log.
 type rateWindow struct {
-	count   int
+	// hits holds the times of requests allowed within the last window,
+	// oldest first.
+	hits []time.Time
+	// resetAt is when the newest hit leaves the window, emptying the log.
 	resetAt time.Time
 }
 
-// IPRateLimiter applies a fixed-window limit by client IP.
+// IPRateLimiter applies a sliding-window limit by client IP, or by API key
+// for requests authenticated by APIKeyAuthMiddleware: at most limit
+// requests are allowed in any window-long interval.
 type IPRateLimiter struct {
 	mu      sync.Mutex
 	limit   int
@@ -129,49 +549,96 @@ func NewIPRateLimiter(limit int, window time.Duration) *IPRateLimiter {
 	}
 }
 
-// Middleware returns gin middleware for per-IP rate limiting.
+// Middleware returns gin middleware for per-client rate limiting.
 func (l *IPRateLimiter) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		allowed, remaining, resetUnix := l.Allow(c.ClientIP(), time.Now())
+		allowed, remaining, resetUnix := l.Allow(rateLimitKey(c), time.Now())
 		c.Writer.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
 		c.Writer.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
 		c.Writer.Header().Set("X-RateLimit-Reset", strconv.FormatInt(resetUnix, 10))
 
 		if !allowed {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": "rate limit exceeded",
-				"code":  "rate_limit_exceeded",
-			})
+			setRetryAfter(c, time.Until(time.Unix(resetUnix, 0)))
+			apierr.RespondError(c, apierr.New(apierr.RateLimitExceeded, "rate limit exceeded"))
 			return
 		}
 		c.Next()
 	}
 }
 
-// Allow records one request and returns current allowance.
+// StartJanitor deletes clients with an empty window every interval, so
+// the map does not grow with every client ever seen. The returned function
+// stops it.
+func (l *IPRateLimiter) StartJanitor(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = l.window
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				l.sweep(now)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// sweep deletes clients whose every request has left the window.
+func (l *IPRateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client, state := range l.clients {
+		if !now.Before(state.resetAt) {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// Clients returns the number of clients being tracked.
+func (l *IPRateLimiter) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.clients)
+}
+
+// rateLimitKey buckets authenticated requests by API key and the rest by
+// client IP.
+func rateLimitKey(c *gin.Context) string {
+	if identity := c.GetString(apiKeyIdentityKey); identity != "" {
+		return "key:" + identity
+	}
+	return c.ClientIP()
+}
+
+// Allow records one request and returns current allowance. resetUnix is
+// when the oldest counted request leaves the window, freeing a slot.
 func (l *IPRateLimiter) Allow(clientIP string, now time.Time) (allowed bool, remaining int, resetUnix int64) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	state, ok := l.clients[clientIP]
-	if !ok || now.After(state.resetAt) {
-		state = rateWindow{
-			count:   0,
-			resetAt: now.Add(l.window),
-		}
+	state := l.clients[clientIP]
+	cutoff := now.Add(-l.window)
+	expired := 0
+	for expired < len(state.hits) && !state.hits[expired].After(cutoff) {
+		expired++
 	}
+	state.hits = state.hits[expired:]
 
-	if state.count >= l.limit {
+	if len(state.hits) >= l.limit {
 		l.clients[clientIP] = state
-		return false, 0, state.resetAt.Unix()
+		return false, 0, state.hits[0].Add(l.window).Unix()
 	}
 
-	state.count++
+	state.hits = append(state.hits, now)
+	state.resetAt = now.Add(l.window)
 	l.clients[clientIP] = state
 
-	remaining = l.limit - state.count
-	if remaining < 0 {
-		remaining = 0
-	}
-	return true, remaining, state.resetAt.Unix()
+	return true, l.limit - len(state.hits), state.hits[0].Add(l.window).Unix()
 }
@@ -1,8 +1,20 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
 )
 
 func TestIPRateLimiterAllow(t *testing.T) {
@@ -24,8 +36,398 @@ func TestIPRateLimiterAllow(t *testing.T) {
 		t.Fatalf("expected third request denied with remaining 0, got allowed=%v remaining=%d", allowed, remaining)
 	}
 
-	allowed, remaining, _ = limiter.Allow("127.0.0.1", now.Add(61*time.Second))
+	allowed, remaining, _ = limiter.Allow("127.0.0.1", now.Add(71*time.Second))
 	if !allowed || remaining != 1 {
 		t.Fatalf("expected request after reset to be allowed with remaining 1, got allowed=%v remaining=%d", allowed, remaining)
 	}
 }
+
+func TestLimitersSetRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serve := func(middleware gin.HandlerFunc) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.GET("/scenes", middleware, func(c *gin.Context) { c.Status(http.StatusOK) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scenes", nil))
+		return w
+	}
+
+	rateLimiter := NewIPRateLimiter(1, time.Minute)
+	if w := serve(rateLimiter.Middleware()); w.Code != http.StatusOK || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("expected an allowed request without Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	w := serve(rateLimiter.Middleware())
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if w.Code != http.StatusTooManyRequests || err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Fatalf("expected 429 with Retry-After within the window, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	limiter := NewConcurrencyLimiter(1, 0).WithRetryAfter(3 * time.Second)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer limiter.Release()
+	if w := serve(limiter.Middleware()); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3" {
+		t.Fatalf("expected 429 with Retry-After 3, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serve(NewConcurrencyLimiter(1, 0).Middleware()); w.Code != http.StatusOK {
+		t.Fatalf("expected a free slot to admit the request, got %d", w.Code)
+	}
+}
+
+func TestIPRateLimiterRejectsBoundaryBurst(t *testing.T) {
+	limiter := NewIPRateLimiter(2, time.Minute)
+	now := time.Unix(1000, 0)
+
+	// Two requests at the end of one minute...
+	for _, at := range []time.Duration{58 * time.Second, 59 * time.Second} {
+		if allowed, _, _ := limiter.Allow("127.0.0.1", now.Add(at)); !allowed {
+			t.Fatalf("expected request at %s to be allowed", at)
+		}
+	}
+	// ...leave no room at the start of the next, as a fixed window would.
+	allowed, remaining, resetUnix := limiter.Allow("127.0.0.1", now.Add(61*time.Second))
+	if allowed || remaining != 0 {
+		t.Fatalf("expected boundary burst to be denied, got allowed=%v remaining=%d", allowed, remaining)
+	}
+	if want := now.Add(118 * time.Second).Unix(); resetUnix != want {
+		t.Fatalf("expected reset when the oldest request leaves the window (%d), got %d", want, resetUnix)
+	}
+
+	// The oldest request leaving the window frees exactly one slot.
+	if allowed, remaining, _ := limiter.Allow("127.0.0.1", now.Add(118*time.Second)); !allowed || remaining != 0 {
+		t.Fatalf("expected one slot after the oldest request expired, got allowed=%v remaining=%d", allowed, remaining)
+	}
+}
+
+func TestIPRateLimiterJanitorCollectsStaleClients(t *testing.T) {
+	limiter := NewIPRateLimiter(2, time.Minute)
+	now := time.Now()
+	limiter.Allow("10.0.0.1", now.Add(-2*time.Hour))
+	limiter.Allow("10.0.0.2", now.Add(-2*time.Minute))
+	limiter.Allow("10.0.0.3", now)
+
+	stop := limiter.StartJanitor(time.Millisecond)
+	defer stop()
+	deadline := time.Now().Add(time.Second)
+	for limiter.Clients() > 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	if got := limiter.Clients(); got != 1 {
+		t.Fatalf("expected only the active client to remain, got %d", got)
+	}
+	if allowed, remaining, _ := limiter.Allow("10.0.0.3", time.Now()); !allowed || remaining != 0 {
+		t.Fatalf("expected the active client's history to be kept, got allowed=%v remaining=%d", allowed, remaining)
+	}
+}
+
+func TestConcurrencyLimiterServesQueueInOrder(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, 3)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error acquiring first slot: %v", err)
+	}
+
+	order := make(chan int, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := limiter.Acquire(context.Background()); err != nil {
+				t.Errorf("waiter %d: unexpected error: %v", id, err)
+				return
+			}
+			order <- id
+			limiter.Release()
+		}(i)
+		waitForQueueLength(t, limiter, i+1)
+	}
+
+	if err := limiter.Acquire(context.Background()); !errors.Is(err, errQueueFull) {
+		t.Fatalf("expected errQueueFull when queue is full, got %v", err)
+	}
+
+	limiter.Release()
+	wg.Wait()
+	close(order)
+
+	expected := 0
+	for id := range order {
+		if id != expected {
+			t.Fatalf("expected waiter %d to be served, got %d", expected, id)
+		}
+		expected++
+	}
+	if limiter.QueueLength() != 0 {
+		t.Fatalf("expected empty queue, got %d", limiter.QueueLength())
+	}
+}
+
+func TestConcurrencyLimiterCancelledWaiterLeavesQueue(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, 1)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- limiter.Acquire(ctx) }()
+	waitForQueueLength(t, limiter, 1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if limiter.QueueLength() != 0 {
+		t.Fatalf("expected cancelled waiter to leave queue, got %d", limiter.QueueLength())
+	}
+}
+
+func TestConcurrencyLimiterAdmitsHigherPriorityFirst(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, 4)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Queue two batch jobs, then two interactive ones.
+	waiters := []struct {
+		id       string
+		priority string
+	}{{"low-1", "low"}, {"low-2", "low"}, {"high-1", "high"}, {"high-2", "HIGH"}}
+	order := make(chan string, len(waiters))
+	var wg sync.WaitGroup
+	for i, waiter := range waiters {
+		wg.Add(1)
+		go func(id, priority string) {
+			defer wg.Done()
+			if err := limiter.AcquirePriority(context.Background(), priority); err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+				return
+			}
+			order <- id
+			limiter.Release()
+		}(waiter.id, waiter.priority)
+		waitForQueueLength(t, limiter, i+1)
+	}
+	if got := limiter.PriorityQueueLength("low"); got != 2 {
+		t.Fatalf("expected 2 low-priority waiters, got %d", got)
+	}
+
+	limiter.Release()
+	wg.Wait()
+	close(order)
+
+	var got []string
+	for id := range order {
+		got = append(got, id)
+	}
+	want := []string{"high-1", "high-2", "low-1", "low-2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected admission order %v, got %v", want, got)
+		}
+	}
+}
+
+func TestPeekPriorityRestoresBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	const body = `{"intention":"再会","priority":"high"}`
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/scenes", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	if got := peekPriority(c); got != "high" {
+		t.Fatalf("expected high, got %q", got)
+	}
+	restored, _ := io.ReadAll(c.Request.Body)
+	if string(restored) != body {
+		t.Fatalf("expected body restored, got %q", restored)
+	}
+}
+
+func TestPriorityCappedByAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	middleware := PriorityMiddleware(map[string]string{"Alice": "high"})
+	cases := []struct {
+		identity  string
+		requested string
+		want      string
+	}{
+		{"alice", "high", "high"},
+		{"bob", "high", "normal"},
+		{"", "high", "normal"},
+		{"bob", "low", "low"},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		if tc.identity != "" {
+			c.Set(apiKeyIdentityKey, tc.identity)
+		}
+		middleware(c)
+		if got := capPriority(c, tc.requested); got != tc.want {
+			t.Errorf("key %q asking for %s: expected %s, got %s", tc.identity, tc.requested, tc.want, got)
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if got := capPriority(c, "high"); got != "normal" {
+		t.Fatalf("expected requests without the middleware held to normal, got %s", got)
+	}
+}
+
+func waitForQueueLength(t *testing.T, limiter *ConcurrencyLimiter, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for limiter.QueueLength() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for queue length %d", want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zerolog.New(io.Discard)
+	stats := NewStatsStore()
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.Use(RecoveryMiddleware(&logger, stats))
+	r.GET("/boom", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON body: %v", err)
+	}
+	if body["code"] != "internal_error" || body["request_id"] != "req-42" {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if got := stats.Snapshot().PanicsTotal; got != 1 {
+		t.Fatalf("expected 1 panic counted, got %d", got)
+	}
+}
+
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := NewIPRateLimiter(1, time.Minute)
+
+	r := gin.New()
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	protected := r.Group("", APIKeyAuthMiddleware(map[string]string{"alice": "k-alice", "bob": "k-bob"}), limiter.Middleware())
+	protected.GET("/scenes", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(apiKeyIdentityKey))
+	})
+
+	get := func(path, auth string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, auth := range []string{"", "Bearer wrong", "k-alice", "Basic k-alice"} {
+		w := get("/scenes", auth)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"code":"unauthorized"`) {
+			t.Fatalf("expected 401 for %q, got %d %s", auth, w.Code, w.Body.String())
+		}
+	}
+	if w := get("/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected an unguarded route to stay public, got %d", w.Code)
+	}
+
+	// Each key has its own rate limit bucket.
+	for _, tc := range []struct{ auth, identity string }{{"Bearer k-alice", "alice"}, {"Bearer k-bob", "bob"}} {
+		if w := get("/scenes", tc.auth); w.Code != http.StatusOK || w.Body.String() != tc.identity {
+			t.Fatalf("expected %s to pass, got %d %q", tc.identity, w.Code, w.Body.String())
+		}
+	}
+	if w := get("/scenes", "Bearer k-alice"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected alice to be rate limited, got %d", w.Code)
+	}
+}
+
+func TestAPIKeyAuthMiddlewareWithoutKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/scenes", APIKeyAuthMiddleware(nil), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scenes", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected an open API without keys, got %d", w.Code)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serve := func(origins []string, method, origin string) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.Use(CORSMiddleware(origins))
+		r.POST("/scenes", func(c *gin.Context) { c.Status(http.StatusCreated) })
+		req := httptest.NewRequest(method, "/scenes", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	origins := []string{"https://app.example.com/"}
+
+	w := serve(origins, http.MethodOptions, "https://app.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected preflight 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("expected the origin echoed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Fatalf("expected credentials allowed, got %q", got)
+	}
+	headers := w.Header().Get("Access-Control-Allow-Headers")
+	if !strings.Contains(headers, "Authorization") || !strings.Contains(headers, "X-Request-ID") {
+		t.Fatalf("expected Authorization and X-Request-ID allowed, got %q", headers)
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), http.MethodPost) {
+		t.Fatalf("expected POST allowed, got %q", w.Header().Get("Access-Control-Allow-Methods"))
+	}
+
+	w = serve(origins, http.MethodPost, "https://app.example.com")
+	if w.Code != http.StatusCreated || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("expected the request served with CORS headers, got %d %v", w.Code, w.Header())
+	}
+
+	w = serve(origins, http.MethodPost, "https://evil.example.com")
+	if w.Code != http.StatusCreated || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected no CORS headers for another origin, got %d %v", w.Code, w.Header())
+	}
+
+	w = serve([]string{"*"}, http.MethodOptions, "https://any.example.com")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expected a wildcard preflight answer, got %d %v", w.Code, w.Header())
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Fatalf("expected no credentials with a wildcard origin, got %q", got)
+	}
+}
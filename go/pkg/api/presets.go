@@ -0,0 +1,85 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/novelist/novelist/pkg/agents"
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// WithPresets sets the scene presets requests can select with "preset".
+// Names are case-insensitive; presets with invalid values are dropped with
+// a warning.
+func (h *Handler) WithPresets(presets map[string]models.ScenePreset) *Handler {
+	h.presets = make(map[string]models.ScenePreset, len(presets))
+	for name, preset := range presets {
+		name = strings.ToLower(strings.TrimSpace(name))
+		preset = normalizePreset(preset)
+		if err := validatePreset(preset); err != nil {
+			h.logger.Warn().Err(err).Str("preset", name).Msg("Ignoring invalid scene preset")
+			continue
+		}
+		h.presets[name] = preset
+	}
+	return h
+}
+
+func normalizePreset(preset models.ScenePreset) models.ScenePreset {
+	preset.Mood = strings.TrimSpace(preset.Mood)
+	preset.Pacing = strings.ToLower(strings.TrimSpace(preset.Pacing))
+	preset.DialogueRatio = strings.ToLower(strings.TrimSpace(preset.DialogueRatio))
+	return preset
+}
+
+func validatePreset(preset models.ScenePreset) error {
+	if preset.WordCount < 0 || preset.WordCount > 5000 {
+		return errors.New("word_count must be between 0 and 5000")
+	}
+	if !agents.IsValidPacing(preset.Pacing) {
+		return fmt.Errorf("pacing must be one of %s", strings.Join(agents.ScenePacings, ", "))
+	}
+	if !agents.IsValidDialogueRatio(preset.DialogueRatio) {
+		return fmt.Errorf("dialogue_ratio must be one of %s", strings.Join(agents.DialogueRatios, ", "))
+	}
+	return nil
+}
+
+// applyPreset fills the request fields left empty from its preset.
+// Explicit request values always win.
+func (h *Handler) applyPreset(req *models.SceneRequest) error {
+	req.Preset = strings.ToLower(strings.TrimSpace(req.Preset))
+	if req.Preset == "" {
+		return nil
+	}
+	preset, ok := h.presets[req.Preset]
+	if !ok {
+		return fmt.Errorf("unknown preset %q (available: %s)", req.Preset, strings.Join(h.presetNames(), ", "))
+	}
+
+	if strings.TrimSpace(req.Mood) == "" {
+		req.Mood = preset.Mood
+	}
+	if strings.TrimSpace(req.Pacing) == "" {
+		req.Pacing = preset.Pacing
+	}
+	if req.WordCount == 0 {
+		req.WordCount = preset.WordCount
+	}
+	if strings.TrimSpace(req.DialogueRatio) == "" {
+		req.DialogueRatio = preset.DialogueRatio
+	}
+	return nil
+}
+
+// presetNames returns the configured preset names in sorted order.
+func (h *Handler) presetNames() []string {
+	names := make([]string, 0, len(h.presets))
+	for name := range h.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrSceneNotFound is returned by SceneRepository.Get for unknown IDs.
+var ErrSceneNotFound = errors.New("scene not found")
+
+// SceneSummary describes a stored scene without its response.
+type SceneSummary struct {
+	ID        string    `json:"id"`
+	Chapter   int       `json:"chapter"`
+	Scene     int       `json:"scene"`
+	Title     string    `json:"title"`
+	Summary   string    `json:"summary,omitempty"`
+	Chars     int       `json:"chars"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SceneRepository persists generated scenes so they outlive the in-memory
+// SceneStore.
+type SceneRepository interface {
+	// Save stores a scene, replacing any earlier save with the same ID.
+	Save(ctx context.Context, scene *StoredScene) error
+	// Get returns a scene by ID, or ErrSceneNotFound.
+	Get(ctx context.Context, id string) (*StoredScene, error)
+	// ListByChapter summarizes a chapter's scenes ordered by scene number.
+	ListByChapter(ctx context.Context, chapter int) ([]SceneSummary, error)
+	// Delete removes a scene by ID. Unknown IDs are not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// FileSceneRepository keeps each scene as {id}.json under a directory.
+type FileSceneRepository struct {
+	dir    string
+	logger *zerolog.Logger
+	mu     sync.RWMutex
+}
+
+// NewFileSceneRepository creates a repository in dir, created on first
+// save.
+func NewFileSceneRepository(dir string, logger *zerolog.Logger) *FileSceneRepository {
+	return &FileSceneRepository{dir: dir, logger: logger}
+}
+
+func (r *FileSceneRepository) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid scene id %q", id)
+	}
+	return filepath.Join(r.dir, id+".json"), nil
+}
+
+// Save writes the scene through a temporary file, so readers never see a
+// partial one.
+func (r *FileSceneRepository) Save(ctx context.Context, scene *StoredScene) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := r.path(scene.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(scene, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode scene %s: %w", scene.ID, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get reads a scene by ID.
+func (r *FileSceneRepository) Get(ctx context.Context, id string) (*StoredScene, error) {
+	path, err := r.path(id)
+	if err != nil {
+		return nil, ErrSceneNotFound
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return readStoredScene(path)
+}
+
+// Delete removes a scene's file.
+func (r *FileSceneRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := r.path(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ListByChapter reads every stored scene and summarizes the chapter's.
+// Files that can't be read are logged and skipped, so one corrupt scene
+// doesn't hide the rest of the chapter.
+func (r *FileSceneRepository) ListByChapter(ctx context.Context, chapter int) ([]SceneSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	summaries := []SceneSummary{}
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		scene, err := readStoredScene(path)
+		if err != nil {
+			r.logger.Warn().Err(err).Str("file", path).Msg("Skipping unreadable stored scene")
+			continue
+		}
+		if scene.Chapter == chapter {
+			summaries = append(summaries, summarizeScene(scene))
+		}
+	}
+	sortSceneSummaries(summaries)
+	return summaries, nil
+}
+
+func readStoredScene(path string) (*StoredScene, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSceneNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scene StoredScene
+	if err := json.Unmarshal(data, &scene); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &scene, nil
+}
+
+func summarizeScene(scene *StoredScene) SceneSummary {
+	summary := SceneSummary{
+		ID:        scene.ID,
+		Chapter:   scene.Chapter,
+		Scene:     scene.Scene,
+		Title:     scene.Title,
+		CreatedAt: scene.CreatedAt,
+	}
+	if scene.Response != nil {
+		summary.Summary = scene.Response.Summary
+		summary.Chars = scene.Response.Chars
+	}
+	return summary
+}
+
+// sortSceneSummaries orders by scene number, then creation time, then ID.
+func sortSceneSummaries(summaries []SceneSummary) {
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Scene != b.Scene {
+			return a.Scene < b.Scene
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
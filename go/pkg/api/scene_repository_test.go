@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/rs/zerolog"
+)
+
+func TestFileSceneRepository(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	logger := zerolog.Nop()
+	repository := NewFileSceneRepository(dir, &logger)
+	now := time.Now()
+	for _, scene := range []*StoredScene{
+		{ID: "b", Chapter: 1, Scene: 2, CreatedAt: now, Response: &models.SceneResponse{RequestID: "b", Text: "二", Chars: 1}},
+		{ID: "a", Chapter: 1, Scene: 1, CreatedAt: now, Response: &models.SceneResponse{RequestID: "a", Text: "一", Chars: 1}},
+		{ID: "c", Chapter: 2, Scene: 1, CreatedAt: now, Response: &models.SceneResponse{RequestID: "c"}},
+	} {
+		if err := repository.Save(ctx, scene); err != nil {
+			t.Fatalf("save %s: %v", scene.ID, err)
+		}
+	}
+
+	scene, err := repository.Get(ctx, "b")
+	if err != nil || scene.Response.Text != "二" {
+		t.Fatalf("expected scene b, got %+v (err=%v)", scene, err)
+	}
+	for _, id := range []string{"missing", "../b", ""} {
+		if _, err := repository.Get(ctx, id); !errors.Is(err, ErrSceneNotFound) {
+			t.Fatalf("expected ErrSceneNotFound for %q, got %v", id, err)
+		}
+	}
+	if err := repository.Save(ctx, &StoredScene{ID: "../escape"}); err == nil {
+		t.Fatal("expected an ID with a path separator to be rejected")
+	}
+
+	summaries, err := repository.ListByChapter(ctx, 1)
+	if err != nil || len(summaries) != 2 || summaries[0].ID != "a" || summaries[1].ID != "b" || summaries[0].Chars != 1 {
+		t.Fatalf("expected chapter 1's scenes in order, got %+v (err=%v)", summaries, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Delete(ctx, "a"); err != nil {
+		t.Fatalf("delete a: %v", err)
+	}
+	if err := repository.Delete(ctx, "a"); err != nil {
+		t.Fatalf("expected deleting a missing scene to succeed, got %v", err)
+	}
+	summaries, err = repository.ListByChapter(ctx, 1)
+	if err != nil || len(summaries) != 1 || summaries[0].ID != "b" {
+		t.Fatalf("expected the deleted and corrupt scenes left out, got %+v (err=%v)", summaries, err)
+	}
+}
@@ -0,0 +1,438 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/novelist/novelist/pkg/models"
+	"github.com/novelist/novelist/pkg/textutil"
+)
+
+const (
+	defaultSceneStoreCap = 1000
+	snippetRadius        = 60
+)
+
+// StoredScene is a generated scene kept for later lookup.
+type StoredScene struct {
+	ID        string                `json:"id"`
+	Chapter   int                   `json:"chapter"`
+	Scene     int                   `json:"scene"`
+	Title     string                `json:"title"`
+	Metadata  map[string]string     `json:"metadata,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+	Response  *models.SceneResponse `json:"response"`
+}
+
+// SceneSearchQuery describes a full-text search over stored scenes.
+type SceneSearchQuery struct {
+	Text     string
+	Chapter  int
+	Metadata map[string]string
+	Limit    int
+}
+
+// DuplicateSceneError reports a save to a chapter and scene number that
+// another stored scene already holds.
+type DuplicateSceneError struct {
+	Chapter    int
+	Scene      int
+	ExistingID string
+}
+
+func (e *DuplicateSceneError) Error() string {
+	return fmt.Sprintf("chapter %d already has scene %d (%s); pass overwrite=true to replace it or renumber the chapter",
+		e.Chapter, e.Scene, e.ExistingID)
+}
+
+// SceneRenumbering records one scene moved by Renumber.
+type SceneRenumbering struct {
+	ID   string `json:"id"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// sceneSlot is a chapter and scene number.
+type sceneSlot struct{ chapter, scene int }
+
+// SceneStore keeps generated scenes in memory with an inverted index over
+// prose, titles and metadata. The oldest scenes are evicted past capacity.
+type SceneStore struct {
+	mu       sync.RWMutex
+	capacity int
+	order    []string
+	scenes   map[string]*StoredScene
+	index    map[string]map[string]int
+	lengths  map[string]int
+	// slots maps each chapter and scene number to the latest scene saved
+	// there.
+	slots map[sceneSlot]string
+	// unique rejects saves to a slot another scene holds.
+	unique bool
+}
+
+// NewSceneStore creates an in-memory scene store.
+func NewSceneStore(capacity int) *SceneStore {
+	if capacity <= 0 {
+		capacity = defaultSceneStoreCap
+	}
+	return &SceneStore{
+		capacity: capacity,
+		scenes:   make(map[string]*StoredScene),
+		index:    make(map[string]map[string]int),
+		lengths:  make(map[string]int),
+		slots:    make(map[sceneSlot]string),
+	}
+}
+
+// SetUniqueSceneNumbers makes Save reject a chapter and scene number that
+// another stored scene already holds, unless it overwrites.
+func (s *SceneStore) SetUniqueSceneNumbers(unique bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unique = unique
+}
+
+// CheckSlot returns a *DuplicateSceneError when unique scene numbers are
+// enforced and a scene other than id holds chapter and scene.
+func (s *SceneStore) CheckSlot(chapter, scene int, id string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkSlotLocked(sceneSlot{chapter, scene}, id)
+}
+
+func (s *SceneStore) checkSlotLocked(slot sceneSlot, id string) error {
+	if !s.unique {
+		return nil
+	}
+	if existing, ok := s.slots[slot]; ok && existing != id {
+		return &DuplicateSceneError{Chapter: slot.chapter, Scene: slot.scene, ExistingID: existing}
+	}
+	return nil
+}
+
+// Save stores the response of a successful generation and returns the IDs
+// of the scenes it replaced or evicted. With unique scene numbers
+// enforced, a scene already at the same chapter and scene number is a
+// *DuplicateSceneError, or is replaced when overwrite is set.
+func (s *SceneStore) Save(req *models.SceneRequest, resp *models.SceneResponse, overwrite bool) (*StoredScene, []string, error) {
+	scene := &StoredScene{
+		ID:        resp.RequestID,
+		Chapter:   req.Chapter,
+		Scene:     req.Scene,
+		Metadata:  req.Metadata,
+		CreatedAt: resp.Timestamp,
+		Response:  resp,
+	}
+	if resp.SceneSpec != nil {
+		scene.Title = resp.SceneSpec.Scene.Title
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed []string
+	slot := sceneSlot{scene.Chapter, scene.Scene}
+	if err := s.checkSlotLocked(slot, scene.ID); err != nil {
+		if !overwrite {
+			return nil, nil, err
+		}
+		removed = append(removed, s.slots[slot])
+		s.deleteLocked(s.slots[slot])
+	}
+	if _, exists := s.scenes[scene.ID]; exists {
+		s.deleteLocked(scene.ID)
+	}
+	s.scenes[scene.ID] = scene
+	s.order = append(s.order, scene.ID)
+	s.slots[slot] = scene.ID
+	s.indexLocked(scene)
+
+	for len(s.order) > s.capacity {
+		removed = append(removed, s.order[0])
+		s.deleteLocked(s.order[0])
+	}
+	return scene, removed, nil
+}
+
+// Renumber resequences a chapter's scenes as 1..n, ordered by their
+// current scene number, then creation time, then ID, and returns the
+// scenes that moved.
+func (s *SceneStore) Renumber(chapter int) []SceneRenumbering {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var scenes []*StoredScene
+	for _, scene := range s.scenes {
+		if scene.Chapter == chapter {
+			scenes = append(scenes, scene)
+		}
+	}
+	sort.Slice(scenes, func(i, j int) bool {
+		a, b := scenes[i], scenes[j]
+		if a.Scene != b.Scene {
+			return a.Scene < b.Scene
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	moved := []SceneRenumbering{}
+	for _, scene := range scenes {
+		if s.slots[sceneSlot{chapter, scene.Scene}] == scene.ID {
+			delete(s.slots, sceneSlot{chapter, scene.Scene})
+		}
+	}
+	for i, scene := range scenes {
+		number := i + 1
+		if scene.Scene != number {
+			moved = append(moved, SceneRenumbering{ID: scene.ID, From: scene.Scene, To: number})
+			scene.Scene = number
+			if scene.Response != nil && scene.Response.SceneSpec != nil {
+				scene.Response.SceneSpec.Scene.SequenceInChapter = number
+			}
+		}
+		s.slots[sceneSlot{chapter, number}] = scene.ID
+	}
+	return moved
+}
+
+// deleteLocked removes a scene everywhere. Callers hold mu.
+func (s *SceneStore) deleteLocked(id string) {
+	scene, ok := s.scenes[id]
+	if !ok {
+		return
+	}
+	slot := sceneSlot{scene.Chapter, scene.Scene}
+	if s.slots[slot] == id {
+		delete(s.slots, slot)
+	}
+	s.unindexLocked(id)
+	s.removeOrderLocked(id)
+	delete(s.scenes, id)
+}
+
+// Get returns a stored scene by ID.
+func (s *SceneStore) Get(id string) (*StoredScene, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	scene, ok := s.scenes[id]
+	return scene, ok
+}
+
+// ListByChapter summarizes a chapter's stored scenes ordered by scene
+// number.
+func (s *SceneStore) ListByChapter(chapter int) []SceneSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summaries := []SceneSummary{}
+	for _, scene := range s.scenes {
+		if scene.Chapter == chapter {
+			summaries = append(summaries, summarizeScene(scene))
+		}
+	}
+	sortSceneSummaries(summaries)
+	return summaries
+}
+
+// Search ranks stored scenes containing every query term.
+func (s *SceneStore) Search(query SceneSearchQuery) []models.SearchResult {
+	terms := textutil.SearchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scores := make(map[string]float64)
+	for i, term := range terms {
+		postings := s.index[term]
+		next := make(map[string]float64)
+		for id, count := range postings {
+			if i > 0 {
+				if _, ok := scores[id]; !ok {
+					continue
+				}
+			}
+			next[id] = scores[id] + float64(count)
+		}
+		scores = next
+		if len(scores) == 0 {
+			return nil
+		}
+	}
+
+	results := make([]models.SearchResult, 0, len(scores))
+	for id, score := range scores {
+		scene := s.scenes[id]
+		if query.Chapter > 0 && scene.Chapter != query.Chapter {
+			continue
+		}
+		if !metadataMatches(scene.Metadata, query.Metadata) {
+			continue
+		}
+
+		snippet, highlights := searchSnippet(scene.Response.Text, query.Text)
+		results = append(results, models.SearchResult{
+			Document: models.Document{
+				ID:       scene.ID,
+				Content:  snippet,
+				Source:   "scene",
+				DocType:  "scene",
+				Metadata: sceneDocumentMetadata(scene),
+			},
+			Score:      score / math.Sqrt(float64(s.lengths[id]+1)),
+			Highlights: highlights,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Document.ID < results[j].Document.ID
+	})
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+	return results
+}
+
+func (s *SceneStore) indexLocked(scene *StoredScene) {
+	fields := []string{scene.Title}
+	if scene.Response != nil {
+		fields = append(fields, scene.Response.Text)
+	}
+	for _, value := range scene.Metadata {
+		fields = append(fields, value)
+	}
+
+	total := 0
+	for _, field := range fields {
+		for _, term := range textutil.SearchTerms(field) {
+			postings := s.index[term]
+			if postings == nil {
+				postings = make(map[string]int)
+				s.index[term] = postings
+			}
+			postings[scene.ID]++
+			total++
+		}
+	}
+	s.lengths[scene.ID] = total
+}
+
+func (s *SceneStore) unindexLocked(id string) {
+	for term, postings := range s.index {
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(s.index, term)
+		}
+	}
+	delete(s.lengths, id)
+}
+
+func (s *SceneStore) removeOrderLocked(id string) {
+	for i, existing := range s.order {
+		if existing == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func metadataMatches(metadata, filter map[string]string) bool {
+	for key, value := range filter {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sceneDocumentMetadata(scene *StoredScene) map[string]string {
+	metadata := map[string]string{
+		"chapter": strconv.Itoa(scene.Chapter),
+		"scene":   strconv.Itoa(scene.Scene),
+		"title":   scene.Title,
+	}
+	for key, value := range scene.Metadata {
+		if _, reserved := metadata[key]; !reserved {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// searchSnippet returns a window of text around the first match of any query
+// word, with rune-offset highlights of every match inside that window.
+func searchSnippet(text, query string) (string, []models.Highlight) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	needles := strings.Fields(strings.ToLower(query))
+
+	first := -1
+	for _, needle := range needles {
+		if idx := runeIndex(lower, []rune(needle), 0); idx >= 0 && (first == -1 || idx < first) {
+			first = idx
+		}
+	}
+	if first == -1 {
+		first = 0
+	}
+
+	start := first - snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	end := first + snippetRadius
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var highlights []models.Highlight
+	for _, needle := range needles {
+		n := []rune(needle)
+		for idx := runeIndex(lower[:end], n, start); idx >= 0; idx = runeIndex(lower[:end], n, idx+len(n)) {
+			highlights = append(highlights, models.Highlight{Start: idx - start, End: idx - start + len(n)})
+		}
+	}
+	sort.Slice(highlights, func(i, j int) bool { return highlights[i].Start < highlights[j].Start })
+
+	return string(runes[start:end]), highlights
+}
+
+func runeIndex(haystack, needle []rune, from int) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for i := from; i+len(needle) <= len(haystack); i++ {
+		match := true
+		for j, r := range needle {
+			if haystack[i+j] != r {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestSceneStoreSearch(t *testing.T) {
+	store := NewSceneStore(10)
+	save := func(id string, chapter int, text string, metadata map[string]string) {
+		store.Save(
+			&models.SceneRequest{Chapter: chapter, Scene: 1, Metadata: metadata},
+			&models.SceneResponse{RequestID: id, Timestamp: time.Now(), Text: text},
+			false,
+		)
+	}
+	save("a", 1, "古い図書館で魔法の書を見つけた。", map[string]string{"author_id": "u1"})
+	save("b", 2, "彼女は魔法の書を閉じ、図書館を後にした。魔法の書は重かった。", map[string]string{"author_id": "u2"})
+	save("c", 2, "The dragon slept in the library.", nil)
+
+	results := store.Search(SceneSearchQuery{Text: "魔法の書"})
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Rank != 1 || results[1].Rank != 2 {
+		t.Fatalf("expected ranks to be assigned, got %+v", results)
+	}
+	if len(results[0].Highlights) == 0 {
+		t.Fatal("expected highlights in snippet")
+	}
+	hl := results[0].Highlights[0]
+	if got := string([]rune(results[0].Document.Content)[hl.Start:hl.End]); got != "魔法の書" {
+		t.Fatalf("expected highlight on match, got %q", got)
+	}
+
+	if got := store.Search(SceneSearchQuery{Text: "魔法の書", Chapter: 1}); len(got) != 1 || got[0].Document.ID != "a" {
+		t.Fatalf("expected chapter filter to keep scene a, got %+v", got)
+	}
+	if got := store.Search(SceneSearchQuery{Text: "魔法", Metadata: map[string]string{"author_id": "u2"}}); len(got) != 1 || got[0].Document.ID != "b" {
+		t.Fatalf("expected metadata filter to keep scene b, got %+v", got)
+	}
+	if got := store.Search(SceneSearchQuery{Text: "DRAGON library"}); len(got) != 1 || got[0].Document.ID != "c" {
+		t.Fatalf("expected case-insensitive word match, got %+v", got)
+	}
+	if got := store.Search(SceneSearchQuery{Text: "魔法", Limit: 1}); len(got) != 1 {
+		t.Fatalf("expected limit to apply, got %d", len(got))
+	}
+}
+
+func TestSceneStoreEvictsOldest(t *testing.T) {
+	store := NewSceneStore(1)
+	store.Save(&models.SceneRequest{}, &models.SceneResponse{RequestID: "old", Text: "alpha"}, false)
+	_, removed, _ := store.Save(&models.SceneRequest{}, &models.SceneResponse{RequestID: "new", Text: "beta"}, false)
+
+	if len(removed) != 1 || removed[0] != "old" {
+		t.Fatalf("expected the evicted scene reported, got %v", removed)
+	}
+	if _, ok := store.Get("old"); ok {
+		t.Fatal("expected oldest scene to be evicted")
+	}
+	if got := store.Search(SceneSearchQuery{Text: "alpha"}); len(got) != 0 {
+		t.Fatalf("expected evicted scene to leave the index, got %+v", got)
+	}
+}
+
+func TestSceneStoreUniqueSceneNumbers(t *testing.T) {
+	store := NewSceneStore(10)
+	store.SetUniqueSceneNumbers(true)
+	save := func(id string, scene int, overwrite bool) error {
+		_, _, err := store.Save(
+			&models.SceneRequest{Chapter: 1, Scene: scene},
+			&models.SceneResponse{RequestID: id, Text: id},
+			overwrite,
+		)
+		return err
+	}
+
+	if err := save("first", 3, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := save("second", 3, false)
+	var duplicate *DuplicateSceneError
+	if !errors.As(err, &duplicate) || duplicate.ExistingID != "first" || duplicate.Scene != 3 {
+		t.Fatalf("expected a duplicate scene error naming first, got %v", err)
+	}
+	if err := store.CheckSlot(1, 3, "first"); err != nil {
+		t.Fatalf("expected a scene to be free to replace itself, got %v", err)
+	}
+
+	if err := save("second", 3, true); err != nil {
+		t.Fatalf("expected overwrite to replace the scene, got %v", err)
+	}
+	if _, ok := store.Get("first"); ok {
+		t.Fatal("expected the overwritten scene to be removed")
+	}
+}
+
+func TestSceneStoreRenumber(t *testing.T) {
+	store := NewSceneStore(10)
+	base := time.Unix(1_700_000_000, 0)
+	for i, entry := range []struct {
+		id    string
+		scene int
+	}{{"c", 7}, {"a", 2}, {"b", 2}, {"d", 4}} {
+		spec := &models.SceneSpec{}
+		spec.Scene.SequenceInChapter = entry.scene
+		store.Save(
+			&models.SceneRequest{Chapter: 1, Scene: entry.scene},
+			&models.SceneResponse{RequestID: entry.id, Timestamp: base.Add(time.Duration(i) * time.Second), SceneSpec: spec},
+			false,
+		)
+	}
+	store.Save(&models.SceneRequest{Chapter: 2, Scene: 5}, &models.SceneResponse{RequestID: "other"}, false)
+
+	moved := store.Renumber(1)
+	want := map[string]int{"a": 1, "b": 2, "d": 3, "c": 4}
+	for id, number := range want {
+		scene, _ := store.Get(id)
+		if scene.Scene != number || scene.Response.SceneSpec.Scene.SequenceInChapter != number {
+			t.Fatalf("expected %s renumbered to %d, got %d", id, number, scene.Scene)
+		}
+	}
+	if len(moved) != 3 {
+		t.Fatalf("expected 3 scenes moved, got %+v", moved)
+	}
+	if other, _ := store.Get("other"); other.Scene != 5 {
+		t.Fatalf("expected other chapters untouched, got %d", other.Scene)
+	}
+
+	store.SetUniqueSceneNumbers(true)
+	if err := store.CheckSlot(1, 5, "new"); err != nil {
+		t.Fatalf("expected slot 5 free after renumbering, got %v", err)
+	}
+	if err := store.CheckSlot(1, 4, "new"); err == nil {
+		t.Fatal("expected slot 4 taken after renumbering")
+	}
+}
@@ -12,9 +12,101 @@ type StatsStore struct {
 	startedAt    time.Time
 	totalCount   int64
 	inFlight     int64
+	panics       int64
 	statusCounts map[int]int64
-	latencies    []time.Duration
-	latencyCap   int
+	latencies    *latencyWindow
+	endpoints    map[string]*endpointStats
+	gauges       map[string]func() int
+	rates        map[string]func() float64
+	usage        usageStats
+}
+
+const (
+	latencyWindowSize         = 4096
+	endpointLatencyWindowSize = 1024
+	// unmatchedRoute keys requests that matched no route, so arbitrary
+	// 404 paths cannot grow the endpoint map.
+	unmatchedRoute = "(unmatched)"
+)
+
+// endpointStats are the counters kept per route.
+type endpointStats struct {
+	total        int64
+	statusCounts map[int]int64
+	latencies    *latencyWindow
+}
+
+// EndpointStats is one route's share of a StatsSnapshot.
+type EndpointStats struct {
+	RequestsTotal int64         `json:"requests_total"`
+	StatusCounts  map[int]int64 `json:"status_counts"`
+	LatencyMsP50  float64       `json:"latency_ms_p50"`
+	LatencyMsP95  float64       `json:"latency_ms_p95"`
+}
+
+// TokenUsage counts the LLM tokens spent by generations.
+type TokenUsage struct {
+	Generations      int64 `json:"generations"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+func (u *TokenUsage) add(promptTokens, completionTokens int) {
+	u.Generations++
+	u.PromptTokens += int64(promptTokens)
+	u.CompletionTokens += int64(completionTokens)
+}
+
+// UsageStats is the token usage share of a StatsSnapshot: the totals and
+// their breakdown by provider and by agent.
+type UsageStats struct {
+	TokenUsage
+	Providers map[string]TokenUsage `json:"providers,omitempty"`
+	Agents    map[string]TokenUsage `json:"agents,omitempty"`
+}
+
+// usageStats accumulates UsageStats.
+type usageStats struct {
+	total     TokenUsage
+	providers map[string]*TokenUsage
+	agents    map[string]*TokenUsage
+}
+
+func (u *usageStats) snapshot() UsageStats {
+	snapshot := UsageStats{TokenUsage: u.total}
+	if len(u.providers) > 0 {
+		snapshot.Providers = copyTokenUsage(u.providers)
+		snapshot.Agents = copyTokenUsage(u.agents)
+	}
+	return snapshot
+}
+
+func copyTokenUsage(usage map[string]*TokenUsage) map[string]TokenUsage {
+	copied := make(map[string]TokenUsage, len(usage))
+	for name, u := range usage {
+		copied[name] = *u
+	}
+	return copied
+}
+
+// latencyWindow is a ring buffer of the most recent latencies.
+type latencyWindow struct {
+	values []time.Duration
+	next   int
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{values: make([]time.Duration, 0, size)}
+}
+
+// add records latency, overwriting the oldest value once full.
+func (w *latencyWindow) add(latency time.Duration) {
+	if len(w.values) < cap(w.values) {
+		w.values = append(w.values, latency)
+		return
+	}
+	w.values[w.next] = latency
+	w.next = (w.next + 1) % len(w.values)
 }
 
 // StatsSnapshot contains immutable stats values for API responses.
@@ -23,9 +115,16 @@ type StatsSnapshot struct {
 	RequestsTotal     int64         `json:"requests_total"`
 	RequestsPerMinute float64       `json:"requests_per_minute"`
 	InFlight          int64         `json:"in_flight"`
+	PanicsTotal       int64         `json:"panics_total"`
 	StatusCounts      map[int]int64 `json:"status_counts"`
 	LatencyMsP50      float64       `json:"latency_ms_p50"`
 	LatencyMsP95      float64       `json:"latency_ms_p95"`
+	// Endpoints breaks the counts and latencies down by route.
+	Endpoints map[string]EndpointStats `json:"endpoints,omitempty"`
+	Gauges    map[string]int           `json:"gauges,omitempty"`
+	Rates     map[string]float64       `json:"rates,omitempty"`
+	// Usage is the LLM token usage since start.
+	Usage UsageStats `json:"usage"`
 }
 
 // NewStatsStore creates a new StatsStore.
@@ -33,10 +132,32 @@ func NewStatsStore() *StatsStore {
 	return &StatsStore{
 		startedAt:    time.Now(),
 		statusCounts: make(map[int]int64),
-		latencyCap:   4096,
+		latencies:    newLatencyWindow(latencyWindowSize),
+		endpoints:    make(map[string]*endpointStats),
+		gauges:       make(map[string]func() int),
+		rates:        make(map[string]func() float64),
+		usage: usageStats{
+			providers: make(map[string]*TokenUsage),
+			agents:    make(map[string]*TokenUsage),
+		},
 	}
 }
 
+// RegisterGauge adds a named point-in-time value reported in snapshots.
+func (s *StatsStore) RegisterGauge(name string, fn func() int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gauges[name] = fn
+}
+
+// RegisterRate adds a named ratio, such as a cache hit rate, reported in
+// snapshots.
+func (s *StatsStore) RegisterRate(name string, fn func() float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rates[name] = fn
+}
+
 // BeginRequest marks request start.
 func (s *StatsStore) BeginRequest() {
 	s.mu.Lock()
@@ -44,8 +165,9 @@ func (s *StatsStore) BeginRequest() {
 	s.inFlight++
 }
 
-// EndRequest records request completion.
-func (s *StatsStore) EndRequest(statusCode int, latency time.Duration) {
+// EndRequest records request completion for route, the matched route
+// pattern ("" when none matched).
+func (s *StatsStore) EndRequest(route string, statusCode int, latency time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.inFlight > 0 {
@@ -57,12 +179,54 @@ func (s *StatsStore) EndRequest(statusCode int, latency time.Duration) {
 	if latency < 0 {
 		latency = 0
 	}
-	if len(s.latencies) >= s.latencyCap {
-		copy(s.latencies, s.latencies[1:])
-		s.latencies[len(s.latencies)-1] = latency
-	} else {
-		s.latencies = append(s.latencies, latency)
+	s.latencies.add(latency)
+
+	if route == "" {
+		route = unmatchedRoute
+	}
+	endpoint, ok := s.endpoints[route]
+	if !ok {
+		endpoint = &endpointStats{
+			statusCounts: make(map[int]int64),
+			latencies:    newLatencyWindow(endpointLatencyWindowSize),
+		}
+		s.endpoints[route] = endpoint
 	}
+	endpoint.total++
+	endpoint.statusCounts[statusCode]++
+	endpoint.latencies.add(latency)
+}
+
+// InFlight returns the number of requests currently being served.
+func (s *StatsStore) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.inFlight)
+}
+
+// RecordPanic counts a recovered panic.
+func (s *StatsStore) RecordPanic() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panics++
+}
+
+// RecordUsage counts the tokens of one generation by agent on provider.
+func (s *StatsStore) RecordUsage(provider, agent string, promptTokens, completionTokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage.total.add(promptTokens, completionTokens)
+	addTokenUsage(s.usage.providers, provider, promptTokens, completionTokens)
+	addTokenUsage(s.usage.agents, agent, promptTokens, completionTokens)
+}
+
+func addTokenUsage(usage map[string]*TokenUsage, name string, promptTokens, completionTokens int) {
+	u, ok := usage[name]
+	if !ok {
+		u = &TokenUsage{}
+		usage[name] = u
+	}
+	u.add(promptTokens, completionTokens)
 }
 
 // Snapshot returns current stats snapshot.
@@ -81,14 +245,52 @@ func (s *StatsStore) Snapshot() StatsSnapshot {
 		status[code] = count
 	}
 
+	var endpoints map[string]EndpointStats
+	if len(s.endpoints) > 0 {
+		endpoints = make(map[string]EndpointStats, len(s.endpoints))
+		for route, endpoint := range s.endpoints {
+			counts := make(map[int]int64, len(endpoint.statusCounts))
+			for code, count := range endpoint.statusCounts {
+				counts[code] = count
+			}
+			endpoints[route] = EndpointStats{
+				RequestsTotal: endpoint.total,
+				StatusCounts:  counts,
+				LatencyMsP50:  durationPercentileMs(endpoint.latencies.values, 0.50),
+				LatencyMsP95:  durationPercentileMs(endpoint.latencies.values, 0.95),
+			}
+		}
+	}
+
+	var gauges map[string]int
+	if len(s.gauges) > 0 {
+		gauges = make(map[string]int, len(s.gauges))
+		for name, fn := range s.gauges {
+			gauges[name] = fn()
+		}
+	}
+
+	var rates map[string]float64
+	if len(s.rates) > 0 {
+		rates = make(map[string]float64, len(s.rates))
+		for name, fn := range s.rates {
+			rates[name] = fn()
+		}
+	}
+
 	return StatsSnapshot{
 		StartedAt:         s.startedAt,
 		RequestsTotal:     s.totalCount,
 		RequestsPerMinute: perMinute,
 		InFlight:          s.inFlight,
+		PanicsTotal:       s.panics,
 		StatusCounts:      status,
-		LatencyMsP50:      durationPercentileMs(s.latencies, 0.50),
-		LatencyMsP95:      durationPercentileMs(s.latencies, 0.95),
+		LatencyMsP50:      durationPercentileMs(s.latencies.values, 0.50),
+		LatencyMsP95:      durationPercentileMs(s.latencies.values, 0.95),
+		Endpoints:         endpoints,
+		Gauges:            gauges,
+		Rates:             rates,
+		Usage:             s.usage.snapshot(),
 	}
 }
 
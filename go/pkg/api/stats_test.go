@@ -8,9 +8,9 @@ import (
 func TestStatsStoreSnapshot(t *testing.T) {
 	stats := NewStatsStore()
 	stats.BeginRequest()
-	stats.EndRequest(200, 10*time.Millisecond)
+	stats.EndRequest("/api/v1/scenes", 200, 10*time.Millisecond)
 	stats.BeginRequest()
-	stats.EndRequest(429, 30*time.Millisecond)
+	stats.EndRequest("/api/v1/scenes", 429, 30*time.Millisecond)
 
 	snapshot := stats.Snapshot()
 	if snapshot.RequestsTotal != 2 {
@@ -29,3 +29,67 @@ func TestStatsStoreSnapshot(t *testing.T) {
 		t.Fatalf("expected latency p95 > 0, got %f", snapshot.LatencyMsP95)
 	}
 }
+
+func TestStatsStoreEndpoints(t *testing.T) {
+	stats := NewStatsStore()
+	for i := 0; i < endpointLatencyWindowSize+10; i++ {
+		stats.BeginRequest()
+		stats.EndRequest("/api/v1/scenes", 200, time.Second)
+	}
+	stats.BeginRequest()
+	stats.EndRequest("/health", 200, time.Millisecond)
+	stats.BeginRequest()
+	stats.EndRequest("", 404, time.Millisecond)
+
+	snapshot := stats.Snapshot()
+	scenes := snapshot.Endpoints["/api/v1/scenes"]
+	if scenes.RequestsTotal != endpointLatencyWindowSize+10 || scenes.LatencyMsP50 != 1000 {
+		t.Fatalf("unexpected scene stats %+v", scenes)
+	}
+	if health := snapshot.Endpoints["/health"]; health.RequestsTotal != 1 || health.LatencyMsP95 != 1 {
+		t.Fatalf("unexpected health stats %+v", health)
+	}
+	if unmatched := snapshot.Endpoints[unmatchedRoute]; unmatched.StatusCounts[404] != 1 {
+		t.Fatalf("expected unmatched requests grouped, got %+v", snapshot.Endpoints)
+	}
+	if got := len(stats.endpoints["/api/v1/scenes"].latencies.values); got != endpointLatencyWindowSize {
+		t.Fatalf("expected the latency window capped at %d, got %d", endpointLatencyWindowSize, got)
+	}
+	if snapshot.RequestsTotal != endpointLatencyWindowSize+12 {
+		t.Fatalf("expected aggregate totals kept, got %d", snapshot.RequestsTotal)
+	}
+}
+
+func TestStatsStoreRates(t *testing.T) {
+	stats := NewStatsStore()
+	if snapshot := stats.Snapshot(); snapshot.Rates != nil {
+		t.Fatalf("expected no rates by default, got %v", snapshot.Rates)
+	}
+
+	stats.RegisterRate("response_cache_hit_rate", func() float64 { return 0.25 })
+	if got := stats.Snapshot().Rates["response_cache_hit_rate"]; got != 0.25 {
+		t.Fatalf("expected registered rate 0.25, got %f", got)
+	}
+}
+
+func TestStatsStoreUsage(t *testing.T) {
+	stats := NewStatsStore()
+	if usage := stats.Snapshot().Usage; usage.Generations != 0 || usage.Providers != nil {
+		t.Fatalf("expected no usage by default, got %+v", usage)
+	}
+
+	stats.RecordUsage("openai", "writer", 1200, 800)
+	stats.RecordUsage("openai", "checker", 900, 100)
+	stats.RecordUsage("ollama", "director", 300, 200)
+
+	usage := stats.Snapshot().Usage
+	if usage.Generations != 3 || usage.PromptTokens != 2400 || usage.CompletionTokens != 1100 {
+		t.Fatalf("unexpected totals: %+v", usage.TokenUsage)
+	}
+	if got := usage.Providers["openai"]; got != (TokenUsage{Generations: 2, PromptTokens: 2100, CompletionTokens: 900}) {
+		t.Fatalf("unexpected openai usage: %+v", got)
+	}
+	if got := usage.Agents["director"]; got != (TokenUsage{Generations: 1, PromptTokens: 300, CompletionTokens: 200}) {
+		t.Fatalf("unexpected director usage: %+v", got)
+	}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scene stream event names.
+const (
+	streamEventToken  = "token"
+	streamEventResult = "result"
+	streamEventError  = "error"
+)
+
+// StreamEvent is one server-sent event emitted for a stream.
+type StreamEvent struct {
+	ID    int
+	Event string
+	Data  string
+}
+
+type streamLog struct {
+	events    []StreamEvent
+	nextID    int
+	done      bool
+	expiresAt time.Time
+	changed   chan struct{}
+}
+
+// StreamReplayBuffer keeps recently emitted stream events per stream ID so a
+// client reconnecting with Last-Event-ID can resume instead of regenerating.
+// Streams are kept for ttl after their last event.
+type StreamReplayBuffer struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	maxEvents int
+	streams   map[string]*streamLog
+}
+
+// NewStreamReplayBuffer creates a replay buffer.
+func NewStreamReplayBuffer(ttl time.Duration, maxEvents int) *StreamReplayBuffer {
+	if ttl <= 0 {
+		ttl = 2 * time.Minute
+	}
+	if maxEvents <= 0 {
+		maxEvents = 4096
+	}
+	return &StreamReplayBuffer{
+		ttl:       ttl,
+		maxEvents: maxEvents,
+		streams:   make(map[string]*streamLog),
+	}
+}
+
+// Open registers streamID before its first event, so readers can wait on
+// it. It reports false when the stream already exists.
+func (b *StreamReplayBuffer) Open(streamID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.sweepLocked(now)
+	if _, ok := b.streams[streamID]; ok {
+		return false
+	}
+	b.streams[streamID] = &streamLog{nextID: 1, expiresAt: now.Add(b.ttl), changed: make(chan struct{})}
+	return true
+}
+
+// Append records an event for streamID and wakes up waiting readers.
+func (b *StreamReplayBuffer) Append(streamID, event, data string) StreamEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.sweepLocked(now)
+
+	log := b.streams[streamID]
+	if log == nil {
+		log = &streamLog{nextID: 1, changed: make(chan struct{})}
+		b.streams[streamID] = log
+	}
+
+	ev := StreamEvent{ID: log.nextID, Event: event, Data: data}
+	log.nextID++
+	log.events = append(log.events, ev)
+	if len(log.events) > b.maxEvents {
+		log.events = log.events[len(log.events)-b.maxEvents:]
+	}
+	log.expiresAt = now.Add(b.ttl)
+	close(log.changed)
+	log.changed = make(chan struct{})
+
+	return ev
+}
+
+// Finish marks streamID as complete so readers stop waiting for more events.
+func (b *StreamReplayBuffer) Finish(streamID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log := b.streams[streamID]
+	if log == nil || log.done {
+		return
+	}
+	log.done = true
+	log.expiresAt = time.Now().Add(b.ttl)
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+// Since returns the buffered events after afterID, whether the stream is
+// finished, and a channel closed when the stream changes next. ok is false
+// when the stream is unknown or has expired.
+func (b *StreamReplayBuffer) Since(streamID string, afterID int) (events []StreamEvent, done bool, changed <-chan struct{}, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweepLocked(time.Now())
+
+	log := b.streams[streamID]
+	if log == nil {
+		return nil, false, nil, false
+	}
+	for _, ev := range log.events {
+		if ev.ID > afterID {
+			events = append(events, ev)
+		}
+	}
+	return events, log.done, log.changed, true
+}
+
+// Has reports whether a stream is currently buffered.
+func (b *StreamReplayBuffer) Has(streamID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweepLocked(time.Now())
+	_, ok := b.streams[streamID]
+	return ok
+}
+
+func (b *StreamReplayBuffer) sweepLocked(now time.Time) {
+	for id, log := range b.streams {
+		if now.After(log.expiresAt) {
+			delete(b.streams, id)
+		}
+	}
+}
+
+// parseLastEventID parses a Last-Event-ID header value.
+func parseLastEventID(raw string) (int, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, false
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// writeStream sends the events of streamID after afterID as server-sent
+// events until the stream finishes. With finished set, the caller owns the
+// generation: writing continues until it ends, even if the client left, so
+// the request holds its slot for as long as the work runs. A resuming
+// reader (finished nil) stops when its client disconnects.
+func (h *Handler) writeStream(c *gin.Context, streamID string, afterID int, finished <-chan struct{}) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	var gone <-chan struct{}
+	if finished == nil {
+		gone = c.Request.Context().Done()
+	}
+	for {
+		events, done, changed, ok := h.streams.Since(streamID, afterID)
+		if !ok {
+			break
+		}
+		for _, ev := range events {
+			writeStreamEvent(c.Writer, ev)
+			afterID = ev.ID
+		}
+		c.Writer.Flush()
+		if done {
+			break
+		}
+		select {
+		case <-changed:
+			continue
+		case <-gone:
+		}
+		break
+	}
+	if finished != nil {
+		<-finished
+	}
+}
+
+// writeStreamEvent writes ev in the text/event-stream format.
+func writeStreamEvent(w io.Writer, ev StreamEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\n", ev.ID, ev.Event)
+	for _, line := range strings.Split(ev.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// eventData encodes v as an event's JSON data.
+func eventData(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(data)
+}
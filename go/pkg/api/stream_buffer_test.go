@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamReplayBufferResumesAfterLastEventID(t *testing.T) {
+	buffer := NewStreamReplayBuffer(time.Minute, 10)
+	buffer.Append("req-1", "chunk", "a")
+	buffer.Append("req-1", "chunk", "b")
+	buffer.Append("req-1", "chunk", "c")
+
+	lastID, ok := parseLastEventID("1")
+	if !ok {
+		t.Fatal("expected Last-Event-ID to parse")
+	}
+
+	events, done, changed, ok := buffer.Since("req-1", lastID)
+	if !ok || done {
+		t.Fatalf("expected open buffered stream, got ok=%v done=%v", ok, done)
+	}
+	if len(events) != 2 || events[0].Data != "b" || events[1].ID != 3 {
+		t.Fatalf("unexpected replay %+v", events)
+	}
+
+	buffer.Finish("req-1")
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected waiters to be notified on finish")
+	}
+
+	if _, done, _, _ := buffer.Since("req-1", 3); !done {
+		t.Fatal("expected stream to be finished")
+	}
+	if _, _, _, ok := buffer.Since("unknown", 0); ok {
+		t.Fatal("expected unknown stream to be missing")
+	}
+}
+
+func TestStreamReplayBufferExpiresStreams(t *testing.T) {
+	buffer := NewStreamReplayBuffer(time.Minute, 10)
+	buffer.Append("req-1", "chunk", "a")
+
+	buffer.mu.Lock()
+	buffer.sweepLocked(time.Now().Add(2 * time.Minute))
+	buffer.mu.Unlock()
+
+	if buffer.Has("req-1") {
+		t.Fatal("expected expired stream to be swept")
+	}
+}
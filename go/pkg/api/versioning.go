@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/novelist/novelist/pkg/models"
+)
+
+// Scene response schema versions. v1 is the original shape with the spec
+// under "scenespec"; v2 uses "scene_spec" like the request side does.
+const (
+	SchemaV1 = "1"
+	SchemaV2 = "2"
+
+	defaultSchemaVersion = SchemaV1
+)
+
+// SchemaVersions lists the accepted Accept-Version values, oldest first.
+var SchemaVersions = []string{SchemaV1, SchemaV2}
+
+var sceneResponseEncoders = map[string]func(*models.SceneResponse) interface{}{
+	SchemaV1: encodeSceneResponseV1,
+	SchemaV2: encodeSceneResponseV2,
+}
+
+// sceneResponseV2 renames the spec field. The nil LegacySpec at the outer
+// level hides the embedded "scenespec" field from encoding/json.
+type sceneResponseV2 struct {
+	*models.SceneResponse
+	LegacySpec *models.SceneSpec `json:"scenespec,omitempty"`
+	SceneSpec  *models.SceneSpec `json:"scene_spec,omitempty"`
+}
+
+func encodeSceneResponseV1(resp *models.SceneResponse) interface{} {
+	return resp
+}
+
+func encodeSceneResponseV2(resp *models.SceneResponse) interface{} {
+	return sceneResponseV2{SceneResponse: resp, SceneSpec: resp.SceneSpec}
+}
+
+// normalizeSchemaVersion accepts "2", "v2" and "V2" alike. It returns false
+// for unknown versions.
+func normalizeSchemaVersion(raw string) (string, bool) {
+	version := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(raw)), "v")
+	_, ok := sceneResponseEncoders[version]
+	return version, ok
+}
+
+// IsSupportedSchemaVersion reports whether version names a known scene
+// response schema.
+func IsSupportedSchemaVersion(version string) bool {
+	_, ok := normalizeSchemaVersion(version)
+	return ok
+}
+
+// negotiateSchemaVersion picks the response version from the Accept-Version
+// header, falling back to fallback when the header is absent.
+func negotiateSchemaVersion(c *gin.Context, fallback string) (string, error) {
+	raw := c.GetHeader("Accept-Version")
+	if raw == "" {
+		return fallback, nil
+	}
+	version, ok := normalizeSchemaVersion(raw)
+	if !ok {
+		return "", fmt.Errorf("unsupported Accept-Version %q (supported: %s)", raw, strings.Join(SchemaVersions, ", "))
+	}
+	return version, nil
+}
+
+// encodeSceneResponse stamps resp with version and maps it to that version's
+// wire shape. resp itself keeps the v1 field layout.
+func encodeSceneResponse(resp *models.SceneResponse, version string) interface{} {
+	stamped := *resp
+	stamped.SchemaVersion = version
+	return sceneResponseEncoders[version](&stamped)
+}
@@ -0,0 +1,159 @@
+// Package apierr defines the API's error codes, their HTTP statuses, and
+// the helper every handler and middleware uses to write an error response.
+package apierr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/novelist/novelist/pkg/agents"
+)
+
+// Code is a machine-readable error code returned in the "code" field.
+type Code string
+
+const (
+	InvalidRequest          Code = "invalid_request"
+	PayloadTooLarge         Code = "payload_too_large"
+	UnsupportedVersion      Code = "unsupported_version"
+	Unauthorized            Code = "unauthorized"
+	Forbidden               Code = "forbidden"
+	NotFound                Code = "not_found"
+	InvalidProviderOverride Code = "invalid_provider_override"
+	SceneConflict           Code = "scene_conflict"
+	ContentFlagged          Code = "content_flagged"
+	RequestTimeout          Code = "request_timeout"
+	TooManyRequests         Code = "too_many_requests"
+	RateLimitExceeded       Code = "rate_limit_exceeded"
+	ProviderRateLimited     Code = "provider_rate_limited"
+	ProviderAuthFailed      Code = "provider_auth_failed"
+	ModelUnavailable        Code = "model_unavailable"
+	ProviderError           Code = "provider_error"
+	GenerationFailed        Code = "generation_failed"
+	InternalError           Code = "internal_error"
+)
+
+// statuses maps every code to its HTTP status. A code missing here is a
+// bug; TestEveryCodeHasStatus guards it.
+var statuses = map[Code]int{
+	InvalidRequest:          http.StatusBadRequest,
+	PayloadTooLarge:         http.StatusRequestEntityTooLarge,
+	UnsupportedVersion:      http.StatusNotAcceptable,
+	Unauthorized:            http.StatusUnauthorized,
+	Forbidden:               http.StatusForbidden,
+	NotFound:                http.StatusNotFound,
+	InvalidProviderOverride: http.StatusBadRequest,
+	SceneConflict:           http.StatusConflict,
+	ContentFlagged:          http.StatusUnprocessableEntity,
+	RequestTimeout:          http.StatusRequestTimeout,
+	TooManyRequests:         http.StatusTooManyRequests,
+	RateLimitExceeded:       http.StatusTooManyRequests,
+	ProviderRateLimited:     http.StatusServiceUnavailable,
+	ProviderAuthFailed:      http.StatusBadGateway,
+	ModelUnavailable:        http.StatusServiceUnavailable,
+	ProviderError:           http.StatusBadGateway,
+	GenerationFailed:        http.StatusInternalServerError,
+	InternalError:           http.StatusInternalServerError,
+}
+
+// Codes lists every code, in declaration order.
+var Codes = []Code{
+	InvalidRequest,
+	PayloadTooLarge,
+	UnsupportedVersion,
+	Unauthorized,
+	Forbidden,
+	NotFound,
+	InvalidProviderOverride,
+	SceneConflict,
+	ContentFlagged,
+	RequestTimeout,
+	TooManyRequests,
+	RateLimitExceeded,
+	ProviderRateLimited,
+	ProviderAuthFailed,
+	ModelUnavailable,
+	ProviderError,
+	GenerationFailed,
+	InternalError,
+}
+
+// Status returns the HTTP status for c, or 500 for an unknown code.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error tagged with the code it should be reported as.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+// New returns an Error with the given code and message.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Err: errors.New(message)}
+}
+
+// Wrap tags err with code.
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// CodeOf classifies err. An oversized request body is always
+// payload_too_large; otherwise errors tagged with Wrap or New keep their
+// code, known agent errors map to their own codes, and anything else is
+// generation_failed.
+func CodeOf(err error) Code {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || strings.Contains(err.Error(), "http: request body too large") {
+		return PayloadTooLarge
+	}
+	var tagged *Error
+	if errors.As(err, &tagged) {
+		return tagged.Code
+	}
+	var moderationErr *agents.ModerationError
+	if errors.As(err, &moderationErr) {
+		return ContentFlagged
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, agents.ErrInsufficientTimeRemaining) {
+		return RequestTimeout
+	}
+	if errors.Is(err, agents.ErrModelUnavailable) {
+		return ModelUnavailable
+	}
+	if agents.IsRateLimited(err) {
+		return ProviderRateLimited
+	}
+	if agents.IsAuthError(err) {
+		return ProviderAuthFailed
+	}
+	var httpErr *agents.ProviderHTTPError
+	if errors.As(err, &httpErr) {
+		return ProviderError
+	}
+	return GenerationFailed
+}
+
+// RespondError aborts the request with err's code, status and message.
+// The request ID is included when RequestIDMiddleware has set one.
+func RespondError(c *gin.Context, err error) {
+	code := CodeOf(err)
+	body := gin.H{
+		"error": err.Error(),
+		"code":  code,
+	}
+	if requestID := c.GetString("request_id"); requestID != "" {
+		body["request_id"] = requestID
+	}
+	c.AbortWithStatusJSON(code.Status(), body)
+}
@@ -0,0 +1,72 @@
+package apierr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/novelist/novelist/pkg/agents"
+)
+
+func TestEveryCodeHasStatus(t *testing.T) {
+	for _, code := range Codes {
+		if _, ok := statuses[code]; !ok {
+			t.Errorf("code %q has no status", code)
+		}
+	}
+	if len(statuses) != len(Codes) {
+		t.Fatalf("statuses has %d entries, Codes has %d", len(statuses), len(Codes))
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{"tagged", Wrap(InvalidRequest, errors.New("bad")), InvalidRequest},
+		{"moderation", fmt.Errorf("writer: %w", &agents.ModerationError{}), ContentFlagged},
+		{"deadline", context.DeadlineExceeded, RequestTimeout},
+		{"insufficient time", fmt.Errorf("%w: 10ms left", agents.ErrInsufficientTimeRemaining), RequestTimeout},
+		{"provider 429", &agents.ProviderHTTPError{Provider: "openai", StatusCode: 429}, ProviderRateLimited},
+		{"provider 500", &agents.ProviderHTTPError{Provider: "openai", StatusCode: 500}, ProviderError},
+		{"provider 401", fmt.Errorf("writer generation failed: %w", &agents.ProviderHTTPError{Provider: "openai", StatusCode: 401}), ProviderAuthFailed},
+		{"model unavailable", fmt.Errorf("writer generation failed: %w: %w", agents.ErrModelUnavailable, &agents.ProviderHTTPError{StatusCode: 404}), ModelUnavailable},
+		{"body too large", Wrap(InvalidRequest, &http.MaxBytesError{Limit: 10}), PayloadTooLarge},
+		{"unknown", errors.New("boom"), GenerationFailed},
+	}
+	for _, tt := range tests {
+		if got := CodeOf(tt.err); got != tt.want {
+			t.Errorf("%s: CodeOf = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("request_id", "req-7")
+
+	RespondError(c, &agents.ProviderHTTPError{Provider: "openai", StatusCode: 429, Message: "slow down"})
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	if !c.IsAborted() {
+		t.Fatal("context was not aborted")
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["code"] != string(ProviderRateLimited) || body["request_id"] != "req-7" || body["error"] == "" {
+		t.Fatalf("body = %v", body)
+	}
+}
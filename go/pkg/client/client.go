@@ -0,0 +1,471 @@
+// Package client is a Go client for the novelist HTTP API.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBaseDelay  = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+	maxErrorBodyBytes = 4096
+)
+
+// Client calls the novelist API.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey sends key as a bearer token on every request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithHTTPClient replaces the default http.Client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithMaxRetries sets how many times 429 and 5xx responses are retried.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// New creates a client for the API served at baseURL, e.g.
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 2 * time.Minute},
+		maxRetries: defaultMaxRetries,
+		baseDelay:  defaultBaseDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose requests carry id as X-Request-ID,
+// so client and server logs can be correlated.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// APIError is a non-2xx response from the API.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("novelist api: %d %s: %s", e.StatusCode, e.Code, msg)
+	}
+	return fmt.Sprintf("novelist api: %d: %s", e.StatusCode, msg)
+}
+
+// HealthStatus is the /health response. Status is healthy, degraded or
+// unhealthy.
+type HealthStatus struct {
+	Status           string                          `json:"status"`
+	Score            float64                         `json:"score"`
+	Version          string                          `json:"version"`
+	Degraded         bool                            `json:"degraded"`
+	Dependencies     map[string]ProviderHealthStatus `json:"dependencies"`
+	CriticalFailures []string                        `json:"critical_failures,omitempty"`
+	OptionalFailures []string                        `json:"optional_failures,omitempty"`
+	ModelUnavailable []string                        `json:"model_unavailable,omitempty"`
+}
+
+// ProviderHealthStatus is the health of one agent's provider.
+type ProviderHealthStatus struct {
+	Provider    string           `json:"provider"`
+	Type        string           `json:"type,omitempty"`
+	Model       string           `json:"model,omitempty"`
+	Healthy     bool             `json:"healthy"`
+	Error       string           `json:"error,omitempty"`
+	LatencyMs   int64            `json:"latency_ms"`
+	CheckedAt   time.Time        `json:"checked_at"`
+	LastError   string           `json:"last_error,omitempty"`
+	LastErrorAt *time.Time       `json:"last_error_at,omitempty"`
+	Endpoints   []EndpointStatus `json:"endpoints,omitempty"`
+}
+
+// EndpointStatus is the health of one endpoint of a multi-endpoint provider.
+type EndpointStatus struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Stats is the /stats response.
+type Stats struct {
+	StartedAt         time.Time        `json:"started_at"`
+	RequestsTotal     int64            `json:"requests_total"`
+	RequestsPerMinute float64          `json:"requests_per_minute"`
+	InFlight          int64            `json:"in_flight"`
+	PanicsTotal       int64            `json:"panics_total"`
+	StatusCounts      map[string]int64 `json:"status_counts"`
+	LatencyMsP50      float64          `json:"latency_ms_p50"`
+	LatencyMsP95      float64          `json:"latency_ms_p95"`
+	// Endpoints breaks the counts and latencies down by route.
+	Endpoints map[string]EndpointStats `json:"endpoints,omitempty"`
+	Gauges    map[string]int           `json:"gauges,omitempty"`
+	Rates     map[string]float64       `json:"rates,omitempty"`
+	Usage     UsageStats               `json:"usage"`
+}
+
+// EndpointStats is one route's share of a Stats response.
+type EndpointStats struct {
+	RequestsTotal int64            `json:"requests_total"`
+	StatusCounts  map[string]int64 `json:"status_counts"`
+	LatencyMsP50  float64          `json:"latency_ms_p50"`
+	LatencyMsP95  float64          `json:"latency_ms_p95"`
+}
+
+// TokenUsage counts LLM tokens in a Stats response.
+type TokenUsage struct {
+	Generations      int64 `json:"generations"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// UsageStats is the token usage since the server started, in total and by
+// provider and agent.
+type UsageStats struct {
+	TokenUsage
+	Providers map[string]TokenUsage `json:"providers,omitempty"`
+	Agents    map[string]TokenUsage `json:"agents,omitempty"`
+}
+
+// Job statuses.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is an asynchronous scene generation, as returned by SubmitJob and
+// GetJob.
+type Job struct {
+	ID         string     `json:"job_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Code       string     `json:"code,omitempty"`
+	// Result is the scene response once the job is done.
+	Result *models.SceneResponse `json:"result,omitempty"`
+	// TimedOut marks a partial result cut short by the job timeout.
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// GenerateScene runs the scene pipeline for req.
+func (c *Client) GenerateScene(ctx context.Context, req *models.SceneRequest) (*models.SceneResponse, error) {
+	var resp models.SceneResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/scenes", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GenerateSceneStream runs the scene pipeline for req like GenerateScene,
+// calling onToken with each chunk of the writer's draft as it streams. The
+// draft may differ from the returned scene when later stages revise it.
+// Streams are not retried.
+func (c *Client) GenerateSceneStream(ctx context.Context, req *models.SceneRequest, onToken func(text string)) (*models.SceneResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/api/v1/scenes/stream", body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, readAPIError(resp)
+	}
+	defer resp.Body.Close()
+
+	var result *models.SceneResponse
+	err = readEvents(resp.Body, func(event string, data []byte) (bool, error) {
+		switch event {
+		case "token":
+			var token struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(data, &token); err != nil {
+				return false, fmt.Errorf("decode token event: %w", err)
+			}
+			if onToken != nil {
+				onToken(token.Text)
+			}
+		case "result":
+			result = &models.SceneResponse{}
+			if err := json.Unmarshal(data, result); err != nil {
+				return false, fmt.Errorf("decode result event: %w", err)
+			}
+			return true, nil
+		case "error":
+			var payload struct {
+				Error     string `json:"error"`
+				Code      string `json:"code"`
+				RequestID string `json:"request_id"`
+			}
+			if err := json.Unmarshal(data, &payload); err != nil {
+				return false, fmt.Errorf("decode error event: %w", err)
+			}
+			return false, &APIError{StatusCode: resp.StatusCode, Code: payload.Code, Message: payload.Error, RequestID: payload.RequestID}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, errors.New("stream ended without a result")
+	}
+	return result, nil
+}
+
+// readEvents reads text/event-stream events from r, passing each event's
+// name and data to handle until it reports done or fails.
+func readEvents(r io.Reader, handle func(event string, data []byte) (bool, error)) error {
+	reader := bufio.NewReader(r)
+	var event string
+	var data [][]byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read stream: %w", err)
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0:
+			if len(data) > 0 {
+				done, err := handle(event, bytes.Join(data, []byte("\n")))
+				if done || err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case bytes.HasPrefix(line, []byte("event:")):
+			event = strings.TrimSpace(string(line[len("event:"):]))
+		case bytes.HasPrefix(line, []byte("data:")):
+			data = append(data, bytes.TrimPrefix(line[len("data:"):], []byte(" ")))
+		}
+	}
+}
+
+// ValidateScene runs only the checker over req's prose.
+func (c *Client) ValidateScene(ctx context.Context, req *models.ValidateSceneRequest) (*models.ValidationResponse, error) {
+	var resp models.ValidationResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/scenes/validate", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SubmitJob queues req for asynchronous generation. Poll the job with
+// GetJob; its ID is the request ID.
+func (c *Client) SubmitJob(ctx context.Context, req *models.SceneRequest) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodPost, "/api/v1/jobs", req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJob returns a job's status, with its result once done.
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Health reports service and provider health.
+func (c *Client) Health(ctx context.Context) (*HealthStatus, error) {
+	var resp HealthStatus
+	if err := c.do(ctx, http.MethodGet, "/api/v1/health", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Stats returns the server's runtime statistics.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var resp Stats
+	if err := c.do(ctx, http.MethodGet, "/api/v1/stats", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// do sends a request, retrying 429 and 5xx responses, and decodes the JSON
+// response into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, body)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode < http.StatusBadRequest {
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("decode response: %w", err)
+			}
+			return nil
+		}
+
+		apiErr := readAPIError(resp)
+		if !retryable(resp.StatusCode) || attempt >= c.maxRetries {
+			return apiErr
+		}
+
+		delay := retryDelay(resp.Header.Get("Retry-After"), c.baseDelay, attempt)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	// models.SceneResponse carries the v1 field names.
+	req.Header.Set("Accept-Version", "1")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+func readAPIError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	var payload struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.Unmarshal(raw, &payload); err == nil && payload.Error != "" {
+		apiErr.Message = payload.Error
+		apiErr.Code = payload.Code
+	} else {
+		apiErr.Message = strings.TrimSpace(string(raw))
+	}
+	return apiErr
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// retryDelay honours a Retry-After header given in seconds or as an HTTP
+// date, falling back to exponential backoff.
+func retryDelay(retryAfter string, base time.Duration, attempt int) time.Duration {
+	if retryAfter != "" {
+		if secs, err := strconv.Atoi(retryAfter); err == nil && secs >= 0 {
+			return minDuration(time.Duration(secs)*time.Second, maxRetryDelay)
+		}
+		if at, err := http.ParseTime(retryAfter); err == nil {
+			if d := time.Until(at); d > 0 {
+				return minDuration(d, maxRetryDelay)
+			}
+			return 0
+		}
+	}
+	return minDuration(base<<attempt, maxRetryDelay)
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// IsRetryable reports whether err is an APIError worth retrying later.
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && retryable(apiErr.StatusCode)
+}
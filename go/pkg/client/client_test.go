@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestGenerateSceneRetriesAndPropagatesRequestID(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if got := r.Header.Get("X-Request-ID"); got != "req-1" {
+			t.Errorf("expected request id header, got %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("expected bearer token, got %q", got)
+		}
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"rate limit exceeded","code":"rate_limit_exceeded"}`))
+			return
+		}
+
+		var req models.SceneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("retried request body not readable: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(models.SceneResponse{RequestID: "req-1", Text: req.Intention})
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithAPIKey("secret"))
+	ctx := WithRequestID(context.Background(), "req-1")
+	resp, err := c.GenerateScene(ctx, &models.SceneRequest{Intention: "再会"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 2 || resp.Text != "再会" {
+		t.Fatalf("expected success on second attempt, got attempts=%d resp=%+v", attempts, resp)
+	}
+}
+
+func TestClientReturnsAPIError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("X-Request-ID", "srv-9")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"intention is required","code":"invalid_request"}`))
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).GenerateScene(context.Background(), &models.SceneRequest{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "invalid_request" || apiErr.RequestID != "srv-9" {
+		t.Fatalf("unexpected error fields: %+v", apiErr)
+	}
+	if attempts != 1 || IsRetryable(err) {
+		t.Fatalf("expected 4xx not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestClientGivesUpAfterMaxRetries(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithMaxRetries(2))
+	c.baseDelay = time.Millisecond
+	_, err := c.Health(context.Background())
+	if !IsRetryable(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base := 100 * time.Millisecond
+	if d := retryDelay("2", base, 0); d != 2*time.Second {
+		t.Fatalf("expected Retry-After seconds, got %s", d)
+	}
+	if d := retryDelay("", base, 2); d != 400*time.Millisecond {
+		t.Fatalf("expected exponential backoff, got %s", d)
+	}
+	if d := retryDelay("3600", base, 0); d != maxRetryDelay {
+		t.Fatalf("expected delay capped, got %s", d)
+	}
+	past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+	if d := retryDelay(past, base, 0); d != 0 {
+		t.Fatalf("expected no delay for past date, got %s", d)
+	}
+}
+
+func TestGenerateSceneStreamDeliversTokensAndResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/scenes/stream" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("id: 1\nevent: token\ndata: {\"text\":\"夜が\"}\n\n" +
+			"id: 2\nevent: token\ndata: {\"text\":\"明けた。\"}\n\n" +
+			"id: 3\nevent: result\ndata: {\"request_id\":\"req-1\",\"text\":\"夜が明けた。\"}\n\n"))
+	}))
+	defer server.Close()
+
+	var draft string
+	resp, err := New(server.URL).GenerateSceneStream(context.Background(), &models.SceneRequest{Intention: "再会"}, func(text string) {
+		draft += text
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if draft != "夜が明けた。" || resp.Text != "夜が明けた。" {
+		t.Fatalf("expected the streamed draft and result, got %q and %+v", draft, resp)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("id: 1\nevent: error\ndata: {\"error\":\"timed out\",\"code\":\"request_timeout\"}\n\n"))
+	}))
+	defer failing.Close()
+	_, err = New(failing.URL).GenerateSceneStream(context.Background(), &models.SceneRequest{Intention: "再会"}, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "request_timeout" {
+		t.Fatalf("expected the error event as an APIError, got %v", err)
+	}
+}
+
+func TestSubmitAndGetJob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/jobs":
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"job_id":"req-1","status":"queued"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/jobs/req-1":
+			_, _ = w.Write([]byte(`{"job_id":"req-1","status":"done","result":{"request_id":"req-1","text":"本文"}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	job, err := c.SubmitJob(context.Background(), &models.SceneRequest{Intention: "再会"})
+	if err != nil || job.ID != "req-1" || job.Status != JobQueued {
+		t.Fatalf("expected a queued job, got %+v (err=%v)", job, err)
+	}
+	job, err = c.GetJob(context.Background(), job.ID)
+	if err != nil || job.Status != JobDone || job.Result == nil || job.Result.Text != "本文" {
+		t.Fatalf("expected the finished job's result, got %+v (err=%v)", job, err)
+	}
+}
+
+func TestStatsIncludesEndpoints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"requests_total":3,"endpoints":{"/api/v1/scenes":{"requests_total":2,"status_counts":{"200":2},"latency_ms_p50":12.5,"latency_ms_p95":40}}}`))
+	}))
+	defer server.Close()
+
+	stats, err := New(server.URL).Stats(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	endpoint, ok := stats.Endpoints["/api/v1/scenes"]
+	if !ok || endpoint.RequestsTotal != 2 || endpoint.StatusCounts["200"] != 2 || endpoint.LatencyMsP95 != 40 {
+		t.Fatalf("expected per-endpoint stats, got %+v", stats.Endpoints)
+	}
+}
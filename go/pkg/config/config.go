@@ -2,19 +2,37 @@ package config
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/novelist/novelist/pkg/models"
 	"github.com/spf13/viper"
 )
 
 // Config represents application configuration
 type Config struct {
-	Server   ServerConfig           `mapstructure:"server"`
-	Project  string                 `mapstructure:"project"`
-	Provider models.ProviderSection `mapstructure:"provider"`
+	Server      ServerConfig             `mapstructure:"server"`
+	Project     string                   `mapstructure:"project"`
+	Provider    models.ProviderSection   `mapstructure:"provider"`
+	Swarm       models.SwarmSection      `mapstructure:"swarm"`
+	Context     models.ContextSection    `mapstructure:"context"`
+	Moderation  models.ModerationConfig  `mapstructure:"moderation"`
+	InputFilter models.InputFilterConfig `mapstructure:"input_filter"`
+	Health      models.HealthConfig      `mapstructure:"health"`
+	// Presets are named SceneRequest defaults selected by "preset".
+	Presets map[string]models.ScenePreset `mapstructure:"presets"`
+	// Prompts override agents' built-in prompts, by agent.
+	Prompts map[string]models.PromptOverride `mapstructure:"prompts"`
+
+	// File is the config file that was read, empty when none was found.
+	File string `mapstructure:"-"`
 }
 
 // ServerConfig represents server configuration
@@ -22,9 +40,24 @@ type ServerConfig struct {
 	HTTPPort string `mapstructure:"http_port"`
 	GRPCPort string `mapstructure:"grpc_port"`
 	Host     string `mapstructure:"host"`
+	// APIKeys maps key names to the bearer keys accepted by /api/v1;
+	// empty leaves the API open.
+	APIKeys map[string]string `mapstructure:"api_keys"`
+	// KeyPriorities maps key names to the highest request priority they
+	// may claim; other keys, and an open API, are held to normal.
+	KeyPriorities map[string]string `mapstructure:"key_priorities"`
 }
 
-// Load loads configuration from file
+// configSearchPaths are the directories searched for a config file named
+// config.{yaml,json,toml,...} when no path is given, after the
+// NOVELIST_PROJECT directory.
+var configSearchPaths = []string{".", "/etc/novelist"}
+
+// Load loads configuration from configPath, else from NOVELIST_CONFIG,
+// else from the first config file found in the NOVELIST_PROJECT directory
+// or configSearchPaths. The format follows the file extension. A missing
+// file is an error only when its path was given; with none found, the
+// defaults and environment apply.
 func Load(configPath string) (*Config, error) {
 	v := viper.New()
 
@@ -33,35 +66,131 @@ func Load(configPath string) (*Config, error) {
 	v.SetDefault("server.grpc_port", "50051")
 	v.SetDefault("server.host", "0.0.0.0")
 
-	// Read from file if provided
 	if configPath == "" {
-		if projectPath := os.Getenv("NOVELIST_PROJECT"); projectPath != "" {
-			candidate := filepath.Join(projectPath, "config.yaml")
-			if _, err := os.Stat(candidate); err == nil {
-				configPath = candidate
-			}
-		}
+		configPath = strings.TrimSpace(os.Getenv("NOVELIST_CONFIG"))
 	}
-
 	if configPath != "" {
 		v.SetConfigFile(configPath)
 		if err := v.ReadInConfig(); err != nil {
-			return nil, fmt.Errorf("failed to read config: %w", err)
+			return nil, fmt.Errorf("failed to read config %s: %w", configPath, err)
+		}
+	} else {
+		v.SetConfigName("config")
+		if projectPath := os.Getenv("NOVELIST_PROJECT"); projectPath != "" {
+			v.AddConfigPath(projectPath)
+		}
+		for _, path := range configSearchPaths {
+			v.AddConfigPath(path)
+		}
+		if err := v.ReadInConfig(); err != nil {
+			var notFound viper.ConfigFileNotFoundError
+			if !errors.As(err, &notFound) {
+				return nil, fmt.Errorf("failed to read config: %w", err)
+			}
 		}
 	}
 
 	// Environment variables
 	v.AutomaticEnv()
 	v.SetEnvPrefix("NOVELIST")
+	// NOVELIST_PROJECT also names the directory committed scenes go to.
+	if err := v.BindEnv("project"); err != nil {
+		return nil, fmt.Errorf("failed to bind project env: %w", err)
+	}
 
 	var cfg Config
-	if err := v.Unmarshal(&cfg); err != nil {
+	if err := v.Unmarshal(&cfg, decodeHook); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	cfg.File = v.ConfigFileUsed()
 
 	return &cfg, nil
 }
 
+// Validate checks that the provider settings are consistent: the default
+// provider and every routing target are available, each provider has a
+// known type, and providers that need an API key have it in the
+// environment. It
+// reports every problem found, not just the first.
+func (c *Config) Validate() error {
+	var problems []string
+	provider := c.Provider
+
+	checkTargets := func(what, raw string) {
+		for _, name := range routingTargets(raw) {
+			if _, ok := provider.Available[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s references unknown provider %q", what, name))
+			}
+		}
+	}
+	checkTargets("provider.default", provider.Default)
+	for _, agent := range sortedKeys(provider.Routing) {
+		checkTargets("provider.routing."+agent, provider.Routing[agent])
+	}
+	if name := c.Context.EmbeddingProvider; name != "" {
+		if _, ok := provider.Available[name]; !ok {
+			problems = append(problems, fmt.Sprintf("context.embedding_provider references unknown provider %q", name))
+		}
+	}
+	for i, rule := range provider.RoutingRules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule_%d", i+1)
+		}
+		checkTargets("provider.routing_rules."+name, rule.Provider)
+	}
+
+	for _, name := range sortedKeys(provider.Available) {
+		available := provider.Available[name]
+		providerType := strings.ToLower(strings.TrimSpace(available.Type))
+		if providerType == "" {
+			problems = append(problems, fmt.Sprintf("provider.available.%s has no type", name))
+			continue
+		}
+		if !slices.Contains(models.ProviderTypes, providerType) {
+			problems = append(problems, fmt.Sprintf("provider.available.%s has unknown type %q (want one of %s)", name, available.Type, strings.Join(models.ProviderTypes, ", ")))
+			continue
+		}
+		if providerType == models.ProviderTypeMock {
+			continue
+		}
+		keyEnv := strings.TrimSpace(available.APIKeyEnv)
+		if keyEnv == "" {
+			keyEnv = models.DefaultAPIKeyEnv(providerType)
+		}
+		if keyEnv != "" && strings.TrimSpace(os.Getenv(keyEnv)) == "" {
+			problems = append(problems, fmt.Sprintf("provider.available.%s needs its API key in env %s", name, keyEnv))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config (%d problems): %s", len(problems), strings.Join(problems, "; "))
+}
+
+// routingTargets returns the provider names in a routing value, a chain
+// such as "ollama, openai" or weights such as "ollama=1, openai=3".
+func routingTargets(raw string) []string {
+	var names []string
+	for _, entry := range strings.Split(raw, ",") {
+		name, _, _ := strings.Cut(entry, "=")
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // LoadProjectConfig loads project-specific config
 func LoadProjectConfig(projectPath string) (*models.ProjectConfig, error) {
 	configFile := filepath.Join(projectPath, "config.yaml")
@@ -79,9 +208,54 @@ func LoadProjectConfig(projectPath string) (*models.ProjectConfig, error) {
 	}
 
 	var cfg models.ProjectConfig
-	if err := v.Unmarshal(&cfg); err != nil {
+	if err := v.Unmarshal(&cfg, decodeHook); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal: %w", err)
 	}
 
 	return &cfg, nil
 }
+
+// decodeHook extends viper's default hooks so that string settings also
+// accept lists, joined with commas, and maps, joined as name=value pairs.
+// This lets provider routing be written as `writer: [ollama, openai, mock]`
+// as well as "ollama, openai, mock", and weighted as
+// `writer: {ollama: 1, openai: 1}` as well as "ollama=1, openai=1".
+var decodeHook = viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+	joinListHook,
+	joinMapHook,
+))
+
+func joinListHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to.Kind() != reflect.String || (from.Kind() != reflect.Slice && from.Kind() != reflect.Array) {
+		return data, nil
+	}
+	items, ok := data.([]interface{})
+	if !ok {
+		return data, nil
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = fmt.Sprint(item)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// joinMapHook joins a map decoded into a string as name=value pairs sorted
+// by name.
+func joinMapHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to.Kind() != reflect.String || from.Kind() != reflect.Map {
+		return data, nil
+	}
+	items, ok := data.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+	parts := make([]string, 0, len(items))
+	for name, value := range items {
+		parts = append(parts, fmt.Sprintf("%s=%v", name, value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ","), nil
+}
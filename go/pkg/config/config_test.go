@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/novelist/novelist/pkg/models"
+)
+
+func TestValidateListsEveryProblem(t *testing.T) {
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "")
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	cfg := &Config{Provider: models.ProviderSection{
+		Default: "local",
+		Available: map[string]models.ProviderConfig{
+			"local":  {Type: "ollama", Model: "qwen3"},
+			"gpt":    {Type: "openai", Model: "gpt-4o", APIKeyEnv: "NOVELIST_TEST_OPENAI_KEY"},
+			"claude": {Type: "anthropic", Model: "claude-sonnet-4-5"},
+			"blank":  {Model: "x"},
+			"typo":   {Type: "openia"},
+			"mock":   {Type: "mock"},
+		},
+		Routing: map[string]string{
+			"writer": "local, gtp",
+			"editor": "local=1, mock=1",
+		},
+		RoutingRules: []models.RoutingRule{{Provider: "olama"}},
+	}, Context: models.ContextSection{EmbeddingProvider: "vectors"}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, want := range []string{
+		`provider.routing.writer references unknown provider "gtp"`,
+		`provider.routing_rules.rule_1 references unknown provider "olama"`,
+		`context.embedding_provider references unknown provider "vectors"`,
+		"provider.available.blank has no type",
+		`provider.available.typo has unknown type "openia"`,
+		"provider.available.claude needs its API key in env ANTHROPIC_API_KEY",
+		"provider.available.gpt needs its API key in env NOVELIST_TEST_OPENAI_KEY",
+		"(7 problems)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+
+	t.Setenv("NOVELIST_TEST_OPENAI_KEY", "sk-test")
+	t.Setenv("ANTHROPIC_API_KEY", "sk-test")
+	delete(cfg.Provider.Available, "blank")
+	delete(cfg.Provider.Available, "typo")
+	cfg.Provider.Routing["writer"] = "local, gpt"
+	cfg.Provider.RoutingRules[0].Provider = "claude"
+	cfg.Context.EmbeddingProvider = "local"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected a consistent config to pass, got %v", err)
+	}
+}
+
+func TestValidateAcceptsEmptyConfig(t *testing.T) {
+	if err := (&Config{}).Validate(); err != nil {
+		t.Fatalf("expected the empty config, all mock, to pass: %v", err)
+	}
+}
+
+func TestLoadReadsConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "novelist.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"provider": {"default": "local"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(jsonPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Provider.Default != "local" || cfg.File != jsonPath {
+		t.Fatalf("expected the json file read, got default %q from %q", cfg.Provider.Default, cfg.File)
+	}
+
+	// NOVELIST_CONFIG applies when no path is given.
+	tomlPath := filepath.Join(dir, "novelist.toml")
+	if err := os.WriteFile(tomlPath, []byte("[provider]\ndefault = \"remote\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NOVELIST_CONFIG", tomlPath)
+	if cfg, err = Load(""); err != nil || cfg.Provider.Default != "remote" {
+		t.Fatalf("expected the toml file from NOVELIST_CONFIG, got %+v (%v)", cfg, err)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("expected an explicitly given missing file to fail")
+	}
+
+	// With nothing to find, the defaults apply.
+	t.Setenv("NOVELIST_CONFIG", "")
+	t.Setenv("NOVELIST_PROJECT", "")
+	restore := configSearchPaths
+	configSearchPaths = []string{t.TempDir()}
+	defer func() { configSearchPaths = restore }()
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("expected no file to be a soft default, got %v", err)
+	}
+	if cfg.File != "" || cfg.Server.HTTPPort != "8080" {
+		t.Fatalf("expected defaults without a file, got %+v", cfg)
+	}
+}
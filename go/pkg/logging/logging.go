@@ -0,0 +1,61 @@
+// Package logging builds the zerolog logger shared by the novelist commands.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Output formats accepted by New.
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// New returns a logger writing to out in the given format ("json" or
+// "console"; empty means json) at the given level (empty means info). It
+// also installs the logger and level globally so packages using
+// zerolog/log follow the same settings.
+func New(out io.Writer, format, level string) (zerolog.Logger, error) {
+	lvl := zerolog.InfoLevel
+	if strings.TrimSpace(level) != "" {
+		parsed, err := zerolog.ParseLevel(strings.ToLower(strings.TrimSpace(level)))
+		if err != nil {
+			return zerolog.Nop(), fmt.Errorf("invalid log level %q: %w", level, err)
+		}
+		lvl = parsed
+	}
+
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatJSON:
+		zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	case FormatConsole:
+		zerolog.TimeFieldFormat = time.RFC3339
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: "15:04:05"}
+	default:
+		return zerolog.Nop(), fmt.Errorf("invalid log format %q (want %s or %s)", format, FormatJSON, FormatConsole)
+	}
+
+	zerolog.SetGlobalLevel(lvl)
+	logger := zerolog.New(out).With().Timestamp().Logger()
+	log.Logger = logger
+	return logger, nil
+}
+
+// FromEnv builds the logger from NOVELIST_LOG_FORMAT and NOVELIST_LOG_LEVEL,
+// writing to stdout. Invalid values fall back to JSON at info level and are
+// reported through the returned error so callers can log it.
+func FromEnv() (zerolog.Logger, error) {
+	logger, err := New(os.Stdout, os.Getenv("NOVELIST_LOG_FORMAT"), os.Getenv("NOVELIST_LOG_LEVEL"))
+	if err != nil {
+		fallback, _ := New(os.Stdout, FormatJSON, "")
+		return fallback, err
+	}
+	return logger, nil
+}
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNewFormats(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.TraceLevel)
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Info().Str("k", "v").Msg("hello")
+	if !strings.HasPrefix(buf.String(), "{") || !strings.Contains(buf.String(), `"k":"v"`) {
+		t.Fatalf("expected JSON output, got %q", buf.String())
+	}
+
+	buf.Reset()
+	logger, err = New(&buf, "console", "warn")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Info().Msg("dropped")
+	logger.Warn().Str("k", "v").Msg("hello")
+	out := buf.String()
+	if strings.Contains(out, "dropped") {
+		t.Fatalf("expected info to be filtered at warn level, got %q", out)
+	}
+	if strings.HasPrefix(out, "{") || !strings.Contains(out, "hello") || !strings.Contains(out, "k=") {
+		t.Fatalf("expected console output, got %q", out)
+	}
+
+	if _, err := New(&buf, "xml", ""); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+	if _, err := New(&buf, "json", "loud"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
@@ -7,44 +7,534 @@ type ProviderSection struct {
 	Default   string                    `mapstructure:"default" json:"default" yaml:"default"`
 	Available map[string]ProviderConfig `mapstructure:"available" json:"available" yaml:"available"`
 	Routing   map[string]string         `mapstructure:"routing" json:"routing" yaml:"routing"`
+	// Strict turns routing references to unknown providers into startup
+	// errors instead of warnings.
+	Strict bool `mapstructure:"strict" json:"strict" yaml:"strict"`
+	// OnAllMock is "warn" (default) or "fail": what to do when every agent
+	// falls back to the mock provider despite configured routing.
+	OnAllMock string `mapstructure:"on_all_mock" json:"on_all_mock,omitempty" yaml:"on_all_mock,omitempty"`
+	// RoutingRules pick the writer's provider per request. The first
+	// matching rule wins; Routing applies when none match.
+	RoutingRules []RoutingRule `mapstructure:"routing_rules" json:"routing_rules,omitempty" yaml:"routing_rules,omitempty"`
+	// Pricing lists per-model token prices used to estimate generation
+	// cost.
+	Pricing []ModelPricing `mapstructure:"pricing" json:"pricing,omitempty" yaml:"pricing,omitempty"`
+}
+
+// ModelPricing prices one provider type and model in USD per million
+// tokens.
+type ModelPricing struct {
+	Provider         string  `mapstructure:"provider" json:"provider" yaml:"provider"`
+	Model            string  `mapstructure:"model" json:"model" yaml:"model"`
+	InputPerMTokUSD  float64 `mapstructure:"input_per_mtok_usd" json:"input_per_mtok_usd" yaml:"input_per_mtok_usd"`
+	OutputPerMTokUSD float64 `mapstructure:"output_per_mtok_usd" json:"output_per_mtok_usd" yaml:"output_per_mtok_usd"`
+}
+
+// RoutingRule selects a provider when a request matches all of its set
+// conditions. Zero-valued conditions are ignored.
+type RoutingRule struct {
+	Name         string `mapstructure:"name" json:"name" yaml:"name"`
+	MinWordCount int    `mapstructure:"min_word_count" json:"min_word_count,omitempty" yaml:"min_word_count,omitempty"`
+	MaxWordCount int    `mapstructure:"max_word_count" json:"max_word_count,omitempty" yaml:"max_word_count,omitempty"`
+	Priority     string `mapstructure:"priority" json:"priority,omitempty" yaml:"priority,omitempty"`
+	Provider     string `mapstructure:"provider" json:"provider" yaml:"provider"`
+}
+
+// Provider types accepted in ProviderConfig.Type.
+const (
+	ProviderTypeMock      = "mock"
+	ProviderTypeOllama    = "ollama"
+	ProviderTypeOpenAI    = "openai"
+	ProviderTypeAnthropic = "anthropic"
+	ProviderTypeGemini    = "gemini"
+)
+
+// ProviderTypes lists every provider type.
+var ProviderTypes = []string{ProviderTypeMock, ProviderTypeOllama, ProviderTypeOpenAI, ProviderTypeAnthropic, ProviderTypeGemini}
+
+// DefaultAPIKeyEnv returns the env var a provider of providerType reads its
+// API key from when api_key_env is unset, or "" when it needs no key.
+func DefaultAPIKeyEnv(providerType string) string {
+	switch providerType {
+	case ProviderTypeOpenAI:
+		return "OPENAI_API_KEY"
+	case ProviderTypeAnthropic:
+		return "ANTHROPIC_API_KEY"
+	case ProviderTypeGemini:
+		return "GOOGLE_API_KEY"
+	}
+	return ""
 }
 
 // ProviderConfig represents a single provider definition.
 type ProviderConfig struct {
-	Type      string `mapstructure:"type" json:"type" yaml:"type"`
-	Model     string `mapstructure:"model" json:"model" yaml:"model"`
-	BaseURL   string `mapstructure:"base_url" json:"base_url" yaml:"base_url"`
-	APIKeyEnv string `mapstructure:"api_key_env" json:"api_key_env" yaml:"api_key_env"`
-	Timeout   int    `mapstructure:"timeout" json:"timeout" yaml:"timeout"`
+	Type    string `mapstructure:"type" json:"type" yaml:"type"`
+	Model   string `mapstructure:"model" json:"model" yaml:"model"`
+	BaseURL string `mapstructure:"base_url" json:"base_url" yaml:"base_url"`
+	// BaseURLs lists redundant endpoints for the same provider (OpenAI
+	// only); requests round-robin and fail over on connection errors and
+	// 5xx. Replaces BaseURL when set.
+	BaseURLs  []string `mapstructure:"base_urls" json:"base_urls,omitempty" yaml:"base_urls,omitempty"`
+	APIKeyEnv string   `mapstructure:"api_key_env" json:"api_key_env" yaml:"api_key_env"`
+	Timeout   int      `mapstructure:"timeout" json:"timeout" yaml:"timeout"`
+	// ChatPath and ModelsPath override the OpenAI endpoint paths for
+	// gateways with non-standard layouts. Relative to BaseURL, or absolute.
+	ChatPath   string `mapstructure:"chat_path" json:"chat_path,omitempty" yaml:"chat_path,omitempty"`
+	ModelsPath string `mapstructure:"models_path" json:"models_path,omitempty" yaml:"models_path,omitempty"`
+	// Retry tunes retries of transient failures for this provider.
+	Retry RetryConfig `mapstructure:"retry" json:"retry" yaml:"retry"`
+	// EmbeddingModel is the model Embed uses. OpenAI defaults to
+	// text-embedding-3-small, Ollama to Model.
+	EmbeddingModel string `mapstructure:"embedding_model" json:"embedding_model,omitempty" yaml:"embedding_model,omitempty"`
+}
+
+// RetryConfig controls retries of transient provider failures (429, 5xx
+// gateway errors, timeouts). Retries also draw on the request's shared
+// retry budget. Zero fields use the defaults.
+type RetryConfig struct {
+	// MaxAttempts counts the first call; 1 disables retries (default 3).
+	MaxAttempts int `mapstructure:"max_attempts" json:"max_attempts" yaml:"max_attempts"`
+	// BaseDelayMs is the first backoff, doubled per retry (default 500).
+	BaseDelayMs int `mapstructure:"base_delay_ms" json:"base_delay_ms" yaml:"base_delay_ms"`
+	// MaxDelayMs caps a backoff. A Retry-After longer than this ends the
+	// retries instead (default 5000).
+	MaxDelayMs int `mapstructure:"max_delay_ms" json:"max_delay_ms" yaml:"max_delay_ms"`
 }
 
 // ProjectConfig represents project-level configuration.
 type ProjectConfig struct {
 	Provider ProviderSection `mapstructure:"provider" json:"provider" yaml:"provider"`
+	Swarm    SwarmSection    `mapstructure:"swarm" json:"swarm" yaml:"swarm"`
+}
+
+// ContextSection controls the reference documents retrieved into the
+// director's and writer's prompts.
+type ContextSection struct {
+	// Budgets caps retrieved text per agent ("director", "writer"), in
+	// estimated tokens (default 800).
+	Budgets map[string]int `mapstructure:"budgets" json:"budgets,omitempty" yaml:"budgets,omitempty"`
+	// Documents are .md/.txt files or directories indexed at startup.
+	Documents []string `mapstructure:"documents" json:"documents,omitempty" yaml:"documents,omitempty"`
+	// TopK caps the documents retrieved per prompt (default 3).
+	TopK int `mapstructure:"top_k" json:"top_k" yaml:"top_k"`
+	// EmbeddingProvider names the available provider whose embeddings
+	// rank Documents by semantic similarity. Unset ranks them by TF-IDF.
+	EmbeddingProvider string `mapstructure:"embedding_provider" json:"embedding_provider,omitempty" yaml:"embedding_provider,omitempty"`
+}
+
+// ModerationConfig represents optional content moderation settings.
+type ModerationConfig struct {
+	Enabled     bool   `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	Type        string `mapstructure:"type" json:"type" yaml:"type"`
+	Model       string `mapstructure:"model" json:"model" yaml:"model"`
+	BaseURL     string `mapstructure:"base_url" json:"base_url" yaml:"base_url"`
+	APIKeyEnv   string `mapstructure:"api_key_env" json:"api_key_env" yaml:"api_key_env"`
+	Timeout     int    `mapstructure:"timeout" json:"timeout" yaml:"timeout"`
+	BlockCommit bool   `mapstructure:"block_commit" json:"block_commit" yaml:"block_commit"`
+}
+
+// InputFilterConfig represents optional prompt-injection filtering of user
+// input. Mode is "delimit" (default) or "strip"; Patterns replace the
+// built-in case-insensitive regular expressions when set.
+type InputFilterConfig struct {
+	Enabled  bool     `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	Mode     string   `mapstructure:"mode" json:"mode" yaml:"mode"`
+	Patterns []string `mapstructure:"patterns" json:"patterns,omitempty" yaml:"patterns,omitempty"`
+}
+
+// HealthConfig weights agent providers in the /health score. Weights
+// default to 3 for critical agents and 1 for the rest; Critical defaults
+// to director and writer. Status is unhealthy when a critical provider is
+// down or the score falls below Threshold (default 0.5), and degraded when
+// any provider is down. Provider checks are reused for CacheTTLSec
+// seconds (default 5, negative to check on every probe).
+type HealthConfig struct {
+	Weights     map[string]float64 `mapstructure:"weights" json:"weights,omitempty" yaml:"weights,omitempty"`
+	Critical    []string           `mapstructure:"critical" json:"critical,omitempty" yaml:"critical,omitempty"`
+	Threshold   float64            `mapstructure:"threshold" json:"threshold" yaml:"threshold"`
+	CacheTTLSec int                `mapstructure:"cache_ttl_sec" json:"cache_ttl_sec" yaml:"cache_ttl_sec"`
+}
+
+// SwarmSection represents pipeline configuration.
+type SwarmSection struct {
+	WriterTimeout   ScaledTimeout     `mapstructure:"writer_timeout" json:"writer_timeout" yaml:"writer_timeout"`
+	TitleGeneration TitleGeneration   `mapstructure:"title_generation" json:"title_generation" yaml:"title_generation"`
+	Summary         SummaryGeneration `mapstructure:"summary" json:"summary" yaml:"summary"`
+	// StageTimeouts caps the director, checker and editor stages, in
+	// seconds. Unset stages get a share of the remaining request time.
+	StageTimeouts map[string]int `mapstructure:"stage_timeouts" json:"stage_timeouts,omitempty" yaml:"stage_timeouts,omitempty"`
+	// RetryBudget is the total number of provider retries allowed across
+	// all stages of one request. Zero uses the default; negative disables
+	// retries.
+	RetryBudget int `mapstructure:"retry_budget" json:"retry_budget" yaml:"retry_budget"`
+	// MaxDraftCount caps the parallel drafts one request may ask for. Zero
+	// uses the default (agents.MaxDraftCount, also the upper bound).
+	MaxDraftCount int `mapstructure:"max_draft_count" json:"max_draft_count" yaml:"max_draft_count"`
+	// MaxTotalTokens caps the tokens one request's generations may use;
+	// requests may lower it. Zero means no cap.
+	MaxTotalTokens int `mapstructure:"max_total_tokens" json:"max_total_tokens" yaml:"max_total_tokens"`
+	// CommitterLite sheds the committer's LLM work under load.
+	CommitterLite CommitterLiteConfig `mapstructure:"committer_lite" json:"committer_lite" yaml:"committer_lite"`
+	// LoadShedding skips the checker and editor under load.
+	LoadShedding LoadSheddingConfig `mapstructure:"load_shedding" json:"load_shedding" yaml:"load_shedding"`
+	// SyncCommit runs the committer before the response is returned and
+	// reports it as a stage, instead of in the background.
+	SyncCommit bool `mapstructure:"sync_commit" json:"sync_commit" yaml:"sync_commit"`
+	// ResponseCache caches low-temperature generations by prompt.
+	ResponseCache ResponseCacheConfig `mapstructure:"response_cache" json:"response_cache" yaml:"response_cache"`
+	// PromptTokenWarn logs a per-stage breakdown when a prompt's estimated
+	// token count exceeds it. Zero disables the warning.
+	PromptTokenWarn int `mapstructure:"prompt_token_warn" json:"prompt_token_warn" yaml:"prompt_token_warn"`
+	// MinTimeRemainingMs is, per stage, the least time left on the request
+	// deadline for a provider call to start (default 2000). Stages short on
+	// time are skipped where the pipeline can continue without them.
+	MinTimeRemainingMs map[string]int `mapstructure:"min_time_remaining_ms" json:"min_time_remaining_ms" yaml:"min_time_remaining_ms"`
+	// Paragraphs normalizes paragraph breaks in the writer's and editor's
+	// output.
+	Paragraphs ParagraphConfig `mapstructure:"paragraphs" json:"paragraphs" yaml:"paragraphs"`
+	// TrimIncompleteSentences cuts prose that ends mid-sentence back to
+	// its last complete sentence, with a sentence_trimmed warning.
+	TrimIncompleteSentences bool `mapstructure:"trim_incomplete_sentences" json:"trim_incomplete_sentences" yaml:"trim_incomplete_sentences"`
+	// CheckerParseRetries retries a checker response that is not a JSON
+	// array. Zero uses the default (1); -1 disables retries.
+	CheckerParseRetries int `mapstructure:"checker_parse_retries" json:"checker_parse_retries" yaml:"checker_parse_retries"`
+	// CheckerMaxIssues caps the checker issues kept after deduplication,
+	// keeping the most severe. Zero uses the default (50); -1 disables it.
+	CheckerMaxIssues int `mapstructure:"checker_max_issues" json:"checker_max_issues" yaml:"checker_max_issues"`
+	// MaxRevision is how many editor passes may run, re-checking after
+	// each. Zero uses the default (1); -1 disables the editor.
+	MaxRevision int `mapstructure:"max_revision" json:"max_revision" yaml:"max_revision"`
+	// CheckCategories is the default checker category set for requests
+	// that do not choose their own. Empty means all categories.
+	CheckCategories []string `mapstructure:"check_categories" json:"check_categories" yaml:"check_categories"`
+	// EditorMaxExpansion caps an edit's length as a multiple of the
+	// original text. Zero uses the default (1.3).
+	EditorMaxExpansion float64 `mapstructure:"editor_max_expansion" json:"editor_max_expansion" yaml:"editor_max_expansion"`
+	// TopP applies to generations that do not set their own. Zero leaves it
+	// to each provider's default.
+	TopP float64 `mapstructure:"top_p" json:"top_p" yaml:"top_p"`
+	// Seed is sent to providers that support reproducible sampling
+	// (OpenAI, Ollama) for generations that do not set their own. Zero
+	// leaves it unset.
+	Seed int64 `mapstructure:"seed" json:"seed,omitempty" yaml:"seed,omitempty"`
+	// Agents overrides generation parameters per agent (director, writer,
+	// checker, editor, committer, titler).
+	Agents map[string]AgentParams `mapstructure:"agents" json:"agents,omitempty" yaml:"agents,omitempty"`
+	// ContextOverflow moves an agent to a larger-context provider after
+	// repeated context-length errors from its primary.
+	ContextOverflow ContextOverflowConfig `mapstructure:"context_overflow" json:"context_overflow" yaml:"context_overflow"`
+	// ModelUnavailable moves an agent to another provider once its model
+	// is reported removed or deprecated.
+	ModelUnavailable ModelUnavailableConfig `mapstructure:"model_unavailable" json:"model_unavailable" yaml:"model_unavailable"`
+	// LengthGate handles writer output far longer than word_count.
+	LengthGate LengthGateConfig `mapstructure:"length_gate" json:"length_gate" yaml:"length_gate"`
+	// StyleExamples are few-shot passages given to the writer.
+	StyleExamples StyleExamplesConfig `mapstructure:"style_examples" json:"style_examples" yaml:"style_examples"`
+}
+
+// PromptOverride replaces an agent's built-in prompts for a deployment.
+// An empty System keeps the built-in system prompt.
+type PromptOverride struct {
+	// System replaces the agent's system prompt in every language, as is.
+	System string `mapstructure:"system" json:"system,omitempty" yaml:"system,omitempty"`
+}
+
+// AgentParams are one agent's generation parameters. Unset fields keep the
+// agent's built-in defaults.
+type AgentParams struct {
+	// Temperature is a pointer so that 0 can be configured explicitly.
+	Temperature *float64 `mapstructure:"temperature" json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	MaxTokens   int      `mapstructure:"max_tokens" json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	TopP        float64  `mapstructure:"top_p" json:"top_p,omitempty" yaml:"top_p,omitempty"`
+	Seed        int64    `mapstructure:"seed" json:"seed,omitempty" yaml:"seed,omitempty"`
+}
+
+// StyleExamplesConfig controls the writer's few-shot style examples.
+type StyleExamplesConfig struct {
+	// Examples are used for requests without their own, most important
+	// first.
+	Examples []string `mapstructure:"examples" json:"examples,omitempty" yaml:"examples,omitempty"`
+	// MaxExamples caps how many examples reach the prompt (default 3).
+	MaxExamples int `mapstructure:"max_examples" json:"max_examples" yaml:"max_examples"`
+	// MaxTokens caps the examples' estimated size (default 1500). Less is
+	// used when the writer's context window is tighter.
+	MaxTokens int `mapstructure:"max_tokens" json:"max_tokens" yaml:"max_tokens"`
+}
+
+// LengthGateConfig controls what happens when the writer's output exceeds
+// word_count by more than MaxFactor.
+type LengthGateConfig struct {
+	// MaxFactor is the longest accepted output as a multiple of
+	// word_count. Zero disables the gate.
+	MaxFactor float64 `mapstructure:"max_factor" json:"max_factor" yaml:"max_factor"`
+	// Action is "warn" (default), "truncate" (cut at a sentence boundary)
+	// or "regenerate" (retry once with a strict length instruction).
+	Action string `mapstructure:"action" json:"action" yaml:"action"`
+	// Tolerance is the accepted deviation from word_count as a fraction,
+	// e.g. 0.2 for ±20%. Output outside it gets one writer pass to expand
+	// or condense it. Zero disables the pass.
+	Tolerance float64 `mapstructure:"tolerance" json:"tolerance" yaml:"tolerance"`
+}
+
+// ModelUnavailableConfig names the provider used when an agent's model is
+// no longer served.
+type ModelUnavailableConfig struct {
+	// Fallback is an available provider name. Empty uses the next provider
+	// in the agent's routing chain, if any.
+	Fallback string `mapstructure:"fallback" json:"fallback" yaml:"fallback"`
+}
+
+// ContextOverflowConfig controls the switch to a fallback provider when an
+// agent's primary keeps rejecting prompts as too long for its context.
+type ContextOverflowConfig struct {
+	Enabled bool `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	// Threshold is how many overflows within WindowSec trigger the switch
+	// (default 3).
+	Threshold int `mapstructure:"threshold" json:"threshold" yaml:"threshold"`
+	// WindowSec is the period overflows are counted over (default 300).
+	WindowSec int `mapstructure:"window_sec" json:"window_sec" yaml:"window_sec"`
+	// CooldownSec is how long the agent stays on the fallback before its
+	// primary is tried again (default 600).
+	CooldownSec int `mapstructure:"cooldown_sec" json:"cooldown_sec" yaml:"cooldown_sec"`
+	// Fallback names the provider to switch to. Empty uses the next
+	// provider in each agent's routing chain.
+	Fallback string `mapstructure:"fallback" json:"fallback" yaml:"fallback"`
+}
+
+// ParagraphConfig controls paragraph normalization of generated prose.
+type ParagraphConfig struct {
+	Enabled bool `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	// Style is "web" (blank line between paragraphs, the default) or
+	// "print" (one paragraph per line, narration indented with 　).
+	Style string `mapstructure:"style" json:"style" yaml:"style"`
+}
+
+// TitleGeneration controls the optional scene-title generation step.
+type TitleGeneration struct {
+	Enabled bool `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+}
+
+// CommitterLiteConfig switches the committer to lite mode (prose and spec
+// only, no LLM calls) while in-flight requests exceed InFlightThreshold.
+// Zero disables the switch.
+type CommitterLiteConfig struct {
+	InFlightThreshold int `mapstructure:"in_flight_threshold" json:"in_flight_threshold" yaml:"in_flight_threshold"`
+}
+
+// LoadSheddingConfig makes scene requests skip the checker and editor,
+// returning the writer's prose as degraded, while in-flight requests
+// exceed InFlightThreshold. Zero disables it.
+type LoadSheddingConfig struct {
+	InFlightThreshold int `mapstructure:"in_flight_threshold" json:"in_flight_threshold" yaml:"in_flight_threshold"`
+}
+
+// ResponseCacheConfig controls the prompt-keyed response cache. Only
+// generations at or below MaxTemperature are cached.
+type ResponseCacheConfig struct {
+	Enabled        bool    `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	TTLSec         int     `mapstructure:"ttl_sec" json:"ttl_sec" yaml:"ttl_sec"`
+	MaxEntries     int     `mapstructure:"max_entries" json:"max_entries" yaml:"max_entries"`
+	MaxTemperature float64 `mapstructure:"max_temperature" json:"max_temperature" yaml:"max_temperature"`
+}
+
+// SummaryGeneration controls the committer's per-scene summary. When
+// disabled the summary is taken from the scene spec instead.
+type SummaryGeneration struct {
+	Enabled      bool `mapstructure:"enabled" json:"enabled" yaml:"enabled"`
+	MaxSentences int  `mapstructure:"max_sentences" json:"max_sentences" yaml:"max_sentences"`
+}
+
+// ScaledTimeout describes a timeout that grows with the requested output size.
+type ScaledTimeout struct {
+	BaseSec    int `mapstructure:"base_sec" json:"base_sec" yaml:"base_sec"`
+	PerTokenMs int `mapstructure:"per_token_ms" json:"per_token_ms" yaml:"per_token_ms"`
 }
 
 // SceneRequest represents API request for scene generation.
 type SceneRequest struct {
-	ID             string   `json:"id"`
-	Intention      string   `json:"intention"`
-	Chapter        int      `json:"chapter"`
-	Scene          int      `json:"scene"`
-	WordCount      int      `json:"word_count"`
-	POVCharacter   string   `json:"pov_character"`
-	Mood           string   `json:"mood"`
-	RequiredEvents []string `json:"required_events"`
+	ID                string            `json:"id"`
+	Intention         string            `json:"intention"`
+	Chapter           int               `json:"chapter"`
+	Scene             int               `json:"scene"`
+	WordCount         int               `json:"word_count"`
+	POVCharacter      string            `json:"pov_character"`
+	CharactersPresent []string          `json:"characters_present,omitempty"`
+	Mood              string            `json:"mood"`
+	Pacing            string            `json:"pacing,omitempty"`
+	DialogueRatio     string            `json:"dialogue_ratio,omitempty"`
+	RequiredEvents    []string          `json:"required_events"`
+	Priority          string            `json:"priority,omitempty"`
+	CheckCategories   []string          `json:"check_categories,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	// Preset names a configured ScenePreset whose values fill in fields
+	// the request leaves empty.
+	Preset string `json:"preset,omitempty"`
+	// StyleExamples are passages demonstrating the target voice, most
+	// important first. They replace the configured default examples.
+	StyleExamples []string `json:"style_examples,omitempty"`
+	// AgentHints are extra instructions for single agents, keyed by agent
+	// name, appended to that agent's prompt for this request only.
+	AgentHints map[string]string `json:"agent_hints,omitempty"`
+	// Language selects the agents' prompt templates, such as "ja" or "en".
+	// Empty means "ja".
+	Language string `json:"language,omitempty"`
+	// DraftCount, when above 1, writes that many drafts in parallel at
+	// rising temperatures and keeps the one the checker finds cleanest.
+	DraftCount int `json:"draft_count,omitempty"`
+	// MaxTotalTokens caps the tokens the request's generations may use,
+	// below the server's cap. Once spent, optional stages are skipped.
+	MaxTotalTokens int `json:"max_total_tokens,omitempty"`
+}
+
+// ScenePreset holds default SceneRequest values for a kind of scene, such
+// as action or dialogue.
+type ScenePreset struct {
+	Mood          string `mapstructure:"mood" json:"mood,omitempty" yaml:"mood,omitempty"`
+	Pacing        string `mapstructure:"pacing" json:"pacing,omitempty" yaml:"pacing,omitempty"`
+	WordCount     int    `mapstructure:"word_count" json:"word_count,omitempty" yaml:"word_count,omitempty"`
+	DialogueRatio string `mapstructure:"dialogue_ratio" json:"dialogue_ratio,omitempty" yaml:"dialogue_ratio,omitempty"`
+}
+
+// PromptPreviewRequest asks for the prompts a SceneRequest would produce.
+// SceneSpec optionally replaces the dry-run spec given to the writer.
+type PromptPreviewRequest struct {
+	SceneRequest
+	SceneSpec *SceneSpec `json:"scene_spec,omitempty"`
+}
+
+// PromptPreview holds the effective prompts each agent would be sent.
+type PromptPreview struct {
+	Director AgentPrompt `json:"director"`
+	Writer   AgentPrompt `json:"writer"`
+	// Checker's user prompt has an empty text to check, standing in for
+	// the writer's output.
+	Checker   AgentPrompt `json:"checker"`
+	SceneSpec *SceneSpec  `json:"scenespec"`
+}
+
+// ScenePreview is the /scenes/preview response: PromptPreview with each
+// agent's prompts as flat keys.
+type ScenePreview struct {
+	DirectorSystem string     `json:"director_system"`
+	DirectorUser   string     `json:"director_user"`
+	WriterSystem   string     `json:"writer_system"`
+	WriterUser     string     `json:"writer_user"`
+	CheckerSystem  string     `json:"checker_system"`
+	CheckerUser    string     `json:"checker_user"`
+	SceneSpec      *SceneSpec `json:"scenespec"`
+}
+
+// ValidateSceneRequest asks for a check of prose written elsewhere.
+type ValidateSceneRequest struct {
+	Text         string `json:"text"`
+	Chapter      int    `json:"chapter"`
+	Scene        int    `json:"scene"`
+	POVCharacter string `json:"pov_character"`
+	// CheckCategories restricts the checks like SceneRequest's.
+	CheckCategories []string `json:"check_categories,omitempty"`
+	// Language selects the checker's prompts like SceneRequest's.
+	Language string `json:"language,omitempty"`
+}
+
+// ValidationResponse holds the checker's verdict on submitted prose.
+type ValidationResponse struct {
+	RequestID string      `json:"request_id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Stages    []StageInfo `json:"stages"`
+	Issues    []Issue     `json:"issues"`
+	// Checked is false when the checker output could not be parsed.
+	Checked         bool      `json:"checked"`
+	IssuesTruncated bool      `json:"issues_truncated,omitempty"`
+	IssuesTotal     int       `json:"issues_total,omitempty"`
+	Warnings        []Warning `json:"warnings,omitempty"`
+	TotalDurationMs int64     `json:"total_duration_ms"`
+}
+
+// AgentPrompt is a system/user prompt pair.
+type AgentPrompt struct {
+	System string `json:"system"`
+	User   string `json:"user"`
 }
 
 // SceneResponse represents response for scene generation.
 type SceneResponse struct {
-	RequestID       string      `json:"request_id"`
-	Timestamp       time.Time   `json:"timestamp"`
-	Stages          []StageInfo `json:"stages"`
-	SceneSpec       *SceneSpec  `json:"scenespec,omitempty"`
-	Issues          []Issue     `json:"issues,omitempty"`
-	RevisionMade    bool        `json:"revision_made"`
-	Text            string      `json:"text"`
-	TotalDurationMs int64       `json:"total_duration_ms"`
+	SchemaVersion string      `json:"schema_version,omitempty"`
+	RequestID     string      `json:"request_id"`
+	Timestamp     time.Time   `json:"timestamp"`
+	Stages        []StageInfo `json:"stages"`
+	SceneSpec     *SceneSpec  `json:"scenespec,omitempty"`
+	Issues        []Issue     `json:"issues,omitempty"`
+	// IssuesTruncated is set when checker issues were capped; IssuesTotal
+	// is the checker's count before capping.
+	IssuesTruncated bool      `json:"issues_truncated,omitempty"`
+	IssuesTotal     int       `json:"issues_total,omitempty"`
+	Warnings        []Warning `json:"warnings,omitempty"`
+	RevisionMade    bool      `json:"revision_made"`
+	// Revisions counts the editor passes applied.
+	Revisions int `json:"revisions,omitempty"`
+	// Drafts records the choice between parallel drafts when the request
+	// asked for more than one.
+	Drafts *DraftSelection `json:"drafts,omitempty"`
+	Text   string          `json:"text"`
+	// Chars is the length of Text, counted like StageInfo.Chars.
+	Chars      int    `json:"chars"`
+	Summary    string `json:"summary,omitempty"`
+	CommitMode string `json:"commit_mode,omitempty"`
+	// Degraded is set when the response is not the full pipeline's: the
+	// agents run on mock providers, or checks were shed under load.
+	Degraded bool `json:"degraded,omitempty"`
+	// LoadShed is set when checks were shed under load, telling that cause
+	// of Degraded apart from mock providers.
+	LoadShed        bool              `json:"load_shed,omitempty"`
+	Moderation      *ModerationResult `json:"moderation,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Debug           *DebugInfo        `json:"debug,omitempty"`
+	TotalDurationMs int64             `json:"total_duration_ms"`
+	// TotalCostUSD sums the estimated cost of the generations made for
+	// the response; the asynchronous commit is not included.
+	TotalCostUSD float64 `json:"total_cost_usd,omitempty"`
+	// TotalTokens sums the tokens of the same generations.
+	TotalTokens int `json:"total_tokens,omitempty"`
+	// BudgetExceeded is set when stages were skipped because the token
+	// budget ran out; without text, the budget ran out after the director.
+	BudgetExceeded bool `json:"budget_exceeded,omitempty"`
+	// TimedOut is set when the request deadline passed mid-pipeline: the
+	// response holds what the completed stages produced and was not
+	// committed.
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// DraftSelection records how the scene's draft was chosen: the one with
+// the fewest error issues, then the fewest warnings, then the earliest.
+type DraftSelection struct {
+	// Selected indexes Drafts.
+	Selected int            `json:"selected"`
+	Drafts   []DraftSummary `json:"drafts"`
+}
+
+// DraftSummary describes one parallel writer draft and its check.
+type DraftSummary struct {
+	Temperature float64 `json:"temperature"`
+	Chars       int     `json:"chars,omitempty"`
+	Tokens      int     `json:"tokens,omitempty"`
+	Errors      int     `json:"errors"`
+	Warnings    int     `json:"warnings"`
+	// Checked is false when the draft's check failed or was inconclusive.
+	Checked bool `json:"checked"`
+	// Error is set when the writer failed to produce the draft.
+	Error string `json:"error,omitempty"`
+}
+
+// DebugInfo carries pipeline diagnostics for a response.
+type DebugInfo struct {
+	RetriesUsed int `json:"retries_used"`
+	RetryBudget int `json:"retry_budget"`
+}
+
+// ModerationResult represents the moderation verdict on generated prose.
+type ModerationResult struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories,omitempty"`
 }
 
 // StageInfo represents a pipeline stage result.
@@ -53,6 +543,59 @@ type StageInfo struct {
 	Operation  string `json:"operation"`
 	DurationMs int64  `json:"duration_ms,omitempty"`
 	Tokens     int    `json:"tokens,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	Route      string `json:"route,omitempty"`
+	// SkipReason is set when the stage was skipped, e.g. "timeout".
+	SkipReason string `json:"skip_reason,omitempty"`
+	// Override is set when Provider came from a request's provider
+	// override rather than configuration.
+	Override bool `json:"override,omitempty"`
+	// Chars is the length of the prose the stage produced, counted as
+	// runes without whitespace.
+	Chars int `json:"chars,omitempty"`
+	// Error is set when the stage's output was unusable and the pipeline
+	// continued with a fallback, e.g. an unparseable director spec.
+	Error string `json:"error,omitempty"`
+	// Reprompts counts the times the agent was asked again because its
+	// output did not fit the expected schema.
+	Reprompts int `json:"reprompts,omitempty"`
+}
+
+// Warning codes for operational problems reported in SceneResponse.Warnings.
+const (
+	WarningDirectorFallback    = "director_fallback"
+	WarningSpecIncomplete      = "spec_incomplete"
+	WarningTruncated           = "truncated"
+	WarningProviderDegraded    = "provider_degraded"
+	WarningCacheHit            = "cache_hit"
+	WarningCheckerFailed       = "checker_failed"
+	WarningEditorFailed        = "editor_failed"
+	WarningEditorDiscarded     = "editor_discarded"
+	WarningCheckerInconclusive = "checker_inconclusive"
+	WarningSentenceTrimmed     = "sentence_trimmed"
+	WarningLengthExceeded      = "length_exceeded"
+	WarningLengthTruncated     = "length_truncated"
+	WarningLengthRegenerated   = "length_regenerated"
+	WarningLengthAdjusted      = "length_adjusted"
+	WarningLengthOffTarget     = "length_off_target"
+	WarningTitleFallback       = "title_fallback"
+	WarningSummaryFallback     = "summary_fallback"
+	WarningStageTimeout        = "stage_timeout"
+	WarningModerationSkipped   = "moderation_skipped"
+	WarningRevisionStalled     = "revision_stalled"
+	WarningRetrievalFailed     = "retrieval_failed"
+	WarningCommitterFailed     = "committer_failed"
+	WarningBudgetExceeded      = "budget_exceeded"
+	WarningLoadShed            = "load_shed"
+	WarningTimedOut            = "timed_out"
+)
+
+// Warning represents a pipeline concern, as opposed to an Issue with the
+// prose itself.
+type Warning struct {
+	Code    string `json:"code"`
+	Stage   string `json:"stage,omitempty"`
+	Message string `json:"message"`
 }
 
 // Issue represents a checker finding.
@@ -60,6 +603,8 @@ type Issue struct {
 	Category    string `json:"category"`
 	Severity    string `json:"severity"`
 	Description string `json:"description"`
+	// Location quotes the text the issue was found in, when known.
+	Location string `json:"location,omitempty"`
 }
 
 // GenerationResult represents LLM generation output.
@@ -68,6 +613,28 @@ type GenerationResult struct {
 	PromptTokens     int    `json:"prompt_tokens"`
 	CompletionTokens int    `json:"completion_tokens"`
 	DurationMs       int64  `json:"duration_ms"`
+	// FinishReason is the provider's stop reason; "length" means the output
+	// hit max_tokens.
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Cached is set when the result was served from the response cache.
+	Cached bool `json:"cached,omitempty"`
+	// CostUSD is the estimated cost from the configured pricing.
+	CostUSD float64 `json:"cost_usd,omitempty"`
+	// ToolCalls holds the tool calls the model made, if any.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ServedBy names the configured provider that produced the result
+	// when the agent is routed to a fallback chain.
+	ServedBy string `json:"served_by,omitempty"`
+	// Reprompts counts the extra generations the agent asked for because
+	// the output did not fit its schema; the token counts include them.
+	Reprompts int `json:"reprompts,omitempty"`
+}
+
+// ToolCall is one function call made by a model. Arguments is the raw
+// JSON the model produced.
+type ToolCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // SceneSpec represents a structured scene design.
@@ -97,9 +664,10 @@ type SceneSpecNarrative struct {
 
 // SceneSpecConstraints describes constraints for the scene.
 type SceneSpecConstraints struct {
-	POVCharacter string `json:"pov_character"`
-	Location     string `json:"location"`
-	Mood         string `json:"mood"`
+	POVCharacter      string   `json:"pov_character"`
+	CharactersPresent []string `json:"characters_present,omitempty"`
+	Location          string   `json:"location"`
+	Mood              string   `json:"mood"`
 }
 
 // SceneSpecContinuity describes continuity requirements.
@@ -108,3 +676,56 @@ type SceneSpecContinuity struct {
 	ForeshadowingToResolve []string `json:"foreshadowing_to_resolve"`
 	ForeshadowingToPlant   []string `json:"foreshadowing_to_plant"`
 }
+
+// Character represents a character in the story.
+type Character struct {
+	ID          string               `json:"id" yaml:"id"`
+	Name        CharacterName        `json:"name" yaml:"name"`
+	Language    CharacterLanguage    `json:"language" yaml:"language"`
+	Personality CharacterPersonality `json:"personality" yaml:"personality"`
+}
+
+// CharacterName represents character naming.
+type CharacterName struct {
+	Full    string   `json:"full" yaml:"full"`
+	Short   string   `json:"short" yaml:"short"`
+	Aliases []string `json:"aliases" yaml:"aliases"`
+}
+
+// CharacterLanguage represents character speech patterns.
+type CharacterLanguage struct {
+	FirstPerson    string   `json:"first_person" yaml:"first_person"`
+	Tone           string   `json:"tone" yaml:"tone"`
+	SpeechPattern  string   `json:"speech_pattern" yaml:"speech_pattern"`
+	ForbiddenWords []string `json:"forbidden_words" yaml:"forbidden_words"`
+}
+
+// CharacterPersonality represents character traits.
+type CharacterPersonality struct {
+	Values      []string `json:"values" yaml:"values"`
+	Motivations []string `json:"motivations" yaml:"motivations"`
+	Fears       []string `json:"fears" yaml:"fears"`
+}
+
+// Document represents a searchable text document.
+type Document struct {
+	ID       string            `json:"id"`
+	Content  string            `json:"content"`
+	Source   string            `json:"source"`
+	DocType  string            `json:"doc_type"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// SearchResult represents a ranked search hit.
+type SearchResult struct {
+	Document   Document    `json:"document"`
+	Score      float64     `json:"score"`
+	Rank       int         `json:"rank"`
+	Highlights []Highlight `json:"highlights,omitempty"`
+}
+
+// Highlight marks a match inside SearchResult.Document.Content as rune offsets.
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
@@ -0,0 +1,14 @@
+package textutil
+
+// CountChars returns the length of prose the way Japanese manuscripts are
+// counted: one per rune, whatever its byte width, ignoring whitespace and
+// ideographic spaces.
+func CountChars(text string) int {
+	count := 0
+	for _, r := range text {
+		if !isSpaceOrIdeographicSpace(r) {
+			count++
+		}
+	}
+	return count
+}
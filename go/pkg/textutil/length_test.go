@@ -0,0 +1,20 @@
+package textutil
+
+import "testing"
+
+func TestCountChars(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int
+	}{
+		{"夜が明けた。", 6},
+		{"「行こう」\n\n　彼は振り返った。", 13},
+		{"She turned.", 10},
+		{"", 0},
+	}
+	for _, tc := range cases {
+		if got := CountChars(tc.in); got != tc.want {
+			t.Fatalf("CountChars(%q) = %d; want %d", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Package textutil holds text helpers for generated prose.
+package textutil
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Paragraph styles accepted by NormalizeParagraphs.
+const (
+	// StyleWeb separates paragraphs with a blank line and does not indent.
+	StyleWeb = "web"
+	// StylePrint puts one paragraph per line and indents narration with a
+	// full-width space; dialogue lines starting with 「 are not indented.
+	StylePrint = "print"
+)
+
+const indent = "　"
+
+// cjkStart is the first rune of the CJK blocks.
+const cjkStart = '\u2e80'
+
+// IsValidParagraphStyle reports whether style is StyleWeb or StylePrint.
+func IsValidParagraphStyle(style string) bool {
+	return style == StyleWeb || style == StylePrint
+}
+
+// SplitParagraphs splits prose into trimmed paragraphs. Blank lines and line
+// breaks after a sentence end separate paragraphs; a line break mid-sentence
+// is treated as a hard wrap and joined, with a space when either side of the
+// join is a non-CJK letter or digit, as in English. Dialogue in 「」 that follows a
+// sentence end is split onto its own paragraph, as is narration following
+// a dialogue paragraph.
+func SplitParagraphs(text string) []string {
+	var paragraphs []string
+	var current strings.Builder
+	flush := func() {
+		if p := strings.TrimSpace(current.String()); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+		current.Reset()
+	}
+
+	for _, block := range strings.Split(normalizeNewlines(text), "\n\n") {
+		for _, line := range strings.Split(block, "\n") {
+			line = trimLine(line)
+			if line == "" {
+				continue
+			}
+			if current.Len() > 0 && (endsSentence(current.String()) || strings.HasPrefix(line, "「")) {
+				flush()
+			}
+			if current.Len() > 0 && joinsWithSpace(current.String(), line) {
+				current.WriteByte(' ')
+			}
+			splitDialogue(line, &current, flush)
+		}
+		flush()
+	}
+	return paragraphs
+}
+
+// NormalizeParagraphs re-joins SplitParagraphs' output in the given style.
+// Unknown styles are treated as StyleWeb. It is idempotent.
+func NormalizeParagraphs(text, style string) string {
+	paragraphs := SplitParagraphs(text)
+	if style != StylePrint {
+		return strings.Join(paragraphs, "\n\n")
+	}
+	for i, p := range paragraphs {
+		if !strings.HasPrefix(p, "「") {
+			paragraphs[i] = indent + p
+		}
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+// splitDialogue appends line to current, flushing before an opening 「 that
+// follows a sentence end and after the closing 」 of a paragraph that is
+// pure dialogue, unless the quote is followed by a quotative such as と.
+func splitDialogue(line string, current *strings.Builder, flush func()) {
+	depth := 0
+	for _, r := range line {
+		if r == '「' && depth == 0 && current.Len() > 0 && endsSentence(current.String()) {
+			flush()
+		}
+		if depth == 0 && current.Len() > 0 && isDialogue(current.String()) && !unicode.IsSpace(r) && !continuesDialogue(r) {
+			flush()
+		}
+		if current.Len() == 0 && unicode.IsSpace(r) {
+			continue
+		}
+		current.WriteRune(r)
+		switch r {
+		case '「':
+			depth++
+		case '」':
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+}
+
+// joinsWithSpace reports whether a hard-wrapped line joins the text before
+// it with a space: when the last rune before the break or the first after
+// it is a non-CJK letter or digit. CJK text joins directly.
+func joinsWithSpace(before, after string) bool {
+	last, _ := utf8.DecodeLastRuneInString(before)
+	first, _ := utf8.DecodeRuneInString(after)
+	return isSpacedRune(last) || isSpacedRune(first)
+}
+
+// isSpacedRune reports whether r is a letter or digit of a script written
+// with spaces between words: anything below the CJK blocks, which start at
+// U+2E80. Full-width Latin and kana such as ー are past it.
+func isSpacedRune(r rune) bool {
+	return r < cjkStart && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// continuesDialogue reports whether r attaches to the preceding quote, as
+// in 「行こう」と言った, rather than starting new narration.
+func continuesDialogue(r rune) bool {
+	switch r {
+	case '「', 'と', 'っ', '、', '，', ',':
+		return true
+	}
+	return false
+}
+
+// isDialogue reports whether p is a complete 「...」 line.
+func isDialogue(p string) bool {
+	p = strings.TrimSpace(p)
+	if !strings.HasPrefix(p, "「") || !strings.HasSuffix(p, "」") {
+		return false
+	}
+	depth := 0
+	for _, r := range p {
+		switch r {
+		case '「':
+			depth++
+		case '」':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+func endsSentence(p string) bool {
+	p = strings.TrimRightFunc(p, unicode.IsSpace)
+	last, _ := utf8.DecodeLastRuneInString(p)
+	return p != "" && IsSentenceEnd(last)
+}
+
+func trimLine(line string) string {
+	return strings.TrimFunc(line, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '　'
+	})
+}
+
+func normalizeNewlines(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	// Collapse whitespace-only lines so "\n \n" separates paragraphs too.
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		}
+	}
+	text = strings.Join(lines, "\n")
+	for strings.Contains(text, "\n\n\n") {
+		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+	}
+	return text
+}
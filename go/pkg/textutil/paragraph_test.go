@@ -0,0 +1,62 @@
+package textutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitParagraphs(t *testing.T) {
+	cases := map[string]struct {
+		in   string
+		want []string
+	}{
+		"one block with dialogue": {
+			in:   "夜が明けた。「行こう」と彼は言った。「待って」彼女は振り返った。",
+			want: []string{"夜が明けた。", "「行こう」と彼は言った。", "「待って」", "彼女は振り返った。"},
+		},
+		"hard-wrapped lines": {
+			in:   "長い道のりを歩いて\n彼はようやく\n村に着いた。\n空は青かった。",
+			want: []string{"長い道のりを歩いて彼はようやく村に着いた。", "空は青かった。"},
+		},
+		"hard-wrapped English": {
+			in:   "She walked\ninto the room,\nsmiling.\nHe left.",
+			want: []string{"She walked into the room, smiling.", "He left."},
+		},
+		"hard-wrapped mixed text": {
+			in:   "彼はコーヒー\nを飲み\nJohnに会った。",
+			want: []string{"彼はコーヒーを飲み Johnに会った。"},
+		},
+		"over-segmented blank lines": {
+			in:   "　一行目。\n\n\n\n  \n二行目。\r\n\r\n「台詞」",
+			want: []string{"一行目。", "二行目。", "「台詞」"},
+		},
+	}
+	for name, tc := range cases {
+		if got := SplitParagraphs(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeParagraphsStylesAndIdempotence(t *testing.T) {
+	in := "夜が明けた。「行こう」\n空は青かった。"
+
+	web := NormalizeParagraphs(in, StyleWeb)
+	if web != "夜が明けた。\n\n「行こう」\n\n空は青かった。" {
+		t.Fatalf("unexpected web output: %q", web)
+	}
+	print := NormalizeParagraphs(in, StylePrint)
+	if print != "　夜が明けた。\n「行こう」\n　空は青かった。" {
+		t.Fatalf("unexpected print output: %q", print)
+	}
+
+	for _, style := range []string{StyleWeb, StylePrint} {
+		once := NormalizeParagraphs(in, style)
+		if twice := NormalizeParagraphs(once, style); twice != once {
+			t.Fatalf("%s: not idempotent: %q -> %q", style, once, twice)
+		}
+	}
+	if NormalizeParagraphs(print, StyleWeb) != web {
+		t.Fatalf("expected print output to convert back to web")
+	}
+}
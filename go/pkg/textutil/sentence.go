@@ -0,0 +1,69 @@
+package textutil
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// IsSentenceEnd reports whether r closes a sentence: Japanese and Latin
+// terminators, an ellipsis, or a closing quote.
+func IsSentenceEnd(r rune) bool {
+	switch r {
+	case '。', '！', '？', '.', '!', '?', '…', '」', '』', '”', '"':
+		return true
+	}
+	return false
+}
+
+// TrimIncompleteSentence drops a trailing sentence fragment, such as prose
+// cut off by a length limit ("彼は振り返って"), back to the last complete
+// sentence. It reports whether anything was trimmed. Text with no complete
+// sentence is returned unchanged.
+func TrimIncompleteSentence(text string) (string, bool) {
+	trimmed := strings.TrimRightFunc(text, isSpaceOrIdeographicSpace)
+	if trimmed == "" {
+		return text, false
+	}
+	if last, _ := utf8.DecodeLastRuneInString(trimmed); IsSentenceEnd(last) {
+		return text, false
+	}
+
+	cut := strings.LastIndexFunc(trimmed, IsSentenceEnd)
+	if cut < 0 {
+		return text, false
+	}
+	_, size := utf8.DecodeRuneInString(trimmed[cut:])
+	return strings.TrimRightFunc(trimmed[:cut+size], isSpaceOrIdeographicSpace), true
+}
+
+// TruncateAtSentence cuts text to at most maxChars characters, counted
+// like CountChars, ending at the last complete sentence inside the limit,
+// and reports whether anything was cut. With no sentence end inside the
+// limit the cut is made at maxChars.
+func TruncateAtSentence(text string, maxChars int) (string, bool) {
+	if maxChars <= 0 || CountChars(text) <= maxChars {
+		return text, false
+	}
+	runes, chars := 0, 0
+	for _, r := range text {
+		if !isSpaceOrIdeographicSpace(r) {
+			if chars == maxChars {
+				break
+			}
+			chars++
+		}
+		runes++
+	}
+	head := string([]rune(text)[:runes])
+	cut := strings.LastIndexFunc(head, IsSentenceEnd)
+	if cut < 0 {
+		return strings.TrimRightFunc(head, isSpaceOrIdeographicSpace), true
+	}
+	_, size := utf8.DecodeRuneInString(head[cut:])
+	return head[:cut+size], true
+}
+
+func isSpaceOrIdeographicSpace(r rune) bool {
+	return unicode.IsSpace(r) || r == '　'
+}
@@ -0,0 +1,46 @@
+package textutil
+
+import "testing"
+
+func TestTrimIncompleteSentence(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		trimmed bool
+	}{
+		{"夜が明けた。彼は振り返って", "夜が明けた。", true},
+		{"「行こう」\n\n彼は振り返って\n", "「行こう」", true},
+		{"夜が明けた。", "夜が明けた。", false},
+		{"She turned. Then she", "She turned.", true},
+		{"彼は振り返って", "彼は振り返って", false},
+		{"", "", false},
+	}
+	for _, tc := range cases {
+		got, trimmed := TrimIncompleteSentence(tc.in)
+		if got != tc.want || trimmed != tc.trimmed {
+			t.Fatalf("TrimIncompleteSentence(%q) = %q, %v; want %q, %v", tc.in, got, trimmed, tc.want, tc.trimmed)
+		}
+	}
+}
+
+func TestTruncateAtSentence(t *testing.T) {
+	cases := []struct {
+		in        string
+		max       int
+		want      string
+		truncated bool
+	}{
+		{"一文目です。二文目です。三文目です。", 15, "一文目です。二文目です。", true},
+		{"「行こう」と言った。", 6, "「行こう」", true},
+		{"句読点のない長い文章", 5, "句読点のな", true},
+		{"短い。", 10, "短い。", false},
+		{"She left. He stayed.", 14, "She left.", true},
+		{"She left.", 8, "She left.", false},
+	}
+	for _, tc := range cases {
+		got, truncated := TruncateAtSentence(tc.in, tc.max)
+		if got != tc.want || truncated != tc.truncated {
+			t.Fatalf("TruncateAtSentence(%q, %d) = %q, %v; want %q, %v", tc.in, tc.max, got, truncated, tc.want, tc.truncated)
+		}
+	}
+}